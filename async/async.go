@@ -0,0 +1,88 @@
+// Package async queues function invocations and processes them in the
+// background. Calls are persisted until they succeed, failed too often
+// and are moved to the dead letters, or failed for good. The result is
+// posted to the callback URL of the call.
+package async
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	logger = logrus.WithField("package", "async")
+
+	ErrQueueClosed  = errors.New("async queue closed")
+	ErrCallNotFound = errors.New("call not found")
+)
+
+const (
+	// CallIDHeader carries the ID of a call in the response to the
+	// invocation and in the callback
+	CallIDHeader = "X-Call-Id"
+	// CallbackURLHeader sets the URL the result of a call is posted to
+	CallbackURLHeader = "X-Callback-Url"
+	// FunctionNameHeader names the function in the callback
+	FunctionNameHeader = "X-Function-Name"
+	// FunctionStatusHeader carries the status code of the function in the callback
+	FunctionStatusHeader = "X-Function-Status"
+
+	// ConcurrencyAnnotation limits the calls of a function processed at the same time
+	ConcurrencyAnnotation = "com.openfaas.rancher.async-concurrency"
+)
+
+// Call is a queued invocation
+type Call struct {
+	// Seq orders the calls in the queue
+	Seq         uint64      `json:"seq"`
+	ID          string      `json:"id"`
+	Function    string      `json:"function"`
+	Method      string      `json:"method"`
+	Path        string      `json:"path,omitempty"`
+	Query       string      `json:"query,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	CallbackURL string      `json:"callbackUrl,omitempty"`
+	Created     time.Time   `json:"created"`
+	Attempts    int         `json:"attempts"`
+	NextAttempt time.Time   `json:"nextAttempt,omitempty"`
+	// LastStatus is the status code of the last attempt, 0 if the function was not reached
+	LastStatus int    `json:"lastStatus,omitempty"`
+	LastError  string `json:"lastError,omitempty"`
+}
+
+// newCallID returns a random call ID
+func newCallID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Annotate(err, "Read")
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// ParseConcurrency returns the limit set by the ConcurrencyAnnotation,
+// 0 if the annotation is missing
+func ParseConcurrency(annotations map[string]string) (int, error) {
+	value, ok := annotations[ConcurrencyAnnotation]
+	if !ok {
+		return 0, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Annotatef(err, "%s", ConcurrencyAnnotation)
+	}
+
+	if limit <= 0 {
+		return 0, errors.Errorf("%s must be positive, got %q", ConcurrencyAnnotation, value)
+	}
+
+	return limit, nil
+}
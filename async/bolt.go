@@ -0,0 +1,188 @@
+package async
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/juju/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketNameCalls       = []byte("calls")
+	bucketNameDeadLetters = []byte("dead-letters")
+)
+
+// BoltQueue persists calls in a bolt database file. Calls are keyed by
+// their sequence number, so the buckets are ordered by arrival.
+type BoltQueue struct {
+	database *bolt.DB
+}
+
+// NewBoltQueue opens the queue stored in path
+func NewBoltQueue(path string) (*BoltQueue, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Annotate(err, "MkdirAll")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Annotate(err, "Open")
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketNameCalls, bucketNameDeadLetters} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return errors.Annotatef(err, "CreateBucketIfNotExists [%s]", name)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, errors.Annotate(err, "Update")
+	}
+
+	return &BoltQueue{database: db}, nil
+}
+
+// Close closes the database
+func (q *BoltQueue) Close() (err error) {
+	if q.database != nil {
+		err = q.database.Close()
+		q.database = nil
+	}
+	return err
+}
+
+// Enqueue assigns Seq, ID and Created and stores the call
+func (q *BoltQueue) Enqueue(call *Call) error {
+	if q.database == nil {
+		return ErrQueueClosed
+	}
+
+	id, err := newCallID()
+	if err != nil {
+		return errors.Annotate(err, "newCallID")
+	}
+
+	call.ID = id
+	call.Created = time.Now().UTC()
+
+	err = q.database.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketNameCalls)
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return errors.Annotate(err, "NextSequence")
+		}
+		call.Seq = seq
+
+		return errors.Annotate(put(bucket, call), "put")
+	})
+
+	return errors.Annotate(err, "Update")
+}
+
+// Pending returns the queued calls in the order they arrived
+func (q *BoltQueue) Pending() ([]Call, error) {
+	return q.list(bucketNameCalls)
+}
+
+// DeadLetters returns the calls given up in the order they arrived
+func (q *BoltQueue) DeadLetters() ([]Call, error) {
+	return q.list(bucketNameDeadLetters)
+}
+
+// Update stores the changed state of a queued call
+func (q *BoltQueue) Update(call *Call) error {
+	if q.database == nil {
+		return ErrQueueClosed
+	}
+
+	err := q.database.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketNameCalls)
+		if bucket.Get(key(call.Seq)) == nil {
+			return ErrCallNotFound
+		}
+
+		return errors.Annotate(put(bucket, call), "put")
+	})
+
+	return errors.Annotate(err, "Update")
+}
+
+// Complete removes a processed call from the queue
+func (q *BoltQueue) Complete(call *Call) error {
+	if q.database == nil {
+		return ErrQueueClosed
+	}
+
+	err := q.database.Update(func(tx *bolt.Tx) error {
+		return errors.Annotate(tx.Bucket(bucketNameCalls).Delete(key(call.Seq)), "Delete")
+	})
+
+	return errors.Annotate(err, "Update")
+}
+
+// DeadLetter moves a call from the queue to the dead letters
+func (q *BoltQueue) DeadLetter(call *Call) error {
+	if q.database == nil {
+		return ErrQueueClosed
+	}
+
+	err := q.database.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketNameCalls).Delete(key(call.Seq)); err != nil {
+			return errors.Annotate(err, "Delete")
+		}
+
+		return errors.Annotate(put(tx.Bucket(bucketNameDeadLetters), call), "put")
+	})
+
+	return errors.Annotate(err, "Update")
+}
+
+func (q *BoltQueue) list(bucketName []byte) ([]Call, error) {
+	if q.database == nil {
+		return nil, ErrQueueClosed
+	}
+
+	calls := []Call{}
+	err := q.database.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).ForEach(func(k, v []byte) error {
+			call := Call{}
+			if err := json.Unmarshal(v, &call); err != nil {
+				return errors.Annotatef(err, "Unmarshal [%d]", binary.BigEndian.Uint64(k))
+			}
+
+			calls = append(calls, call)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, errors.Annotate(err, "View")
+	}
+
+	return calls, nil
+}
+
+func put(bucket *bolt.Bucket, call *Call) error {
+	buf, err := json.Marshal(call)
+	if err != nil {
+		return errors.Annotate(err, "Marshal")
+	}
+
+	return errors.Annotate(bucket.Put(key(call.Seq), buf), "Put")
+}
+
+func key(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
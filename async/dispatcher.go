@@ -0,0 +1,379 @@
+package async
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+)
+
+const (
+	callbackTimeout = 30 * time.Second
+)
+
+// Options configure a Dispatcher
+type Options struct {
+	// number of calls processed at the same time
+	Workers int
+	// calls failing more often are moved to the dead letters
+	MaxAttempts int
+	// delay of the first retry, doubled with every further attempt
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// limit of calls per function processed at the same time unless
+	// overridden by the ConcurrencyAnnotation, 0 is unlimited
+	FunctionConcurrency int
+	// interval the queue is checked for calls due for a retry
+	PollInterval time.Duration
+	// namespace of functions addressed without namespace
+	DefaultNamespace string
+}
+
+// Dispatcher processes the queued calls with a pool of workers
+type Dispatcher struct {
+	queue  *BoltQueue
+	cache  *metastore.Cache
+	invoke http.HandlerFunc
+	client *http.Client
+	opts   Options
+	wake   chan struct{}
+
+	mu sync.Mutex
+	// IDs of the calls being processed
+	running map[string]bool
+	// count of calls being processed by function
+	inflight map[string]int
+}
+
+// NewDispatcher creates a dispatcher passing the calls of queue to the
+// function proxy invoke. The limits of the functions are read from cache.
+func NewDispatcher(queue *BoltQueue, cache *metastore.Cache, invoke http.HandlerFunc, opts Options) *Dispatcher {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+
+	d := Dispatcher{
+		queue:    queue,
+		cache:    cache,
+		invoke:   invoke,
+		client:   newCallbackClient(),
+		opts:     opts,
+		wake:     make(chan struct{}, 1),
+		running:  make(map[string]bool),
+		inflight: make(map[string]int),
+	}
+
+	return &d
+}
+
+// newCallbackClient creates the client posting results to the callback
+// URLs. It has its own transport, the default transport may carry the TLS
+// settings of the cattle server.
+func newCallbackClient() *http.Client {
+	transport := http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConns:        100,
+	}
+
+	return &http.Client{Timeout: callbackTimeout, Transport: &transport}
+}
+
+// Enqueue stores the call and wakes the dispatcher
+func (d *Dispatcher) Enqueue(call *Call) error {
+	if err := d.queue.Enqueue(call); err != nil {
+		return errors.Annotate(err, "Enqueue")
+	}
+
+	queued.Inc()
+	d.notify()
+	return nil
+}
+
+// DeadLetters returns the calls given up
+func (d *Dispatcher) DeadLetters() ([]Call, error) {
+	return d.queue.DeadLetters()
+}
+
+// Run processes the queue until stop is closed, waiting for the calls
+// being processed. This function is blocking.
+func (d *Dispatcher) Run(stop <-chan struct{}) {
+	// there are never more calls dispatched than workers
+	work := make(chan Call, d.opts.Workers)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < d.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for call := range work {
+				d.process(call)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.dispatch(work)
+
+		select {
+		case <-stop:
+			close(work)
+			wg.Wait()
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// notify wakes the dispatcher without blocking
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch passes the due calls to idle workers, respecting the limits
+// of the functions
+func (d *Dispatcher) dispatch(work chan<- Call) {
+	calls, err := d.queue.Pending()
+	if err != nil {
+		logger.Error(errors.Annotate(err, "Pending"))
+		return
+	}
+
+	pending.Set(float64(len(calls)))
+
+	now := time.Now()
+	limits := make(map[string]int)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, call := range calls {
+		if len(d.running) >= d.opts.Workers {
+			return
+		}
+
+		if d.running[call.ID] || now.Before(call.NextAttempt) {
+			continue
+		}
+
+		function := d.functionKey(call.Function)
+		limit, ok := limits[function]
+		if !ok {
+			limit = d.limit(call.Function)
+			limits[function] = limit
+		}
+
+		if limit > 0 && d.inflight[function] >= limit {
+			continue
+		}
+
+		d.running[call.ID] = true
+		d.inflight[function]++
+		work <- call
+	}
+}
+
+// process invokes the function of the call and records the outcome
+func (d *Dispatcher) process(call Call) {
+	defer func() {
+		d.mu.Lock()
+		delete(d.running, call.ID)
+		d.inflight[d.functionKey(call.Function)]--
+		d.mu.Unlock()
+
+		d.notify()
+	}()
+
+	result := d.call(&call)
+	call.Attempts++
+	call.LastStatus = result.status
+
+	if result.status < http.StatusInternalServerError && result.status != http.StatusTooManyRequests {
+		outcome := outcomeSuccess
+		if result.status >= http.StatusBadRequest {
+			outcome = outcomeFailure
+		}
+
+		attempts.WithLabelValues(outcome).Inc()
+		if err := d.queue.Complete(&call); err != nil {
+			logger.Error(errors.Annotatef(err, "Complete [%s]", call.ID))
+		}
+
+		d.callback(&call, result)
+		return
+	}
+
+	call.LastError = fmt.Sprintf("status %d: %s", result.status, bytes.TrimSpace(result.body.Bytes()))
+	if call.Attempts >= d.opts.MaxAttempts {
+		logger.Warnf("giving up call %s to %s after %d attempts: %s", call.ID, call.Function, call.Attempts, call.LastError)
+		attempts.WithLabelValues(outcomeDeadLetter).Inc()
+		if err := d.queue.DeadLetter(&call); err != nil {
+			logger.Error(errors.Annotatef(err, "DeadLetter [%s]", call.ID))
+		}
+
+		d.callback(&call, result)
+		return
+	}
+
+	call.NextAttempt = time.Now().Add(d.backoff(call.Attempts))
+	logger.Infof("retrying call %s to %s at %s: %s", call.ID, call.Function, call.NextAttempt.Format(time.RFC3339), call.LastError)
+	attempts.WithLabelValues(outcomeRetry).Inc()
+	if err := d.queue.Update(&call); err != nil {
+		logger.Error(errors.Annotatef(err, "Update [%s]", call.ID))
+	}
+}
+
+// call passes the call to the function proxy
+func (d *Dispatcher) call(call *Call) *response {
+	target := "/function/" + call.Function
+	if call.Path != "" {
+		target += "/" + call.Path
+	}
+	if call.Query != "" {
+		target += "?" + call.Query
+	}
+
+	result := newResponse()
+
+	req, err := http.NewRequest(call.Method, target, bytes.NewReader(call.Body))
+	if err != nil {
+		result.status = http.StatusBadRequest
+		result.body.WriteString(err.Error())
+		return result
+	}
+
+	for k, v := range call.Header {
+		req.Header[k] = append([]string(nil), v...)
+	}
+
+	d.invoke(result, mux.SetURLVars(req, map[string]string{"name": call.Function, "params": call.Path}))
+	if result.status == 0 {
+		result.status = http.StatusOK
+	}
+
+	return result
+}
+
+// callback posts the result of the call to its callback URL
+func (d *Dispatcher) callback(call *Call, result *response) {
+	if call.CallbackURL == "" {
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, call.CallbackURL, bytes.NewReader(result.body.Bytes()))
+	if err != nil {
+		callbackErrors.Inc()
+		logger.Warn(errors.Annotatef(err, "NewRequest [%s]", call.ID))
+		return
+	}
+
+	if contentType := result.header.Get("Content-Type"); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set(CallIDHeader, call.ID)
+	req.Header.Set(FunctionNameHeader, call.Function)
+	req.Header.Set(FunctionStatusHeader, fmt.Sprint(result.status))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		callbackErrors.Inc()
+		logger.Warn(errors.Annotatef(err, "Do [callback %s]", call.ID))
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		callbackErrors.Inc()
+		logger.Warnf("callback of call %s to %s answered %d", call.ID, call.CallbackURL, resp.StatusCode)
+	}
+}
+
+// backoff returns the delay before the next of attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.opts.Backoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if d.opts.MaxBackoff > 0 && delay >= d.opts.MaxBackoff {
+			return d.opts.MaxBackoff
+		}
+	}
+
+	return delay
+}
+
+// limit returns the concurrency limit of the function
+func (d *Dispatcher) limit(service string) int {
+	annotations, err := d.cache.Annotations(d.split(service))
+	if err != nil {
+		return d.opts.FunctionConcurrency
+	}
+
+	limit, err := ParseConcurrency(annotations)
+	if err != nil {
+		logger.Warnf("ignoring concurrency limit of %q: %v", service, err)
+	}
+
+	if limit == 0 {
+		return d.opts.FunctionConcurrency
+	}
+
+	return limit
+}
+
+func (d *Dispatcher) split(service string) (string, string) {
+	name, namespace := backend.SplitFunctionName(service)
+	if namespace == d.opts.DefaultNamespace {
+		namespace = ""
+	}
+
+	return name, namespace
+}
+
+// functionKey identifies the function of service
+func (d *Dispatcher) functionKey(service string) string {
+	name, namespace := d.split(service)
+	return name + "." + namespace
+}
+
+// response records the answer of the function proxy
+type response struct {
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func newResponse() *response {
+	return &response{header: make(http.Header)}
+}
+
+func (r *response) Header() http.Header {
+	return r.header
+}
+
+func (r *response) Write(buf []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	return r.body.Write(buf)
+}
+
+func (r *response) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
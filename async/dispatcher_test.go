@@ -0,0 +1,284 @@
+package async
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func newQueue(t *testing.T) (*BoltQueue, func()) {
+	dir, err := ioutil.TempDir("", "async")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue, err := NewBoltQueue(filepath.Join(dir, "async.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return queue, func() {
+		queue.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func newOptions() Options {
+	return Options{
+		Workers:          2,
+		MaxAttempts:      3,
+		Backoff:          time.Millisecond,
+		MaxBackoff:       time.Millisecond,
+		PollInterval:     5 * time.Millisecond,
+		DefaultNamespace: "faas-functions",
+	}
+}
+
+// start runs the dispatcher and returns a function stopping it
+func start(d *Dispatcher) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		d.Run(stop)
+		close(done)
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// waitFor polls condition until it holds or a second passed
+func waitFor(condition func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for !condition() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	return condition()
+}
+
+func Test_BoltQueue_Persists_Calls(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir, _ := ioutil.TempDir("", "async")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nested", "async.db")
+
+	queue, err := NewBoltQueue(path)
+	if !assert.NoError(err) {
+		return
+	}
+
+	first := Call{Function: "some-function", Method: "POST", Body: []byte("first")}
+	second := Call{Function: "other-function", Method: "POST", Body: []byte("second")}
+	assert.NoError(queue.Enqueue(&first))
+	assert.NoError(queue.Enqueue(&second))
+	assert.NoError(queue.DeadLetter(&first))
+	queue.Close()
+
+	// Act
+	reopened, err := NewBoltQueue(path)
+	if !assert.NoError(err) {
+		return
+	}
+	defer reopened.Close()
+
+	calls, pendingErr := reopened.Pending()
+	deadLetters, deadLettersErr := reopened.DeadLetters()
+
+	// Assert
+	assert.NoError(pendingErr)
+	assert.NoError(deadLettersErr)
+	assert.Len(first.ID, 32)
+	assert.NotEqual(first.ID, second.ID)
+	if assert.Len(calls, 1) {
+		assert.Equal(second.ID, calls[0].ID)
+		assert.Equal([]byte("second"), calls[0].Body)
+	}
+	if assert.Len(deadLetters, 1) {
+		assert.Equal(first.ID, deadLetters[0].ID)
+	}
+	assert.Error(reopened.Update(&first))
+}
+
+func Test_Dispatcher_Invokes_And_Calls_Back(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	queue, cleanup := newQueue(t)
+	defer cleanup()
+
+	results := make(chan *http.Request, 1)
+	bodies := make(chan string, 1)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(body)
+		results <- r
+	}))
+	defer callback.Close()
+
+	proxy := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(mux.Vars(r)["name"] + " " + mux.Vars(r)["params"] + "?" + r.URL.RawQuery + " " + string(body) + " " + r.Header.Get("X-Custom")))
+	}
+
+	d := NewDispatcher(queue, metastore.NewCache(metastore.NewMemoryStore(), "faas-functions", time.Minute), proxy, newOptions())
+	defer start(d)()
+
+	call := Call{
+		Function:    "some-function",
+		Method:      "POST",
+		Path:        "sub/path",
+		Query:       "a=1",
+		Header:      http.Header{"X-Custom": []string{"value"}},
+		Body:        []byte("hello"),
+		CallbackURL: callback.URL,
+	}
+
+	// Act
+	assert.NoError(d.Enqueue(&call))
+
+	// Assert
+	select {
+	case r := <-results:
+		assert.Equal("some-function sub/path?a=1 hello value", <-bodies)
+		assert.Equal(call.ID, r.Header.Get(CallIDHeader))
+		assert.Equal("some-function", r.Header.Get(FunctionNameHeader))
+		assert.Equal("200", r.Header.Get(FunctionStatusHeader))
+		assert.Equal("text/plain", r.Header.Get("Content-Type"))
+	case <-time.After(time.Second):
+		assert.Fail("no callback")
+	}
+
+	assert.True(waitFor(func() bool {
+		calls, _ := queue.Pending()
+		return len(calls) == 0
+	}))
+}
+
+func Test_Dispatcher_Retries_Then_Dead_Letters(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	queue, cleanup := newQueue(t)
+	defer cleanup()
+
+	mu := sync.Mutex{}
+	invocations := 0
+	proxy := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		invocations++
+		mu.Unlock()
+		http.Error(w, "function is paused", http.StatusServiceUnavailable)
+	}
+
+	d := NewDispatcher(queue, metastore.NewCache(metastore.NewMemoryStore(), "faas-functions", time.Minute), proxy, newOptions())
+	defer start(d)()
+
+	// Act
+	assert.NoError(d.Enqueue(&Call{Function: "some-function", Method: "POST"}))
+
+	// Assert
+	assert.True(waitFor(func() bool {
+		deadLetters, _ := d.DeadLetters()
+		return len(deadLetters) == 1
+	}))
+
+	deadLetters, _ := d.DeadLetters()
+	if assert.Len(deadLetters, 1) {
+		assert.Equal(3, deadLetters[0].Attempts)
+		assert.Equal(http.StatusServiceUnavailable, deadLetters[0].LastStatus)
+		assert.Equal("status 503: function is paused", deadLetters[0].LastError)
+	}
+
+	calls, _ := queue.Pending()
+	assert.Empty(calls)
+
+	mu.Lock()
+	assert.Equal(3, invocations)
+	mu.Unlock()
+}
+
+func Test_Dispatcher_Limits_Function_Concurrency(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	queue, cleanup := newQueue(t)
+	defer cleanup()
+
+	store := metastore.NewMemoryStore()
+	store.Put(&metastore.FunctionMeta{
+		Service:     "some-function",
+		Image:       "some/image",
+		Annotations: map[string]interface{}{ConcurrencyAnnotation: "1"},
+	})
+
+	mu := sync.Mutex{}
+	inflight, peak, done := 0, 0, 0
+	proxy := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inflight++
+		if inflight > peak {
+			peak = inflight
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inflight--
+		done++
+		mu.Unlock()
+	}
+
+	opts := newOptions()
+	opts.Workers = 4
+	d := NewDispatcher(queue, metastore.NewCache(store, "faas-functions", time.Minute), proxy, opts)
+
+	for i := 0; i < 4; i++ {
+		assert.NoError(d.Enqueue(&Call{Function: "some-function.faas-functions", Method: "POST"}))
+	}
+
+	// Act
+	defer start(d)()
+
+	// Assert
+	assert.True(waitFor(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return done == 4
+	}))
+
+	mu.Lock()
+	assert.Equal(1, peak)
+	mu.Unlock()
+}
+
+func Test_ParseConcurrency(t *testing.T) {
+	assert := assert.New(t)
+
+	limit, err := ParseConcurrency(map[string]string{ConcurrencyAnnotation: "3"})
+	assert.NoError(err)
+	assert.Equal(3, limit)
+
+	limit, err = ParseConcurrency(nil)
+	assert.NoError(err)
+	assert.Equal(0, limit)
+
+	_, err = ParseConcurrency(map[string]string{ConcurrencyAnnotation: "many"})
+	assert.Error(err)
+
+	_, err = ParseConcurrency(map[string]string{ConcurrencyAnnotation: "0"})
+	assert.Error(err)
+}
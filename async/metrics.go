@@ -0,0 +1,46 @@
+package async
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	outcomeSuccess    = "success"
+	outcomeFailure    = "failure"
+	outcomeRetry      = "retry"
+	outcomeDeadLetter = "dead-letter"
+)
+
+var (
+	queued = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "async",
+		Name:      "queued_total",
+		Help:      "Invocations accepted into the async queue.",
+	})
+
+	attempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "async",
+		Name:      "attempts_total",
+		Help:      "Processed async invocation attempts by outcome.",
+	}, []string{"outcome"})
+
+	pending = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "async",
+		Name:      "pending",
+		Help:      "Invocations waiting in the async queue.",
+	})
+
+	callbackErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "async",
+		Name:      "callback_errors_total",
+		Help:      "Results that could not be posted to the callback URL.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(queued, attempts, pending, callbackErrors)
+}
@@ -0,0 +1,165 @@
+// Package audit records the mutations done through the provider API
+package audit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	logger = logrus.WithField("package", "audit")
+
+	ErrLogClosed = errors.New("audit log closed")
+)
+
+const (
+	// Redacted replaces the values of changed env vars
+	Redacted = "[redacted]"
+
+	envVarsPrefix = "envVars."
+)
+
+// Operation is the kind of mutation an entry records
+type Operation string
+
+const (
+	OperationDeploy       Operation = "deploy"
+	OperationUpdate       Operation = "update"
+	OperationDelete       Operation = "delete"
+	OperationRestore      Operation = "restore"
+	OperationScale        Operation = "scale"
+	OperationPause        Operation = "pause"
+	OperationResume       Operation = "resume"
+	OperationCreateSecret Operation = "create-secret"
+	OperationUpdateSecret Operation = "update-secret"
+	OperationDeleteSecret Operation = "delete-secret"
+)
+
+// Outcome tells whether the mutation succeeded
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+)
+
+// Change is a field of the function spec changed by a mutation
+type Change struct {
+	Field  string `json:"field"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// Entry records a single mutation
+type Entry struct {
+	ID        uint64    `json:"id"`
+	Time      time.Time `json:"time"`
+	Operation Operation `json:"operation"`
+	Function  string    `json:"function,omitempty"`
+	Secret    string    `json:"secret,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Source    string    `json:"source"`
+	User      string    `json:"user,omitempty"`
+	Changes   []Change  `json:"changes,omitempty"`
+	Outcome   Outcome   `json:"outcome"`
+	Status    int       `json:"status"`
+}
+
+// Query selects entries, zero fields match all entries
+type Query struct {
+	Function  string
+	Namespace string
+	Since     time.Time
+	Until     time.Time
+	// Limit returns the latest Limit matching entries only
+	Limit int
+}
+
+func (q *Query) matches(e *Entry) bool {
+	return (q.Function == "" || q.Function == e.Function) &&
+		(q.Namespace == "" || q.Namespace == e.Namespace) &&
+		(q.Since.IsZero() || !e.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || e.Time.Before(q.Until))
+}
+
+// Log is an append only log of entries. Old entries are dropped by rotation only.
+type Log interface {
+	// Append assigns ID and Time if unset and stores the entry
+	Append(entry *Entry) error
+	// Query returns the matching entries in the order they were appended
+	Query(query Query) ([]Entry, error)
+	Close() error
+}
+
+// Diff lists the fields differing between two states of a function.
+// Either state may be nil if the function did not exist.
+func Diff(before, after *backend.Function) []Change {
+	b, a := fields(before), fields(after)
+
+	keys := make([]string, 0, len(b)+len(a))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := []Change{}
+	for _, k := range keys {
+		if b[k] != a[k] {
+			change := Change{Field: k, Before: b[k], After: a[k]}
+			// env vars often carry credentials
+			if strings.HasPrefix(k, envVarsPrefix) {
+				change.Before, change.After = redact(b, k), redact(a, k)
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	return changes
+}
+
+// redact hides the value of k, a missing field stays empty
+func redact(m map[string]string, k string) string {
+	if _, ok := m[k]; !ok {
+		return ""
+	}
+
+	return Redacted
+}
+
+// fields flattens the user visible spec of a function
+func fields(f *backend.Function) map[string]string {
+	m := make(map[string]string)
+	if f == nil {
+		return m
+	}
+
+	m["image"] = f.Image
+	m["replicas"] = fmt.Sprint(f.Replicas)
+	if f.EnvProcess != "" {
+		m["envProcess"] = f.EnvProcess
+	}
+	if len(f.Secrets) > 0 {
+		secrets := append([]string(nil), f.Secrets...)
+		sort.Strings(secrets)
+		m["secrets"] = strings.Join(secrets, ",")
+	}
+	for k, v := range f.EnvVars {
+		m[envVarsPrefix+k] = v
+	}
+	for k, v := range f.Labels {
+		m["labels."+k] = v
+	}
+
+	return m
+}
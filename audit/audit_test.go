@@ -0,0 +1,184 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/stretchr/testify/assert"
+)
+
+func tempLogPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "nested", "audit.db"), func() { os.RemoveAll(dir) }
+}
+
+func forEachLog(t *testing.T, maxEntries int, fn func(t *testing.T, log Log)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, NewMemoryLog(maxEntries))
+	})
+
+	t.Run("bolt", func(t *testing.T) {
+		path, cleanup := tempLogPath(t)
+		defer cleanup()
+
+		log, err := NewBoltLog(path, maxEntries, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer log.Close()
+
+		fn(t, log)
+	})
+}
+
+func Test_Log_Query_Filters_Entries(t *testing.T) {
+	forEachLog(t, 0, func(t *testing.T, log Log) {
+		assert := assert.New(t)
+		// Arrange
+		start := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+		for i, name := range []string{"a", "b", "a", "a"} {
+			entry := Entry{Operation: OperationDeploy, Function: name, Time: start.Add(time.Duration(i) * time.Hour)}
+			assert.NoError(log.Append(&entry))
+		}
+
+		// Act
+		all, allErr := log.Query(Query{})
+		byFunction, byFunctionErr := log.Query(Query{Function: "a"})
+		byTime, byTimeErr := log.Query(Query{Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour)})
+		latest, latestErr := log.Query(Query{Function: "a", Limit: 2})
+
+		// Assert
+		assert.NoError(allErr)
+		assert.NoError(byFunctionErr)
+		assert.NoError(byTimeErr)
+		assert.NoError(latestErr)
+
+		ids := func(entries []Entry) []uint64 {
+			ids := []uint64{}
+			for _, e := range entries {
+				ids = append(ids, e.ID)
+			}
+			return ids
+		}
+		assert.Equal([]uint64{1, 2, 3, 4}, ids(all))
+		assert.Equal([]uint64{1, 3, 4}, ids(byFunction))
+		assert.Equal([]uint64{2, 3}, ids(byTime))
+		assert.Equal([]uint64{3, 4}, ids(latest))
+	})
+}
+
+func Test_Log_Rotates_By_Count(t *testing.T) {
+	forEachLog(t, 3, func(t *testing.T, log Log) {
+		assert := assert.New(t)
+		// Arrange & Act
+		for i := 0; i < 5; i++ {
+			assert.NoError(log.Append(&Entry{Operation: OperationScale, Function: "a"}))
+		}
+
+		// Assert
+		entries, err := log.Query(Query{})
+		assert.NoError(err)
+		if assert.Len(entries, 3) {
+			assert.Equal(uint64(3), entries[0].ID)
+			assert.Equal(uint64(5), entries[2].ID)
+		}
+	})
+}
+
+func Test_BoltLog_Rotates_By_Age(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	path, cleanup := tempLogPath(t)
+	defer cleanup()
+
+	log, err := NewBoltLog(path, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	now := time.Now().UTC()
+	assert.NoError(log.Append(&Entry{Function: "old", Time: now.Add(-2 * time.Hour)}))
+
+	// Act
+	assert.NoError(log.Append(&Entry{Function: "new", Time: now}))
+
+	// Assert
+	entries, err := log.Query(Query{})
+	assert.NoError(err)
+	if assert.Len(entries, 1) {
+		assert.Equal("new", entries[0].Function)
+	}
+}
+
+func Test_BoltLog_Reopen_Keeps_Entries(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	path, cleanup := tempLogPath(t)
+	defer cleanup()
+
+	log, err := NewBoltLog(path, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(log.Append(&Entry{Function: "a"}))
+	assert.NoError(log.Append(&Entry{Function: "b"}))
+	assert.NoError(log.Close())
+
+	// Act
+	log, err = NewBoltLog(path, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	assert.NoError(log.Append(&Entry{Function: "c"}))
+
+	// Assert
+	entries, err := log.Query(Query{})
+	assert.NoError(err)
+	if assert.Len(entries, 2) {
+		assert.Equal(Entry{ID: 3, Function: "c", Time: entries[1].Time}, entries[1])
+		assert.Equal("b", entries[0].Function)
+	}
+}
+
+func Test_Diff_Lists_Changed_Fields(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	before := &backend.Function{
+		Name:     "some-function",
+		Image:    "some/image:1",
+		Replicas: 1,
+		EnvVars:  map[string]string{"KEEP": "1", "DROP": "x", "TOKEN": "old-secret"},
+	}
+	after := &backend.Function{
+		Name:     "some-function",
+		Image:    "some/image:2",
+		Replicas: 1,
+		EnvVars:  map[string]string{"KEEP": "1", "ADD": "y", "TOKEN": "new-secret"},
+		Secrets:  []string{"b", "a"},
+	}
+
+	// Act
+	changes := Diff(before, after)
+	created := Diff(nil, before)
+
+	// Assert
+	assert.Equal([]Change{
+		{Field: "envVars.ADD", After: Redacted},
+		{Field: "envVars.DROP", Before: Redacted},
+		{Field: "envVars.TOKEN", Before: Redacted, After: Redacted},
+		{Field: "image", Before: "some/image:1", After: "some/image:2"},
+		{Field: "secrets", After: "a,b"},
+	}, changes)
+	assert.Len(created, 5)
+	assert.Empty(Diff(after, after))
+}
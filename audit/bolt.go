@@ -0,0 +1,176 @@
+package audit
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/juju/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketNameEntries = []byte("entries")
+)
+
+// BoltLog implements Log in a bolt database file. Entries are keyed by
+// their ID, so the bucket is ordered by the time they were appended.
+type BoltLog struct {
+	database   *bolt.DB
+	maxEntries int
+	maxAge     time.Duration
+	// count of stored entries, only accessed in update transactions
+	count int
+}
+
+// NewBoltLog opens the log stored in path. Appending rotates out the
+// oldest entries beyond maxEntries or older than maxAge, zero values
+// disable the respective limit.
+func NewBoltLog(path string, maxEntries int, maxAge time.Duration) (*BoltLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Annotate(err, "MkdirAll")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Annotate(err, "Open")
+	}
+
+	l := BoltLog{
+		database:   db,
+		maxEntries: maxEntries,
+		maxAge:     maxAge,
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucketNameEntries)
+		if err != nil {
+			return errors.Annotate(err, "CreateBucketIfNotExists")
+		}
+
+		l.count = bucket.Stats().KeyN
+		return nil
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, errors.Annotate(err, "Update")
+	}
+
+	return &l, nil
+}
+
+// Close closes the database
+func (l *BoltLog) Close() (err error) {
+	if l.database != nil {
+		err = l.database.Close()
+		l.database = nil
+	}
+	return err
+}
+
+// Append stores the entry and rotates the log
+func (l *BoltLog) Append(entry *Entry) error {
+	if l.database == nil {
+		return ErrLogClosed
+	}
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	err := l.database.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketNameEntries)
+
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return errors.Annotate(err, "NextSequence")
+		}
+		entry.ID = id
+
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			return errors.Annotate(err, "Marshal")
+		}
+
+		if err := bucket.Put(key(id), buf); err != nil {
+			return errors.Annotate(err, "Put")
+		}
+		l.count++
+
+		return errors.Annotate(l.rotate(bucket, entry.Time), "rotate")
+	})
+
+	return errors.Annotate(err, "Update")
+}
+
+// rotate deletes the oldest entries exceeding the limits
+func (l *BoltLog) rotate(bucket *bolt.Bucket, now time.Time) error {
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.First() {
+		if l.maxEntries <= 0 || l.count <= l.maxEntries {
+			if l.maxAge <= 0 {
+				return nil
+			}
+
+			entry := Entry{}
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return errors.Annotate(err, "Unmarshal")
+			}
+
+			if now.Sub(entry.Time) <= l.maxAge {
+				return nil
+			}
+		}
+
+		if err := c.Delete(); err != nil {
+			return errors.Annotate(err, "Delete")
+		}
+		l.count--
+	}
+
+	return nil
+}
+
+// Query returns the matching entries
+func (l *BoltLog) Query(query Query) ([]Entry, error) {
+	if l.database == nil {
+		return nil, ErrLogClosed
+	}
+
+	entries := []Entry{}
+	err := l.database.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketNameEntries).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if query.Limit > 0 && len(entries) == query.Limit {
+				break
+			}
+
+			entry := Entry{}
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return errors.Annotate(err, "Unmarshal")
+			}
+
+			if query.matches(&entry) {
+				entries = append(entries, entry)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.Annotate(err, "View")
+	}
+
+	reverse(entries)
+	return entries, nil
+}
+
+func key(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
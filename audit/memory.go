@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryLog implements Log in memory, it is meant for tests
+type MemoryLog struct {
+	mu         sync.Mutex
+	entries    []Entry
+	sequence   uint64
+	maxEntries int
+}
+
+// NewMemoryLog creates an empty log keeping at most maxEntries entries, 0 keeps all
+func NewMemoryLog(maxEntries int) *MemoryLog {
+	return &MemoryLog{maxEntries: maxEntries}
+}
+
+// Append stores the entry
+func (l *MemoryLog) Append(entry *Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sequence++
+	entry.ID = l.sequence
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	l.entries = append(l.entries, *entry)
+	if l.maxEntries > 0 && len(l.entries) > l.maxEntries {
+		l.entries = append([]Entry(nil), l.entries[len(l.entries)-l.maxEntries:]...)
+	}
+
+	return nil
+}
+
+// Query returns the matching entries
+func (l *MemoryLog) Query(query Query) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := []Entry{}
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if query.Limit > 0 && len(entries) == query.Limit {
+			break
+		}
+		if query.matches(&l.entries[i]) {
+			entries = append(entries, l.entries[i])
+		}
+	}
+
+	reverse(entries)
+	return entries, nil
+}
+
+// Close does nothing
+func (l *MemoryLog) Close() error {
+	return nil
+}
+
+func reverse(entries []Entry) {
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+}
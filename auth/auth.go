@@ -0,0 +1,440 @@
+// Package auth authenticates requests to the provider API. Credentials
+// are read from a directory of files like other OpenFaaS providers do
+// and reloaded when the files change.
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	logger = logrus.WithField("package", "auth")
+)
+
+// Mode selects the scheme accepted besides basic auth
+type Mode string
+
+const (
+	// ModeBasic accepts basic auth only
+	ModeBasic Mode = "basic"
+	// ModeBearer also accepts bearer tokens
+	ModeBearer Mode = "bearer"
+	// ModeHMAC also accepts requests signed with a shared secret
+	ModeHMAC Mode = "hmac"
+)
+
+const (
+	// UserFile holds the basic auth user
+	UserFile = "basic-auth-user"
+	// PasswordFile holds the basic auth password
+	PasswordFile = "basic-auth-password"
+	// TokensFile holds one identity:token pair per line
+	TokensFile = "api-tokens"
+	// HMACSecretsFile holds one identity:secret pair per line
+	HMACSecretsFile = "hmac-secrets"
+
+	// TimestampHeader carries the unix time signed requests were created at
+	TimestampHeader = "X-Timestamp"
+	// MaxSkew is the maximum age of signed requests
+	MaxSkew = 5 * time.Minute
+	// MaxBodyBytes limits the bodies read to verify signatures
+	MaxBodyBytes = 10 << 20
+
+	// ProtectedPrefix is the path prefix of the routes requiring authentication
+	ProtectedPrefix = "/system/"
+)
+
+var (
+	ErrInvalidMode        = errors.New("invalid auth mode")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+type contextKey struct{}
+
+// Credentials are the credentials accepted by an Authenticator
+type Credentials struct {
+	User     string
+	Password string
+	// identities by token or hmac secret
+	Tokens  map[string]string
+	Secrets map[string]string
+}
+
+// Authenticator authenticates requests with the credentials read from a directory
+type Authenticator struct {
+	dir  string
+	mode Mode
+
+	mu          sync.RWMutex
+	credentials *Credentials
+	files       string
+
+	// signatures accepted until they expire, a signed request is
+	// accepted only once
+	seenMu    sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewAuthenticator reads the credentials of mode from dir
+func NewAuthenticator(dir string, mode Mode) (*Authenticator, error) {
+	switch mode {
+	case ModeBasic, ModeBearer, ModeHMAC:
+	default:
+		return nil, errors.Annotatef(ErrInvalidMode, "%q", mode)
+	}
+
+	a := Authenticator{
+		dir:  dir,
+		mode: mode,
+		seen: make(map[string]time.Time),
+	}
+
+	if err := a.Reload(); err != nil {
+		return nil, errors.Annotate(err, "Reload")
+	}
+
+	return &a, nil
+}
+
+// Reload reads the credential files again. The previous credentials
+// are kept if they can't be read.
+func (a *Authenticator) Reload() error {
+	files, err := a.readFiles()
+	if err != nil {
+		return errors.Annotate(err, "readFiles")
+	}
+
+	credentials, err := parseCredentials(a.mode, files)
+	if err != nil {
+		return errors.Annotate(err, "parseCredentials")
+	}
+
+	a.mu.Lock()
+	a.credentials = credentials
+	a.files = strings.Join(files, "\x00")
+	a.mu.Unlock()
+
+	return nil
+}
+
+// Watch polls the credential files for changes until stop is closed.
+// Failed reloads are logged and retried with the next change.
+func (a *Authenticator) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var failed string
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		files, err := a.readFiles()
+		if err != nil {
+			logger.Error(errors.Annotate(err, "readFiles"))
+			continue
+		}
+
+		current := strings.Join(files, "\x00")
+		a.mu.RLock()
+		unchanged := current == a.files
+		a.mu.RUnlock()
+
+		// don't retry invalid credentials on every tick
+		if unchanged || current == failed {
+			continue
+		}
+
+		if err := a.Reload(); err != nil {
+			logger.Errorf("reloading api credentials failed, keeping previous credentials: %v", err)
+			failed = current
+			continue
+		}
+
+		logger.Info("reloaded api credentials")
+		failed = ""
+	}
+}
+
+// Middleware rejects unauthenticated requests to the protected routes
+// with 401 Unauthorized. The identity of authenticated requests is
+// available from Identity.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, ProtectedPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity, err := a.Authenticate(r)
+		if err != nil {
+			logger.Debugf("rejected %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, WithIdentity(r, identity))
+	})
+}
+
+// Authenticate returns the identity the request authenticates as
+func (a *Authenticator) Authenticate(r *http.Request) (string, error) {
+	a.mu.RLock()
+	credentials := a.credentials
+	a.mu.RUnlock()
+
+	if user, password, ok := r.BasicAuth(); ok {
+		// both are compared in constant time, the outcome doesn't tell
+		// which of them was wrong
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(credentials.User))
+		passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(credentials.Password))
+		if userOK&passwordOK == 1 {
+			return user, nil
+		}
+
+		return "", ErrInvalidCredentials
+	}
+
+	scheme, value := splitAuthorization(r.Header.Get("Authorization"))
+	switch {
+	case scheme == "bearer" && a.mode == ModeBearer:
+		for token, identity := range credentials.Tokens {
+			if equal(value, token) {
+				return identity, nil
+			}
+		}
+	case scheme == "hmac" && a.mode == ModeHMAC:
+		identity, expires, err := verifySignature(r, value, credentials.Secrets)
+		if err != nil {
+			return "", err
+		}
+
+		if !a.firstUse(value, expires) {
+			return "", errors.Annotate(ErrInvalidCredentials, "replayed signature")
+		}
+
+		return identity, nil
+	}
+
+	return "", ErrInvalidCredentials
+}
+
+// Identity returns the identity the request was authenticated as by the
+// Middleware, empty if it wasn't
+func Identity(r *http.Request) string {
+	identity, _ := r.Context().Value(contextKey{}).(string)
+	return identity
+}
+
+// WithIdentity returns a copy of the request authenticated as identity
+func WithIdentity(r *http.Request, identity string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), contextKey{}, identity))
+}
+
+// Sign signs the request with the secret of identity. The body of r is
+// read and restored.
+func Sign(r *http.Request, identity, secret string, now time.Time) error {
+	body, err := readBody(r)
+	if err != nil {
+		return errors.Annotate(err, "readBody")
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	r.Header.Set(TimestampHeader, timestamp)
+	r.Header.Set("Authorization", "HMAC "+identity+":"+signature(secret, timestamp, r, body))
+	return nil
+}
+
+// verifySignature checks the identity:signature value of a signed request
+// and returns the identity and the time the signature expires
+func verifySignature(r *http.Request, value string, secrets map[string]string) (string, time.Time, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return "", time.Time{}, ErrInvalidCredentials
+	}
+
+	identity, sig := parts[0], parts[1]
+	secret, ok := secrets[identity]
+	if !ok {
+		return "", time.Time{}, ErrInvalidCredentials
+	}
+
+	timestamp := r.Header.Get(TimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", time.Time{}, errors.Annotate(ErrInvalidCredentials, "timestamp")
+	}
+
+	signed := time.Unix(unix, 0)
+	skew := time.Since(signed)
+	if skew > MaxSkew || skew < -MaxSkew {
+		return "", time.Time{}, errors.Annotate(ErrInvalidCredentials, "expired signature")
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return "", time.Time{}, errors.Annotate(err, "readBody")
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, timestamp, r, body))) {
+		return "", time.Time{}, ErrInvalidCredentials
+	}
+
+	return identity, signed.Add(MaxSkew), nil
+}
+
+// firstUse remembers the signature until it expires and reports whether
+// it was not seen before
+func (a *Authenticator) firstUse(sig string, expires time.Time) bool {
+	a.seenMu.Lock()
+	defer a.seenMu.Unlock()
+
+	now := time.Now()
+	if now.Sub(a.lastSweep) > MaxSkew {
+		for seen, until := range a.seen {
+			if now.After(until) {
+				delete(a.seen, seen)
+			}
+		}
+		a.lastSweep = now
+	}
+
+	if _, ok := a.seen[sig]; ok {
+		return false
+	}
+
+	a.seen[sig] = expires
+	return true
+}
+
+// signature signs the timestamp, method, request URI and body of a request
+func signature(secret, timestamp string, r *http.Request, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + r.Method + "\n" + r.URL.RequestURI() + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// readFiles reads the credential files of the mode
+func (a *Authenticator) readFiles() ([]string, error) {
+	names := []string{UserFile, PasswordFile}
+	switch a.mode {
+	case ModeBearer:
+		names = append(names, TokensFile)
+	case ModeHMAC:
+		names = append(names, HMACSecretsFile)
+	}
+
+	files := make([]string, 0, len(names))
+	for _, name := range names {
+		buf, err := ioutil.ReadFile(strings.TrimSuffix(a.dir, "/") + "/" + name)
+		if err != nil {
+			return nil, errors.Annotatef(err, "ReadFile [%s]", name)
+		}
+
+		files = append(files, string(buf))
+	}
+
+	return files, nil
+}
+
+// parseCredentials parses the contents of the credential files read by readFiles
+func parseCredentials(mode Mode, files []string) (*Credentials, error) {
+	credentials := Credentials{
+		User:     strings.TrimSpace(files[0]),
+		Password: strings.TrimSpace(files[1]),
+	}
+
+	if credentials.User == "" || credentials.Password == "" {
+		return nil, errors.New("basic auth user and password must not be empty")
+	}
+
+	var err error
+	switch mode {
+	case ModeBearer:
+		credentials.Tokens, err = parsePairs(files[2], true)
+		if err != nil {
+			return nil, errors.Annotate(err, TokensFile)
+		}
+	case ModeHMAC:
+		credentials.Secrets, err = parsePairs(files[2], false)
+		if err != nil {
+			return nil, errors.Annotate(err, HMACSecretsFile)
+		}
+	}
+
+	return &credentials, nil
+}
+
+// parsePairs parses identity:value lines, skipping empty lines and
+// comments. The pairs are keyed by value if byValue is set.
+func parsePairs(content string, byValue bool) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, errors.Errorf("line %d: expected identity:value", i+1)
+		}
+
+		identity, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if byValue {
+			pairs[value] = identity
+			continue
+		}
+		pairs[identity] = value
+	}
+
+	if len(pairs) == 0 {
+		return nil, errors.New("no credentials")
+	}
+
+	return pairs, nil
+}
+
+func splitAuthorization(header string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+
+	return strings.ToLower(parts[0]), strings.TrimSpace(parts[1])
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// readBody reads up to MaxBodyBytes of the body and restores it
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, MaxBodyBytes))
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, err
+}
@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// credentialsDir creates a directory holding the credential files
+func credentialsDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, dir, files)
+	return dir
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// serve passes the request through the middleware and returns the response
+// and the identity seen by the handler
+func serve(a *Authenticator, req *http.Request) (*httptest.ResponseRecorder, string) {
+	identity := ""
+	handler := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = Identity(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr, identity
+}
+
+func Test_Middleware_Basic_Auth(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir := credentialsDir(t, map[string]string{UserFile: "admin\n", PasswordFile: "secret\n"})
+	defer os.RemoveAll(dir)
+
+	a, err := NewAuthenticator(dir, ModeBasic)
+	if !assert.NoError(err) {
+		return
+	}
+
+	valid, _ := http.NewRequest("GET", "/system/functions", nil)
+	valid.SetBasicAuth("admin", "secret")
+	invalid, _ := http.NewRequest("GET", "/system/functions", nil)
+	invalid.SetBasicAuth("admin", "wrong")
+	unknown, _ := http.NewRequest("GET", "/system/functions", nil)
+	unknown.SetBasicAuth("root", "secret")
+	missing, _ := http.NewRequest("GET", "/system/functions", nil)
+	health, _ := http.NewRequest("GET", "/healthz", nil)
+
+	// Act
+	validRR, identity := serve(a, valid)
+	invalidRR, _ := serve(a, invalid)
+	unknownRR, _ := serve(a, unknown)
+	missingRR, _ := serve(a, missing)
+	healthRR, _ := serve(a, health)
+
+	// Assert
+	assert.Equal(http.StatusOK, validRR.Code)
+	assert.Equal("admin", identity)
+	assert.Equal(http.StatusUnauthorized, invalidRR.Code)
+	assert.Equal(http.StatusUnauthorized, unknownRR.Code)
+	assert.Equal(invalidRR.Body.String(), unknownRR.Body.String())
+	assert.Equal(http.StatusUnauthorized, missingRR.Code)
+	assert.Equal(`Basic realm="Restricted"`, missingRR.Header().Get("WWW-Authenticate"))
+	assert.Equal(http.StatusOK, healthRR.Code)
+}
+
+func Test_Middleware_Bearer_Tokens(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir := credentialsDir(t, map[string]string{
+		UserFile:     "admin",
+		PasswordFile: "secret",
+		TokensFile:   "# deploy pipeline\nci:ci-token\n\noncall:oncall-token\n",
+	})
+	defer os.RemoveAll(dir)
+
+	a, err := NewAuthenticator(dir, ModeBearer)
+	if !assert.NoError(err) {
+		return
+	}
+
+	req, _ := http.NewRequest("POST", "/system/functions", nil)
+	req.Header.Set("Authorization", "Bearer oncall-token")
+	unknown, _ := http.NewRequest("POST", "/system/functions", nil)
+	unknown.Header.Set("Authorization", "Bearer other-token")
+
+	// Act
+	rr, identity := serve(a, req)
+	unknownRR, _ := serve(a, unknown)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("oncall", identity)
+	assert.Equal(http.StatusUnauthorized, unknownRR.Code)
+}
+
+func Test_Middleware_Rejects_Bearer_Tokens_In_Basic_Mode(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir := credentialsDir(t, map[string]string{
+		UserFile:     "admin",
+		PasswordFile: "secret",
+		TokensFile:   "ci:ci-token",
+	})
+	defer os.RemoveAll(dir)
+
+	a, _ := NewAuthenticator(dir, ModeBasic)
+	req, _ := http.NewRequest("GET", "/system/functions", nil)
+	req.Header.Set("Authorization", "Bearer ci-token")
+
+	// Act
+	rr, _ := serve(a, req)
+
+	// Assert
+	assert.Equal(http.StatusUnauthorized, rr.Code)
+}
+
+func Test_Middleware_HMAC_Signatures(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir := credentialsDir(t, map[string]string{
+		UserFile:        "admin",
+		PasswordFile:    "secret",
+		HMACSecretsFile: "ci:shared-secret",
+	})
+	defer os.RemoveAll(dir)
+
+	a, err := NewAuthenticator(dir, ModeHMAC)
+	if !assert.NoError(err) {
+		return
+	}
+
+	body := []byte(`{"service":"some-function"}`)
+	signed, _ := http.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+	Sign(signed, "ci", "shared-secret", time.Now())
+
+	tampered, _ := http.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+	Sign(tampered, "ci", "shared-secret", time.Now())
+	tampered.Body = ioutil.NopCloser(bytes.NewReader([]byte(`{"service":"other-function"}`)))
+
+	expired, _ := http.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+	Sign(expired, "ci", "shared-secret", time.Now().Add(-2*MaxSkew))
+
+	// Act
+	var received []byte
+	handler := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = ioutil.ReadAll(r.Body)
+	}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, signed)
+	tamperedRR, _ := serve(a, tampered)
+	expiredRR, _ := serve(a, expired)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal(body, received)
+	assert.Equal(http.StatusUnauthorized, tamperedRR.Code)
+	assert.Equal(http.StatusUnauthorized, expiredRR.Code)
+}
+
+func Test_Middleware_Rejects_Replayed_And_Oversized_Requests(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir := credentialsDir(t, map[string]string{
+		UserFile:        "admin",
+		PasswordFile:    "secret",
+		HMACSecretsFile: "ci:shared-secret",
+	})
+	defer os.RemoveAll(dir)
+
+	a, err := NewAuthenticator(dir, ModeHMAC)
+	if !assert.NoError(err) {
+		return
+	}
+
+	body := []byte(`{"service":"some-function"}`)
+	signed, _ := http.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+	Sign(signed, "ci", "shared-secret", time.Now())
+
+	replayed, _ := http.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+	replayed.Header = signed.Header
+
+	oversized, _ := http.NewRequest("POST", "/system/functions", bytes.NewReader(make([]byte, MaxBodyBytes+1)))
+	oversized.Header.Set(TimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	oversized.Header.Set("Authorization", "HMAC ci:signature")
+
+	// Act
+	signedRR, _ := serve(a, signed)
+	replayedRR, _ := serve(a, replayed)
+	oversizedRR, _ := serve(a, oversized)
+
+	// Assert
+	assert.Equal(http.StatusOK, signedRR.Code)
+	assert.Equal(http.StatusUnauthorized, replayedRR.Code)
+	assert.Equal(http.StatusUnauthorized, oversizedRR.Code)
+}
+
+func Test_NewAuthenticator_Missing_Files(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir := credentialsDir(t, map[string]string{UserFile: "admin", PasswordFile: "secret"})
+	defer os.RemoveAll(dir)
+
+	// Act
+	_, bearerErr := NewAuthenticator(dir, ModeBearer)
+	_, modeErr := NewAuthenticator(dir, Mode("digest"))
+
+	// Assert
+	assert.Error(bearerErr)
+	assert.Error(modeErr)
+}
+
+func Test_Watch_Reloads_Changed_Credentials(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir := credentialsDir(t, map[string]string{UserFile: "admin", PasswordFile: "secret"})
+	defer os.RemoveAll(dir)
+
+	a, _ := NewAuthenticator(dir, ModeBasic)
+	stop := make(chan struct{})
+	defer close(stop)
+	go a.Watch(10*time.Millisecond, stop)
+
+	authenticates := func(password string) bool {
+		req, _ := http.NewRequest("GET", "/system/functions", nil)
+		req.SetBasicAuth("admin", password)
+		_, err := a.Authenticate(req)
+		return err == nil
+	}
+
+	// Act
+	writeFiles(t, dir, map[string]string{PasswordFile: "rotated"})
+
+	// Assert
+	deadline := time.Now().Add(time.Second)
+	for !authenticates("rotated") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(authenticates("rotated"))
+	assert.False(authenticates("secret"))
+
+	// invalid credentials keep the previous ones
+	writeFiles(t, dir, map[string]string{PasswordFile: ""})
+	time.Sleep(50 * time.Millisecond)
+	assert.True(authenticates("rotated"))
+}
@@ -0,0 +1,157 @@
+// Package backend defines the orchestration neutral interface the handlers
+// depend on. Every supported orchestrator (Rancher 1.x, plain Docker Engine)
+// provides an implementation of Backend.
+package backend
+
+import (
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/openfaas/faas-provider/types"
+)
+
+const (
+	// FunctionLabel is the label set to faas function services and containers
+	FunctionLabel = "faas_function"
+	// PortLabel overrides the port the watchdog of a function listens on
+	PortLabel = "com.openfaas.rancher.port"
+
+	// StateActive is reported for functions which are ready to serve requests
+	StateActive = "active"
+	// StateInactive is reported for functions which are stopped
+	StateInactive = "inactive"
+)
+
+// Status summarizes the state of a function independent of the backend
+const (
+	StatusReady     = "ready"
+	StatusDeploying = "deploying"
+	StatusDegraded  = "degraded"
+	StatusUnhealthy = "unhealthy"
+	StatusInactive  = "inactive"
+	StatusPaused    = "paused"
+	StatusRemoving  = "removing"
+	StatusError     = "error"
+	StatusUnknown   = "unknown"
+)
+
+var (
+	ErrFunctionNotFound = errors.New("function not found")
+	ErrSecretNotFound   = errors.New("secret not found")
+	ErrNotSupported     = errors.New("operation not supported by backend")
+	ErrInvalidNamespace = errors.New("invalid namespace")
+	// ErrFunctionInactive is returned by backends which can't update
+	// inactive (e.g. paused) functions
+	ErrFunctionInactive = errors.New("function is not active")
+	ErrFunctionExists   = errors.New("function exists already")
+)
+
+// FunctionSpec describes the desired state of a function
+type FunctionSpec struct {
+	Name        string
+	Namespace   string
+	Image       string
+	EnvProcess  string
+	EnvVars     map[string]string
+	Labels      map[string]string
+	Constraints []string
+	Secrets     []string
+	// Paused keeps the replicas of an updated function stopped
+	Paused bool
+}
+
+// Function is the state of a deployed function as reported by a Backend
+type Function struct {
+	Name              string
+	Namespace         string
+	Image             string
+	EnvProcess        string
+	EnvVars           map[string]string
+	Labels            map[string]string
+	Secrets           []string
+	Replicas          uint64
+	AvailableReplicas uint64
+	// State is the state reported by the orchestrator
+	State string
+	// Status is State mapped to one of the Status constants
+	Status string
+	// Origin names the backend reporting the function if several are combined
+	Origin string
+	// Draining is set while the function finishes its requests before it is removed
+	Draining bool
+	// InFlight and Queued count the requests served by functions with a
+	// concurrency limit and the requests waiting for them
+	InFlight int
+	Queued   int
+}
+
+// Instance is a replica of a function reachable at Address
+type Instance struct {
+	ID      string
+	Address string
+	// Healthy is false while the health check of the instance fails or is pending
+	Healthy bool
+}
+
+// Backend is implemented by every orchestrator faas-rancher can deploy functions to.
+// An empty namespace selects the default namespace of the backend.
+type Backend interface {
+	// ListNamespaces lists the namespaces functions can be deployed to
+	ListNamespaces() ([]string, error)
+	// ListFunctions lists all functions managed by the backend
+	ListFunctions(namespace string) ([]Function, error)
+	// FindFunction returns ErrFunctionNotFound if there is no function with that name
+	FindFunction(name, namespace string) (*Function, error)
+	DeployFunction(spec *FunctionSpec) error
+	UpdateFunction(spec *FunctionSpec) error
+	DeleteFunction(name, namespace string) error
+	ScaleFunction(name, namespace string, replicas uint64) error
+	// DeactivateFunction stops all replicas, the function and its scale are kept
+	DeactivateFunction(name, namespace string) error
+	// ActivateFunction starts the replicas of a deactivated function again
+	ActivateFunction(name, namespace string) error
+	// ListInstances lists the running replicas of a function
+	ListInstances(name, namespace string) ([]Instance, error)
+
+	ListSecrets(namespace string) ([]types.Secret, error)
+	// EnsureSecret creates the secret or updates its value if it already exists
+	EnsureSecret(secret *types.Secret) error
+	// DeleteSecret returns ErrSecretNotFound if there is no secret with that name
+	DeleteSecret(name, namespace string) error
+}
+
+// SplitFunctionName splits the name.namespace form the gateway passes to the proxy
+func SplitFunctionName(service string) (string, string) {
+	parts := strings.SplitN(service, ".", 2)
+	if len(parts) == 1 {
+		return service, ""
+	}
+
+	return parts[0], parts[1]
+}
+
+// SpecFromDeployment converts an OpenFaaS deployment request into a FunctionSpec
+func SpecFromDeployment(req *types.FunctionDeployment) *FunctionSpec {
+	spec := &FunctionSpec{
+		Name:        req.Service,
+		Namespace:   req.Namespace,
+		Image:       req.Image,
+		EnvProcess:  req.EnvProcess,
+		EnvVars:     make(map[string]string),
+		Labels:      make(map[string]string),
+		Constraints: req.Constraints,
+		Secrets:     req.Secrets,
+	}
+
+	for k, v := range req.EnvVars {
+		spec.EnvVars[k] = v
+	}
+
+	if req.Labels != nil {
+		for k, v := range *req.Labels {
+			spec.Labels[k] = v
+		}
+	}
+
+	return spec
+}
@@ -0,0 +1,339 @@
+package backend
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/openfaas/faas-provider/types"
+)
+
+// Route configures one of the backends combined by a Router
+type Route struct {
+	// Name of the route, reported as Function.Origin
+	Name    string
+	Backend Backend
+	// Namespaces served by this backend
+	Namespaces []string
+}
+
+// Router implements Backend by routing every operation to one of several
+// backends, e.g. one per Rancher environment. The target of a deployment is
+// selected by the routing label of the function, then by its namespace and
+// falls back to the default route. Existing functions are looked up in the
+// backend they were last seen in.
+type Router struct {
+	routes       map[string]Backend
+	names        []string
+	namespaces   map[string]string
+	label        string
+	defaultRoute string
+
+	mu sync.RWMutex
+	// route names by function key
+	located map[string]string
+}
+
+// NewRouter creates a router for routes. Functions with the label set are
+// deployed to the route named by its value.
+func NewRouter(routes []Route, defaultRoute string, label string) (*Router, error) {
+	r := Router{
+		routes:       make(map[string]Backend),
+		namespaces:   make(map[string]string),
+		label:        label,
+		defaultRoute: defaultRoute,
+		located:      make(map[string]string),
+	}
+
+	for _, route := range routes {
+		if _, ok := r.routes[route.Name]; ok {
+			return nil, errors.Errorf("duplicate route %q", route.Name)
+		}
+
+		r.routes[route.Name] = route.Backend
+		r.names = append(r.names, route.Name)
+
+		for _, namespace := range route.Namespaces {
+			if other, ok := r.namespaces[namespace]; ok {
+				return nil, errors.Errorf("namespace %q mapped to %q and %q", namespace, other, route.Name)
+			}
+			r.namespaces[namespace] = route.Name
+		}
+	}
+
+	if _, ok := r.routes[defaultRoute]; !ok {
+		return nil, errors.Errorf("default route %q not configured", defaultRoute)
+	}
+
+	sort.Strings(r.names)
+	return &r, nil
+}
+
+// Route returns the name of the route serving the function. The backends
+// are only queried if the function has not been seen before.
+func (r *Router) Route(name, namespace string) (string, error) {
+	if route, ok := r.lookup(name, namespace); ok {
+		return route, nil
+	}
+
+	route, _, err := r.locate(name, namespace)
+	if err != nil {
+		return "", errors.Annotate(err, "locate")
+	}
+
+	return route, nil
+}
+
+// ListNamespaces aggregates the namespaces of all routes
+func (r *Router) ListNamespaces() ([]string, error) {
+	seen := make(map[string]bool)
+	namespaces := []string{}
+	for _, name := range r.names {
+		list, err := r.routes[name].ListNamespaces()
+		if err != nil {
+			if errors.Cause(err) == ErrNotSupported {
+				continue
+			}
+			return nil, errors.Annotatef(err, "ListNamespaces [%s]", name)
+		}
+
+		for _, namespace := range list {
+			if !seen[namespace] {
+				seen[namespace] = true
+				namespaces = append(namespaces, namespace)
+			}
+		}
+	}
+
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// ListFunctions aggregates the functions of all routes serving the namespace
+func (r *Router) ListFunctions(namespace string) ([]Function, error) {
+	names := r.names
+	if route, ok := r.namespaces[namespace]; ok {
+		names = []string{route}
+	}
+
+	functions := []Function{}
+	for _, name := range names {
+		list, err := r.routes[name].ListFunctions(namespace)
+		if err != nil {
+			return nil, errors.Annotatef(err, "ListFunctions [%s]", name)
+		}
+
+		for _, function := range list {
+			function.Origin = name
+			r.remember(function.Name, namespace, name)
+			functions = append(functions, function)
+		}
+	}
+
+	return functions, nil
+}
+
+// FindFunction finds the function in the route it was last seen in,
+// the route of its namespace or any other route
+func (r *Router) FindFunction(name, namespace string) (*Function, error) {
+	route, function, err := r.locate(name, namespace)
+	if err != nil {
+		return nil, errors.Annotate(err, "locate")
+	}
+
+	function.Origin = route
+	return function, nil
+}
+
+// DeployFunction deploys the function to the route selected by its label or
+// namespace. Function names are unique across routes, the metastore and the
+// proxy know functions by name and namespace only; deploying a function
+// running in another route returns ErrFunctionExists.
+func (r *Router) DeployFunction(spec *FunctionSpec) error {
+	route := r.routeForNamespace(spec.Namespace)
+	if value, ok := spec.Labels[r.label]; ok && r.label != "" {
+		if _, ok := r.routes[value]; !ok {
+			return errors.Errorf("unknown route %q in label %s", value, r.label)
+		}
+		route = value
+	}
+
+	existing, _, err := r.locate(spec.Name, spec.Namespace)
+	if err == nil && existing != route {
+		return errors.Annotatef(ErrFunctionExists, "function %q runs in %q", spec.Name, existing)
+	}
+	if err != nil && errors.Cause(err) != ErrFunctionNotFound {
+		return errors.Annotate(err, "locate")
+	}
+
+	if err := r.routes[route].DeployFunction(spec); err != nil {
+		return errors.Annotatef(err, "DeployFunction [%s]", route)
+	}
+
+	r.remember(spec.Name, spec.Namespace, route)
+	return nil
+}
+
+// UpdateFunction updates the function in the route it is running in.
+// Functions are never moved between routes.
+func (r *Router) UpdateFunction(spec *FunctionSpec) error {
+	route, _, err := r.locate(spec.Name, spec.Namespace)
+	if err != nil {
+		return errors.Annotate(err, "locate")
+	}
+
+	if value, ok := spec.Labels[r.label]; ok && r.label != "" && value != route {
+		return errors.Errorf("function %q runs in %q and can't be moved to %q", spec.Name, route, value)
+	}
+
+	return errors.Annotatef(r.routes[route].UpdateFunction(spec), "UpdateFunction [%s]", route)
+}
+
+// DeleteFunction deletes the function from the route it is running in
+func (r *Router) DeleteFunction(name, namespace string) error {
+	route, _, err := r.locate(name, namespace)
+	if err != nil {
+		return errors.Annotate(err, "locate")
+	}
+
+	if err := r.routes[route].DeleteFunction(name, namespace); err != nil {
+		return errors.Annotatef(err, "DeleteFunction [%s]", route)
+	}
+
+	r.mu.Lock()
+	delete(r.located, functionKey(name, namespace))
+	r.mu.Unlock()
+
+	return nil
+}
+
+// ScaleFunction scales the function in the route it is running in
+func (r *Router) ScaleFunction(name, namespace string, replicas uint64) error {
+	route, _, err := r.locate(name, namespace)
+	if err != nil {
+		return errors.Annotate(err, "locate")
+	}
+
+	return errors.Annotatef(r.routes[route].ScaleFunction(name, namespace, replicas), "ScaleFunction [%s]", route)
+}
+
+// DeactivateFunction deactivates the function in the route it is running in
+func (r *Router) DeactivateFunction(name, namespace string) error {
+	route, _, err := r.locate(name, namespace)
+	if err != nil {
+		return errors.Annotate(err, "locate")
+	}
+
+	return errors.Annotatef(r.routes[route].DeactivateFunction(name, namespace), "DeactivateFunction [%s]", route)
+}
+
+// ActivateFunction activates the function in the route it is running in
+func (r *Router) ActivateFunction(name, namespace string) error {
+	route, _, err := r.locate(name, namespace)
+	if err != nil {
+		return errors.Annotate(err, "locate")
+	}
+
+	return errors.Annotatef(r.routes[route].ActivateFunction(name, namespace), "ActivateFunction [%s]", route)
+}
+
+// ListInstances lists the instances in the environment the function runs in
+func (r *Router) ListInstances(name, namespace string) ([]Instance, error) {
+	route, _, err := r.locate(name, namespace)
+	if err != nil {
+		return nil, errors.Annotate(err, "locate")
+	}
+
+	instances, err := r.routes[route].ListInstances(name, namespace)
+	return instances, errors.Annotatef(err, "ListInstances [%s]", route)
+}
+
+// ListSecrets aggregates the secrets of all routes serving the namespace
+func (r *Router) ListSecrets(namespace string) ([]types.Secret, error) {
+	names := r.names
+	if route, ok := r.namespaces[namespace]; ok {
+		names = []string{route}
+	}
+
+	var secrets []types.Secret
+	for _, name := range names {
+		list, err := r.routes[name].ListSecrets(namespace)
+		if err != nil {
+			if errors.Cause(err) == ErrNotSupported {
+				continue
+			}
+			return nil, errors.Annotatef(err, "ListSecrets [%s]", name)
+		}
+
+		secrets = append(secrets, list...)
+	}
+
+	return secrets, nil
+}
+
+// EnsureSecret creates or updates the secret in the route of its namespace
+func (r *Router) EnsureSecret(secret *types.Secret) error {
+	route := r.routeForNamespace(secret.Namespace)
+	return errors.Annotatef(r.routes[route].EnsureSecret(secret), "EnsureSecret [%s]", route)
+}
+
+// DeleteSecret deletes the secret from the route of its namespace
+func (r *Router) DeleteSecret(name, namespace string) error {
+	route := r.routeForNamespace(namespace)
+	return errors.Annotatef(r.routes[route].DeleteSecret(name, namespace), "DeleteSecret [%s]", route)
+}
+
+func (r *Router) routeForNamespace(namespace string) string {
+	if route, ok := r.namespaces[namespace]; ok {
+		return route
+	}
+
+	return r.defaultRoute
+}
+
+func (r *Router) locate(name, namespace string) (string, *Function, error) {
+	first, ok := r.lookup(name, namespace)
+	if !ok {
+		first = r.routeForNamespace(namespace)
+	}
+
+	candidates := []string{first}
+	for _, route := range r.names {
+		if route != candidates[0] {
+			candidates = append(candidates, route)
+		}
+	}
+
+	for _, route := range candidates {
+		function, err := r.routes[route].FindFunction(name, namespace)
+		if err == nil {
+			r.remember(name, namespace, route)
+			return route, function, nil
+		}
+
+		if errors.Cause(err) != ErrFunctionNotFound {
+			return "", nil, errors.Annotatef(err, "FindFunction [%s]", route)
+		}
+	}
+
+	return "", nil, ErrFunctionNotFound
+}
+
+func (r *Router) lookup(name, namespace string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	route, ok := r.located[functionKey(name, namespace)]
+	return route, ok
+}
+
+func (r *Router) remember(name, namespace, route string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.located[functionKey(name, namespace)] = route
+}
+
+func functionKey(name, namespace string) string {
+	return name + "." + namespace
+}
@@ -0,0 +1,172 @@
+package backend_test
+
+import (
+	"testing"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/mocks"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestRouter(t *testing.T) (*backend.Router, *mocks.Backend, *mocks.Backend) {
+	prod := new(mocks.Backend)
+	staging := new(mocks.Backend)
+
+	router, err := backend.NewRouter([]backend.Route{
+		{Name: "prod", Backend: prod},
+		{Name: "staging", Backend: staging, Namespaces: []string{"dev"}},
+	}, "prod", "com.openfaas.environment")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return router, prod, staging
+}
+
+func Test_Router_ListFunctions_Aggregates_And_Tags_Origin(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	router, prod, staging := newTestRouter(t)
+	prod.On("ListFunctions", "").Return([]backend.Function{{Name: "a"}}, nil)
+	staging.On("ListFunctions", "").Return([]backend.Function{{Name: "b"}}, nil)
+
+	// Act
+	functions, err := router.ListFunctions("")
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal([]backend.Function{
+		{Name: "a", Origin: "prod"},
+		{Name: "b", Origin: "staging"},
+	}, functions)
+	prod.AssertExpectations(t)
+	staging.AssertExpectations(t)
+}
+
+func Test_Router_ListFunctions_Only_Queries_Namespace_Route(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	router, prod, staging := newTestRouter(t)
+	staging.On("ListFunctions", "dev").Return([]backend.Function{{Name: "b"}}, nil)
+
+	// Act
+	functions, err := router.ListFunctions("dev")
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(functions, 1)
+	prod.AssertNotCalled(t, "ListFunctions", mock.Anything)
+	staging.AssertExpectations(t)
+}
+
+func Test_Router_DeployFunction_Routes_By_Label_Then_Namespace(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	router, prod, staging := newTestRouter(t)
+	labeled := &backend.FunctionSpec{
+		Name:   "labeled",
+		Labels: map[string]string{"com.openfaas.environment": "staging"},
+	}
+	namespaced := &backend.FunctionSpec{Name: "namespaced", Namespace: "dev"}
+	plain := &backend.FunctionSpec{Name: "plain"}
+
+	prod.On("FindFunction", mock.Anything, mock.Anything).Return(nil, backend.ErrFunctionNotFound)
+	staging.On("FindFunction", mock.Anything, mock.Anything).Return(nil, backend.ErrFunctionNotFound)
+	staging.On("DeployFunction", labeled).Return(nil)
+	staging.On("DeployFunction", namespaced).Return(nil)
+	prod.On("DeployFunction", plain).Return(nil)
+
+	// Act & Assert
+	assert.NoError(router.DeployFunction(labeled))
+	assert.NoError(router.DeployFunction(namespaced))
+	assert.NoError(router.DeployFunction(plain))
+
+	route, err := router.Route("labeled", "")
+	assert.NoError(err)
+	assert.Equal("staging", route)
+	prod.AssertExpectations(t)
+	staging.AssertExpectations(t)
+}
+
+func Test_Router_DeployFunction_Rejects_Unknown_Route(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	router, _, _ := newTestRouter(t)
+	spec := &backend.FunctionSpec{
+		Name:   "some-function",
+		Labels: map[string]string{"com.openfaas.environment": "unknown"},
+	}
+
+	// Act
+	err := router.DeployFunction(spec)
+
+	// Assert
+	assert.Error(err)
+}
+
+func Test_Router_DeployFunction_Rejects_Function_Of_Other_Route(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	router, prod, staging := newTestRouter(t)
+	spec := &backend.FunctionSpec{
+		Name:   "some-function",
+		Labels: map[string]string{"com.openfaas.environment": "staging"},
+	}
+	prod.On("FindFunction", "some-function", "").Return(&backend.Function{Name: "some-function"}, nil)
+
+	// Act
+	err := router.DeployFunction(spec)
+
+	// Assert
+	assert.Equal(backend.ErrFunctionExists, errors.Cause(err))
+	staging.AssertNotCalled(t, "DeployFunction", mock.Anything)
+}
+
+func Test_Router_ScaleFunction_Searches_All_Routes(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	router, prod, staging := newTestRouter(t)
+	prod.On("FindFunction", "some-function", "").Return(nil, backend.ErrFunctionNotFound)
+	staging.On("FindFunction", "some-function", "").Return(&backend.Function{Name: "some-function"}, nil)
+	staging.On("ScaleFunction", "some-function", "", uint64(2)).Return(nil)
+
+	// Act
+	err := router.ScaleFunction("some-function", "", 2)
+
+	// Assert
+	assert.NoError(err)
+	prod.AssertExpectations(t)
+	staging.AssertExpectations(t)
+}
+
+func Test_Router_DeleteFunction_Not_Found(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	router, prod, staging := newTestRouter(t)
+	prod.On("FindFunction", "some-function", "").Return(nil, backend.ErrFunctionNotFound)
+	staging.On("FindFunction", "some-function", "").Return(nil, backend.ErrFunctionNotFound)
+
+	// Act
+	err := router.DeleteFunction("some-function", "")
+
+	// Assert
+	assert.Equal(backend.ErrFunctionNotFound, errors.Cause(err))
+}
+
+func Test_NewRouter_Rejects_Ambiguous_Namespace(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	routes := []backend.Route{
+		{Name: "prod", Backend: new(mocks.Backend), Namespaces: []string{"dev"}},
+		{Name: "staging", Backend: new(mocks.Backend), Namespaces: []string{"dev"}},
+	}
+
+	// Act
+	_, err := backend.NewRouter(routes, "prod", "")
+
+	// Assert
+	assert.Error(err)
+}
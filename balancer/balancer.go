@@ -0,0 +1,414 @@
+// Package balancer proxies function invocations to the instances of a
+// function directly instead of relying on the DNS round robin of the
+// orchestrator. The instances are listed from the backend, refreshed
+// periodically and temporarily ejected when requests to them fail. A
+// circuit breaker per function fails invocations fast while the function
+// keeps failing.
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/juju/errors"
+	"github.com/openfaas/faas-provider/proxy"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	logger = logrus.WithField("package", "balancer")
+)
+
+// Strategy selects how instances are picked
+type Strategy string
+
+const (
+	// None resolves every invocation with the fallback, leaving the
+	// balancing to the DNS of the orchestrator
+	None Strategy = ""
+	// RoundRobin picks the instances in turn
+	RoundRobin Strategy = "round-robin"
+	// LeastConnections picks the instance with the fewest requests in flight
+	LeastConnections Strategy = "least-connections"
+)
+
+const (
+	// functions cached as not found at most
+	maxMisses = 10000
+)
+
+var (
+	ErrInvalidStrategy = errors.New("invalid balancing strategy")
+	ErrCircuitOpen     = errors.New("circuit open")
+)
+
+// Options configure a Balancer
+type Options struct {
+	Strategy Strategy
+	// namespace of functions addressed without namespace
+	DefaultNamespace string
+	// port of the watchdog unless overridden by backend.PortLabel
+	Port int
+	// interval the instances of a function are listed again
+	RefreshInterval time.Duration
+	// time failed instances don't receive requests
+	EjectDuration time.Duration
+	// time functions not found are not listed again
+	MissTTL time.Duration
+	// times idempotent invocations are retried with another instance.
+	// With strategy None the retry resolves the fallback again and may be
+	// sent to the same instance.
+	Retries int
+	// largest body buffered to retry an invocation, larger bodies are
+	// sent once
+	RetryMaxBodyBytes int64
+	// consecutive failed invocations opening the circuit of a function, 0 disables the breaker
+	BreakerThreshold int
+	// time an open circuit fails invocations before one is let through
+	BreakerCooldown time.Duration
+}
+
+type instance struct {
+	id       string
+	address  string
+	healthy  bool
+	inflight int
+	// the instance is skipped until then after a failure
+	ejectedUntil time.Time
+}
+
+type pool struct {
+	port       int
+	instances  []*instance
+	next       int
+	updated    time.Time
+	refreshing bool
+}
+
+// Balancer picks the instance to send an invocation to
+type Balancer struct {
+	b        backend.Backend
+	fallback proxy.BaseURLResolver
+	opts     Options
+
+	mu       sync.Mutex
+	pools    map[string]*pool
+	breakers map[string]*breaker
+	// functions not found until the time
+	misses map[string]time.Time
+}
+
+// Lease is an invocation sent to an instance, finish it with Done
+type Lease struct {
+	URL url.URL
+
+	b        *Balancer
+	instance *instance
+	// the circuit of the function
+	circuit string
+	probe   bool
+}
+
+// NewBalancer creates a balancer listing the instances from b. Functions
+// without available instances are resolved with fallback.
+func NewBalancer(b backend.Backend, fallback proxy.BaseURLResolver, opts Options) (*Balancer, error) {
+	switch opts.Strategy {
+	case None, RoundRobin, LeastConnections:
+	default:
+		return nil, errors.Annotatef(ErrInvalidStrategy, "%q", opts.Strategy)
+	}
+
+	balancer := Balancer{
+		b:        b,
+		fallback: fallback,
+		opts:     opts,
+		pools:    make(map[string]*pool),
+		breakers: make(map[string]*breaker),
+		misses:   make(map[string]time.Time),
+	}
+
+	return &balancer, nil
+}
+
+// Pick leases an instance of service, the function name optionally
+// followed by .namespace. It resolves to the fallback if all instances of
+// the function are unhealthy or ejected, it fails with ErrCircuitOpen
+// instead if the circuit breaker is enabled.
+func (b *Balancer) Pick(service string) (*Lease, error) {
+	name, namespace := backend.SplitFunctionName(service)
+	if namespace == b.opts.DefaultNamespace {
+		namespace = ""
+	}
+
+	circuit := name
+	if namespace != "" {
+		circuit = name + "." + namespace
+	}
+
+	p, err := b.pool(name, namespace)
+	if err != nil {
+		return nil, errors.Annotate(err, "pool")
+	}
+
+	if b.opts.Strategy == None {
+		b.mu.Lock()
+		port := p.port
+		b.mu.Unlock()
+
+		u, err := b.fallback.Resolve(service)
+		if err != nil {
+			return nil, errors.Annotate(err, "Resolve")
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+
+		probe, err := b.allow(circuit)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Lease{URL: u, b: b, circuit: circuit, probe: probe}, nil
+	}
+
+	probe, err := b.allow(circuit)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	port := p.port
+	selected := b.pick(p)
+	if selected != nil {
+		selected.inflight++
+	}
+	unavailable := selected == nil && len(p.instances) > 0 && b.opts.BreakerThreshold > 0
+	b.mu.Unlock()
+
+	if unavailable {
+		b.record(circuit, probe, nil, false)
+		rejected.WithLabelValues(circuit).Inc()
+		return nil, errors.Annotatef(ErrCircuitOpen, "no instance of %s available", circuit)
+	}
+
+	if selected == nil {
+		u, err := b.fallback.Resolve(service)
+		if err != nil {
+			b.record(circuit, probe, nil, false)
+			return nil, errors.Annotate(err, "Resolve")
+		}
+
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+		return &Lease{URL: u, b: b, circuit: circuit, probe: probe}, nil
+	}
+
+	lease := Lease{
+		URL: url.URL{
+			Scheme: "http",
+			Host:   net.JoinHostPort(selected.address, strconv.Itoa(port)),
+			Path:   "/",
+		},
+		b:        b,
+		instance: selected,
+		circuit:  circuit,
+		probe:    probe,
+	}
+
+	return &lease, nil
+}
+
+// Done finishes the invocation, instances failing with err are ejected
+// and count towards opening the circuit of the function
+func (l *Lease) Done(err error) {
+	l.b.record(l.circuit, l.probe, err, true)
+	l.release(err)
+}
+
+// Release finishes an invocation without outcome, like a canceled one
+func (l *Lease) Release() {
+	l.b.record(l.circuit, l.probe, nil, false)
+	l.release(nil)
+}
+
+func (l *Lease) release(err error) {
+	if l.instance == nil {
+		return
+	}
+
+	l.b.mu.Lock()
+	defer l.b.mu.Unlock()
+
+	l.instance.inflight--
+	if err != nil && l.b.opts.EjectDuration > 0 {
+		logger.Warnf("ejecting instance %s (%s) for %s: %v", l.instance.id, l.instance.address, l.b.opts.EjectDuration, err)
+		l.instance.ejectedUntil = time.Now().Add(l.b.opts.EjectDuration)
+	}
+}
+
+// pick selects an instance of the pool, nil if none is available.
+// The caller holds the lock.
+func (b *Balancer) pick(p *pool) *instance {
+	now := time.Now()
+	candidates := []*instance{}
+	for _, i := range p.instances {
+		if i.healthy && !now.Before(i.ejectedUntil) {
+			candidates = append(candidates, i)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	start := p.next % len(candidates)
+	p.next++
+
+	selected := candidates[start]
+	if b.opts.Strategy == LeastConnections {
+		for n := 1; n < len(candidates); n++ {
+			candidate := candidates[(start+n)%len(candidates)]
+			if candidate.inflight < selected.inflight {
+				selected = candidate
+			}
+		}
+	}
+
+	return selected
+}
+
+// pool returns the instance pool of the function, listing the instances
+// first if they are unknown or outdated. Functions not found are not
+// listed again for MissTTL.
+func (b *Balancer) pool(name, namespace string) (*pool, error) {
+	key := fmt.Sprintf("%s.%s", name, namespace)
+
+	b.mu.Lock()
+	if until, missed := b.misses[key]; missed {
+		if time.Now().Before(until) {
+			b.mu.Unlock()
+			return nil, errors.Annotate(backend.ErrFunctionNotFound, "cached")
+		}
+		delete(b.misses, key)
+	}
+
+	p, ok := b.pools[key]
+	stale := ok && !p.refreshing && time.Since(p.updated) >= b.opts.RefreshInterval
+	if stale {
+		// requests meanwhile use the outdated instances
+		p.refreshing = true
+	}
+	b.mu.Unlock()
+
+	if ok && !stale {
+		return p, nil
+	}
+
+	port, instances, err := b.list(name, namespace)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err != nil {
+		if ok && errors.Cause(err) != backend.ErrFunctionNotFound {
+			// retry with the next refresh
+			p.refreshing = false
+			p.updated = time.Now()
+			logger.Warn(errors.Annotatef(err, "list [%s], keeping previous instances", key))
+			return p, nil
+		}
+
+		delete(b.pools, key)
+		if errors.Cause(err) == backend.ErrFunctionNotFound && b.opts.MissTTL > 0 {
+			b.miss(key)
+		}
+		return nil, errors.Annotate(err, "list")
+	}
+
+	if current, exists := b.pools[key]; exists {
+		p = current
+	} else {
+		p = &pool{}
+		b.pools[key] = p
+	}
+
+	p.merge(instances)
+	p.port = port
+	p.updated = time.Now()
+	p.refreshing = false
+	return p, nil
+}
+
+// miss caches that the function was not found, the caller holds the lock
+func (b *Balancer) miss(key string) {
+	now := time.Now()
+	// unknown names must not grow the cache without bounds
+	if len(b.misses) >= maxMisses {
+		for k, until := range b.misses {
+			if !now.Before(until) {
+				delete(b.misses, k)
+			}
+		}
+	}
+
+	if len(b.misses) < maxMisses {
+		b.misses[key] = now.Add(b.opts.MissTTL)
+	}
+}
+
+// merge replaces the instances, keeping the state of known ones.
+// The caller holds the lock.
+func (p *pool) merge(instances []backend.Instance) {
+	known := make(map[string]*instance)
+	for _, i := range p.instances {
+		known[i.address] = i
+	}
+
+	merged := make([]*instance, 0, len(instances))
+	for _, listed := range instances {
+		i, ok := known[listed.Address]
+		if !ok {
+			i = &instance{address: listed.Address}
+		}
+
+		i.id = listed.ID
+		i.healthy = listed.Healthy
+		merged = append(merged, i)
+	}
+
+	p.instances = merged
+}
+
+// list returns the watchdog port and the instances of the function
+func (b *Balancer) list(name, namespace string) (int, []backend.Instance, error) {
+	function, err := b.b.FindFunction(name, namespace)
+	if err != nil {
+		return 0, nil, errors.Annotate(err, "FindFunction")
+	}
+
+	port := b.opts.Port
+	if value, ok := function.Labels[backend.PortLabel]; ok {
+		if labeled, err := strconv.Atoi(value); err == nil && labeled > 0 && labeled < 65536 {
+			port = labeled
+		} else {
+			logger.Warnf("ignoring invalid %s label %q of %s", backend.PortLabel, value, name)
+		}
+	}
+
+	// the DNS of the orchestrator balances without strategy
+	if b.opts.Strategy == None {
+		return port, nil, nil
+	}
+
+	instances, err := b.b.ListInstances(name, namespace)
+	if err != nil {
+		if errors.Cause(err) != backend.ErrNotSupported {
+			return 0, nil, errors.Annotate(err, "ListInstances")
+		}
+		instances = nil
+	}
+
+	return port, instances, nil
+}
@@ -0,0 +1,462 @@
+package balancer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/mocks"
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// dnsResolver resolves functions to their name like the DNS resolver of the provider
+type dnsResolver struct{}
+
+func (dnsResolver) Resolve(service string) (url.URL, error) {
+	u, err := url.Parse(fmt.Sprintf("http://%s:8080/", service))
+	return *u, err
+}
+
+// staticResolver resolves all functions to the same URL
+type staticResolver struct {
+	u *url.URL
+}
+
+func (r staticResolver) Resolve(service string) (url.URL, error) {
+	return *r.u, nil
+}
+
+func newBalancer(b backend.Backend, strategy Strategy) *Balancer {
+	balancer, _ := NewBalancer(b, dnsResolver{}, Options{
+		Strategy:         strategy,
+		DefaultNamespace: "faas-functions",
+		Port:             8080,
+		RefreshInterval:  time.Minute,
+		EjectDuration:    time.Minute,
+	})
+
+	return balancer
+}
+
+func withInstances(b *mocks.Backend, labels map[string]string, instances ...backend.Instance) {
+	b.On("FindFunction", "some-function", "").Return(&backend.Function{Name: "some-function", Labels: labels}, nil)
+	b.On("ListInstances", "some-function", "").Return(instances, nil)
+}
+
+// withPort serves the function on the port of u
+func withPort(u *url.URL) *mocks.Backend {
+	b := new(mocks.Backend)
+	withInstances(b, map[string]string{backend.PortLabel: u.Port()})
+
+	return b
+}
+
+func Test_Balancer_Round_Robin(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	withInstances(mockClient, nil,
+		backend.Instance{ID: "1i1", Address: "10.42.0.1", Healthy: true},
+		backend.Instance{ID: "1i2", Address: "10.42.0.2", Healthy: true},
+		backend.Instance{ID: "1i3", Address: "10.42.0.3", Healthy: false},
+	)
+	balancer := newBalancer(mockClient, RoundRobin)
+
+	// Act
+	hosts := []string{}
+	for i := 0; i < 4; i++ {
+		lease, err := balancer.Pick("some-function.faas-functions")
+		if !assert.NoError(err) {
+			return
+		}
+		hosts = append(hosts, lease.URL.Host)
+		lease.Done(nil)
+	}
+
+	// Assert
+	assert.Equal([]string{"10.42.0.1:8080", "10.42.0.2:8080", "10.42.0.1:8080", "10.42.0.2:8080"}, hosts)
+	mockClient.AssertNumberOfCalls(t, "ListInstances", 1)
+}
+
+func Test_Balancer_Least_Connections(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	withInstances(mockClient, nil,
+		backend.Instance{ID: "1i1", Address: "10.42.0.1", Healthy: true},
+		backend.Instance{ID: "1i2", Address: "10.42.0.2", Healthy: true},
+	)
+	balancer := newBalancer(mockClient, LeastConnections)
+
+	// Act
+	busy, _ := balancer.Pick("some-function")
+	picked := []string{}
+	for i := 0; i < 3; i++ {
+		lease, _ := balancer.Pick("some-function")
+		picked = append(picked, lease.URL.Host)
+		lease.Done(nil)
+	}
+
+	// Assert
+	assert.Equal("10.42.0.1:8080", busy.URL.Host)
+	assert.Equal([]string{"10.42.0.2:8080", "10.42.0.2:8080", "10.42.0.2:8080"}, picked)
+}
+
+func Test_Balancer_Ejects_Failed_Instances(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	withInstances(mockClient, map[string]string{backend.PortLabel: "9090"},
+		backend.Instance{ID: "1i1", Address: "10.42.0.1", Healthy: true},
+	)
+	balancer := newBalancer(mockClient, RoundRobin)
+
+	// Act
+	failed, _ := balancer.Pick("some-function")
+	failed.Done(errors.New("connection refused"))
+	fallback, err := balancer.Pick("some-function")
+
+	// Assert
+	assert.Equal("10.42.0.1:9090", failed.URL.Host)
+	if assert.NoError(err) {
+		assert.Equal("some-function:9090", fallback.URL.Host)
+	}
+}
+
+func Test_Balancer_Unknown_Function(t *testing.T) {
+	// Arrange
+	mockClient := new(mocks.Backend)
+	mockClient.On("FindFunction", "other-function", "").Return(nil, backend.ErrFunctionNotFound)
+	balancer := newBalancer(mockClient, RoundRobin)
+
+	// Act
+	_, err := balancer.Pick("other-function")
+
+	// Assert
+	assert.Error(t, err)
+}
+
+func Test_Balancer_Caches_Unknown_Functions(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	mockClient.On("FindFunction", "other-function", "").Return(nil, backend.ErrFunctionNotFound)
+	balancer, _ := NewBalancer(mockClient, dnsResolver{}, Options{
+		Strategy:        RoundRobin,
+		RefreshInterval: time.Minute,
+		MissTTL:         time.Minute,
+	})
+
+	// Act
+	_, first := balancer.Pick("other-function")
+	_, second := balancer.Pick("other-function")
+
+	// Assert
+	assert.Equal(backend.ErrFunctionNotFound, errors.Cause(first))
+	assert.Equal(backend.ErrFunctionNotFound, errors.Cause(second))
+	mockClient.AssertNumberOfCalls(t, "FindFunction", 1)
+}
+
+func Test_Balancer_None_Uses_Port_Label(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	withInstances(mockClient, map[string]string{backend.PortLabel: "9090"})
+	balancer := newBalancer(mockClient, None)
+
+	// Act
+	lease, err := balancer.Pick("some-function")
+
+	// Assert
+	if assert.NoError(err) {
+		assert.Equal("some-function:9090", lease.URL.Host)
+	}
+	mockClient.AssertNotCalled(t, "ListInstances", "some-function", "")
+}
+
+func Test_NewBalancer_Invalid_Strategy(t *testing.T) {
+	_, err := NewBalancer(new(mocks.Backend), dnsResolver{}, Options{Strategy: "random"})
+	assert.Error(t, err)
+}
+
+func Test_Balancer_Proxy_Skips_Unreachable_Instances(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"path": %q, "query": %q}`, r.URL.Path, r.URL.RawQuery)
+	}))
+	defer function.Close()
+
+	_, port, _ := net.SplitHostPort(function.Listener.Addr().String())
+
+	// only 127.0.0.1 listens on the port
+	mockClient := new(mocks.Backend)
+	withInstances(mockClient, map[string]string{backend.PortLabel: port},
+		backend.Instance{ID: "1i1", Address: "127.0.0.2", Healthy: true},
+		backend.Instance{ID: "1i2", Address: "127.0.0.1", Healthy: true},
+	)
+	handler := newBalancer(mockClient, RoundRobin).Proxy(types.FaaSConfig{ReadTimeout: time.Second})
+
+	invoke := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/function/some-function/sub/path?a=1", nil)
+		rr := httptest.NewRecorder()
+		handler(rr, mux.SetURLVars(req, map[string]string{"name": "some-function", "params": "sub/path"}))
+		return rr
+	}
+
+	// Act
+	failed := invoke()
+	results := []int{invoke().Code, invoke().Code, invoke().Code}
+	served := invoke()
+
+	// Assert
+	assert.Equal(http.StatusInternalServerError, failed.Code)
+	assert.Equal([]int{http.StatusOK, http.StatusOK, http.StatusOK}, results)
+	assert.Equal("application/json", served.Header().Get("Content-Type"))
+	assert.Equal(`{"path": "/sub/path", "query": "a=1"}`, served.Body.String())
+}
+
+func Test_Balancer_Proxy_Times_Out(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	release := make(chan struct{})
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer function.Close()
+	defer close(release)
+
+	u, _ := url.Parse(function.URL)
+	resolver := staticResolver{u}
+	balancer, _ := NewBalancer(withPort(u), resolver, Options{Strategy: None})
+	handler := balancer.Proxy(types.FaaSConfig{ReadTimeout: 50 * time.Millisecond})
+
+	req, _ := http.NewRequest("GET", "/function/some-function", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, mux.SetURLVars(req, map[string]string{"name": "some-function"}))
+
+	// Assert
+	assert.Equal(http.StatusGatewayTimeout, rr.Code)
+	assert.Contains(rr.Body.String(), "some-function did not respond within 50ms")
+}
+
+func Test_Balancer_Proxy_Retries_With_Another_Instance(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	upgrading := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upgrading.Close()
+
+	_, port, _ := net.SplitHostPort(upgrading.Listener.Addr().String())
+
+	// 127.0.0.2 serves the function on the same port
+	listener, err := net.Listen("tcp", "127.0.0.2:"+port)
+	if err != nil {
+		t.Skip(err)
+	}
+	function := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", r.Method, body)
+	}))
+	function.Listener.Close()
+	function.Listener = listener
+	function.Start()
+	defer function.Close()
+
+	mockClient := new(mocks.Backend)
+	withInstances(mockClient, map[string]string{backend.PortLabel: port},
+		backend.Instance{ID: "1i1", Address: "127.0.0.1", Healthy: true},
+		backend.Instance{ID: "1i2", Address: "127.0.0.2", Healthy: true},
+		backend.Instance{ID: "1i3", Address: "127.0.0.3", Healthy: true},
+	)
+	balancer, _ := NewBalancer(mockClient, dnsResolver{}, Options{
+		Strategy:          RoundRobin,
+		RefreshInterval:   time.Minute,
+		EjectDuration:     time.Minute,
+		Retries:           2,
+		RetryMaxBodyBytes: 1024,
+	})
+	handler := balancer.Proxy(types.FaaSConfig{ReadTimeout: time.Second})
+
+	invoke := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler(rr, mux.SetURLVars(req, map[string]string{"name": "some-function"}))
+		return rr
+	}
+
+	// Act
+	get, _ := http.NewRequest("GET", "/function/some-function", nil)
+	retried := invoke(get)
+
+	post, _ := http.NewRequest("POST", "/function/some-function", strings.NewReader("payload"))
+	optedIn := invoke(post.WithContext(WithRetry(post.Context(), true)))
+
+	// Assert
+	assert.Equal(http.StatusOK, retried.Code)
+	assert.Equal("GET ", retried.Body.String())
+	assert.Equal(http.StatusOK, optedIn.Code)
+	assert.Equal("POST payload", optedIn.Body.String())
+}
+
+func Test_Balancer_Proxy_Does_Not_Retry_Other_Requests(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	calls := 0
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer function.Close()
+
+	u, _ := url.Parse(function.URL)
+	balancer, _ := NewBalancer(withPort(u), staticResolver{u}, Options{Strategy: None, Retries: 2})
+	handler := balancer.Proxy(types.FaaSConfig{ReadTimeout: time.Second})
+
+	req, _ := http.NewRequest("POST", "/function/some-function", strings.NewReader("payload"))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, mux.SetURLVars(req, map[string]string{"name": "some-function"}))
+
+	// Assert
+	assert.Equal(http.StatusBadGateway, rr.Code)
+	assert.Equal(1, calls)
+}
+
+func Test_Balancer_Proxy_Passes_Large_Bodies_Through_Once(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	bodies := []string{}
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer function.Close()
+
+	u, _ := url.Parse(function.URL)
+	balancer, _ := NewBalancer(withPort(u), staticResolver{u}, Options{Strategy: None, Retries: 2, RetryMaxBodyBytes: 4})
+	handler := balancer.Proxy(types.FaaSConfig{ReadTimeout: time.Second})
+
+	invoke := func(payload string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/function/some-function", strings.NewReader(payload))
+		req = req.WithContext(WithRetry(req.Context(), true))
+		rr := httptest.NewRecorder()
+		handler(rr, mux.SetURLVars(req, map[string]string{"name": "some-function"}))
+		return rr
+	}
+
+	// Act
+	large := invoke("payload")
+	small := invoke("tiny")
+
+	// Assert
+	assert.Equal(http.StatusServiceUnavailable, large.Code)
+	assert.Equal(http.StatusServiceUnavailable, small.Code)
+	assert.Equal([]string{"payload", "tiny", "tiny", "tiny"}, bodies)
+}
+
+func Test_Balancer_Proxy_Fails_Fast_With_Open_Circuit(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	calls := 0
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer function.Close()
+
+	u, _ := url.Parse(function.URL)
+	balancer, _ := NewBalancer(withPort(u), staticResolver{u}, Options{
+		Strategy:         None,
+		DefaultNamespace: "faas-functions",
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Minute,
+	})
+	handler := balancer.Proxy(types.FaaSConfig{ReadTimeout: time.Second})
+
+	invoke := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/function/some-function", nil)
+		rr := httptest.NewRecorder()
+		handler(rr, mux.SetURLVars(req, map[string]string{"name": "some-function.faas-functions"}))
+		return rr
+	}
+
+	// Act
+	results := []int{invoke().Code, invoke().Code}
+	failed := invoke()
+
+	// Assert
+	assert.Equal([]int{http.StatusServiceUnavailable, http.StatusServiceUnavailable}, results)
+	assert.Equal(http.StatusServiceUnavailable, failed.Code)
+	assert.Equal("60", failed.Header().Get("Retry-After"))
+	assert.Equal(2, calls)
+}
+
+func Test_Balancer_Circuit_Probes_After_Cooldown(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	withInstances(mockClient, nil,
+		backend.Instance{ID: "1i1", Address: "10.42.0.1", Healthy: true},
+	)
+	balancer, _ := NewBalancer(mockClient, dnsResolver{}, Options{
+		Strategy:         RoundRobin,
+		Port:             8080,
+		RefreshInterval:  time.Minute,
+		BreakerThreshold: 1,
+		BreakerCooldown:  10 * time.Millisecond,
+	})
+
+	failed, _ := balancer.Pick("some-function")
+	failed.Done(errors.New("connection refused"))
+
+	// Act
+	_, open := balancer.Pick("some-function")
+	time.Sleep(20 * time.Millisecond)
+	probe, probeErr := balancer.Pick("some-function")
+	_, probing := balancer.Pick("some-function")
+	probe.Done(nil)
+	closed, closedErr := balancer.Pick("some-function")
+
+	// Assert
+	assert.Equal(ErrCircuitOpen, errors.Cause(open))
+	assert.NoError(probeErr)
+	assert.Equal(ErrCircuitOpen, errors.Cause(probing))
+	if assert.NoError(closedErr) {
+		assert.Equal("10.42.0.1:8080", closed.URL.Host)
+	}
+}
+
+func Test_Balancer_Circuit_Opens_Without_Available_Instances(t *testing.T) {
+	// Arrange
+	mockClient := new(mocks.Backend)
+	withInstances(mockClient, nil,
+		backend.Instance{ID: "1i1", Address: "10.42.0.1", Healthy: false},
+	)
+	balancer, _ := NewBalancer(mockClient, dnsResolver{}, Options{
+		Strategy:         RoundRobin,
+		RefreshInterval:  time.Minute,
+		BreakerThreshold: 5,
+	})
+
+	// Act
+	_, err := balancer.Pick("some-function")
+
+	// Assert
+	assert.Equal(t, ErrCircuitOpen, errors.Cause(err))
+}
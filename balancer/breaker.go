@@ -0,0 +1,86 @@
+package balancer
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// breaker counts the consecutive failed invocations of a function
+type breaker struct {
+	failures int
+	// invocations fail fast until then once failures reached the threshold
+	openUntil time.Time
+	// an invocation is let through to test the function
+	probing bool
+}
+
+// allow reports ErrCircuitOpen if the circuit of the function is open.
+// Once the cooldown passed, it lets one invocation through as a probe
+// and reports true for it.
+func (b *Balancer) allow(circuit string) (bool, error) {
+	if b.opts.BreakerThreshold <= 0 {
+		return false, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br, ok := b.breakers[circuit]
+	if !ok || br.failures < b.opts.BreakerThreshold {
+		return false, nil
+	}
+
+	if br.probing || time.Now().Before(br.openUntil) {
+		rejected.WithLabelValues(circuit).Inc()
+		return false, errors.Annotatef(ErrCircuitOpen, "%s", circuit)
+	}
+
+	br.probing = true
+	return true, nil
+}
+
+// record counts the outcome of an invocation of the function. Invocations
+// without outcome only finish a probe.
+func (b *Balancer) record(circuit string, probe bool, err error, judged bool) {
+	if b.opts.BreakerThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br, ok := b.breakers[circuit]
+	if ok && probe {
+		br.probing = false
+	}
+
+	if !judged {
+		return
+	}
+
+	if err == nil {
+		if ok {
+			if br.failures >= b.opts.BreakerThreshold {
+				logger.Infof("closing circuit of %s", circuit)
+			}
+			delete(b.breakers, circuit)
+			circuitOpen.WithLabelValues(circuit).Set(0)
+		}
+		return
+	}
+
+	if !ok {
+		br = &breaker{}
+		b.breakers[circuit] = br
+	}
+
+	br.failures++
+	if br.failures >= b.opts.BreakerThreshold {
+		if br.failures == b.opts.BreakerThreshold || probe {
+			logger.Warnf("opening circuit of %s for %s after %d failures: %v", circuit, b.opts.BreakerCooldown, br.failures, err)
+		}
+		br.openUntil = time.Now().Add(b.opts.BreakerCooldown)
+		circuitOpen.WithLabelValues(circuit).Set(1)
+	}
+}
@@ -0,0 +1,34 @@
+package balancer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "proxy",
+		Name:      "retries_total",
+		Help:      "Invocations retried with another instance by reason, connection or status.",
+	}, []string{"function", "reason"})
+
+	circuitOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "proxy",
+		Name:      "circuit_open",
+		Help:      "Whether the circuit of functions is open.",
+	}, []string{"function"})
+
+	rejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "proxy",
+		Name:      "circuit_rejected_total",
+		Help:      "Invocations failed fast because the circuit of functions was open.",
+	}, []string{"function"})
+)
+
+func init() {
+	prometheus.MustRegister(retries)
+	prometheus.MustRegister(circuitOpen)
+	prometheus.MustRegister(rejected)
+}
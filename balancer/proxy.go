@@ -0,0 +1,244 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+	"github.com/openfaas/faas-provider/httputil"
+	"github.com/openfaas/faas-provider/proxy"
+	"github.com/openfaas/faas-provider/types"
+)
+
+const (
+	defaultContentType = "text/plain"
+)
+
+type retryKey struct{}
+
+// WithRetry overrides whether invocations with ctx are retried. By
+// default only the idempotent GET and HEAD requests are.
+func WithRetry(ctx context.Context, retry bool) context.Context {
+	return context.WithValue(ctx, retryKey{}, retry)
+}
+
+// retryable reports whether r may be sent again after a failure
+func retryable(r *http.Request) bool {
+	if retry, ok := r.Context().Value(retryKey{}).(bool); ok {
+		return retry
+	}
+
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// Proxy returns a function proxy handler like proxy.NewHandlerFunc
+// sending the invocations to the instances picked by the balancer.
+// Invocations time out with the deadline of the request context, the
+// read timeout of config if there is none. Retryable invocations failing
+// to connect or answered with 502 or 503 are sent to another instance up
+// to Retries times, unless their body exceeds RetryMaxBodyBytes.
+func (b *Balancer) Proxy(config types.FaaSConfig) http.HandlerFunc {
+	proxyClient := proxy.NewProxyClientFromConfig(config)
+	// the timeout of the invocations is up to the request context
+	proxyClient.Timeout = 0
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		switch r.Method {
+		case http.MethodPost,
+			http.MethodPut,
+			http.MethodPatch,
+			http.MethodDelete,
+			http.MethodGet,
+			http.MethodHead:
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		vars := mux.Vars(r)
+		name := vars["name"]
+		if name == "" {
+			httputil.Errorf(w, http.StatusBadRequest, "Please provide a valid route /function/function_name.")
+			return
+		}
+
+		attempts := 1
+		var body []byte
+		if retryable(r) && b.opts.Retries > 0 {
+			attempts += b.opts.Retries
+
+			// the body is sent again with every attempt. Reading one byte
+			// beyond the limit tells larger bodies apart, they are passed
+			// through once with the bytes read so far.
+			if r.Body != nil {
+				read, err := ioutil.ReadAll(io.LimitReader(r.Body, b.opts.RetryMaxBodyBytes+1))
+				if err != nil {
+					httputil.Errorf(w, http.StatusBadRequest, "Failed to read request body: %s.", err)
+					return
+				}
+
+				if int64(len(read)) > b.opts.RetryMaxBodyBytes {
+					logger.Debugf("not retrying %s, the body exceeds %d bytes", name, b.opts.RetryMaxBodyBytes)
+					attempts = 1
+					r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(read), r.Body))
+				} else {
+					body = read
+				}
+			}
+		}
+
+		ctx := r.Context()
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, config.GetReadTimeout())
+			defer cancel()
+		}
+
+		for attempt := 1; ; attempt++ {
+			last := attempt == attempts
+
+			lease, err := b.Pick(name)
+			if err != nil {
+				if errors.Cause(err) == ErrCircuitOpen {
+					logger.Warnf("failing %s fast: %v", name, err)
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(b.opts.BreakerCooldown.Seconds()))))
+					httputil.Errorf(w, http.StatusServiceUnavailable, "Service %s is unavailable.", name)
+					return
+				}
+
+				logger.Errorf("cannot find %s: %v", name, err)
+				httputil.Errorf(w, http.StatusNotFound, "Cannot find service: %s.", name)
+				return
+			}
+
+			upstream, err := buildProxyRequest(r, lease.URL, vars["params"])
+			if err != nil {
+				lease.Release()
+				httputil.Errorf(w, http.StatusInternalServerError, "Failed to resolve service: %s.", name)
+				return
+			}
+
+			if body != nil {
+				upstream.Body = ioutil.NopCloser(bytes.NewReader(body))
+				upstream.ContentLength = int64(len(body))
+			}
+
+			start := time.Now()
+			deadline, _ := ctx.Deadline()
+			response, err := proxyClient.Do(upstream.WithContext(ctx))
+			if err != nil {
+				// timed out or canceled requests don't tell about the instance
+				if ctx.Err() != nil {
+					lease.Release()
+				} else {
+					lease.Done(err)
+				}
+
+				if ctx.Err() == context.DeadlineExceeded {
+					timeout := deadline.Sub(start).Round(time.Millisecond)
+					logger.Warnf("proxy request to %s timed out after %s", upstream.URL.String(), timeout)
+					httputil.Errorf(w, http.StatusGatewayTimeout, "Function %s did not respond within %s.", name, timeout)
+					return
+				}
+
+				if ctx.Err() == nil && !last {
+					logger.Warnf("retrying %s after error with proxy request to %s: %v", name, upstream.URL.String(), err)
+					retries.WithLabelValues(lease.circuit, "connection").Inc()
+					continue
+				}
+
+				logger.Errorf("error with proxy request to %s: %v", upstream.URL.String(), err)
+				httputil.Errorf(w, http.StatusInternalServerError, "Can't reach service for: %s.", name)
+				return
+			}
+
+			if response.StatusCode == http.StatusBadGateway || response.StatusCode == http.StatusServiceUnavailable {
+				failure := errors.Errorf("status %d", response.StatusCode)
+				if !last {
+					response.Body.Close()
+					lease.Done(failure)
+					logger.Warnf("retrying %s after %s from %s", name, failure, upstream.URL.String())
+					retries.WithLabelValues(lease.circuit, "status").Inc()
+					continue
+				}
+
+				defer lease.Done(failure)
+			} else {
+				defer lease.Done(nil)
+			}
+			defer response.Body.Close()
+
+			logger.Debugf("%s took %f seconds", name, time.Since(start).Seconds())
+
+			copyHeaders(w.Header(), response.Header)
+			w.Header().Set("Content-Type", contentType(response.Header, r.Header))
+
+			w.WriteHeader(response.StatusCode)
+			io.Copy(w, response.Body)
+			return
+		}
+	}
+}
+
+// buildProxyRequest creates the request to the instance at baseURL,
+// preserving the headers of the original request
+func buildProxyRequest(r *http.Request, baseURL url.URL, extraPath string) (*http.Request, error) {
+	u := url.URL{
+		Scheme:   baseURL.Scheme,
+		Host:     baseURL.Host,
+		Path:     extraPath,
+		RawQuery: r.URL.RawQuery,
+	}
+
+	upstream, err := http.NewRequest(r.Method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	copyHeaders(upstream.Header, r.Header)
+
+	if len(r.Host) > 0 && upstream.Header.Get("X-Forwarded-Host") == "" {
+		upstream.Header.Set("X-Forwarded-Host", r.Host)
+	}
+	if upstream.Header.Get("X-Forwarded-For") == "" {
+		upstream.Header.Set("X-Forwarded-For", r.RemoteAddr)
+	}
+
+	if r.Body != nil {
+		upstream.Body = r.Body
+	}
+
+	return upstream, nil
+}
+
+func copyHeaders(destination http.Header, source http.Header) {
+	for k, v := range source {
+		clone := make([]string, len(v))
+		copy(clone, v)
+		destination[k] = clone
+	}
+}
+
+// contentType prefers the content type of the response over the one of the request
+func contentType(response http.Header, request http.Header) string {
+	if value := response.Get("Content-Type"); value != "" {
+		return value
+	}
+
+	if value := request.Get("Content-Type"); value != "" {
+		return value
+	}
+
+	return defaultContentType
+}
@@ -0,0 +1,230 @@
+// Package certs loads TLS certificates and CA bundles and reloads them
+// when their files change, so certificates can be rotated without restart.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	logger = logrus.WithField("package", "certs")
+)
+
+// Reloader holds a certificate and a CA bundle read from files
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+	// stamp of the files the current certificate and pool were read from
+	stamp string
+}
+
+// NewReloader reads the certificate and key from certFile and keyFile and
+// the CA bundle from caFile. Either the certificate or the CA bundle may
+// be omitted by passing empty file names.
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("certificate and key files must be set together")
+	}
+
+	r := Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, errors.Annotate(err, "Reload")
+	}
+
+	return &r, nil
+}
+
+// Reload reads the files again. The previous certificate and bundle are
+// kept if they can't be read.
+func (r *Reloader) Reload() error {
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return errors.Annotate(err, "fileStamp")
+	}
+
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		pair, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return errors.Annotate(err, "LoadX509KeyPair")
+		}
+		cert = &pair
+	}
+
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		if pool, err = LoadCertPool(r.caFile); err != nil {
+			return errors.Annotate(err, "LoadCertPool")
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = cert
+	r.pool = pool
+	r.stamp = stamp
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Watch polls the files for changes until stop is closed. Failed reloads
+// are logged and retried with the next change.
+func (r *Reloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var failed string
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		stamp, err := r.fileStamp()
+		if err != nil {
+			logger.Error(errors.Annotate(err, "fileStamp"))
+			continue
+		}
+
+		r.mu.RLock()
+		unchanged := stamp == r.stamp
+		r.mu.RUnlock()
+
+		// don't retry broken files on every tick
+		if unchanged || stamp == failed {
+			continue
+		}
+
+		if err := r.Reload(); err != nil {
+			logger.Errorf("reloading certificates failed, keeping previous certificates: %v", err)
+			failed = stamp
+			continue
+		}
+
+		logger.Infof("reloaded certificates %s", strings.Join(r.files(), ", "))
+		failed = ""
+	}
+}
+
+// Certificate returns the current certificate, nil if there is none
+func (r *Reloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// Pool returns the current CA bundle, nil if there is none
+func (r *Reloader) Pool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+// ServerConfig returns a server config presenting the current certificate.
+// With a CA bundle clients have to present a certificate signed by it.
+func (r *Reloader) ServerConfig() *tls.Config {
+	config := tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert := r.Certificate()
+		if cert == nil {
+			return nil, errors.New("no server certificate")
+		}
+
+		c := tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{*cert},
+		}
+
+		if pool := r.Pool(); pool != nil {
+			c.ClientCAs = pool
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+
+		return &c, nil
+	}
+
+	return &config
+}
+
+// ClientConfig returns a client config trusting the CA bundle and presenting
+// the current certificate. Without CA bundle the system roots are trusted.
+// Changes of the bundle apply to configs created afterwards only.
+func (r *Reloader) ClientConfig() *tls.Config {
+	config := tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    r.Pool(),
+	}
+
+	if r.certFile != "" {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.Certificate(), nil
+		}
+	}
+
+	return &config
+}
+
+// LoadCertPool reads a bundle of PEM encoded CA certificates
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotate(err, "ReadFile")
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(buf) {
+		return nil, errors.Errorf("no certificates in %s", path)
+	}
+
+	return pool, nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{}
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
+// fileStamp identifies the current version of the files by size and modification time
+func (r *Reloader) fileStamp() (string, error) {
+	stamps := []string{}
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", errors.Annotate(err, "Stat")
+		}
+
+		stamps = append(stamps, fmt.Sprintf("%s:%d:%d", file, info.Size(), info.ModTime().UnixNano()))
+	}
+
+	return strings.Join(stamps, ","), nil
+}
@@ -0,0 +1,197 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newAuthority creates a self-signed CA
+func newAuthority(t *testing.T, name string) *authority {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	return &authority{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM encoded certificate and key of name signed by the CA
+func (a *authority) issue(t *testing.T, name string, serial int64) ([]byte, []byte) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, dir, name string, content []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// serveTLS starts a server with the config
+func serveTLS(config *tls.Config) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = config
+	ts.StartTLS()
+
+	return ts
+}
+
+func get(url string, config *tls.Config) (*http.Response, error) {
+	client := http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	return client.Get(url)
+}
+
+func Test_Reloader_Mutual_TLS(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir, _ := ioutil.TempDir("", "certs")
+	defer os.RemoveAll(dir)
+
+	ca := newAuthority(t, "ca")
+	other := newAuthority(t, "other")
+	serverCert, serverKey := ca.issue(t, "provider", 2)
+	clientCert, clientKey := ca.issue(t, "gateway", 3)
+	strangerCert, strangerKey := other.issue(t, "stranger", 4)
+
+	caFile := writeFile(t, dir, "ca.pem", ca.pem)
+	server, err := NewReloader(writeFile(t, dir, "server.pem", serverCert), writeFile(t, dir, "server-key.pem", serverKey), caFile)
+	if !assert.NoError(err) {
+		return
+	}
+
+	gateway, _ := NewReloader(writeFile(t, dir, "client.pem", clientCert), writeFile(t, dir, "client-key.pem", clientKey), caFile)
+	stranger, _ := NewReloader(writeFile(t, dir, "stranger.pem", strangerCert), writeFile(t, dir, "stranger-key.pem", strangerKey), caFile)
+	anonymous, _ := NewReloader("", "", caFile)
+
+	// Act
+	ts := serveTLS(server.ServerConfig())
+	defer ts.Close()
+	resp, gatewayErr := get(ts.URL, gateway.ClientConfig())
+	_, strangerErr := get(ts.URL, stranger.ClientConfig())
+	_, anonymousErr := get(ts.URL, anonymous.ClientConfig())
+
+	// Assert
+	if assert.NoError(gatewayErr) {
+		assert.Equal(http.StatusOK, resp.StatusCode)
+	}
+	assert.Error(strangerErr)
+	assert.Error(anonymousErr)
+}
+
+func Test_Reloader_Watch_Reloads_Changed_Certificate(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir, _ := ioutil.TempDir("", "certs")
+	defer os.RemoveAll(dir)
+
+	ca := newAuthority(t, "ca")
+	cert, key := ca.issue(t, "provider", 2)
+	certFile := writeFile(t, dir, "server.pem", cert)
+	keyFile := writeFile(t, dir, "server-key.pem", key)
+
+	r, err := NewReloader(certFile, keyFile, "")
+	if !assert.NoError(err) {
+		return
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go r.Watch(10*time.Millisecond, stop)
+
+	serial := func() int64 {
+		leaf, _ := x509.ParseCertificate(r.Certificate().Certificate[0])
+		return leaf.SerialNumber.Int64()
+	}
+
+	// Act
+	rotated, rotatedKey := ca.issue(t, "provider", 5)
+	writeFile(t, dir, "server.pem", rotated)
+	writeFile(t, dir, "server-key.pem", rotatedKey)
+
+	// Assert
+	deadline := time.Now().Add(time.Second)
+	for serial() != 5 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(int64(5), serial())
+
+	// broken files keep the previous certificate
+	writeFile(t, dir, "server.pem", []byte("garbage"))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(int64(5), serial())
+}
+
+func Test_NewReloader_Rejects_Invalid_Files(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir, _ := ioutil.TempDir("", "certs")
+	defer os.RemoveAll(dir)
+
+	garbage := writeFile(t, dir, "garbage.pem", []byte("garbage"))
+
+	// Act
+	_, halfErr := NewReloader(garbage, "", "")
+	_, certErr := NewReloader(garbage, garbage, "")
+	_, caErr := NewReloader("", "", garbage)
+
+	// Assert
+	assert.Error(halfErr)
+	assert.Error(certErr)
+	assert.Error(caErr)
+}
@@ -0,0 +1,48 @@
+package concurrency
+
+import (
+	"github.com/gitmonster/faas-rancher/backend"
+)
+
+// Backend decorates a backend.Backend to report the requests served by
+// the functions and waiting for them
+type Backend struct {
+	backend.Backend
+
+	limiter *Limiter
+}
+
+// NewBackend creates a backend reporting the stats of limiter
+func NewBackend(b backend.Backend, limiter *Limiter) *Backend {
+	c := Backend{
+		Backend: b,
+		limiter: limiter,
+	}
+
+	return &c
+}
+
+// ListFunctions adds the stats of the functions
+func (c *Backend) ListFunctions(namespace string) ([]backend.Function, error) {
+	functions, err := c.Backend.ListFunctions(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range functions {
+		functions[i].InFlight, functions[i].Queued = c.limiter.Stats(functions[i].Name, functions[i].Namespace)
+	}
+
+	return functions, nil
+}
+
+// FindFunction adds the stats of the function
+func (c *Backend) FindFunction(name, namespace string) (*backend.Function, error) {
+	function, err := c.Backend.FindFunction(name, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	function.InFlight, function.Queued = c.limiter.Stats(name, namespace)
+	return function, nil
+}
@@ -0,0 +1,300 @@
+// Package concurrency limits the requests a function serves at the same
+// time. The limits are annotated on the functions, requests beyond the
+// limit wait in a bounded queue or are rejected with 429 Too Many Requests.
+// The provider proxies all invocations, so the limits apply across all
+// replicas of a function.
+package concurrency
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/drain"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	logger = logrus.WithField("package", "concurrency")
+)
+
+const (
+	// MaxInflightAnnotation limits the requests a function serves at the same time
+	MaxInflightAnnotation = "com.openfaas.rancher.max-inflight"
+	// MaxQueueAnnotation limits the requests waiting for a function at its limit
+	MaxQueueAnnotation = "com.openfaas.rancher.max-queue"
+)
+
+// waiter is a request queued for a slot of a function
+type waiter struct {
+	// closed when the request got a slot or was rejected
+	done     chan struct{}
+	rejected bool
+}
+
+// Limiter enforces the concurrency limits of the functions. The requests
+// in flight are counted by the drain.Tracker, the Limiter queues the
+// requests beyond the limit.
+type Limiter struct {
+	cache            *metastore.Cache
+	tracker          *drain.Tracker
+	defaultNamespace string
+	queueTimeout     time.Duration
+
+	mu      sync.Mutex
+	waiters map[string][]*waiter
+}
+
+// NewLimiter creates a limiter reading the limits from cache and counting
+// the requests with tracker. Requests wait up to queueTimeout in the
+// queue. Functions of defaultNamespace are also addressed without
+// namespace.
+func NewLimiter(cache *metastore.Cache, tracker *drain.Tracker, defaultNamespace string, queueTimeout time.Duration) *Limiter {
+	l := Limiter{
+		cache:            cache,
+		tracker:          tracker,
+		defaultNamespace: defaultNamespace,
+		queueTimeout:     queueTimeout,
+		waiters:          make(map[string][]*waiter),
+	}
+
+	return &l
+}
+
+// ParseLimits returns the limits set by the MaxInflightAnnotation and
+// the MaxQueueAnnotation, 0 if they are missing
+func ParseLimits(annotations map[string]string) (int, int, error) {
+	maxInflight, err := parseLimit(annotations, MaxInflightAnnotation)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	maxQueue, err := parseLimit(annotations, MaxQueueAnnotation)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if maxQueue > 0 && maxInflight == 0 {
+		return 0, 0, errors.Errorf("%s requires %s", MaxQueueAnnotation, MaxInflightAnnotation)
+	}
+
+	return maxInflight, maxQueue, nil
+}
+
+func parseLimit(annotations map[string]string, annotation string) (int, error) {
+	value, ok := annotations[annotation]
+	if !ok {
+		return 0, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Annotatef(err, "%s", annotation)
+	}
+
+	if limit < 0 {
+		return 0, errors.Errorf("%s must not be negative, got %q", annotation, value)
+	}
+
+	return limit, nil
+}
+
+// Limit passes requests for the function in the route variable name to
+// next within the limits of the function. Requests to draining functions
+// are rejected with 410 Gone.
+func (l *Limiter) Limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, namespace := backend.SplitFunctionName(mux.Vars(r)["name"])
+		key := l.key(name, namespace)
+		maxInflight, maxQueue := l.limits(name, namespace)
+
+		wait, err := l.acquire(name, namespace, maxInflight, maxQueue)
+		if err == drain.ErrDraining {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+
+		if err != nil {
+			rejected.WithLabelValues(key).Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "function "+key+" is at its concurrency limit", http.StatusTooManyRequests)
+			return
+		}
+
+		if wait != nil {
+			timer := time.NewTimer(l.queueTimeout)
+			defer timer.Stop()
+
+			select {
+			case <-wait.done:
+			case <-timer.C:
+			case <-r.Context().Done():
+			}
+
+			if l.abandon(name, namespace, wait) {
+				rejected.WithLabelValues(key).Inc()
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "function "+key+" is at its concurrency limit", http.StatusTooManyRequests)
+				return
+			}
+
+			if wait.rejected {
+				http.Error(w, drain.ErrDraining.Error(), http.StatusGone)
+				return
+			}
+		}
+		defer l.release(name, namespace, maxInflight > 0)
+
+		next(w, r)
+	}
+}
+
+// Stats returns the number of requests the function serves and the
+// number of requests waiting for it
+func (l *Limiter) Stats(name, namespace string) (int, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.tracker.InFlight(name, namespace), len(l.waiters[l.key(name, namespace)])
+}
+
+// acquire takes a slot of the function if one is free and returns a
+// waiter otherwise, done when the request got a slot from the queue.
+// It fails if the queue is full or the function drains.
+func (l *Limiter) acquire(name, namespace string, maxInflight, maxQueue int) (*waiter, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := l.key(name, namespace)
+	waiters := l.waiters[key]
+
+	// queued requests get the next free slot
+	err := drain.ErrBusy
+	if len(waiters) == 0 || maxInflight == 0 {
+		err = l.tracker.Enter(name, namespace, maxInflight)
+	} else if l.tracker.Draining(name, namespace) {
+		err = drain.ErrDraining
+	}
+
+	if err != drain.ErrBusy {
+		if err == nil && maxInflight > 0 {
+			l.observe(name, namespace)
+		}
+		return nil, err
+	}
+
+	if len(waiters) < maxQueue {
+		wait := &waiter{done: make(chan struct{})}
+		l.waiters[key] = append(waiters, wait)
+		l.observe(name, namespace)
+		return wait, nil
+	}
+
+	return nil, err
+}
+
+// abandon removes a waiter timed out or canceled from the queue. It
+// reports false if the waiter was granted a slot or rejected meanwhile.
+func (l *Limiter) abandon(name, namespace string, wait *waiter) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := l.key(name, namespace)
+	waiters := l.waiters[key]
+	for i, waiter := range waiters {
+		if waiter == wait {
+			l.queue(key, append(waiters[:i], waiters[i+1:]...))
+			l.observe(name, namespace)
+			return true
+		}
+	}
+
+	return false
+}
+
+// release frees the slot of a finished request, passing it on to the
+// first waiter. Waiters of a draining function are rejected.
+func (l *Limiter) release(name, namespace string, limited bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := l.key(name, namespace)
+	waiters := l.waiters[key]
+	if len(waiters) == 0 {
+		l.tracker.Leave(name, namespace)
+		if limited {
+			l.observe(name, namespace)
+		}
+		return
+	}
+
+	if !l.tracker.Draining(name, namespace) {
+		close(waiters[0].done)
+		l.queue(key, waiters[1:])
+		l.observe(name, namespace)
+		return
+	}
+
+	for _, wait := range waiters {
+		wait.rejected = true
+		close(wait.done)
+	}
+	l.queue(key, nil)
+	l.tracker.Leave(name, namespace)
+	l.observe(name, namespace)
+}
+
+// queue replaces the waiters of the function, l.mu must be held
+func (l *Limiter) queue(key string, waiters []*waiter) {
+	if len(waiters) == 0 {
+		delete(l.waiters, key)
+		return
+	}
+
+	l.waiters[key] = waiters
+}
+
+// limits returns the limits annotated on the function
+func (l *Limiter) limits(name, namespace string) (int, int) {
+	annotations, err := l.cache.Annotations(name, namespace)
+	if err != nil {
+		return 0, 0
+	}
+
+	maxInflight, maxQueue, err := ParseLimits(annotations)
+	if err != nil {
+		logger.Warnf("ignoring concurrency limits of %q: %v", l.key(name, namespace), err)
+	}
+
+	return maxInflight, maxQueue
+}
+
+// observe updates the metrics of the function, l.mu must be held. The
+// metrics of idle functions are dropped.
+func (l *Limiter) observe(name, namespace string) {
+	key := l.key(name, namespace)
+	inflightRequests := l.tracker.InFlight(name, namespace)
+	queuedRequests := len(l.waiters[key])
+
+	if inflightRequests == 0 && queuedRequests == 0 {
+		inflight.DeleteLabelValues(key)
+		queued.DeleteLabelValues(key)
+		return
+	}
+
+	inflight.WithLabelValues(key).Set(float64(inflightRequests))
+	queued.WithLabelValues(key).Set(float64(queuedRequests))
+}
+
+func (l *Limiter) key(name, namespace string) string {
+	if namespace == "" || namespace == l.defaultNamespace {
+		return name
+	}
+
+	return name + "." + namespace
+}
@@ -0,0 +1,221 @@
+package concurrency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/drain"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gitmonster/faas-rancher/mocks"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// invoke serves a request to the function through the limited handler
+func invoke(handler http.HandlerFunc, name string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/function/"+name, nil)
+	rr := httptest.NewRecorder()
+	handler(rr, mux.SetURLVars(req, map[string]string{"name": name}))
+	return rr
+}
+
+// blockingHandler blocks requests until release is closed
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func newStore(annotations map[string]interface{}) metastore.Store {
+	store := metastore.NewMemoryStore()
+	store.Put(&metastore.FunctionMeta{
+		Service:     "some-function",
+		Image:       "some/image",
+		Annotations: annotations,
+	})
+
+	return store
+}
+
+func newLimiter(store metastore.Store, queueTimeout time.Duration) *Limiter {
+	cache := metastore.NewCache(store, "faas-functions", time.Minute)
+	return NewLimiter(cache, drain.NewTracker("faas-functions"), "faas-functions", queueTimeout)
+}
+
+// waitForStats polls the stats of some-function until they match
+func waitForStats(l *Limiter, inflight, queued int) bool {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if i, q := l.Stats("some-function", ""); i == inflight && q == queued {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return false
+}
+
+func Test_Limiter_Queues_And_Rejects_Requests(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	store := newStore(map[string]interface{}{MaxInflightAnnotation: "1", MaxQueueAnnotation: "1"})
+	limiter := newLimiter(store, time.Minute)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := limiter.Limit(blockingHandler(started, release))
+
+	// Act
+	first := make(chan int)
+	go func() { first <- invoke(handler, "some-function").Code }()
+	<-started
+
+	second := make(chan int)
+	go func() { second <- invoke(handler, "some-function.faas-functions").Code }()
+
+	// Assert
+	assert.True(waitForStats(limiter, 1, 1))
+
+	rejected := invoke(handler, "some-function")
+	assert.Equal(http.StatusTooManyRequests, rejected.Code)
+	assert.Equal("1", rejected.Header().Get("Retry-After"))
+
+	release <- struct{}{}
+	assert.Equal(http.StatusOK, <-first)
+	<-started
+	assert.True(waitForStats(limiter, 1, 0))
+
+	close(release)
+	assert.Equal(http.StatusOK, <-second)
+	assert.True(waitForStats(limiter, 0, 0))
+}
+
+func Test_Limiter_Queue_Times_Out(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	store := newStore(map[string]interface{}{MaxInflightAnnotation: "1", MaxQueueAnnotation: "5"})
+	limiter := newLimiter(store, 10*time.Millisecond)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := limiter.Limit(blockingHandler(started, release))
+
+	first := make(chan int)
+	go func() { first <- invoke(handler, "some-function").Code }()
+	<-started
+
+	// Act
+	waited := invoke(handler, "some-function")
+
+	// Assert
+	assert.Equal(http.StatusTooManyRequests, waited.Code)
+	assert.True(waitForStats(limiter, 1, 0))
+
+	close(release)
+	assert.Equal(http.StatusOK, <-first)
+	assert.True(waitForStats(limiter, 0, 0))
+}
+
+func Test_Limiter_Passes_Unlimited_Functions(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	limiter := newLimiter(newStore(nil), time.Minute)
+	handler := limiter.Limit(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Act
+	limited := invoke(handler, "some-function")
+	unknown := invoke(handler, "other-function")
+
+	// Assert
+	assert.Equal(http.StatusOK, limited.Code)
+	assert.Equal(http.StatusOK, unknown.Code)
+}
+
+func Test_Limiter_Rejects_Draining_Functions(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	store := newStore(map[string]interface{}{MaxInflightAnnotation: "1", MaxQueueAnnotation: "1"})
+	tracker := drain.NewTracker("faas-functions")
+	limiter := NewLimiter(metastore.NewCache(store, "faas-functions", time.Minute), tracker, "faas-functions", time.Minute)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := limiter.Limit(blockingHandler(started, release))
+
+	first := make(chan int)
+	go func() { first <- invoke(handler, "some-function").Code }()
+	<-started
+
+	second := make(chan int)
+	go func() { second <- invoke(handler, "some-function").Code }()
+	assert.True(waitForStats(limiter, 1, 1))
+
+	// Act
+	drained := make(chan int)
+	go func() { drained <- tracker.Drain("some-function", "", time.Second) }()
+	for !tracker.Draining("some-function", "") {
+		time.Sleep(time.Millisecond)
+	}
+	rejected := invoke(handler, "some-function")
+	close(release)
+
+	// Assert
+	assert.Equal(http.StatusGone, rejected.Code)
+	assert.Equal(http.StatusOK, <-first)
+	assert.Equal(http.StatusGone, <-second)
+	assert.Equal(0, <-drained)
+	assert.True(waitForStats(limiter, 0, 0))
+}
+
+func Test_ParseLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	maxInflight, maxQueue, err := ParseLimits(map[string]string{MaxInflightAnnotation: "4", MaxQueueAnnotation: "10"})
+	assert.NoError(err)
+	assert.Equal(4, maxInflight)
+	assert.Equal(10, maxQueue)
+
+	_, _, err = ParseLimits(map[string]string{MaxInflightAnnotation: "-1"})
+	assert.Error(err)
+
+	_, _, err = ParseLimits(map[string]string{MaxInflightAnnotation: "many"})
+	assert.Error(err)
+
+	_, _, err = ParseLimits(map[string]string{MaxQueueAnnotation: "10"})
+	assert.Error(err)
+}
+
+func Test_Backend_Reports_Stats(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	store := newStore(map[string]interface{}{MaxInflightAnnotation: "1"})
+	limiter := newLimiter(store, time.Minute)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := limiter.Limit(blockingHandler(started, release))
+
+	mockClient := new(mocks.Backend)
+	mockClient.On("ListFunctions", "").Return([]backend.Function{{Name: "some-function"}, {Name: "other-function"}}, nil)
+	b := NewBackend(mockClient, limiter)
+
+	done := make(chan int)
+	go func() { done <- invoke(handler, "some-function").Code }()
+	<-started
+
+	// Act
+	functions, err := b.ListFunctions("")
+
+	// Assert
+	assert.NoError(err)
+	if assert.Len(functions, 2) {
+		assert.Equal(1, functions[0].InFlight)
+		assert.Equal(0, functions[1].InFlight)
+	}
+
+	close(release)
+	<-done
+}
@@ -0,0 +1,32 @@
+package concurrency
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	inflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "proxy",
+		Name:      "inflight",
+		Help:      "Requests served by functions with a concurrency limit.",
+	}, []string{"function"})
+
+	queued = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "proxy",
+		Name:      "queued",
+		Help:      "Requests waiting for functions at their concurrency limit.",
+	}, []string{"function"})
+
+	rejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "proxy",
+		Name:      "rejected_total",
+		Help:      "Requests rejected because functions were at their concurrency limit.",
+	}, []string{"function"})
+)
+
+func init() {
+	prometheus.MustRegister(inflight, queued, rejected)
+}
@@ -0,0 +1,111 @@
+package cron
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/juju/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketNameStatus = []byte("status")
+)
+
+// BoltState persists the status of the scheduled functions in a bolt
+// database file, so missed runs are noticed across restarts
+type BoltState struct {
+	database *bolt.DB
+}
+
+// NewBoltState opens the state stored in path
+func NewBoltState(path string) (*BoltState, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Annotate(err, "MkdirAll")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Annotate(err, "Open")
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketNameStatus)
+		return errors.Annotate(err, "CreateBucketIfNotExists")
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, errors.Annotate(err, "Update")
+	}
+
+	return &BoltState{database: db}, nil
+}
+
+// Close closes the database
+func (s *BoltState) Close() (err error) {
+	if s.database != nil {
+		err = s.database.Close()
+		s.database = nil
+	}
+	return err
+}
+
+// Load returns the stored status by function key
+func (s *BoltState) Load() (map[string]Status, error) {
+	if s.database == nil {
+		return nil, ErrStateClosed
+	}
+
+	statuses := make(map[string]Status)
+	err := s.database.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketNameStatus).ForEach(func(k, v []byte) error {
+			status := Status{}
+			if err := json.Unmarshal(v, &status); err != nil {
+				return errors.Annotatef(err, "Unmarshal [%s]", k)
+			}
+
+			statuses[string(k)] = status
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, errors.Annotate(err, "View")
+	}
+
+	return statuses, nil
+}
+
+// Put stores the status of the function
+func (s *BoltState) Put(key string, status *Status) error {
+	if s.database == nil {
+		return ErrStateClosed
+	}
+
+	buf, err := json.Marshal(status)
+	if err != nil {
+		return errors.Annotate(err, "Marshal")
+	}
+
+	err = s.database.Update(func(tx *bolt.Tx) error {
+		return errors.Annotate(tx.Bucket(bucketNameStatus).Put([]byte(key), buf), "Put")
+	})
+
+	return errors.Annotate(err, "Update")
+}
+
+// Delete removes the status of the function
+func (s *BoltState) Delete(key string) error {
+	if s.database == nil {
+		return ErrStateClosed
+	}
+
+	err := s.database.Update(func(tx *bolt.Tx) error {
+		return errors.Annotate(tx.Bucket(bucketNameStatus).Delete([]byte(key)), "Delete")
+	})
+
+	return errors.Annotate(err, "Update")
+}
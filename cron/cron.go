@@ -0,0 +1,411 @@
+// Package cron invokes functions on the schedule annotated in their
+// metadata. Functions labeled topic=cron-function with a schedule
+// annotation are invoked through the function proxy. Runs overlapping a
+// previous run are skipped, runs missed while the provider was down are
+// skipped or caught up once depending on the MissedRunPolicy.
+package cron
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/helper"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	logger = logrus.WithField("package", "cron")
+
+	ErrStateClosed            = errors.New("cron state closed")
+	ErrInvalidMissedRunPolicy = errors.New("invalid missed run policy")
+)
+
+const (
+	// TopicLabel marks functions invoked by a connector
+	TopicLabel = "topic"
+	// Topic is the value of the TopicLabel of scheduled functions
+	Topic = "cron-function"
+	// ScheduleAnnotation holds the cron expression of scheduled functions
+	ScheduleAnnotation = "schedule"
+	// ScheduledTimeHeader tells the function the time the run was scheduled for
+	ScheduledTimeHeader = "X-Scheduled-Time"
+
+	// runs started later than this after their time count as missed
+	lateness = time.Minute
+	// interval due runs are checked
+	tickInterval = time.Second
+	// bytes of failed responses kept as LastError
+	maxErrorBytes = 256
+)
+
+// MissedRunPolicy decides about runs missed while the provider was down
+type MissedRunPolicy string
+
+const (
+	// MissedRunsSkip drops missed runs and waits for the next one
+	MissedRunsSkip MissedRunPolicy = "skip"
+	// MissedRunsRunOnce runs a function once for all its missed runs
+	MissedRunsRunOnce MissedRunPolicy = "run-once"
+)
+
+// Status reports the schedule of a function and its last run
+type Status struct {
+	Function  string    `json:"function"`
+	Namespace string    `json:"namespace,omitempty"`
+	Schedule  string    `json:"schedule"`
+	Next      time.Time `json:"next"`
+	Running   bool      `json:"running"`
+	LastRun   time.Time `json:"lastRun,omitempty"`
+	// LastStatus is the status code of the last run
+	LastStatus   int     `json:"lastStatus,omitempty"`
+	LastError    string  `json:"lastError,omitempty"`
+	LastDuration float64 `json:"lastDurationSeconds,omitempty"`
+	// Skipped counts the runs skipped because the previous one was still running
+	Skipped int `json:"skipped"`
+	// Missed counts the runs dropped by the MissedRunsSkip policy
+	Missed int `json:"missed"`
+}
+
+type job struct {
+	schedule *Schedule
+	status   Status
+}
+
+// Scheduler invokes the scheduled functions
+type Scheduler struct {
+	store  metastore.Store
+	state  *BoltState
+	invoke http.HandlerFunc
+	policy MissedRunPolicy
+
+	mu   sync.Mutex
+	jobs map[string]*job
+	// status of functions stored before they were scheduled again
+	stored map[string]Status
+	wg     sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler for the functions in store, passing
+// the runs to the function proxy invoke. The last runs are read from state.
+func NewScheduler(store metastore.Store, state *BoltState, invoke http.HandlerFunc, policy MissedRunPolicy) (*Scheduler, error) {
+	switch policy {
+	case MissedRunsSkip, MissedRunsRunOnce:
+	default:
+		return nil, errors.Annotatef(ErrInvalidMissedRunPolicy, "%q", policy)
+	}
+
+	stored, err := state.Load()
+	if err != nil {
+		return nil, errors.Annotate(err, "Load [state]")
+	}
+
+	s := Scheduler{
+		store:  store,
+		state:  state,
+		invoke: invoke,
+		policy: policy,
+		jobs:   make(map[string]*job),
+		stored: stored,
+	}
+
+	return &s, nil
+}
+
+// ParseSchedule returns the schedule of a function with the labels and
+// annotations, nil if the function is not scheduled
+func ParseSchedule(labels, annotations map[string]string) (*Schedule, error) {
+	if labels[TopicLabel] != Topic {
+		return nil, nil
+	}
+
+	expr, ok := annotations[ScheduleAnnotation]
+	if !ok {
+		return nil, errors.Errorf("%s=%s requires the %s annotation", TopicLabel, Topic, ScheduleAnnotation)
+	}
+
+	schedule, err := Parse(expr)
+	if err != nil {
+		return nil, errors.Annotate(err, "Parse")
+	}
+
+	return schedule, nil
+}
+
+// Run schedules the functions in the store, following its changes, until
+// stop is closed. Running invocations are waited for. This function is
+// blocking.
+func (s *Scheduler) Run(resyncInterval time.Duration, stop <-chan struct{}) {
+	events := s.store.Watch(stop)
+	s.sync(time.Now())
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	resync := time.NewTicker(resyncInterval)
+	defer resync.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				s.wg.Wait()
+				return
+			}
+
+			s.apply(event.Type, &event.Meta, time.Now())
+		case <-resync.C:
+			// watch events are dropped under load
+			s.sync(time.Now())
+		case now := <-ticker.C:
+			s.tick(now)
+		}
+	}
+}
+
+// Statuses returns the status of the scheduled functions ordered by name
+func (s *Scheduler) Statuses() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.jobs))
+	for key := range s.jobs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	statuses := make([]Status, 0, len(keys))
+	for _, key := range keys {
+		statuses = append(statuses, s.jobs[key].status)
+	}
+
+	return statuses
+}
+
+// sync schedules the functions in the store and drops the deleted ones
+func (s *Scheduler) sync(now time.Time) {
+	metas, err := s.store.List()
+	if err != nil {
+		logger.Error(errors.Annotate(err, "List [metastore]"))
+		return
+	}
+
+	listed := make(map[string]bool)
+	for i := range metas {
+		listed[string(metas[i].Key())] = true
+		s.apply(metastore.EventPut, &metas[i], now)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.jobs {
+		if !listed[key] {
+			s.remove(key)
+		}
+	}
+}
+
+// apply updates the schedule of the changed function
+func (s *Scheduler) apply(eventType metastore.EventType, meta *metastore.FunctionMeta, now time.Time) {
+	key := string(meta.Key())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if eventType == metastore.EventDelete {
+		s.remove(key)
+		return
+	}
+
+	labels, annotations := map[string]string{}, map[string]string{}
+	if converted := helper.ToFaasMap(meta.Labels); converted != nil {
+		labels = *converted
+	}
+	if converted := helper.ToFaasMap(meta.Annotations); converted != nil {
+		annotations = *converted
+	}
+
+	schedule, err := ParseSchedule(labels, annotations)
+	if err != nil {
+		logger.Warnf("not scheduling %q: %v", key, err)
+	}
+
+	if schedule == nil {
+		s.remove(key)
+		return
+	}
+
+	expr := annotations[ScheduleAnnotation]
+	if current, ok := s.jobs[key]; ok && current.status.Schedule == expr {
+		return
+	}
+
+	j := job{
+		schedule: schedule,
+		status: Status{
+			Function:  meta.Service,
+			Namespace: meta.Namespace,
+			Schedule:  expr,
+		},
+	}
+
+	base := now
+	if current, ok := s.jobs[key]; ok {
+		// a changed schedule keeps the history of the function
+		j.status = current.status
+		j.status.Schedule = expr
+	} else if stored, ok := s.stored[key]; ok && stored.Schedule == expr && !stored.LastRun.IsZero() {
+		// runs since the last one before the restart count as missed
+		j.status = stored
+		base = stored.LastRun
+	}
+
+	j.status.Next = schedule.Next(base)
+	s.jobs[key] = &j
+	logger.Infof("scheduled %q at %q, next run %s", key, expr, j.status.Next.Format(time.RFC3339))
+}
+
+// remove drops the schedule of the function. The caller holds the lock.
+func (s *Scheduler) remove(key string) {
+	delete(s.stored, key)
+	if _, ok := s.jobs[key]; !ok {
+		return
+	}
+
+	delete(s.jobs, key)
+	if err := s.state.Delete(key); err != nil {
+		logger.Warn(errors.Annotatef(err, "Delete [state %s]", key))
+	}
+
+	logger.Infof("unscheduled %q", key)
+}
+
+// tick starts the runs due at now
+func (s *Scheduler) tick(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, j := range s.jobs {
+		if now.Before(j.status.Next) {
+			continue
+		}
+
+		scheduled := j.status.Next
+		j.status.Next = j.schedule.Next(now)
+
+		if j.status.Running {
+			j.status.Skipped++
+			runs.WithLabelValues(outcomeSkipped).Inc()
+			logger.Warnf("skipping run of %q at %s, the previous run is still running", key, scheduled.Format(time.RFC3339))
+			continue
+		}
+
+		if now.Sub(scheduled) > lateness && s.policy == MissedRunsSkip {
+			j.status.Missed++
+			runs.WithLabelValues(outcomeMissed).Inc()
+			logger.Warnf("skipping missed run of %q at %s", key, scheduled.Format(time.RFC3339))
+			continue
+		}
+
+		j.status.Running = true
+		s.wg.Add(1)
+		go s.run(key, j.status.Function, j.status.Namespace, scheduled)
+	}
+}
+
+// run invokes the function and records the result
+func (s *Scheduler) run(key, name, namespace string, scheduled time.Time) {
+	defer s.wg.Done()
+
+	service := name
+	if namespace != "" {
+		service = name + "." + namespace
+	}
+
+	start := time.Now()
+	result := &response{header: make(http.Header)}
+
+	req, err := http.NewRequest(http.MethodPost, "/function/"+service, nil)
+	if err == nil {
+		req.Header.Set(ScheduledTimeHeader, scheduled.Format(time.RFC3339))
+		s.invoke(result, mux.SetURLVars(req, map[string]string{"name": service}))
+	}
+
+	if result.status == 0 {
+		result.status = http.StatusOK
+	}
+
+	lastError := ""
+	switch {
+	case err != nil:
+		lastError = err.Error()
+	case result.status >= http.StatusBadRequest:
+		lastError = strings.TrimSpace(result.body.String())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	outcome := outcomeSuccess
+	if lastError != "" {
+		outcome = outcomeFailure
+		logger.Warnf("run of %q failed with status %d: %s", key, result.status, lastError)
+	}
+	runs.WithLabelValues(outcome).Inc()
+
+	j, ok := s.jobs[key]
+	if !ok {
+		// unscheduled meanwhile
+		return
+	}
+
+	j.status.Running = false
+	j.status.LastRun = scheduled
+	j.status.LastStatus = result.status
+	j.status.LastError = lastError
+	j.status.LastDuration = time.Since(start).Seconds()
+
+	if err := s.state.Put(key, &j.status); err != nil {
+		logger.Warn(errors.Annotatef(err, "Put [state %s]", key))
+	}
+}
+
+// response records the status of the function proxy and the beginning of the body
+type response struct {
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (r *response) Header() http.Header {
+	return r.header
+}
+
+func (r *response) Write(buf []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	if remaining := maxErrorBytes - r.body.Len(); remaining > 0 {
+		if len(buf) > remaining {
+			r.body.Write(buf[:remaining])
+		} else {
+			r.body.Write(buf)
+		}
+	}
+
+	return len(buf), nil
+}
+
+func (r *response) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
@@ -0,0 +1,222 @@
+package cron
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func newState(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "cron")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "cron.db"), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func scheduled(name, expr string) *metastore.FunctionMeta {
+	return &metastore.FunctionMeta{
+		Service:     name,
+		Image:       "some/image",
+		Labels:      map[string]interface{}{TopicLabel: Topic},
+		Annotations: map[string]interface{}{ScheduleAnnotation: expr},
+	}
+}
+
+// recorder counts the invocations by function and blocks them until released
+type recorder struct {
+	mu      sync.Mutex
+	calls   map[string]int
+	release chan struct{}
+}
+
+func (r *recorder) invoke(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.calls[mux.Vars(req)["name"]]++
+	r.mu.Unlock()
+
+	if r.release != nil {
+		<-r.release
+	}
+
+	if req.Header.Get(ScheduledTimeHeader) == "" {
+		http.Error(w, "missing scheduled time", http.StatusBadRequest)
+	}
+}
+
+func (r *recorder) count(name string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls[name]
+}
+
+func Test_Scheduler_Runs_Functions_On_Schedule(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	path, cleanup := newState(t)
+	defer cleanup()
+	state, _ := NewBoltState(path)
+	defer state.Close()
+
+	store := metastore.NewMemoryStore()
+	store.Put(scheduled("every-minute", "* * * * *"))
+	store.Put(scheduled("hourly", "0 * * * *"))
+	store.Put(&metastore.FunctionMeta{Service: "plain", Image: "some/image"})
+
+	rec := &recorder{calls: make(map[string]int), release: make(chan struct{})}
+	s, err := NewScheduler(store, state, rec.invoke, MissedRunsSkip)
+	if !assert.NoError(err) {
+		return
+	}
+
+	now := time.Date(2019, 11, 6, 10, 17, 30, 0, time.UTC)
+	s.sync(now)
+
+	// Act
+	s.tick(now.Add(time.Minute))
+	// the previous run is still running
+	s.tick(now.Add(2 * time.Minute))
+	close(rec.release)
+	s.wg.Wait()
+
+	// Assert
+	assert.Equal(1, rec.count("every-minute"))
+	assert.Equal(0, rec.count("hourly"))
+
+	statuses := s.Statuses()
+	if assert.Len(statuses, 2) {
+		assert.Equal("every-minute", statuses[0].Function)
+		assert.Equal(time.Date(2019, 11, 6, 10, 18, 0, 0, time.UTC), statuses[0].LastRun)
+		assert.Equal(http.StatusOK, statuses[0].LastStatus)
+		assert.Equal(1, statuses[0].Skipped)
+		assert.False(statuses[0].Running)
+		assert.Equal(time.Date(2019, 11, 6, 10, 20, 0, 0, time.UTC), statuses[0].Next)
+		assert.Equal("hourly", statuses[1].Function)
+		assert.Equal(time.Date(2019, 11, 6, 11, 0, 0, 0, time.UTC), statuses[1].Next)
+	}
+}
+
+func Test_Scheduler_Follows_Store_Changes(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	path, cleanup := newState(t)
+	defer cleanup()
+	state, _ := NewBoltState(path)
+	defer state.Close()
+
+	store := metastore.NewMemoryStore()
+	s, _ := NewScheduler(store, state, (&recorder{calls: make(map[string]int)}).invoke, MissedRunsSkip)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(time.Minute, stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	// schedules waits for the scheduled functions to become expected
+	schedules := func(expected ...string) []string {
+		deadline := time.Now().Add(time.Second)
+		for {
+			result := []string{}
+			for _, status := range s.Statuses() {
+				result = append(result, status.Function+" "+status.Schedule)
+			}
+
+			if strings.Join(expected, ",") == strings.Join(result, ",") || time.Now().After(deadline) {
+				return result
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// Act & Assert
+	store.Put(scheduled("some-function", "*/5 * * * *"))
+	assert.Equal([]string{"some-function */5 * * * *"}, schedules("some-function */5 * * * *"))
+
+	store.Put(scheduled("some-function", "@daily"))
+	assert.Equal([]string{"some-function @daily"}, schedules("some-function @daily"))
+
+	store.Delete(scheduled("some-function", ""))
+	assert.Equal([]string{}, schedules())
+}
+
+func Test_Scheduler_Missed_Runs(t *testing.T) {
+	for _, policy := range []MissedRunPolicy{MissedRunsSkip, MissedRunsRunOnce} {
+		t.Run(string(policy), func(t *testing.T) {
+			assert := assert.New(t)
+			// Arrange
+			path, cleanup := newState(t)
+			defer cleanup()
+
+			lastRun := time.Date(2019, 11, 6, 10, 0, 0, 0, time.UTC)
+			state, _ := NewBoltState(path)
+			state.Put("some-function", &Status{Function: "some-function", Schedule: "0 * * * *", LastRun: lastRun})
+			state.Close()
+
+			state, _ = NewBoltState(path)
+			defer state.Close()
+
+			store := metastore.NewMemoryStore()
+			store.Put(scheduled("some-function", "0 * * * *"))
+
+			rec := &recorder{calls: make(map[string]int)}
+			s, _ := NewScheduler(store, state, rec.invoke, policy)
+
+			// Act: restarted hours after the last run
+			now := lastRun.Add(3*time.Hour + 30*time.Minute)
+			s.sync(now)
+			s.tick(now)
+			s.wg.Wait()
+
+			// Assert
+			statuses := s.Statuses()
+			if !assert.Len(statuses, 1) {
+				return
+			}
+			assert.Equal(lastRun.Add(4*time.Hour), statuses[0].Next)
+
+			if policy == MissedRunsSkip {
+				assert.Equal(0, rec.count("some-function"))
+				assert.Equal(1, statuses[0].Missed)
+			} else {
+				assert.Equal(1, rec.count("some-function"))
+				assert.Equal(0, statuses[0].Missed)
+			}
+		})
+	}
+}
+
+func Test_ParseSchedule(t *testing.T) {
+	assert := assert.New(t)
+
+	schedule, err := ParseSchedule(map[string]string{TopicLabel: Topic}, map[string]string{ScheduleAnnotation: "*/5 * * * *"})
+	assert.NoError(err)
+	assert.NotNil(schedule)
+
+	schedule, err = ParseSchedule(nil, map[string]string{ScheduleAnnotation: "*/5 * * * *"})
+	assert.NoError(err)
+	assert.Nil(schedule)
+
+	_, err = ParseSchedule(map[string]string{TopicLabel: Topic}, nil)
+	assert.Error(err)
+
+	_, err = ParseSchedule(map[string]string{TopicLabel: Topic}, map[string]string{ScheduleAnnotation: "every minute"})
+	assert.Error(err)
+}
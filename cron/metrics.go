@@ -0,0 +1,25 @@
+package cron
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+	outcomeSkipped = "skipped"
+	outcomeMissed  = "missed"
+)
+
+var (
+	runs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "cron",
+		Name:      "runs_total",
+		Help:      "Scheduled function runs by outcome.",
+	}, []string{"outcome"})
+)
+
+func init() {
+	prometheus.MustRegister(runs)
+}
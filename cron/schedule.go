@@ -0,0 +1,196 @@
+package cron
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+var (
+	ErrInvalidSchedule = errors.New("invalid cron schedule")
+
+	macros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// field is the set of values a field of a schedule matches
+type field uint64
+
+func (f field) has(value int) bool {
+	return f&(1<<uint(value)) != 0
+}
+
+// Schedule is a standard 5 field cron expression:
+// minute hour day-of-month month day-of-week
+type Schedule struct {
+	minute, hour, dom, month, dow field
+	// a restricted day of month or day of week matches either
+	domStar, dowStar bool
+}
+
+// Parse parses expr. Fields are *, numbers, ranges a-b, lists a,b and
+// steps */n or a-b/n. Sunday is 0 or 7. The macros @yearly, @monthly,
+// @weekly, @daily and @hourly are supported.
+func Parse(expr string) (*Schedule, error) {
+	if expanded, ok := macros[strings.TrimSpace(expr)]; ok {
+		expr = expanded
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.Annotatef(ErrInvalidSchedule, "%q has %d fields, expected 5", expr, len(fields))
+	}
+
+	s := Schedule{
+		domStar: strings.HasPrefix(fields[2], "*") || fields[2] == "?",
+		dowStar: strings.HasPrefix(fields[4], "*") || fields[4] == "?",
+	}
+
+	bounds := []struct {
+		target   *field
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+
+	for i, b := range bounds {
+		f, err := parseField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, errors.Annotatef(err, "%q", expr)
+		}
+		*b.target = f
+	}
+
+	// Sunday is 0 or 7
+	if s.dow.has(7) {
+		s.dow |= 1
+	}
+
+	if s.Next(time.Now()).IsZero() {
+		return nil, errors.Annotatef(ErrInvalidSchedule, "%q never runs", expr)
+	}
+
+	return &s, nil
+}
+
+// parseField parses a comma separated list of ranges
+func parseField(expr string, min, max int) (field, error) {
+	var f field
+	for _, part := range strings.Split(expr, ",") {
+		rangeExpr, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, errors.Annotatef(ErrInvalidSchedule, "invalid step in %q", part)
+			}
+			rangeExpr, step = part[:i], n
+		}
+
+		low, high := min, max
+		switch {
+		case rangeExpr == "*" || rangeExpr == "?":
+		case strings.Contains(rangeExpr, "-"):
+			bounds := strings.SplitN(rangeExpr, "-", 2)
+			var err error
+			if low, err = parseValue(bounds[0], min, max); err != nil {
+				return 0, err
+			}
+			if high, err = parseValue(bounds[1], min, max); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, errors.Annotatef(ErrInvalidSchedule, "invalid range %q", rangeExpr)
+			}
+		default:
+			value, err := parseValue(rangeExpr, min, max)
+			if err != nil {
+				return 0, err
+			}
+			low = value
+			// a single value with step runs up to the maximum
+			if step == 1 {
+				high = value
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			f |= 1 << uint(v)
+		}
+	}
+
+	return f, nil
+}
+
+func parseValue(expr string, min, max int) (int, error) {
+	value, err := strconv.Atoi(expr)
+	if err != nil || value < min || value > max {
+		return 0, errors.Annotatef(ErrInvalidSchedule, "%q is not within %d-%d", expr, min, max)
+	}
+
+	return value, nil
+}
+
+// Next returns the first time matching the schedule after t, truncated
+// to the minute. The zero time is returned if there is none within the
+// next five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !s.month.has(int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.hour.has(t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.minute.has(t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// matchesDay applies the cron rule that a restricted day of month and
+// day of week match if either does
+func (s *Schedule) matchesDay(t time.Time) bool {
+	dom := s.dom.has(t.Day())
+	dow := s.dow.has(int(t.Weekday()))
+
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dow
+	case s.dowStar:
+		return dom
+	default:
+		return dom || dow
+	}
+}
@@ -0,0 +1,43 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Schedule_Next(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2019, 11, 6, 10, 17, 30, 0, time.UTC)
+
+	cases := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2019, 11, 6, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2019, 11, 6, 10, 30, 0, 0, time.UTC)},
+		{"5 9-17/4 * * *", time.Date(2019, 11, 6, 13, 5, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 6 * * 1,5", time.Date(2019, 11, 8, 6, 30, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2019, 11, 10, 12, 0, 0, 0, time.UTC)},
+		// a restricted day of month or day of week matches either
+		{"0 0 15 * 4", time.Date(2019, 11, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2019, 11, 6, 11, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		schedule, err := Parse(c.expr)
+		if assert.NoError(t, err, c.expr) {
+			assert.Equal(t, c.next, schedule.Next(now), c.expr)
+		}
+	}
+}
+
+func Test_Parse_Rejects_Invalid_Expressions(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "0 0 30 2 *"} {
+		_, err := Parse(expr)
+		assert.Error(t, err, expr)
+	}
+}
@@ -0,0 +1,644 @@
+// Package docker implements backend.Backend on top of a plain Docker Engine
+// API reachable over a unix socket. Every replica of a function is a
+// container labeled with backend.FunctionLabel; the containers join a user
+// defined network with the function name as alias, so the watchdog is
+// reachable as http://<function>:8080 from inside that network. Functions
+// scaled to zero keep a placeholder container that is never started.
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/juju/errors"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	envProcess   = "fprocess"
+	stateRunning = "running"
+
+	// the engine merges the env vars and labels of the image into the
+	// config of the containers, these labels list the deployed keys
+	envKeysLabel   = "com.openfaas.rancher.env-keys"
+	labelKeysLabel = "com.openfaas.rancher.label-keys"
+	// placeholderLabel marks the container keeping the spec of a function
+	// scaled to zero
+	placeholderLabel = "com.openfaas.rancher.placeholder"
+)
+
+var (
+	logger = logrus.WithField("package", "docker")
+)
+
+type containerSummary struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	Labels map[string]string `json:"Labels"`
+	State  string            `json:"State"`
+	// Status is human readable and includes the health, e.g. "Up 2 hours (healthy)"
+	Status          string          `json:"Status"`
+	NetworkSettings networkSettings `json:"NetworkSettings"`
+}
+
+type networkSettings struct {
+	Networks map[string]networkEndpoint `json:"Networks"`
+}
+
+type networkEndpoint struct {
+	IPAddress string `json:"IPAddress"`
+}
+
+type containerConfig struct {
+	Image  string            `json:"Image"`
+	Env    []string          `json:"Env"`
+	Labels map[string]string `json:"Labels"`
+}
+
+type containerInspect struct {
+	ID     string          `json:"Id"`
+	Config containerConfig `json:"Config"`
+}
+
+type restartPolicy struct {
+	Name string `json:"Name"`
+}
+
+type hostConfig struct {
+	NetworkMode   string        `json:"NetworkMode,omitempty"`
+	RestartPolicy restartPolicy `json:"RestartPolicy"`
+}
+
+type endpointConfig struct {
+	Aliases []string `json:"Aliases"`
+}
+
+type networkingConfig struct {
+	EndpointsConfig map[string]endpointConfig `json:"EndpointsConfig"`
+}
+
+type containerCreate struct {
+	containerConfig
+	HostConfig       hostConfig        `json:"HostConfig"`
+	NetworkingConfig *networkingConfig `json:"NetworkingConfig,omitempty"`
+}
+
+type containerCreated struct {
+	ID string `json:"Id"`
+}
+
+// Backend implements backend.Backend for a plain Docker Engine
+type Backend struct {
+	client  *client
+	network string
+}
+
+// NewBackend creates a backend talking to the docker engine listening on socket.
+// Function containers are attached to network, which must already exist.
+func NewBackend(socket string, network string) *Backend {
+	b := Backend{
+		client:  newClient(socket),
+		network: network,
+	}
+
+	return &b
+}
+
+// ListFunctions lists all functions with at least one container.
+// Namespaces are not supported and ignored by this backend.
+func (b *Backend) ListFunctions(namespace string) ([]backend.Function, error) {
+	containers, err := b.listContainers("")
+	if err != nil {
+		return nil, errors.Annotate(err, "listContainers")
+	}
+
+	byName := make(map[string][]containerSummary)
+	for _, c := range containers {
+		name := c.Labels[backend.FunctionLabel]
+		byName[name] = append(byName[name], c)
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	functions := []backend.Function{}
+	for _, name := range names {
+		function, err := b.functionFromContainers(name, byName[name])
+		if err != nil {
+			return nil, errors.Annotate(err, "functionFromContainers")
+		}
+		functions = append(functions, *function)
+	}
+
+	return functions, nil
+}
+
+// FindFunction finds a function by its container label
+func (b *Backend) FindFunction(name, namespace string) (*backend.Function, error) {
+	containers, err := b.listContainers(name)
+	if err != nil {
+		return nil, errors.Annotate(err, "listContainers")
+	}
+
+	if len(containers) == 0 {
+		return nil, backend.ErrFunctionNotFound
+	}
+
+	return b.functionFromContainers(name, containers)
+}
+
+// DeployFunction pulls the image and starts a single replica
+func (b *Backend) DeployFunction(spec *backend.FunctionSpec) error {
+	if len(spec.Secrets) > 0 {
+		return errors.Annotate(backend.ErrNotSupported, "secrets")
+	}
+
+	containers, err := b.listContainers(spec.Name)
+	if err != nil {
+		return errors.Annotate(err, "listContainers")
+	}
+
+	if len(containers) > 0 {
+		return errors.Errorf("function %q already exists", spec.Name)
+	}
+
+	if err := b.pullImage(spec.Image); err != nil {
+		return errors.Annotate(err, "pullImage")
+	}
+
+	return errors.Annotate(b.startReplicas(spec, nil, 1, true), "startReplicas")
+}
+
+// UpdateFunction replaces all containers of the function keeping its scale
+func (b *Backend) UpdateFunction(spec *backend.FunctionSpec) error {
+	if len(spec.Secrets) > 0 {
+		return errors.Annotate(backend.ErrNotSupported, "secrets")
+	}
+
+	containers, err := b.listContainers(spec.Name)
+	if err != nil {
+		return errors.Annotate(err, "listContainers")
+	}
+
+	if len(containers) == 0 {
+		return backend.ErrFunctionNotFound
+	}
+
+	if err := b.pullImage(spec.Image); err != nil {
+		return errors.Annotate(err, "pullImage")
+	}
+
+	replicas, placeholders := splitPlaceholders(containers)
+	if len(replicas) == 0 {
+		if err := b.removeContainers(placeholders); err != nil {
+			return errors.Annotate(err, "removeContainers")
+		}
+
+		return errors.Annotate(b.createPlaceholder(spec), "createPlaceholder")
+	}
+
+	// start the new replicas first, like rancher's start-first upgrades,
+	// the replicas of paused functions are only created
+	if err := b.startReplicas(spec, replicas, len(replicas), !spec.Paused); err != nil {
+		return errors.Annotate(err, "startReplicas")
+	}
+
+	return errors.Annotate(b.removeContainers(containers), "removeContainers")
+}
+
+// DeleteFunction removes all containers of the function
+func (b *Backend) DeleteFunction(name, namespace string) error {
+	containers, err := b.listContainers(name)
+	if err != nil {
+		return errors.Annotate(err, "listContainers")
+	}
+
+	if len(containers) == 0 {
+		return backend.ErrFunctionNotFound
+	}
+
+	return errors.Annotate(b.removeContainers(containers), "removeContainers")
+}
+
+// ScaleFunction starts or removes containers until replicas are running.
+// Scaled to zero a placeholder container keeps the spec of the function.
+func (b *Backend) ScaleFunction(name, namespace string, replicas uint64) error {
+	containers, err := b.listContainers(name)
+	if err != nil {
+		return errors.Annotate(err, "listContainers")
+	}
+
+	if len(containers) == 0 {
+		return backend.ErrFunctionNotFound
+	}
+
+	running, placeholders := splitPlaceholders(containers)
+	current := uint64(len(running))
+	if replicas == current {
+		return nil
+	}
+
+	inspect, err := b.inspectContainer(containers[0].ID)
+	if err != nil {
+		return errors.Annotate(err, "inspectContainer")
+	}
+	spec := specFromConfig(name, &inspect.Config)
+
+	if replicas > current {
+		if err := b.startReplicas(spec, running, int(replicas-current), true); err != nil {
+			return errors.Annotate(err, "startReplicas")
+		}
+
+		return errors.Annotate(b.removeContainers(placeholders), "removeContainers")
+	}
+
+	if replicas == 0 {
+		if err := b.createPlaceholder(spec); err != nil {
+			return errors.Annotate(err, "createPlaceholder")
+		}
+	}
+
+	// the engine lists the newest containers first
+	return errors.Annotate(b.removeContainers(running[:current-replicas]), "removeContainers")
+}
+
+// DeactivateFunction stops all containers of the function
+func (b *Backend) DeactivateFunction(name, namespace string) error {
+	return b.forEachContainer(name, "stop")
+}
+
+// ActivateFunction starts all stopped containers of the function
+func (b *Backend) ActivateFunction(name, namespace string) error {
+	return b.forEachContainer(name, "start")
+}
+
+// ListInstances lists the running containers of the function with their
+// address in the function network
+func (b *Backend) ListInstances(name, namespace string) ([]backend.Instance, error) {
+	containers, err := b.listContainers(name)
+	if err != nil {
+		return nil, errors.Annotate(err, "listContainers")
+	}
+
+	if len(containers) == 0 {
+		return nil, backend.ErrFunctionNotFound
+	}
+
+	instances := []backend.Instance{}
+	for _, c := range containers {
+		address := c.NetworkSettings.Networks[b.network].IPAddress
+		if c.State != stateRunning || address == "" {
+			continue
+		}
+
+		instances = append(instances, backend.Instance{
+			ID:      c.ID,
+			Address: address,
+			Healthy: !strings.Contains(c.Status, "(unhealthy)") && !strings.Contains(c.Status, "(health: starting)"),
+		})
+	}
+
+	return instances, nil
+}
+
+// ListNamespaces is not supported, all functions share the configured network
+func (b *Backend) ListNamespaces() ([]string, error) {
+	return nil, backend.ErrNotSupported
+}
+
+// ListSecrets is not supported outside of swarm mode
+func (b *Backend) ListSecrets(namespace string) ([]types.Secret, error) {
+	return nil, backend.ErrNotSupported
+}
+
+// EnsureSecret is not supported outside of swarm mode
+func (b *Backend) EnsureSecret(secret *types.Secret) error {
+	return backend.ErrNotSupported
+}
+
+// DeleteSecret is not supported outside of swarm mode
+func (b *Backend) DeleteSecret(name, namespace string) error {
+	return backend.ErrNotSupported
+}
+
+func (b *Backend) listContainers(name string) ([]containerSummary, error) {
+	label := backend.FunctionLabel
+	if name != "" {
+		label = fmt.Sprintf("%s=%s", backend.FunctionLabel, name)
+	}
+
+	filters, err := json.Marshal(map[string][]string{
+		"label": {label},
+	})
+	if err != nil {
+		return nil, errors.Annotate(err, "Marshal")
+	}
+
+	query := url.Values{}
+	query.Set("all", "true")
+	query.Set("filters", string(filters))
+
+	var containers []containerSummary
+	if err := b.client.do("GET", "/containers/json", query, nil, &containers); err != nil {
+		return nil, errors.Annotate(err, "do")
+	}
+
+	return containers, nil
+}
+
+// forEachContainer runs action on all containers of the function,
+// the engine ignores starting running and stopping stopped containers
+func (b *Backend) forEachContainer(name, action string) error {
+	containers, err := b.listContainers(name)
+	if err != nil {
+		return errors.Annotate(err, "listContainers")
+	}
+
+	if len(containers) == 0 {
+		return backend.ErrFunctionNotFound
+	}
+
+	replicas, _ := splitPlaceholders(containers)
+	for _, c := range replicas {
+		if err := b.client.do("POST", "/containers/"+c.ID+"/"+action, nil, nil, nil); err != nil {
+			return errors.Annotatef(err, "%s %s", action, containerName(&c))
+		}
+	}
+
+	return nil
+}
+
+func (b *Backend) inspectContainer(id string) (*containerInspect, error) {
+	inspect := containerInspect{}
+	if err := b.client.do("GET", "/containers/"+id+"/json", nil, nil, &inspect); err != nil {
+		return nil, errors.Annotate(err, "do")
+	}
+
+	return &inspect, nil
+}
+
+func (b *Backend) removeContainers(containers []containerSummary) error {
+	for _, c := range containers {
+		if err := b.removeContainer(c.ID); err != nil {
+			return errors.Annotate(err, "removeContainer")
+		}
+	}
+
+	return nil
+}
+
+func (b *Backend) removeContainer(id string) error {
+	query := url.Values{}
+	query.Set("force", "true")
+
+	err := b.client.do("DELETE", "/containers/"+id, query, nil, nil)
+	if err != nil && errors.Cause(err) != ErrNotFound {
+		return errors.Annotate(err, "do")
+	}
+
+	return nil
+}
+
+func (b *Backend) pullImage(image string) error {
+	query := url.Values{}
+	query.Set("fromImage", image)
+	if !strings.Contains(image, "@") && !strings.Contains(image[strings.LastIndex(image, "/")+1:], ":") {
+		// without a tag the engine would pull every tag of the repository
+		query.Set("tag", "latest")
+	}
+
+	return errors.Annotate(b.client.do("POST", "/images/create", query, nil, nil), "do")
+}
+
+// startReplicas creates count new containers and starts them if start is
+// set, existing lists the containers already running
+func (b *Backend) startReplicas(spec *backend.FunctionSpec, existing []containerSummary, count int, start bool) error {
+	used := make(map[string]bool)
+	for i := range existing {
+		used[containerName(&existing[i])] = true
+	}
+
+	create := b.createConfig(spec)
+	for index := 1; count > 0; index++ {
+		name := fmt.Sprintf("%s-%d", spec.Name, index)
+		if used[name] {
+			continue
+		}
+
+		query := url.Values{}
+		query.Set("name", name)
+
+		created := containerCreated{}
+		if err := b.client.do("POST", "/containers/create", query, &create, &created); err != nil {
+			return errors.Annotatef(err, "create %s", name)
+		}
+
+		count--
+		if !start {
+			logger.Debugf("created container %s for function %q", name, spec.Name)
+			continue
+		}
+
+		if err := b.client.do("POST", "/containers/"+created.ID+"/start", nil, nil, nil); err != nil {
+			return errors.Annotatef(err, "start %s", name)
+		}
+
+		logger.Debugf("started container %s for function %q", name, spec.Name)
+	}
+
+	return nil
+}
+
+// createPlaceholder creates the container kept for a function scaled to zero
+func (b *Backend) createPlaceholder(spec *backend.FunctionSpec) error {
+	create := b.createConfig(spec)
+	create.Labels[placeholderLabel] = "true"
+
+	query := url.Values{}
+	query.Set("name", spec.Name+"-placeholder")
+
+	created := containerCreated{}
+	return errors.Annotate(b.client.do("POST", "/containers/create", query, &create, &created), "create")
+}
+
+func (b *Backend) createConfig(spec *backend.FunctionSpec) containerCreate {
+	create := containerCreate{
+		containerConfig: configFromSpec(spec),
+		HostConfig: hostConfig{
+			NetworkMode:   b.network,
+			RestartPolicy: restartPolicy{Name: "always"},
+		},
+	}
+
+	if b.network != "" {
+		create.NetworkingConfig = &networkingConfig{
+			EndpointsConfig: map[string]endpointConfig{
+				b.network: {Aliases: []string{spec.Name}},
+			},
+		}
+	}
+
+	return create
+}
+
+func (b *Backend) functionFromContainers(name string, containers []containerSummary) (*backend.Function, error) {
+	inspect, err := b.inspectContainer(containers[0].ID)
+	if err != nil {
+		return nil, errors.Annotate(err, "inspectContainer")
+	}
+
+	replicas, _ := splitPlaceholders(containers)
+	spec := specFromConfig(name, &inspect.Config)
+	function := backend.Function{
+		Name:       name,
+		Image:      spec.Image,
+		EnvProcess: spec.EnvProcess,
+		EnvVars:    spec.EnvVars,
+		Labels:     spec.Labels,
+		Replicas:   uint64(len(replicas)),
+		State:      backend.StateInactive,
+		Status:     backend.StatusInactive,
+	}
+
+	for _, c := range replicas {
+		if c.State == stateRunning {
+			function.AvailableReplicas++
+			function.State = backend.StateActive
+			function.Status = backend.StatusReady
+		}
+	}
+
+	if function.AvailableReplicas > 0 && function.AvailableReplicas < function.Replicas {
+		function.Status = backend.StatusDegraded
+	}
+
+	return &function, nil
+}
+
+func configFromSpec(spec *backend.FunctionSpec) containerConfig {
+	config := containerConfig{
+		Image:  spec.Image,
+		Labels: make(map[string]string),
+	}
+
+	labelKeys := []string{}
+	for k, v := range spec.Labels {
+		config.Labels[k] = v
+		labelKeys = append(labelKeys, k)
+	}
+	config.Labels[backend.FunctionLabel] = spec.Name
+
+	envKeys := []string{}
+	for k, v := range spec.EnvVars {
+		config.Env = append(config.Env, k+"="+v)
+		envKeys = append(envKeys, k)
+	}
+
+	if len(spec.EnvProcess) > 0 {
+		config.Env = append(config.Env, envProcess+"="+spec.EnvProcess)
+	}
+
+	config.Labels[labelKeysLabel] = joinKeys(labelKeys)
+	config.Labels[envKeysLabel] = joinKeys(envKeys)
+
+	sort.Strings(config.Env)
+	return config
+}
+
+func specFromConfig(name string, config *containerConfig) *backend.FunctionSpec {
+	spec := backend.FunctionSpec{
+		Name:    name,
+		Image:   config.Image,
+		EnvVars: make(map[string]string),
+		Labels:  make(map[string]string),
+	}
+
+	// containers of older versions don't list the deployed keys
+	labelKeys, filterLabels := splitKeys(config.Labels, labelKeysLabel)
+	envKeys, filterEnv := splitKeys(config.Labels, envKeysLabel)
+
+	for k, v := range config.Labels {
+		switch k {
+		case labelKeysLabel, envKeysLabel, placeholderLabel:
+			continue
+		}
+
+		if k == backend.FunctionLabel || !filterLabels || labelKeys[k] {
+			spec.Labels[k] = v
+		}
+	}
+
+	for _, kv := range config.Env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		if parts[0] == envProcess {
+			spec.EnvProcess = parts[1]
+		} else if !filterEnv || envKeys[parts[0]] {
+			spec.EnvVars[parts[0]] = parts[1]
+		}
+	}
+
+	return &spec
+}
+
+func joinKeys(keys []string) string {
+	sort.Strings(keys)
+	buf, _ := json.Marshal(keys)
+	return string(buf)
+}
+
+// splitKeys returns the keys listed in the label and whether it is set
+func splitKeys(labels map[string]string, label string) (map[string]bool, bool) {
+	value, ok := labels[label]
+	if !ok {
+		return nil, false
+	}
+
+	keys := []string{}
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		logger.Warnf("ignoring invalid %s label %q: %v", label, value, err)
+		return nil, false
+	}
+
+	result := make(map[string]bool)
+	for _, k := range keys {
+		result[k] = true
+	}
+
+	return result, true
+}
+
+// splitPlaceholders separates the replicas of a function from its placeholders
+func splitPlaceholders(containers []containerSummary) ([]containerSummary, []containerSummary) {
+	replicas := []containerSummary{}
+	placeholders := []containerSummary{}
+	for _, c := range containers {
+		if _, ok := c.Labels[placeholderLabel]; ok {
+			placeholders = append(placeholders, c)
+		} else {
+			replicas = append(replicas, c)
+		}
+	}
+
+	return replicas, placeholders
+}
+
+func containerName(c *containerSummary) string {
+	if len(c.Names) == 0 {
+		return c.ID
+	}
+
+	return strings.TrimPrefix(c.Names[0], "/")
+}
@@ -0,0 +1,326 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeEngine serves the small subset of the Docker Engine API the backend uses
+type fakeEngine struct {
+	sync.Mutex
+	containers []containerInspect
+	names      map[string]string
+	stopped    map[string]bool
+	created    map[string]bool
+	pulls      []string
+	nextID     int
+}
+
+func (e *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.Lock()
+	defer e.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/images/create":
+		e.pulls = append(e.pulls, r.URL.Query().Get("fromImage")+":"+r.URL.Query().Get("tag"))
+		w.Write([]byte(`{"status":"done"}`))
+	case r.Method == "GET" && r.URL.Path == "/containers/json":
+		filters := map[string][]string{}
+		json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+		label := strings.SplitN(filters["label"][0], "=", 2)
+
+		var list []containerSummary
+		// newest first
+		for i := len(e.containers) - 1; i >= 0; i-- {
+			c := e.containers[i]
+			value, ok := c.Config.Labels[label[0]]
+			if !ok || (len(label) == 2 && value != label[1]) {
+				continue
+			}
+			state, status := stateRunning, "Up 1 minute"
+			networks := map[string]networkEndpoint{"faas-functions": {IPAddress: "172.18.0." + strings.TrimPrefix(c.ID, "c")}}
+			if e.stopped[c.ID] {
+				state, status = "exited", "Exited (0) 1 minute ago"
+				networks = nil
+			}
+			if e.created[c.ID] {
+				state, status = "created", "Created"
+				networks = nil
+			}
+			list = append(list, containerSummary{
+				ID:              c.ID,
+				Names:           []string{"/" + e.names[c.ID]},
+				Labels:          c.Config.Labels,
+				State:           state,
+				Status:          status,
+				NetworkSettings: networkSettings{Networks: networks},
+			})
+		}
+		json.NewEncoder(w).Encode(list)
+	case r.Method == "POST" && r.URL.Path == "/containers/create":
+		create := containerCreate{}
+		json.NewDecoder(r.Body).Decode(&create)
+		// like the engine, merge the env vars and labels of the image
+		create.Env = append(create.Env, "PATH=/usr/bin")
+		create.Labels["maintainer"] = "someone"
+		e.nextID++
+		id := fmt.Sprintf("c%d", e.nextID)
+		e.created[id] = true
+		e.containers = append(e.containers, containerInspect{ID: id, Config: create.containerConfig})
+		e.names[id] = r.URL.Query().Get("name")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(containerCreated{ID: id})
+	case r.Method == "POST" && len(parts) == 3 && parts[2] == "start":
+		delete(e.stopped, parts[1])
+		delete(e.created, parts[1])
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "POST" && len(parts) == 3 && parts[2] == "stop":
+		e.stopped[parts[1]] = true
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "GET" && len(parts) == 3 && parts[2] == "json":
+		for _, c := range e.containers {
+			if c.ID == parts[1] {
+				json.NewEncoder(w).Encode(c)
+				return
+			}
+		}
+		http.Error(w, `{"message":"no such container"}`, http.StatusNotFound)
+	case r.Method == "DELETE" && len(parts) == 2:
+		for i, c := range e.containers {
+			if c.ID == parts[1] {
+				e.containers = append(e.containers[:i], e.containers[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		http.Error(w, `{"message":"no such container"}`, http.StatusNotFound)
+	default:
+		http.Error(w, `{"message":"unexpected request"}`, http.StatusInternalServerError)
+	}
+}
+
+func newTestBackend(t *testing.T) (*Backend, *fakeEngine, func()) {
+	dir, err := ioutil.TempDir("", "faas-rancher-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := &fakeEngine{names: make(map[string]string), stopped: make(map[string]bool), created: make(map[string]bool)}
+	server := &http.Server{Handler: engine}
+	go server.Serve(listener)
+
+	return NewBackend(socket, "faas-functions"), engine, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_Backend_Deploy_Scale_Delete(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	b, engine, cleanup := newTestBackend(t)
+	defer cleanup()
+
+	spec := &backend.FunctionSpec{
+		Name:       "some-function",
+		Image:      "some/image",
+		EnvProcess: "cat",
+		EnvVars:    map[string]string{"SOME_ENV": "SOME_VALUE"},
+		Labels:     map[string]string{"com.example": "label"},
+	}
+
+	// Act & Assert
+	assert.NoError(b.DeployFunction(spec))
+	assert.Equal([]string{"some/image:latest"}, engine.pulls)
+
+	function, err := b.FindFunction("some-function", "")
+	assert.NoError(err)
+	assert.Equal(&backend.Function{
+		Name:              "some-function",
+		Image:             "some/image",
+		EnvProcess:        "cat",
+		EnvVars:           map[string]string{"SOME_ENV": "SOME_VALUE"},
+		Labels:            map[string]string{"com.example": "label", "faas_function": "some-function"},
+		Replicas:          1,
+		AvailableReplicas: 1,
+		State:             backend.StateActive,
+		Status:            backend.StatusReady,
+	}, function)
+
+	assert.NoError(b.ScaleFunction("some-function", "", 3))
+	functions, err := b.ListFunctions("")
+	assert.NoError(err)
+	assert.Len(functions, 1)
+	assert.Equal(uint64(3), functions[0].Replicas)
+
+	assert.NoError(b.ScaleFunction("some-function", "", 1))
+	assert.Len(engine.containers, 1)
+	assert.Equal("some-function-1", engine.names[engine.containers[0].ID])
+
+	assert.NoError(b.DeleteFunction("some-function", ""))
+	_, err = b.FindFunction("some-function", "")
+	assert.Equal(backend.ErrFunctionNotFound, errors.Cause(err))
+}
+
+func Test_Backend_Scale_To_Zero(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	b, engine, cleanup := newTestBackend(t)
+	defer cleanup()
+
+	spec := &backend.FunctionSpec{
+		Name:    "some-function",
+		Image:   "some/image",
+		EnvVars: map[string]string{"SOME_ENV": "SOME_VALUE"},
+	}
+	assert.NoError(b.DeployFunction(spec))
+	assert.NoError(b.ScaleFunction("some-function", "", 2))
+
+	// Act & Assert
+	assert.NoError(b.ScaleFunction("some-function", "", 0))
+	assert.Len(engine.containers, 1)
+	assert.Equal("some-function-placeholder", engine.names[engine.containers[0].ID])
+
+	function, err := b.FindFunction("some-function", "")
+	if assert.NoError(err) {
+		assert.Equal(uint64(0), function.Replicas)
+		assert.Equal(map[string]string{"SOME_ENV": "SOME_VALUE"}, function.EnvVars)
+	}
+
+	assert.NoError(b.ActivateFunction("some-function", ""))
+	instances, err := b.ListInstances("some-function", "")
+	assert.NoError(err)
+	assert.Empty(instances)
+
+	spec.Image = "some/image:2.0"
+	assert.NoError(b.UpdateFunction(spec))
+	function, err = b.FindFunction("some-function", "")
+	if assert.NoError(err) {
+		assert.Equal("some/image:2.0", function.Image)
+		assert.Equal(uint64(0), function.Replicas)
+	}
+
+	assert.NoError(b.ScaleFunction("some-function", "", 1))
+	function, err = b.FindFunction("some-function", "")
+	if assert.NoError(err) {
+		assert.Equal(uint64(1), function.Replicas)
+		assert.Equal(uint64(1), function.AvailableReplicas)
+		assert.Equal("some/image:2.0", function.Image)
+	}
+	assert.Len(engine.containers, 1)
+	assert.Equal("some-function-1", engine.names[engine.containers[0].ID])
+}
+
+func Test_Backend_Deactivate_Activate(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	b, _, cleanup := newTestBackend(t)
+	defer cleanup()
+
+	assert.NoError(b.DeployFunction(&backend.FunctionSpec{Name: "some-function", Image: "some/image"}))
+	assert.NoError(b.ScaleFunction("some-function", "", 2))
+
+	// Act & Assert
+	assert.NoError(b.DeactivateFunction("some-function", ""))
+	function, err := b.FindFunction("some-function", "")
+	assert.NoError(err)
+	assert.Equal(backend.StateInactive, function.State)
+	assert.Equal(uint64(2), function.Replicas)
+	assert.Equal(uint64(0), function.AvailableReplicas)
+
+	assert.NoError(b.ActivateFunction("some-function", ""))
+	function, err = b.FindFunction("some-function", "")
+	assert.NoError(err)
+	assert.Equal(backend.StateActive, function.State)
+	assert.Equal(uint64(2), function.AvailableReplicas)
+
+	assert.Equal(backend.ErrFunctionNotFound, errors.Cause(b.ActivateFunction("missing", "")))
+}
+
+func Test_Backend_Update_Keeps_Paused_Function_Stopped(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	b, _, cleanup := newTestBackend(t)
+	defer cleanup()
+
+	assert.NoError(b.DeployFunction(&backend.FunctionSpec{Name: "some-function", Image: "some/image:1"}))
+	assert.NoError(b.ScaleFunction("some-function", "", 2))
+	assert.NoError(b.DeactivateFunction("some-function", ""))
+
+	// Act
+	err := b.UpdateFunction(&backend.FunctionSpec{Name: "some-function", Image: "some/image:2", Paused: true})
+
+	// Assert
+	assert.NoError(err)
+	function, err := b.FindFunction("some-function", "")
+	if assert.NoError(err) {
+		assert.Equal("some/image:2", function.Image)
+		assert.Equal(backend.StateInactive, function.State)
+		assert.Equal(uint64(2), function.Replicas)
+		assert.Equal(uint64(0), function.AvailableReplicas)
+	}
+
+	assert.NoError(b.ActivateFunction("some-function", ""))
+	function, _ = b.FindFunction("some-function", "")
+	assert.Equal(uint64(2), function.AvailableReplicas)
+}
+
+func Test_Backend_ListInstances(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	b, _, cleanup := newTestBackend(t)
+	defer cleanup()
+
+	assert.NoError(b.DeployFunction(&backend.FunctionSpec{Name: "some-function", Image: "some/image"}))
+	assert.NoError(b.ScaleFunction("some-function", "", 2))
+
+	// Act
+	instances, err := b.ListInstances("some-function", "")
+	_, missingErr := b.ListInstances("missing", "")
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal([]backend.Instance{
+		{ID: "c2", Address: "172.18.0.2", Healthy: true},
+		{ID: "c1", Address: "172.18.0.1", Healthy: true},
+	}, instances)
+	assert.Equal(backend.ErrFunctionNotFound, errors.Cause(missingErr))
+}
+
+func Test_Backend_Rejects_Secrets(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	b, _, cleanup := newTestBackend(t)
+	defer cleanup()
+
+	spec := &backend.FunctionSpec{
+		Name:    "some-function",
+		Image:   "some/image:1.0",
+		Secrets: []string{"some-secret"},
+	}
+
+	// Act
+	err := b.DeployFunction(spec)
+
+	// Assert
+	assert.Equal(backend.ErrNotSupported, errors.Cause(err))
+}
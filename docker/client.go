@@ -0,0 +1,104 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	// the host part is ignored when dialing the unix socket
+	baseURL = "http://docker"
+)
+
+// ErrNotFound is returned if the engine responds with 404
+var ErrNotFound = errors.New("not found")
+
+type apiError struct {
+	Message string `json:"message"`
+}
+
+// client is a minimal Docker Engine API client talking over a unix socket
+type client struct {
+	httpClient *http.Client
+}
+
+func newClient(socket string) *client {
+	// no overall timeout, image pulls may take a while
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	c := client{
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+
+	return &c
+}
+
+func (c *client) do(method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return errors.Annotate(err, "Marshal")
+		}
+		body = bytes.NewReader(buf)
+	}
+
+	u := baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return errors.Annotate(err, "NewRequest")
+	}
+
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Annotatef(err, "%s %s", method, path)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errors.Annotatef(ErrNotFound, "%s %s", method, path)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := apiError{}
+		buf, _ := ioutil.ReadAll(resp.Body)
+		if err := json.Unmarshal(buf, &apiErr); err != nil || apiErr.Message == "" {
+			apiErr.Message = string(buf)
+		}
+		return errors.Errorf("%s %s: %d %s", method, path, resp.StatusCode, apiErr.Message)
+	}
+
+	if out == nil {
+		// drain streamed responses like image pulls
+		_, err := io.Copy(ioutil.Discard, resp.Body)
+		return errors.Annotate(err, "Copy")
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.Annotate(err, "Decode")
+	}
+
+	return nil
+}
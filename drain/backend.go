@@ -0,0 +1,81 @@
+package drain
+
+import (
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+)
+
+// Backend decorates a backend.Backend to drain functions before all
+// their replicas are removed by deleting, deactivating or scaling them to
+// zero. Scaling down to fewer replicas is not drained: the orchestrator
+// picks the replicas to remove and the requests can't be told apart by
+// replica, so requests in flight on a removed replica may fail.
+type Backend struct {
+	backend.Backend
+
+	tracker *Tracker
+	timeout time.Duration
+}
+
+// NewBackend creates a draining backend waiting up to timeout for in-flight requests
+func NewBackend(b backend.Backend, tracker *Tracker, timeout time.Duration) *Backend {
+	d := Backend{
+		Backend: b,
+		tracker: tracker,
+		timeout: timeout,
+	}
+
+	return &d
+}
+
+// ListFunctions marks draining functions
+func (d *Backend) ListFunctions(namespace string) ([]backend.Function, error) {
+	functions, err := d.Backend.ListFunctions(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range functions {
+		functions[i].Draining = d.tracker.Draining(functions[i].Name, functions[i].Namespace)
+	}
+
+	return functions, nil
+}
+
+// FindFunction marks the function if it is draining
+func (d *Backend) FindFunction(name, namespace string) (*backend.Function, error) {
+	function, err := d.Backend.FindFunction(name, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	function.Draining = d.tracker.Draining(name, namespace)
+	return function, nil
+}
+
+// DeleteFunction drains the function before deleting it
+func (d *Backend) DeleteFunction(name, namespace string) error {
+	d.tracker.Drain(name, namespace, d.timeout)
+	defer d.tracker.Release(name, namespace)
+
+	return d.Backend.DeleteFunction(name, namespace)
+}
+
+// DeactivateFunction drains the function before deactivating it
+func (d *Backend) DeactivateFunction(name, namespace string) error {
+	d.tracker.Drain(name, namespace, d.timeout)
+	defer d.tracker.Release(name, namespace)
+
+	return d.Backend.DeactivateFunction(name, namespace)
+}
+
+// ScaleFunction drains the function before scaling it to zero
+func (d *Backend) ScaleFunction(name, namespace string, replicas uint64) error {
+	if replicas == 0 {
+		d.tracker.Drain(name, namespace, d.timeout)
+		defer d.tracker.Release(name, namespace)
+	}
+
+	return d.Backend.ScaleFunction(name, namespace, replicas)
+}
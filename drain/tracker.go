@@ -0,0 +1,194 @@
+// Package drain lets functions finish their in-flight requests before
+// they are deleted. The Tracker counts the requests passing the proxy,
+// the Backend decorator waits for them before removing a function.
+package drain
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	logger = logrus.WithField("package", "drain")
+
+	ErrDraining = errors.New("function is being deleted")
+	ErrBusy     = errors.New("function serves its limit of requests")
+)
+
+type function struct {
+	inflight int
+	draining bool
+	// closed when the last in-flight request of a draining function finished
+	idle chan struct{}
+}
+
+// Tracker counts the in-flight requests of every function
+type Tracker struct {
+	defaultNamespace string
+
+	mu        sync.Mutex
+	functions map[string]*function
+}
+
+// NewTracker creates a tracker. Functions of defaultNamespace
+// are also addressed without namespace.
+func NewTracker(defaultNamespace string) *Tracker {
+	t := Tracker{
+		defaultNamespace: defaultNamespace,
+		functions:        make(map[string]*function),
+	}
+
+	return &t
+}
+
+// Track counts the requests next serves for the function in the
+// route variable name. Requests to draining functions are rejected
+// with 410 Gone.
+func (t *Tracker) Track(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, namespace := backend.SplitFunctionName(mux.Vars(r)["name"])
+		if err := t.Enter(name, namespace, 0); err != nil {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		defer t.Leave(name, namespace)
+
+		next(w, r)
+	}
+}
+
+// InFlight returns the number of requests the function is serving
+func (t *Tracker) InFlight(name, namespace string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if f, ok := t.functions[t.key(name, namespace)]; ok {
+		return f.inflight
+	}
+
+	return 0
+}
+
+// Draining reports whether the function is draining
+func (t *Tracker) Draining(name, namespace string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.functions[t.key(name, namespace)]
+	return ok && f.draining
+}
+
+// Drain rejects new requests to the function and waits up to timeout for
+// the in-flight requests to finish. It returns the number of requests
+// still in flight after the timeout.
+func (t *Tracker) Drain(name, namespace string, timeout time.Duration) int {
+	t.mu.Lock()
+	key := t.key(name, namespace)
+	f, ok := t.functions[key]
+	if !ok {
+		f = &function{}
+		t.functions[key] = f
+	}
+
+	f.draining = true
+	if f.inflight == 0 {
+		t.mu.Unlock()
+		return 0
+	}
+
+	if f.idle == nil {
+		f.idle = make(chan struct{})
+	}
+	idle := f.idle
+	t.mu.Unlock()
+
+	logger.Infof("draining %q", key)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-idle:
+		return 0
+	case <-timer.C:
+		remaining := t.InFlight(name, namespace)
+		logger.Warnf("%d requests of %q still in flight after %s", remaining, key, timeout)
+		return remaining
+	}
+}
+
+// Release accepts requests to the function again
+func (t *Tracker) Release(name, namespace string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := t.key(name, namespace)
+	if f, ok := t.functions[key]; ok {
+		f.draining = false
+		t.forget(key, f)
+	}
+}
+
+// Enter counts a request of the function unless it serves limit requests
+// already, 0 is no limit. It fails with ErrBusy at the limit and with
+// ErrDraining while the function drains.
+func (t *Tracker) Enter(name, namespace string, limit int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := t.key(name, namespace)
+	f, ok := t.functions[key]
+	if !ok {
+		f = &function{}
+		t.functions[key] = f
+	}
+
+	if f.draining {
+		return ErrDraining
+	}
+
+	if limit > 0 && f.inflight >= limit {
+		return ErrBusy
+	}
+
+	f.inflight++
+	return nil
+}
+
+// Leave counts a request entered before as finished
+func (t *Tracker) Leave(name, namespace string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := t.key(name, namespace)
+	f := t.functions[key]
+	f.inflight--
+
+	if f.inflight == 0 && f.idle != nil {
+		close(f.idle)
+		f.idle = nil
+	}
+
+	t.forget(key, f)
+}
+
+// forget drops idle functions, t.mu must be held
+func (t *Tracker) forget(key string, f *function) {
+	if f.inflight == 0 && !f.draining {
+		delete(t.functions, key)
+	}
+}
+
+func (t *Tracker) key(name, namespace string) string {
+	if namespace == "" || namespace == t.defaultNamespace {
+		return name
+	}
+
+	return name + "." + namespace
+}
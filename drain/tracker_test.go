@@ -0,0 +1,179 @@
+package drain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/mocks"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// invoke serves a request to the function through the tracked handler
+func invoke(handler http.HandlerFunc, name string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/function/"+name, nil)
+	rr := httptest.NewRecorder()
+	handler(rr, mux.SetURLVars(req, map[string]string{"name": name}))
+	return rr
+}
+
+// blockingHandler blocks requests until release is closed
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func Test_Tracker_Drain_Waits_For_InFlight_Requests(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	tracker := NewTracker("faas-functions")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := tracker.Track(blockingHandler(started, release))
+
+	done := make(chan int)
+	go func() { done <- invoke(handler, "some-function.faas-functions").Code }()
+	<-started
+	assert.Equal(1, tracker.InFlight("some-function", ""))
+
+	// Act
+	drained := make(chan int)
+	go func() { drained <- tracker.Drain("some-function", "", time.Minute) }()
+
+	// Assert
+	for !tracker.Draining("some-function", "faas-functions") {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(http.StatusGone, invoke(handler, "some-function").Code)
+
+	close(release)
+	assert.Equal(http.StatusOK, <-done)
+	assert.Equal(0, <-drained)
+
+	tracker.Release("some-function", "")
+	assert.False(tracker.Draining("some-function", ""))
+	go func() { <-started }()
+	assert.Equal(http.StatusOK, invoke(handler, "some-function").Code)
+}
+
+func Test_Tracker_Drain_Times_Out(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	tracker := NewTracker("faas-functions")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler := tracker.Track(blockingHandler(started, release))
+
+	go invoke(handler, "some-function.dev")
+	<-started
+
+	// Act
+	remaining := tracker.Drain("some-function", "dev", 10*time.Millisecond)
+
+	// Assert
+	assert.Equal(1, remaining)
+	assert.True(tracker.Draining("some-function", "dev"))
+	assert.False(tracker.Draining("some-function", ""))
+}
+
+func Test_Backend_DeleteFunction_Drains_First(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	tracker := NewTracker("faas-functions")
+	b := NewBackend(mockClient, tracker, time.Minute)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := tracker.Track(blockingHandler(started, release))
+	go invoke(handler, "some-function")
+	<-started
+
+	function := backend.Function{Name: "some-function"}
+	mockClient.On("FindFunction", "some-function", "").Return(&function, nil)
+	mockClient.On("DeleteFunction", "some-function", "").Return(nil).Run(func(args mock.Arguments) {
+		assert.Equal(0, tracker.InFlight("some-function", ""))
+	})
+
+	// Act
+	deleted := make(chan error)
+	go func() { deleted <- b.DeleteFunction("some-function", "") }()
+
+	// Assert
+	for !tracker.Draining("some-function", "") {
+		time.Sleep(time.Millisecond)
+	}
+	found, err := b.FindFunction("some-function", "")
+	assert.NoError(err)
+	assert.True(found.Draining)
+
+	close(release)
+	assert.NoError(<-deleted)
+	assert.False(tracker.Draining("some-function", ""))
+	mockClient.AssertExpectations(t)
+}
+
+func Test_Backend_ScaleFunction_Drains_Scaling_To_Zero(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	tracker := NewTracker("faas-functions")
+	b := NewBackend(mockClient, tracker, time.Minute)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := tracker.Track(blockingHandler(started, release))
+	go invoke(handler, "some-function")
+	<-started
+
+	mockClient.On("ScaleFunction", "some-function", "", uint64(1)).Return(nil).Run(func(args mock.Arguments) {
+		assert.False(tracker.Draining("some-function", ""))
+		assert.Equal(1, tracker.InFlight("some-function", ""))
+	})
+	mockClient.On("ScaleFunction", "some-function", "", uint64(0)).Return(nil).Run(func(args mock.Arguments) {
+		assert.Equal(0, tracker.InFlight("some-function", ""))
+	})
+
+	// Act
+	assert.NoError(b.ScaleFunction("some-function", "", 1))
+	scaled := make(chan error)
+	go func() { scaled <- b.ScaleFunction("some-function", "", 0) }()
+
+	// Assert
+	for !tracker.Draining("some-function", "") {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	assert.NoError(<-scaled)
+	assert.False(tracker.Draining("some-function", ""))
+	mockClient.AssertExpectations(t)
+}
+
+func Test_Tracker_Enter_Limit(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	tracker := NewTracker("faas-functions")
+	tracker.Enter("some-function", "", 2)
+	tracker.Enter("some-function", "faas-functions", 2)
+
+	// Act
+	busy := tracker.Enter("some-function", "", 2)
+	unlimited := tracker.Enter("some-function", "", 0)
+	tracker.Leave("some-function", "")
+	tracker.Leave("some-function", "")
+	free := tracker.Enter("some-function", "", 2)
+
+	// Assert
+	assert.Equal(ErrBusy, busy)
+	assert.NoError(unlimited)
+	assert.NoError(free)
+	assert.Equal(2, tracker.InFlight("some-function", ""))
+}
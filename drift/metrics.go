@@ -0,0 +1,59 @@
+package drift
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	orphansDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "drift",
+		Name:      "orphans_deleted_total",
+		Help:      "Metastore entries deleted because their function vanished.",
+	})
+
+	driftedFunctions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "drift",
+		Name:      "drifted_functions",
+		Help:      "Functions differing from their stored spec in the last run.",
+	})
+
+	reapplied = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "drift",
+		Name:      "reapplied_total",
+		Help:      "Drifted functions reset to their stored spec.",
+	})
+
+	reconcileErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "drift",
+		Name:      "errors_total",
+		Help:      "Errors reconciling single functions.",
+	})
+
+	lastRun = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "drift",
+		Name:      "last_run_timestamp_seconds",
+		Help:      "Time of the last reconciliation run.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(orphansDeleted, driftedFunctions, reapplied, reconcileErrors, lastRun)
+}
+
+func observe(report *Report) {
+	orphansDeleted.Add(float64(len(report.Orphans)))
+	driftedFunctions.Set(float64(len(report.Drifted)))
+	reconcileErrors.Add(float64(len(report.Errors)))
+	lastRun.Set(float64(report.Time.Unix()))
+
+	for _, drift := range report.Drifted {
+		if drift.Reapplied {
+			reapplied.Inc()
+		}
+	}
+}
@@ -0,0 +1,295 @@
+// Package drift reconciles the metastore with the functions deployed by
+// the backend. Entries of functions missing in two consecutive runs are
+// deleted, functions changed outside of OpenFaaS are reported and optionally
+// reset to their stored spec.
+package drift
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/helper"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	logger = logrus.WithField("package", "drift")
+)
+
+const (
+	FieldImage      = "image"
+	FieldEnvProcess = "envProcess"
+	FieldEnvVars    = "envVars"
+	FieldLabels     = "labels"
+	FieldSecrets    = "secrets"
+)
+
+// Drift describes a function differing from its stored spec
+type Drift struct {
+	Name      string   `json:"name"`
+	Namespace string   `json:"namespace,omitempty"`
+	Fields    []string `json:"fields"`
+	Reapplied bool     `json:"reapplied"`
+}
+
+// Report is the result of a reconciliation run
+type Report struct {
+	Time time.Time `json:"time"`
+	// Missing functions are deleted if they are still missing in the next run
+	Missing []string `json:"missing"`
+	Orphans []string `json:"orphans"`
+	Drifted []Drift  `json:"drifted"`
+	Errors  []string `json:"errors"`
+}
+
+// Reconciler compares the metastore with the backend
+type Reconciler struct {
+	backend backend.Backend
+	store   metastore.Store
+	reapply bool
+
+	mu   sync.RWMutex
+	last *Report
+	// keys of the functions missing in the last run
+	missing map[string]bool
+}
+
+// NewReconciler creates a reconciler. With reapply set drifted functions
+// are updated to their stored spec.
+func NewReconciler(b backend.Backend, store metastore.Store, reapply bool) *Reconciler {
+	r := Reconciler{
+		backend: b,
+		store:   store,
+		reapply: reapply,
+	}
+
+	return &r
+}
+
+// Run reconciles every interval until stop is closed
+func (r *Reconciler) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Reconcile(); err != nil {
+			logger.Error(errors.Annotate(err, "Reconcile"))
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// LastReport returns the report of the last run or nil if there was none
+func (r *Reconciler) LastReport() *Report {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.last
+}
+
+// Reconcile compares all stored functions with the backend. Errors of single
+// functions are collected in the report, listing failures abort the run.
+func (r *Reconciler) Reconcile() (*Report, error) {
+	metas, err := r.store.List()
+	if err != nil {
+		return nil, errors.Annotate(err, "List [metastore]")
+	}
+
+	r.mu.RLock()
+	missing := r.missing
+	r.mu.RUnlock()
+
+	report := &Report{
+		Time:    time.Now().UTC(),
+		Missing: []string{},
+		Orphans: []string{},
+		Drifted: []Drift{},
+		Errors:  []string{},
+	}
+
+	deployed := make(map[string]map[string]backend.Function)
+	for i := range metas {
+		meta := &metas[i]
+
+		functions, ok := deployed[meta.Namespace]
+		if !ok {
+			list, err := r.backend.ListFunctions(meta.Namespace)
+			if err != nil {
+				return nil, errors.Annotatef(err, "ListFunctions [%s]", meta.Namespace)
+			}
+
+			functions = make(map[string]backend.Function)
+			for _, function := range list {
+				functions[function.Name] = function
+			}
+			deployed[meta.Namespace] = functions
+		}
+
+		function, ok := functions[meta.Service]
+		if !ok {
+			key := string(meta.Key())
+			// a single listing may miss a function, e.g. while it is upgraded
+			if !missing[key] {
+				report.Missing = append(report.Missing, key)
+				continue
+			}
+
+			report.Orphans = append(report.Orphans, key)
+			if err := r.store.Delete(meta); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", meta.Key(), err))
+			}
+			continue
+		}
+
+		fields := Compare(meta, &function)
+		if len(fields) == 0 {
+			continue
+		}
+
+		drift := Drift{
+			Name:      meta.Service,
+			Namespace: meta.Namespace,
+			Fields:    fields,
+		}
+
+		// the spec of entries restored by older versions is incomplete,
+		// paused functions can't be updated
+		if r.reapply && meta.EnvVars != nil && !meta.Paused {
+			if err := r.backend.UpdateFunction(SpecFromMeta(meta)); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", meta.Key(), err))
+			} else {
+				drift.Reapplied = true
+			}
+		}
+
+		report.Drifted = append(report.Drifted, drift)
+	}
+
+	for _, orphan := range report.Orphans {
+		logger.Infof("deleted orphaned metadata of %q", orphan)
+	}
+
+	for _, drift := range report.Drifted {
+		logger.Warnf("function %q drifted in %s", drift.Name, strings.Join(drift.Fields, ", "))
+	}
+
+	observe(report)
+
+	r.mu.Lock()
+	r.last = report
+	r.missing = make(map[string]bool)
+	for _, key := range report.Missing {
+		r.missing[key] = true
+	}
+	r.mu.Unlock()
+
+	return report, nil
+}
+
+// Compare returns the fields of the deployed function differing from the
+// stored spec. Only the env vars and labels of the stored spec are compared,
+// the backends also report the ones set by the image.
+func Compare(meta *metastore.FunctionMeta, function *backend.Function) []string {
+	fields := []string{}
+	if meta.Image != function.Image {
+		fields = append(fields, FieldImage)
+	}
+
+	if meta.EnvProcess != function.EnvProcess {
+		fields = append(fields, FieldEnvProcess)
+	}
+
+	// env vars of entries restored from the backend are unknown
+	if envVars := stringMap(meta.EnvVars); meta.EnvVars != nil && !equalMaps(envVars, only(function.EnvVars, envVars)) {
+		fields = append(fields, FieldEnvVars)
+	}
+
+	if labels := userLabels(stringMap(meta.Labels)); !equalMaps(labels, only(function.Labels, labels)) {
+		fields = append(fields, FieldLabels)
+	}
+
+	if !equalSets(meta.Secrets, function.Secrets) {
+		fields = append(fields, FieldSecrets)
+	}
+
+	return fields
+}
+
+// SpecFromMeta converts stored metadata into the spec it was deployed with
+func SpecFromMeta(meta *metastore.FunctionMeta) *backend.FunctionSpec {
+	return &backend.FunctionSpec{
+		Name:        meta.Service,
+		Namespace:   meta.Namespace,
+		Image:       meta.Image,
+		EnvProcess:  meta.EnvProcess,
+		EnvVars:     stringMap(meta.EnvVars),
+		Labels:      stringMap(meta.Labels),
+		Constraints: meta.Constraints,
+		Secrets:     meta.Secrets,
+	}
+}
+
+func stringMap(m map[string]interface{}) map[string]string {
+	if sm := helper.ToFaasMap(m); sm != nil {
+		return *sm
+	}
+
+	return map[string]string{}
+}
+
+// userLabels drops the labels set by the backends
+func userLabels(labels map[string]string) map[string]string {
+	result := make(map[string]string)
+	for k, v := range labels {
+		if k == backend.FunctionLabel || helper.IsRancherLabel(k) {
+			continue
+		}
+		result[k] = v
+	}
+
+	return result
+}
+
+// only returns the entries of m with the keys of keys
+func only(m map[string]string, keys map[string]string) map[string]string {
+	result := make(map[string]string)
+	for k := range keys {
+		if v, ok := m[k]; ok {
+			result[k] = v
+		}
+	}
+
+	return result
+}
+
+func equalMaps(a, b map[string]string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+func equalSets(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	sa := append([]string{}, a...)
+	sb := append([]string{}, b...)
+	sort.Strings(sa)
+	sort.Strings(sb)
+	return reflect.DeepEqual(sa, sb)
+}
@@ -0,0 +1,139 @@
+package drift
+
+import (
+	"testing"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gitmonster/faas-rancher/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_Reconcile_Deletes_Orphans(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockBackend := new(mocks.Backend)
+	store := metastore.NewMemoryStore()
+	store.Put(&metastore.FunctionMeta{Service: "vanished", Image: "some/image"})
+	store.Put(&metastore.FunctionMeta{Service: "upgraded", Image: "some/image"})
+	mockBackend.On("ListFunctions", "").Return([]backend.Function{}, nil).Once()
+	mockBackend.On("ListFunctions", "").Return([]backend.Function{{Name: "upgraded", Image: "some/image"}}, nil).Once()
+
+	reconciler := NewReconciler(mockBackend, store, false)
+
+	// Act
+	first, err := reconciler.Reconcile()
+	if !assert.NoError(err) {
+		return
+	}
+	report, err := reconciler.Reconcile()
+
+	// Assert
+	assert.Equal([]string{"upgraded", "vanished"}, first.Missing)
+	assert.Empty(first.Orphans)
+
+	assert.NoError(err)
+	assert.Empty(report.Missing)
+	assert.Equal([]string{"vanished"}, report.Orphans)
+	assert.NoError(store.Get(&metastore.FunctionMeta{Service: "upgraded"}))
+	assert.Equal(metastore.ErrEntityNotFound, store.Get(&metastore.FunctionMeta{Service: "vanished"}))
+	assert.Equal(report, reconciler.LastReport())
+}
+
+func Test_Reconcile_Reports_Drift(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockBackend := new(mocks.Backend)
+	store := metastore.NewMemoryStore()
+	store.Put(&metastore.FunctionMeta{
+		Service:   "some-function",
+		Namespace: "dev",
+		Image:     "some/image:1.0",
+		EnvVars:   map[string]interface{}{"SOME_ENV": "SOME_VALUE"},
+		Labels:    map[string]interface{}{"com.example": "label"},
+		Secrets:   []string{"b", "a"},
+	})
+
+	mockBackend.On("ListFunctions", "dev").Return([]backend.Function{
+		{
+			Name:    "some-function",
+			Image:   "some/image:2.0",
+			EnvVars: map[string]string{"SOME_ENV": "SOME_VALUE"},
+			Labels: map[string]string{
+				"com.example":                     "label",
+				"faas_function":                   "some-function",
+				"io.rancher.container.pull_image": "always",
+			},
+			Secrets: []string{"a", "b"},
+		},
+	}, nil)
+
+	reconciler := NewReconciler(mockBackend, store, false)
+
+	// Act
+	report, err := reconciler.Reconcile()
+
+	// Assert
+	assert.NoError(err)
+	assert.Empty(report.Orphans)
+	assert.Equal([]Drift{{Name: "some-function", Namespace: "dev", Fields: []string{FieldImage}}}, report.Drifted)
+	mockBackend.AssertNotCalled(t, "UpdateFunction", mock.Anything)
+}
+
+func Test_Reconcile_Reapplies_Stored_Spec(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockBackend := new(mocks.Backend)
+	store := metastore.NewMemoryStore()
+	store.Put(&metastore.FunctionMeta{
+		Service: "some-function",
+		Image:   "some/image",
+		EnvVars: map[string]interface{}{"SOME_ENV": "SOME_VALUE"},
+	})
+
+	mockBackend.On("ListFunctions", "").Return([]backend.Function{
+		{Name: "some-function", Image: "some/image", EnvVars: map[string]string{"SOME_ENV": "CHANGED"}},
+	}, nil)
+	mockBackend.On("UpdateFunction", mock.MatchedBy(func(spec *backend.FunctionSpec) bool {
+		return spec.Name == "some-function" && spec.EnvVars["SOME_ENV"] == "SOME_VALUE"
+	})).Return(nil)
+
+	reconciler := NewReconciler(mockBackend, store, true)
+
+	// Act
+	report, err := reconciler.Reconcile()
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal([]Drift{{Name: "some-function", Fields: []string{FieldEnvVars}, Reapplied: true}}, report.Drifted)
+	mockBackend.AssertExpectations(t)
+}
+
+func Test_Compare_Ignores_Image_Defaults(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	meta := &metastore.FunctionMeta{
+		Service: "some-function",
+		Image:   "some/image",
+		EnvVars: map[string]interface{}{"SOME_ENV": "SOME_VALUE"},
+		Labels:  map[string]interface{}{"com.example": "label"},
+	}
+
+	function := &backend.Function{
+		Name:    "some-function",
+		Image:   "some/image",
+		EnvVars: map[string]string{"SOME_ENV": "SOME_VALUE", "PATH": "/usr/bin"},
+		Labels:  map[string]string{"com.example": "label", "maintainer": "someone"},
+	}
+
+	// Act
+	unchanged := Compare(meta, function)
+	delete(function.EnvVars, "SOME_ENV")
+	function.Labels["com.example"] = "changed"
+	changed := Compare(meta, function)
+
+	// Assert
+	assert.Empty(unchanged)
+	assert.Equal([]string{FieldEnvVars, FieldLabels}, changed)
+}
@@ -14,6 +14,7 @@ require (
 	github.com/openfaas/faas v0.0.0-20191027090354-60afb7d210b8
 	github.com/openfaas/faas-provider v0.0.0-20191016103933-78c25aab33bb
 	github.com/pkg/errors v0.8.1 // indirect
+	github.com/prometheus/client_golang v1.2.1
 	github.com/rancher/go-rancher v0.0.0-20170915171953-821d581e449e
 	github.com/sirupsen/logrus v1.4.2
 	github.com/stretchr/objx v0.2.0 // indirect
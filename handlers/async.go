@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/gitmonster/faas-rancher/async"
+	"github.com/juju/errors"
+)
+
+var (
+	// credentialHeaders are not persisted with queued calls, they would
+	// be stored in bolt and listed with the dead letters
+	credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+)
+
+// MakeAsyncHandler queues invocations of functions and answers with
+// 202 Accepted and the ID of the call. Bodies larger than maxBodyBytes
+// are rejected, credentials in the headers are dropped.
+func MakeAsyncHandler(d *async.Dispatcher, maxBodyBytes int64) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		defer r.Body.Close()
+
+		callbackURL := r.Header.Get(async.CallbackURLHeader)
+		if callbackURL != "" {
+			u, err := url.Parse(callbackURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				handleBadRequest(w, errors.Errorf("%s %q must be an absolute http(s) URL", async.CallbackURLHeader, callbackURL))
+				return
+			}
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			logger.Error(errors.Annotate(err, "ReadAll"))
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		header := make(http.Header, len(r.Header))
+		for k, v := range r.Header {
+			header[k] = append([]string(nil), v...)
+		}
+		header.Del(async.CallbackURLHeader)
+		for _, name := range credentialHeaders {
+			header.Del(name)
+		}
+
+		call := async.Call{
+			Function:    vars["name"],
+			Method:      r.Method,
+			Path:        vars["params"],
+			Query:       r.URL.RawQuery,
+			Header:      header,
+			Body:        body,
+			CallbackURL: callbackURL,
+		}
+
+		if err := d.Enqueue(&call); err != nil {
+			handleServerError(w, errors.Annotate(err, "Enqueue"))
+			return
+		}
+
+		logger.Debugf("queued call %s to %q", call.ID, call.Function)
+		w.Header().Set(async.CallIDHeader, call.ID)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// MakeDeadLetterLister lists the async calls given up
+func MakeDeadLetterLister(d *async.Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		calls, err := d.DeadLetters()
+		if err != nil {
+			handleServerError(w, errors.Annotate(err, "DeadLetters"))
+			return
+		}
+
+		buf, err := json.Marshal(calls)
+		if err != nil {
+			handleServerError(w, errors.Annotate(err, "Marshal"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf)
+	}
+}
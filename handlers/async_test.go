@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/async"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MakeAsyncHandler_Queues_Calls(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir, _ := ioutil.TempDir("", "async")
+	defer os.RemoveAll(dir)
+
+	queue, err := async.NewBoltQueue(filepath.Join(dir, "async.db"))
+	if !assert.NoError(err) {
+		return
+	}
+	defer queue.Close()
+
+	d := async.NewDispatcher(queue, metastore.NewCache(metastore.NewMemoryStore(), "", time.Minute), nil, async.Options{})
+	handler := MakeAsyncHandler(d, 8)
+
+	invoke := func(body string, callbackURL string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/async-function/some-function/sub?a=1", bytes.NewBufferString(body))
+		req.Header.Set(async.CallbackURLHeader, callbackURL)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Cookie", "session=secret")
+		req.Header.Set("X-Request-Id", "some-id")
+		rr := httptest.NewRecorder()
+		handler(rr, req, map[string]string{"name": "some-function", "params": "sub"})
+		return rr
+	}
+
+	// Act
+	accepted := invoke("payload", "http://receiver:8080/results")
+	invalidCallback := invoke("payload", "receiver")
+	tooLarge := invoke("large payload", "")
+
+	// Assert
+	assert.Equal(http.StatusAccepted, accepted.Code)
+	assert.Equal(http.StatusBadRequest, invalidCallback.Code)
+	assert.Equal(http.StatusRequestEntityTooLarge, tooLarge.Code)
+
+	calls, _ := queue.Pending()
+	if assert.Len(calls, 1) {
+		assert.Equal(accepted.Header().Get(async.CallIDHeader), calls[0].ID)
+		assert.Equal("some-function", calls[0].Function)
+		assert.Equal("sub", calls[0].Path)
+		assert.Equal("a=1", calls[0].Query)
+		assert.Equal([]byte("payload"), calls[0].Body)
+		assert.Equal("http://receiver:8080/results", calls[0].CallbackURL)
+		assert.Empty(calls[0].Header.Get(async.CallbackURLHeader))
+		assert.Empty(calls[0].Header.Get("Authorization"))
+		assert.Empty(calls[0].Header.Get("Cookie"))
+		assert.Equal("some-id", calls[0].Header.Get("X-Request-Id"))
+	}
+
+	rr := httptest.NewRecorder()
+	MakeDeadLetterLister(d)(rr, httptest.NewRequest("GET", "/system/async/dead-letters", nil))
+	deadLetters := []async.Call{}
+	assert.NoError(json.Unmarshal(rr.Body.Bytes(), &deadLetters))
+	assert.Empty(deadLetters)
+}
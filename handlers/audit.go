@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/audit"
+	"github.com/gitmonster/faas-rancher/auth"
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/helper"
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+const (
+	// anonymousUser is audited for requests not authenticated
+	anonymousUser = "anonymous"
+)
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// MakeAuditHandler records the function mutation op done by next in log,
+// including the difference between the function before and after it.
+// Clients are identified behind trustedProxies proxies.
+func MakeAuditHandler(log audit.Log, trustedProxies int, b backend.Backend, op audit.Operation, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := readBody(r)
+		if err != nil {
+			handleBadRequest(w, errors.Annotate(err, "readBody"))
+			return
+		}
+
+		entry := newAuditEntry(r, op, trustedProxies)
+		entry.Namespace = namespaceOf(r)
+
+		switch op {
+		case audit.OperationDeploy, audit.OperationUpdate:
+			req := types.FunctionDeployment{}
+			json.Unmarshal(body, &req)
+			entry.Function = req.Service
+			entry.Namespace = req.Namespace
+		case audit.OperationDelete:
+			req := requests.DeleteFunctionRequest{}
+			json.Unmarshal(body, &req)
+			entry.Function = req.FunctionName
+		default:
+			entry.Function = mux.Vars(r)["name"]
+		}
+
+		before := lookupFunction(b, entry.Function, entry.Namespace)
+
+		rec := statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(&rec, r)
+
+		// failed mutations are assumed to change nothing
+		after := before
+		if rec.status < http.StatusBadRequest {
+			after = lookupFunction(b, entry.Function, entry.Namespace)
+		}
+
+		entry.Changes = audit.Diff(before, after)
+		appendAuditEntry(log, entry, rec.status)
+	}
+}
+
+// MakeSecretAuditHandler records the secret mutations done by next in log.
+// Secret values are never recorded, listing secrets is not recorded at all.
+func MakeSecretAuditHandler(log audit.Log, trustedProxies int, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var op audit.Operation
+		switch r.Method {
+		case http.MethodPost:
+			op = audit.OperationCreateSecret
+		case http.MethodPut:
+			op = audit.OperationUpdateSecret
+		case http.MethodDelete:
+			op = audit.OperationDeleteSecret
+		default:
+			next(w, r)
+			return
+		}
+
+		body, err := readBody(r)
+		if err != nil {
+			handleBadRequest(w, errors.Annotate(err, "readBody"))
+			return
+		}
+
+		secret := types.Secret{}
+		json.Unmarshal(body, &secret)
+
+		entry := newAuditEntry(r, op, trustedProxies)
+		entry.Secret = secret.Name
+		entry.Namespace = secret.Namespace
+
+		rec := statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(&rec, r)
+
+		appendAuditEntry(log, entry, rec.status)
+	}
+}
+
+// MakeAuditReader lists the audit log. The query parameters function and
+// namespace filter the entries, since and until (RFC 3339) select a time
+// range and limit returns the latest entries only.
+func MakeAuditReader(log audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		query := audit.Query{
+			Function:  params.Get("function"),
+			Namespace: params.Get("namespace"),
+		}
+
+		var err error
+		if query.Since, err = parseTime(params.Get("since")); err != nil {
+			handleBadRequest(w, errors.Annotate(err, "since"))
+			return
+		}
+
+		if query.Until, err = parseTime(params.Get("until")); err != nil {
+			handleBadRequest(w, errors.Annotate(err, "until"))
+			return
+		}
+
+		if limit := params.Get("limit"); limit != "" {
+			if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 0 {
+				handleBadRequest(w, errors.Errorf("invalid limit %q", limit))
+				return
+			}
+		}
+
+		entries, err := log.Query(query)
+		if err != nil {
+			handleServerError(w, errors.Annotate(err, "Query"))
+			return
+		}
+
+		buf, err := json.Marshal(entries)
+		if err != nil {
+			handleServerError(w, errors.Annotate(err, "Marshal"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf)
+	}
+}
+
+func newAuditEntry(r *http.Request, op audit.Operation, trustedProxies int) *audit.Entry {
+	return &audit.Entry{
+		Operation: op,
+		Source:    helper.ClientIP(r, trustedProxies),
+		User:      requestUser(r),
+	}
+}
+
+func appendAuditEntry(log audit.Log, entry *audit.Entry, status int) {
+	entry.Status = status
+	entry.Outcome = audit.OutcomeSuccess
+	if status >= http.StatusBadRequest {
+		entry.Outcome = audit.OutcomeFailure
+	}
+
+	// the response is written already, a lost entry must not fail the request
+	if err := log.Append(entry); err != nil {
+		logger.Error(errors.Annotate(err, "Append [audit]"))
+	}
+}
+
+// lookupFunction returns the function or nil if it can't be found
+func lookupFunction(b backend.Backend, name, namespace string) *backend.Function {
+	if name == "" {
+		return nil
+	}
+
+	function, err := b.FindFunction(name, namespace)
+	if err != nil {
+		if !isNotFound(err) {
+			logger.Warn(errors.Annotate(err, "FindFunction"))
+		}
+		return nil
+	}
+
+	return function
+}
+
+// readBody reads the request body and replaces it for the next handler
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, errors.Annotate(err, "ReadAll")
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// requestUser returns the identity the request was authenticated as.
+// Headers naming a user are set by the caller and not trusted.
+func requestUser(r *http.Request) string {
+	if identity := auth.Identity(r); identity != "" {
+		return identity
+	}
+
+	return anonymousUser
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gitmonster/faas-rancher/audit"
+	"github.com/gitmonster/faas-rancher/auth"
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/mocks"
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MakeAuditHandler_Records_Update(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	log := audit.NewMemoryLog(0)
+
+	before := backend.Function{Name: "some-function", Image: "some/image:1", Replicas: 1}
+	after := backend.Function{Name: "some-function", Image: "some/image:2", Replicas: 1}
+	mockClient.On("FindFunction", "some-function", "dev").Return(&before, nil).Once()
+	mockClient.On("FindFunction", "some-function", "dev").Return(&after, nil).Once()
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		req := types.FunctionDeployment{}
+		assert.NoError(json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal("some/image:2", req.Image)
+		w.WriteHeader(http.StatusAccepted)
+	}
+	handler := MakeAuditHandler(log, 0, mockClient, audit.OperationUpdate, next)
+
+	body, _ := json.Marshal(types.FunctionDeployment{Service: "some-function", Namespace: "dev", Image: "some/image:2"})
+	req, _ := http.NewRequest("PUT", "/system/functions", bytes.NewReader(body))
+	req.RemoteAddr = "10.0.0.1:51234"
+	// not added by a trusted proxy
+	req.Header.Set("X-Forwarded-For", "forged")
+	req = auth.WithIdentity(req, "alice")
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req)
+
+	// Assert
+	assert.Equal(http.StatusAccepted, rr.Code)
+	entries, _ := log.Query(audit.Query{})
+	if assert.Len(entries, 1) {
+		entry := entries[0]
+		assert.Equal(audit.OperationUpdate, entry.Operation)
+		assert.Equal("some-function", entry.Function)
+		assert.Equal("dev", entry.Namespace)
+		assert.Equal("10.0.0.1", entry.Source)
+		assert.Equal("alice", entry.User)
+		assert.Equal(audit.OutcomeSuccess, entry.Outcome)
+		assert.Equal(http.StatusAccepted, entry.Status)
+		assert.Equal([]audit.Change{{Field: "image", Before: "some/image:1", After: "some/image:2"}}, entry.Changes)
+	}
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeAuditHandler_Records_Failure(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	log := audit.NewMemoryLog(0)
+	mockClient.On("FindFunction", "some-function", "").Return(nil, backend.ErrFunctionNotFound)
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	// behind the gateway at 10.0.0.1
+	handler := MakeAuditHandler(log, 1, mockClient, audit.OperationScale, next)
+
+	req, _ := http.NewRequest("POST", "/system/scale-function/some-function", bytes.NewReader([]byte(`{"replicas":2}`)))
+	req.RemoteAddr = "10.0.0.1:51234"
+	req.Header.Set("X-Forwarded-For", "forged, 192.168.1.10")
+	req = mux.SetURLVars(req, map[string]string{"name": "some-function"})
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req)
+
+	// Assert
+	entries, _ := log.Query(audit.Query{Function: "some-function"})
+	if assert.Len(entries, 1) {
+		assert.Equal(audit.OutcomeFailure, entries[0].Outcome)
+		assert.Equal("192.168.1.10", entries[0].Source)
+		assert.Empty(entries[0].Changes)
+	}
+}
+
+func Test_MakeSecretAuditHandler_Skips_Values_And_Reads(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	log := audit.NewMemoryLog(0)
+	handler := MakeSecretAuditHandler(log, 0, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	body, _ := json.Marshal(types.Secret{Name: "some-secret", Value: "top secret"})
+	create, _ := http.NewRequest("POST", "/system/secrets", bytes.NewReader(body))
+	// credentials not verified by the authenticator are no identity
+	create.SetBasicAuth("admin", "password")
+	list, _ := http.NewRequest("GET", "/system/secrets", nil)
+
+	// Act
+	handler(httptest.NewRecorder(), create)
+	handler(httptest.NewRecorder(), list)
+
+	// Assert
+	entries, _ := log.Query(audit.Query{})
+	if assert.Len(entries, 1) {
+		assert.Equal(audit.OperationCreateSecret, entries[0].Operation)
+		assert.Equal("some-secret", entries[0].Secret)
+		assert.Equal("anonymous", entries[0].User)
+		buf, _ := json.Marshal(entries[0])
+		assert.NotContains(string(buf), "top secret")
+	}
+}
+
+func Test_MakeAuditReader_Filters_Entries(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	log := audit.NewMemoryLog(0)
+	log.Append(&audit.Entry{Operation: audit.OperationDeploy, Function: "a"})
+	log.Append(&audit.Entry{Operation: audit.OperationDeploy, Function: "b"})
+	handler := MakeAuditReader(log)
+
+	req, _ := http.NewRequest("GET", "/system/audit?function=b&since=2019-01-01T00:00:00Z", nil)
+	invalid, _ := http.NewRequest("GET", "/system/audit?until=yesterday", nil)
+	rr := httptest.NewRecorder()
+	invalidRR := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req)
+	handler(invalidRR, invalid)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	entries := []audit.Entry{}
+	assert.NoError(json.Unmarshal(rr.Body.Bytes(), &entries))
+	if assert.Len(entries, 1) {
+		assert.Equal("b", entries[0].Function)
+	}
+	assert.Equal(http.StatusBadRequest, invalidRR.Code)
+}
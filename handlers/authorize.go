@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gitmonster/faas-rancher/auth"
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/policy"
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/faas/gateway/requests"
+)
+
+// MakeAuthorizeHandler passes requests to next if the policy allows the
+// authenticated identity to do op on the function of the request, else
+// they are rejected with 403 Forbidden and the reason. Updates have to be
+// allowed for the deployed and the requested labels. Deploys replace a soft
+// deleted function of the same name, so they also have to be allowed to
+// delete a function deployed with that name.
+func MakeAuthorizeHandler(p *policy.Policy, b backend.Backend, op policy.Operation, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reqs, err := policyRequests(r, b, op)
+		if err != nil {
+			handleBadRequest(w, errors.Annotate(err, "policyRequests"))
+			return
+		}
+
+		for _, req := range reqs {
+			decision := p.Decide(req)
+			if !decision.Allowed {
+				logger.Warnf("denied %s %s: %s", r.Method, r.URL.Path, decision.Reason)
+				http.Error(w, "forbidden: "+decision.Reason, http.StatusForbidden)
+				return
+			}
+
+			logger.Infof("allowed identity %q to %s %q: %s", req.Identity, req.Operation, req.Function, decision.Reason)
+		}
+
+		next(w, r)
+	}
+}
+
+// policyRequests returns the policy requests to decide for a request to do op
+func policyRequests(r *http.Request, b backend.Backend, op policy.Operation) ([]*policy.Request, error) {
+	req := policy.Request{
+		Identity:  auth.Identity(r),
+		Operation: op,
+		Namespace: namespaceOf(r),
+	}
+
+	switch op {
+	case policy.OperationDeploy, policy.OperationUpdate:
+		body, err := readBody(r)
+		if err != nil {
+			return nil, errors.Annotate(err, "readBody")
+		}
+
+		deployment := types.FunctionDeployment{}
+		if err := json.Unmarshal(body, &deployment); err != nil {
+			return nil, errors.Annotate(err, "Unmarshal")
+		}
+
+		req.Function = deployment.Service
+		req.Namespace = deployment.Namespace
+		if deployment.Labels != nil {
+			req.Labels = *deployment.Labels
+		}
+
+	case policy.OperationDelete:
+		body, err := readBody(r)
+		if err != nil {
+			return nil, errors.Annotate(err, "readBody")
+		}
+
+		deletion := requests.DeleteFunctionRequest{}
+		if err := json.Unmarshal(body, &deletion); err != nil {
+			return nil, errors.Annotate(err, "Unmarshal")
+		}
+
+		req.Function = deletion.FunctionName
+	case policy.OperationScale, policy.OperationPause, policy.OperationResume, policy.OperationRestore:
+		req.Function = mux.Vars(r)["name"]
+	case policy.OperationRead:
+		// reads of a single function are decided on the function
+		req.Function = mux.Vars(r)["name"]
+		if req.Function == "" {
+			return []*policy.Request{&req}, nil
+		}
+	default:
+		return []*policy.Request{&req}, nil
+	}
+
+	// decide on the labels of the deployed function
+	deployed := req
+	deployed.Labels = nil
+	function := lookupFunction(b, req.Function, req.Namespace)
+	if function != nil {
+		deployed.Labels = function.Labels
+	}
+
+	switch op {
+	case policy.OperationDeploy:
+		if function == nil {
+			return []*policy.Request{&req}, nil
+		}
+
+		// the deploy purges the existing, e.g. soft deleted, function
+		deployed.Operation = policy.OperationDelete
+		return []*policy.Request{&req, &deployed}, nil
+	case policy.OperationUpdate:
+		return []*policy.Request{&req, &deployed}, nil
+	}
+
+	return []*policy.Request{&deployed}, nil
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gitmonster/faas-rancher/auth"
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/mocks"
+	"github.com/gitmonster/faas-rancher/policy"
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+)
+
+var teamPolicy = &policy.Policy{
+	Rules: []policy.Rule{
+		{Name: "ci", Identities: []string{"ci"}, Operations: []policy.Operation{policy.OperationDeploy, policy.OperationUpdate}, Labels: map[string]string{"team": "ci"}},
+		{Name: "oncall", Identities: []string{"oncall"}, Operations: []policy.Operation{policy.OperationScale}},
+	},
+}
+
+// authorized serves the request as identity and reports whether next was called
+func authorized(handler func(http.HandlerFunc) http.HandlerFunc, req *http.Request, identity string) (*httptest.ResponseRecorder, bool) {
+	called := false
+	next := func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusAccepted)
+	}
+
+	rr := httptest.NewRecorder()
+	handler(next)(rr, auth.WithIdentity(req, identity))
+	return rr, called
+}
+
+func Test_MakeAuthorizeHandler_Deploy(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	mockClient.On("FindFunction", "some-function", "").Return(nil, backend.ErrFunctionNotFound)
+	handler := func(next http.HandlerFunc) http.HandlerFunc {
+		return MakeAuthorizeHandler(teamPolicy, mockClient, policy.OperationDeploy, next)
+	}
+
+	deploy := func(labels map[string]string) *http.Request {
+		body, _ := json.Marshal(types.FunctionDeployment{Service: "some-function", Labels: &labels})
+		req, _ := http.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+		return req
+	}
+
+	// Act
+	allowedRR, allowed := authorized(handler, deploy(map[string]string{"team": "ci"}), "ci")
+	deniedRR, denied := authorized(handler, deploy(map[string]string{"team": "payments"}), "ci")
+	oncallRR, oncall := authorized(handler, deploy(map[string]string{"team": "ci"}), "oncall")
+
+	// Assert
+	assert.True(allowed)
+	assert.Equal(http.StatusAccepted, allowedRR.Code)
+	assert.False(denied)
+	assert.Equal(http.StatusForbidden, deniedRR.Code)
+	assert.True(strings.HasPrefix(deniedRR.Body.String(), "forbidden: "))
+	assert.False(oncall)
+	assert.Equal(http.StatusForbidden, oncallRR.Code)
+}
+
+func Test_MakeAuthorizeHandler_Update_Checks_Deployed_Labels(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	mockClient.On("FindFunction", "some-function", "").Return(&backend.Function{
+		Name:   "some-function",
+		Labels: map[string]string{"team": "payments"},
+	}, nil)
+	handler := func(next http.HandlerFunc) http.HandlerFunc {
+		return MakeAuthorizeHandler(teamPolicy, mockClient, policy.OperationUpdate, next)
+	}
+
+	labels := map[string]string{"team": "ci"}
+	body, _ := json.Marshal(types.FunctionDeployment{Service: "some-function", Labels: &labels})
+	req, _ := http.NewRequest("PUT", "/system/functions", bytes.NewReader(body))
+
+	// Act
+	rr, called := authorized(handler, req, "ci")
+
+	// Assert
+	assert.False(called)
+	assert.Equal(http.StatusForbidden, rr.Code)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeAuthorizeHandler_Scale(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	mockClient.On("FindFunction", "some-function", "").Return(&backend.Function{Name: "some-function"}, nil)
+	handler := func(next http.HandlerFunc) http.HandlerFunc {
+		return MakeAuthorizeHandler(teamPolicy, mockClient, policy.OperationScale, next)
+	}
+
+	scale := func() *http.Request {
+		req, _ := http.NewRequest("POST", "/system/scale-function/some-function", strings.NewReader(`{"replicas": 2}`))
+		return mux.SetURLVars(req, map[string]string{"name": "some-function"})
+	}
+
+	// Act
+	oncallRR, oncall := authorized(handler, scale(), "oncall")
+	ciRR, ci := authorized(handler, scale(), "ci")
+
+	// Assert
+	assert.True(oncall)
+	assert.Equal(http.StatusAccepted, oncallRR.Code)
+	assert.False(ci)
+	assert.Equal(http.StatusForbidden, ciRR.Code)
+}
+
+func Test_MakeAuthorizeHandler_Deploy_Checks_Purged_Function(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	publicPolicy := &policy.Policy{
+		Rules: []policy.Rule{
+			{Name: "public", Identities: []string{"ci"}, Operations: []policy.Operation{policy.OperationDeploy, policy.OperationDelete}, Functions: []string{"public-*"}},
+			{Name: "team", Identities: []string{"ci"}, Operations: []policy.Operation{policy.OperationDeploy}, Labels: map[string]string{"team": "ci"}},
+		},
+	}
+
+	// a soft deleted function of another team
+	mockClient := new(mocks.Backend)
+	mockClient.On("FindFunction", "payments", "").Return(&backend.Function{
+		Name:   "payments",
+		Labels: map[string]string{"team": "payments"},
+	}, nil)
+	mockClient.On("FindFunction", "public-function", "").Return(&backend.Function{Name: "public-function"}, nil)
+	handler := func(next http.HandlerFunc) http.HandlerFunc {
+		return MakeAuthorizeHandler(publicPolicy, mockClient, policy.OperationDeploy, next)
+	}
+
+	deploy := func(name string) *http.Request {
+		labels := map[string]string{"team": "ci"}
+		body, _ := json.Marshal(types.FunctionDeployment{Service: name, Labels: &labels})
+		req, _ := http.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+		return req
+	}
+
+	// Act
+	deniedRR, denied := authorized(handler, deploy("payments"), "ci")
+	_, allowed := authorized(handler, deploy("public-function"), "ci")
+
+	// Assert
+	assert.False(denied)
+	assert.Equal(http.StatusForbidden, deniedRR.Code)
+	assert.True(allowed)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeAuthorizeHandler_Read_Checks_Function_Name(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	readPolicy := &policy.Policy{
+		Rules: []policy.Rule{
+			{Name: "public", Identities: []string{"viewer"}, Operations: []policy.Operation{policy.OperationRead}, Functions: []string{"public-*"}},
+		},
+	}
+
+	mockClient := new(mocks.Backend)
+	mockClient.On("FindFunction", "public-function", "").Return(&backend.Function{Name: "public-function"}, nil)
+	mockClient.On("FindFunction", "private-function", "").Return(&backend.Function{Name: "private-function"}, nil)
+	handler := func(next http.HandlerFunc) http.HandlerFunc {
+		return MakeAuthorizeHandler(readPolicy, mockClient, policy.OperationRead, next)
+	}
+
+	read := func(name string) *http.Request {
+		req, _ := http.NewRequest("GET", "/system/function/"+name, nil)
+		return mux.SetURLVars(req, map[string]string{"name": name})
+	}
+
+	// Act
+	_, public := authorized(handler, read("public-function"), "viewer")
+	privateRR, private := authorized(handler, read("private-function"), "viewer")
+
+	// Assert
+	assert.True(public)
+	assert.False(private)
+	assert.Equal(http.StatusForbidden, privateRR.Code)
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gitmonster/faas-rancher/cron"
+	"github.com/juju/errors"
+)
+
+// MakeCronLister lists the scheduled functions with their last run
+func MakeCronLister(s *cron.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buf, err := json.Marshal(s.Statuses())
+		if err != nil {
+			handleServerError(w, errors.Annotate(err, "Marshal"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf)
+	}
+}
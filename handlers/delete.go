@@ -8,62 +8,71 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"github.com/gitmonster/faas-rancher/backend"
 	"github.com/gitmonster/faas-rancher/metastore"
-	"github.com/gitmonster/faas-rancher/rancher"
 	"github.com/juju/errors"
 	"github.com/openfaas/faas/gateway/requests"
 )
 
 // MakeDeleteHandler delete a function
-func MakeDeleteHandler(client rancher.BridgeClient) VarsHandler {
+func MakeDeleteHandler(b backend.Backend, store metastore.Store) VarsHandler {
 	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
 
-		defer r.Body.Close()
-		body, err := ioutil.ReadAll(r.Body)
+		request, err := readDeleteRequest(r)
 		if err != nil {
-			handleBadRequest(w, errors.Annotate(err, "ReadAll"))
+			handleBadRequest(w, errors.Annotate(err, "readDeleteRequest"))
 			return
 		}
 
-		request := requests.DeleteFunctionRequest{}
-		if err := json.Unmarshal(body, &request); err != nil {
-			handleServerError(w, errors.Annotate(err, "Unmarshal"))
-			return
-		}
-
-		if len(request.FunctionName) == 0 {
-			handleBadRequest(w, errors.New("FunctionName is empty"))
-			return
-		}
-
-		// This makes sure we don't delete non-labelled deployments
-		service, err := client.FindServiceByName(request.FunctionName)
+		namespace := namespaceOf(r)
+		function, err := b.FindFunction(request.FunctionName, namespace)
 		if err != nil {
-			handleServerError(w, errors.Annotate(err, "FindServiceByName"))
-			return
-		}
+			if isNotFound(err) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
 
-		if service == nil {
-			w.WriteHeader(http.StatusNotFound)
+			handleServerError(w, errors.Annotate(err, "FindFunction"))
 			return
 		}
 
-		if err := client.DeleteService(service); err != nil {
-			handleServerError(w, errors.Annotate(err, "DeleteService"))
+		if err := b.DeleteFunction(function.Name, namespace); err != nil {
+			handleServerError(w, errors.Annotate(err, "DeleteFunction"))
 			return
 		}
 
 		meta := &metastore.FunctionMeta{
-			Service: service.Name,
-			Image:   service.LaunchConfig.ImageUuid,
+			Service:   function.Name,
+			Namespace: namespace,
+			Image:     function.Image,
 		}
 
-		if err := metastore.Delete(meta); err != nil {
+		if err := store.Delete(meta); err != nil {
 			if err != metastore.ErrEntityNotFound {
 				handleServerError(w, errors.Annotate(err, "Delete [metastore]"))
+				return
 			}
 		}
 
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+func readDeleteRequest(r *http.Request) (*requests.DeleteFunctionRequest, error) {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.Annotate(err, "ReadAll")
+	}
+
+	request := requests.DeleteFunctionRequest{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, errors.Annotate(err, "Unmarshal")
+	}
+
+	if len(request.FunctionName) == 0 {
+		return nil, errors.New("FunctionName is empty")
+	}
+
+	return &request, nil
+}
@@ -8,17 +8,18 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/metastore"
 	"github.com/gitmonster/faas-rancher/mocks"
 	"github.com/openfaas/faas/gateway/requests"
-	client "github.com/rancher/go-rancher/v2"
 	"github.com/stretchr/testify/assert"
 )
 
 func Test_MakeDeleteHandler_Service_Delete_Success(t *testing.T) {
 	assert := assert.New(t)
 	// Arrange
-	mockClient := new(mocks.BridgeClient)
-	handler := MakeDeleteHandler(mockClient)
+	mockClient := new(mocks.Backend)
+	handler := MakeDeleteHandler(mockClient, metastore.NewMemoryStore())
 	functionName := "some_function"
 
 	body := requests.DeleteFunctionRequest{
@@ -36,11 +37,12 @@ func Test_MakeDeleteHandler_Service_Delete_Success(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	expectedService := client.Service{
-		Name: "some_rancher_service",
+	expectedFunction := backend.Function{
+		Name:  functionName,
+		Image: "some/docker/image",
 	}
-	mockClient.On("FindServiceByName", functionName).Return(&expectedService, nil)
-	mockClient.On("DeleteService", &expectedService).Return(nil)
+	mockClient.On("FindFunction", functionName, "").Return(&expectedFunction, nil)
+	mockClient.On("DeleteFunction", functionName, "").Return(nil)
 
 	// Act
 	handler(rr, req, nil)
@@ -53,8 +55,8 @@ func Test_MakeDeleteHandler_Service_Delete_Success(t *testing.T) {
 func Test_MakeDeleteHandler_InvalidBody(t *testing.T) {
 	assert := assert.New(t)
 	// Arrange
-	mockClient := new(mocks.BridgeClient)
-	handler := MakeDeleteHandler(mockClient)
+	mockClient := new(mocks.Backend)
+	handler := MakeDeleteHandler(mockClient, metastore.NewMemoryStore())
 
 	b := []byte(`{"name":what?}`)
 	req, reqErr := http.NewRequest("POST", "/system/functions", bytes.NewReader(b))
@@ -74,8 +76,8 @@ func Test_MakeDeleteHandler_InvalidBody(t *testing.T) {
 func Test_MakeDeleteHandler_Empty_FunctionName(t *testing.T) {
 	assert := assert.New(t)
 	// Arrange
-	mockClient := new(mocks.BridgeClient)
-	handler := MakeDeleteHandler(mockClient)
+	mockClient := new(mocks.Backend)
+	handler := MakeDeleteHandler(mockClient, metastore.NewMemoryStore())
 	emptyFunctionName := ""
 
 	invalidBody := requests.DeleteFunctionRequest{
@@ -103,8 +105,8 @@ func Test_MakeDeleteHandler_Empty_FunctionName(t *testing.T) {
 func Test_MakeDeleteHandler_Service_Find_Error(t *testing.T) {
 	assert := assert.New(t)
 	// Arrange
-	mockClient := new(mocks.BridgeClient)
-	handler := MakeDeleteHandler(mockClient)
+	mockClient := new(mocks.Backend)
+	handler := MakeDeleteHandler(mockClient, metastore.NewMemoryStore())
 	functionName := "some_function"
 
 	body := requests.DeleteFunctionRequest{
@@ -122,7 +124,7 @@ func Test_MakeDeleteHandler_Service_Find_Error(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	mockClient.On("FindServiceByName", functionName).Return(nil, fmt.Errorf("Internal Server Error"))
+	mockClient.On("FindFunction", functionName, "").Return(nil, fmt.Errorf("Internal Server Error"))
 
 	// Act
 	handler(rr, req, nil)
@@ -135,8 +137,8 @@ func Test_MakeDeleteHandler_Service_Find_Error(t *testing.T) {
 func Test_MakeDeleteHandler_Service_Nil_Error(t *testing.T) {
 	assert := assert.New(t)
 	// Arrange
-	mockClient := new(mocks.BridgeClient)
-	handler := MakeDeleteHandler(mockClient)
+	mockClient := new(mocks.Backend)
+	handler := MakeDeleteHandler(mockClient, metastore.NewMemoryStore())
 	functionName := "some_function"
 
 	body := requests.DeleteFunctionRequest{
@@ -154,7 +156,7 @@ func Test_MakeDeleteHandler_Service_Nil_Error(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	mockClient.On("FindServiceByName", functionName).Return(nil, nil)
+	mockClient.On("FindFunction", functionName, "").Return(nil, backend.ErrFunctionNotFound)
 
 	// Act
 	handler(rr, req, nil)
@@ -163,11 +165,12 @@ func Test_MakeDeleteHandler_Service_Nil_Error(t *testing.T) {
 	assert.Equal(http.StatusNotFound, rr.Code)
 	mockClient.AssertExpectations(t)
 }
+
 func Test_MakeDeleteHandler_Service_Delete_Error(t *testing.T) {
 	assert := assert.New(t)
 	// Arrange
-	mockClient := new(mocks.BridgeClient)
-	handler := MakeDeleteHandler(mockClient)
+	mockClient := new(mocks.Backend)
+	handler := MakeDeleteHandler(mockClient, metastore.NewMemoryStore())
 	functionName := "some_function"
 
 	body := requests.DeleteFunctionRequest{
@@ -185,16 +188,17 @@ func Test_MakeDeleteHandler_Service_Delete_Error(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	expectedService := client.Service{
-		Name: "some_rancher_service",
+	expectedFunction := backend.Function{
+		Name:  functionName,
+		Image: "some/docker/image",
 	}
-	mockClient.On("FindServiceByName", functionName).Return(&expectedService, nil)
-	mockClient.On("DeleteService", &expectedService).Return(fmt.Errorf("Service Delete Failed"))
+	mockClient.On("FindFunction", functionName, "").Return(&expectedFunction, nil)
+	mockClient.On("DeleteFunction", functionName, "").Return(fmt.Errorf("Service Delete Failed"))
 
 	// Act
 	handler(rr, req, nil)
 
 	// Assert
-	assert.Equal(rr.Code, http.StatusBadRequest)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
 	mockClient.AssertExpectations(t)
 }
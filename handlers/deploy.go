@@ -8,26 +8,71 @@ import (
 	"io/ioutil"
 	"net/http"
 	"regexp"
+	"time"
 
+	"github.com/gitmonster/faas-rancher/async"
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/concurrency"
+	"github.com/gitmonster/faas-rancher/cron"
 	"github.com/gitmonster/faas-rancher/metastore"
-	"github.com/gitmonster/faas-rancher/rancher"
+	"github.com/gitmonster/faas-rancher/ratelimit"
+	"github.com/gitmonster/faas-rancher/trash"
 	"github.com/juju/errors"
 	"github.com/openfaas/faas-provider/types"
 )
 
 // ValidateDeployRequest validates that the service name is valid for Kubernetes
-func ValidateDeployRequest(request *types.FunctionDeployment) error {
+// and the annotations understood by the provider, with timeouts up to maxTimeout
+func ValidateDeployRequest(request *types.FunctionDeployment, maxTimeout time.Duration) error {
 	var validDNS = regexp.MustCompile(`^[a-zA-Z\-]+$`)
 	matched := validDNS.MatchString(request.Service)
-	if matched {
-		return nil
+	if !matched {
+		return errors.Errorf("%q must be a valid DNS entry for service name", request.Service)
 	}
 
-	return errors.Errorf("%q must be a valid DNS entry for service name", request.Service)
+	return validateAnnotations(request, maxTimeout)
 }
 
-// MakeDeployHandler creates a handler to create new functions in the cluster
-func MakeDeployHandler(client rancher.BridgeClient) VarsHandler {
+// validateAnnotations validates the annotations understood by the provider
+func validateAnnotations(request *types.FunctionDeployment, maxTimeout time.Duration) error {
+	labels, annotations := map[string]string{}, map[string]string{}
+	if request.Labels != nil {
+		labels = *request.Labels
+	}
+	if request.Annotations != nil {
+		annotations = *request.Annotations
+	}
+
+	if _, err := ParseTimeout(annotations, maxTimeout); err != nil {
+		return errors.Annotate(err, "ParseTimeout")
+	}
+
+	if _, err := ParseRetry(annotations); err != nil {
+		return errors.Annotate(err, "ParseRetry")
+	}
+
+	if _, err := async.ParseConcurrency(annotations); err != nil {
+		return errors.Annotate(err, "ParseConcurrency")
+	}
+
+	if _, _, err := concurrency.ParseLimits(annotations); err != nil {
+		return errors.Annotate(err, "ParseLimits")
+	}
+
+	if _, err := ratelimit.ParseLimit(annotations); err != nil {
+		return errors.Annotate(err, "ParseLimit")
+	}
+
+	if _, err := cron.ParseSchedule(labels, annotations); err != nil {
+		return errors.Annotate(err, "ParseSchedule")
+	}
+
+	return nil
+}
+
+// MakeDeployHandler creates a handler to create new functions in the cluster,
+// function timeouts may be up to maxTimeout
+func MakeDeployHandler(b backend.Backend, store metastore.Store, maxTimeout time.Duration) VarsHandler {
 	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
 
 		defer r.Body.Close()
@@ -43,26 +88,36 @@ func MakeDeployHandler(client rancher.BridgeClient) VarsHandler {
 			return
 		}
 
-		if err := ValidateDeployRequest(&request); err != nil {
+		if err := ValidateDeployRequest(&request, maxTimeout); err != nil {
 			handleBadRequest(w, errors.Annotate(err, "ValidateDeployRequest"))
 			return
 		}
 
-		serviceSpec, err := makeServiceSpec(client, request)
-		if err != nil {
-			handleServerError(w, errors.Annotate(err, "makeServiceSpec"))
+		// a soft deleted function of the same name is purged for good
+		if err := trash.Discard(b, store, request.Service, request.Namespace); err != nil {
+			handleServerError(w, errors.Annotate(err, "Discard"))
 			return
 		}
 
-		_, err = client.CreateService(serviceSpec)
-		if err != nil {
-			handleServerError(w, errors.Annotate(err, "CreateService"))
+		if err := b.DeployFunction(backend.SpecFromDeployment(&request)); err != nil {
+			if isInvalidNamespace(err) {
+				handleBadRequest(w, errors.Annotate(err, "DeployFunction"))
+				return
+			}
+
+			if isExisting(err) {
+				logger.Warn(errors.Annotate(err, "DeployFunction"))
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+
+			handleServerError(w, errors.Annotate(err, "DeployFunction"))
 			return
 		}
 
 		meta := metastore.FunctionMeta{}
-		if err := metastore.Update(meta.CreateFrom(&request)); err != nil {
-			handleServerError(w, errors.Annotate(err, "Update [metastore]"))
+		if err := store.Put(meta.CreateFrom(&request)); err != nil {
+			handleServerError(w, errors.Annotate(err, "Put [metastore]"))
 			return
 		}
 
@@ -7,23 +7,28 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/cron"
+	"github.com/gitmonster/faas-rancher/metastore"
 	"github.com/gitmonster/faas-rancher/mocks"
-	"github.com/openfaas/faas/gateway/requests"
-	client "github.com/rancher/go-rancher/v2"
+	"github.com/gitmonster/faas-rancher/ratelimit"
+	"github.com/openfaas/faas-provider/types"
 	"github.com/stretchr/testify/assert"
 )
 
 func Test_MakeDeployHandler_Create_Service_Success(t *testing.T) {
 	assert := assert.New(t)
 	// Arrange
-	mockClient := new(mocks.BridgeClient)
-	handler := MakeDeployHandler(mockClient)
+	mockClient := new(mocks.Backend)
+	handler := MakeDeployHandler(mockClient, metastore.NewMemoryStore(), time.Hour)
 
-	request := requests.CreateFunctionRequest{
+	request := types.FunctionDeployment{
 		Service: "some-service",
+		Image:   "some/docker/image",
 		EnvVars: map[string]string{
 			"SOME_ENV": "SOME_VALUE",
 		},
@@ -39,16 +44,14 @@ func Test_MakeDeployHandler_Create_Service_Success(t *testing.T) {
 		logger.Fatal(reqErr)
 	}
 
-	mockClient.On("CreateService",
-		mock.MatchedBy(func(s *client.Service) bool {
+	mockClient.On("DeployFunction",
+		mock.MatchedBy(func(s *backend.FunctionSpec) bool {
 			return s.Name == request.Service &&
-				s.Scale == 1 &&
-				s.StartOnCreate == true &&
-				s.LaunchConfig.Environment["SOME_ENV"] == request.EnvVars["SOME_ENV"] &&
-				s.LaunchConfig.Environment["fprocess"] == request.EnvProcess &&
-				s.LaunchConfig.Labels["faas_function"] == request.Service
+				s.Image == request.Image &&
+				s.EnvVars["SOME_ENV"] == request.EnvVars["SOME_ENV"] &&
+				s.EnvProcess == request.EnvProcess
 		}),
-	).Return(nil, nil)
+	).Return(nil)
 	rr := httptest.NewRecorder()
 
 	// Act
@@ -56,13 +59,14 @@ func Test_MakeDeployHandler_Create_Service_Success(t *testing.T) {
 
 	// Assert
 	assert.Equal(rr.Code, http.StatusAccepted)
+	mockClient.AssertExpectations(t)
 }
 
 func Test_MakeDeployHandler_Bad_Json_Request(t *testing.T) {
 	assert := assert.New(t)
 	// Arrange
-	mockClient := new(mocks.BridgeClient)
-	handler := MakeDeployHandler(mockClient)
+	mockClient := new(mocks.Backend)
+	handler := MakeDeployHandler(mockClient, metastore.NewMemoryStore(), time.Hour)
 
 	badJSON := []byte(`{name: what?}`)
 	req, reqErr := http.NewRequest("POST", "/system/functions", bytes.NewReader(badJSON))
@@ -82,10 +86,10 @@ func Test_MakeDeployHandler_Bad_Json_Request(t *testing.T) {
 func Test_MakeDeployHandler_Invalid_Service_Name(t *testing.T) {
 	assert := assert.New(t)
 	// Arrange
-	mockClient := new(mocks.BridgeClient)
-	handler := MakeDeployHandler(mockClient)
+	mockClient := new(mocks.Backend)
+	handler := MakeDeployHandler(mockClient, metastore.NewMemoryStore(), time.Hour)
 
-	invalidRequest := requests.CreateFunctionRequest{
+	invalidRequest := types.FunctionDeployment{
 		Service: "invalid_servicename", // no valid DNS name
 	}
 	b, err := json.Marshal(invalidRequest)
@@ -110,10 +114,10 @@ func Test_MakeDeployHandler_Invalid_Service_Name(t *testing.T) {
 func Test_MakeDeployHandler_Create_Service_Error(t *testing.T) {
 	assert := assert.New(t)
 	// Arrange
-	mockClient := new(mocks.BridgeClient)
-	handler := MakeDeployHandler(mockClient)
+	mockClient := new(mocks.Backend)
+	handler := MakeDeployHandler(mockClient, metastore.NewMemoryStore(), time.Hour)
 
-	request := requests.CreateFunctionRequest{
+	request := types.FunctionDeployment{
 		Service: "some-service",
 	}
 	b, err := json.Marshal(request)
@@ -126,9 +130,9 @@ func Test_MakeDeployHandler_Create_Service_Error(t *testing.T) {
 		logger.Fatal(reqErr)
 	}
 
-	mockClient.On("CreateService",
-		mock.MatchedBy(func(s *client.Service) bool { return s.Name == request.Service }),
-	).Return(nil, fmt.Errorf("Error"))
+	mockClient.On("DeployFunction",
+		mock.MatchedBy(func(s *backend.FunctionSpec) bool { return s.Name == request.Service }),
+	).Return(fmt.Errorf("Error"))
 	rr := httptest.NewRecorder()
 
 	// Act
@@ -137,3 +141,44 @@ func Test_MakeDeployHandler_Create_Service_Error(t *testing.T) {
 	// Assert
 	assert.Equal(rr.Code, http.StatusInternalServerError)
 }
+
+func Test_MakeDeployHandler_Rejects_Invalid_Schedule(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	handler := MakeDeployHandler(mockClient, metastore.NewMemoryStore(), time.Hour)
+
+	request := types.FunctionDeployment{
+		Service:     "some-service",
+		Image:       "some/docker/image",
+		Labels:      &map[string]string{cron.TopicLabel: cron.Topic},
+		Annotations: &map[string]string{cron.ScheduleAnnotation: "61 * * * *"},
+	}
+
+	// Act
+	rr := doRequest(handler.ServeHTTP, "POST", request, nil)
+
+	// Assert
+	assert.Equal(http.StatusBadRequest, rr.Code)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeDeployHandler_Rejects_Invalid_Rate_Limit(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	handler := MakeDeployHandler(mockClient, metastore.NewMemoryStore(), time.Hour)
+
+	request := types.FunctionDeployment{
+		Service:     "some-service",
+		Image:       "some/docker/image",
+		Annotations: &map[string]string{ratelimit.RateAnnotation: "fast"},
+	}
+
+	// Act
+	rr := doRequest(handler.ServeHTTP, "POST", request, nil)
+
+	// Assert
+	assert.Equal(http.StatusBadRequest, rr.Code)
+	mockClient.AssertExpectations(t)
+}
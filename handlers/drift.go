@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gitmonster/faas-rancher/drift"
+	"github.com/juju/errors"
+)
+
+// MakeDriftHandler reports the result of the last drift reconciliation,
+// 404 Not Found if there was none yet
+func MakeDriftHandler(reconciler *drift.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := reconciler.LastReport()
+		if report == nil {
+			http.Error(w, "no drift reconciliation has run yet", http.StatusNotFound)
+			return
+		}
+
+		writeDriftReport(w, report)
+	}
+}
+
+// MakeDriftReconciler runs a drift reconciliation and reports its result
+func MakeDriftReconciler(reconciler *drift.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := reconciler.Reconcile()
+		if err != nil {
+			handleServerError(w, errors.Annotate(err, "Reconcile"))
+			return
+		}
+
+		writeDriftReport(w, report)
+	}
+}
+
+func writeDriftReport(w http.ResponseWriter, report *drift.Report) {
+	buf, err := json.Marshal(report)
+	if err != nil {
+		handleServerError(w, errors.Annotate(err, "Marshal"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf)
+}
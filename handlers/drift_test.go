@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/drift"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gitmonster/faas-rancher/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MakeDriftHandler_Does_Not_Reconcile(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	mockClient.On("ListFunctions", "").Return([]backend.Function{}, nil)
+	store := metastore.NewMemoryStore()
+	store.Put(&metastore.FunctionMeta{Service: "some-function", Image: "some/image"})
+	reconciler := drift.NewReconciler(mockClient, store, false)
+
+	// Act
+	before := httptest.NewRecorder()
+	MakeDriftHandler(reconciler)(before, httptest.NewRequest("GET", "/system/drift", nil))
+
+	reconciled := httptest.NewRecorder()
+	MakeDriftReconciler(reconciler)(reconciled, httptest.NewRequest("POST", "/system/drift", nil))
+
+	after := httptest.NewRecorder()
+	MakeDriftHandler(reconciler)(after, httptest.NewRequest("GET", "/system/drift", nil))
+
+	// Assert
+	assert.Equal(http.StatusNotFound, before.Code)
+	assert.Equal(http.StatusOK, reconciled.Code)
+	assert.Contains(reconciled.Body.String(), `"missing":["some-function"]`)
+	assert.Equal(reconciled.Body.String(), after.Body.String())
+	mockClient.AssertNumberOfCalls(t, "ListFunctions", 1)
+}
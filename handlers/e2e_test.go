@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gitmonster/faas-rancher/rancher"
+	"github.com/gitmonster/faas-rancher/rancher/cattletest"
+	"github.com/gitmonster/faas-rancher/trash"
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/faas/gateway/requests"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCattleBackend(t *testing.T) (*cattletest.Server, *rancher.Backend) {
+	server := cattletest.NewServer("access", "secret")
+	config, _ := rancher.NewClientConfig("faas-functions", server.URL, "access", "secret")
+
+	client, err := rancher.NewClientForConfig(config)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	return server, rancher.NewBackend(client, "faas-functions")
+}
+
+func doRequest(handler http.HandlerFunc, method string, body interface{}, vars map[string]string) *httptest.ResponseRecorder {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	req, err := http.NewRequest(method, "/system/functions", bytes.NewReader(buf))
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	if vars != nil {
+		VarsHandler(func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+			handler(w, r)
+		})(rr, req, vars)
+		return rr
+	}
+
+	handler(rr, req)
+	return rr
+}
+
+func listFunctions(b *rancher.Backend, store metastore.Store) []types.FunctionStatus {
+	rr := doRequest(MakeFunctionReader(b, store).ServeHTTP, "GET", nil, nil)
+
+	functions := []types.FunctionStatus{}
+	json.Unmarshal(rr.Body.Bytes(), &functions)
+	return functions
+}
+
+func Test_EndToEnd_Function_Lifecycle(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server, b := newCattleBackend(t)
+	defer server.Close()
+	store := metastore.NewMemoryStore()
+
+	deploy := types.FunctionDeployment{
+		Service:    "e-two-e",
+		Image:      "functions/alpine:latest",
+		EnvProcess: "cat",
+		EnvVars:    map[string]string{"SOME_ENV": "SOME_VALUE"},
+	}
+
+	// Act & Assert: deploy
+	rr := doRequest(MakeDeployHandler(b, store, time.Hour).ServeHTTP, "POST", deploy, nil)
+	assert.Equal(http.StatusAccepted, rr.Code)
+
+	services := server.Services()
+	assert.Len(services, 1)
+	assert.Equal("docker:functions/alpine:latest", services[0].LaunchConfig.ImageUuid)
+	assert.Equal("cat", services[0].LaunchConfig.Environment["fprocess"])
+	assert.Equal("e-two-e", services[0].LaunchConfig.Labels["faas_function"])
+
+	functions := listFunctions(b, store)
+	assert.Len(functions, 1)
+	assert.Equal("e-two-e", functions[0].Name)
+	assert.Equal("functions/alpine:latest", functions[0].Image)
+
+	// scale
+	handler := MakeReplicaUpdater(b)
+	req, _ := http.NewRequest("POST", "/system/scale-function/e-two-e", bytes.NewReader([]byte(`{"replicas":3}`)))
+	rr = httptest.NewRecorder()
+	handler(rr, req, map[string]string{"name": "e-two-e"})
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Len(server.Instances(services[0].Id), 3)
+
+	// update, the upgrade is finished in the background
+	deploy.Image = "functions/alpine:next"
+	rr = doRequest(MakeUpdateHandler(b, store, time.Hour).ServeHTTP, "PUT", deploy, nil)
+	assert.Equal(http.StatusAccepted, rr.Code)
+
+	state := ""
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		state = server.Services()[0].State
+		if state == "active" {
+			break
+		}
+	}
+	assert.Equal("active", state)
+	assert.Equal("docker:functions/alpine:next", server.Services()[0].LaunchConfig.ImageUuid)
+
+	// delete
+	rr = doRequest(MakeDeleteHandler(b, store).ServeHTTP, "DELETE", requests.DeleteFunctionRequest{FunctionName: "e-two-e"}, nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Len(server.Services(), 0)
+	assert.Len(listFunctions(b, store), 0)
+}
+
+func Test_EndToEnd_Secrets(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server, b := newCattleBackend(t)
+	defer server.Close()
+	store := metastore.NewMemoryStore()
+	handler := MakeSecretHandler(b)
+
+	// Act & Assert
+	rr := doRequest(handler, "POST", types.Secret{Name: "some-secret", Value: "v1"}, nil)
+	assert.Equal(http.StatusAccepted, rr.Code)
+
+	rr = doRequest(handler, "PUT", types.Secret{Name: "some-secret", Value: "v2"}, nil)
+	assert.Equal(http.StatusAccepted, rr.Code)
+	assert.Len(server.Secrets(), 1)
+	assert.Equal("v2", server.Secrets()[0].Value)
+
+	deploy := types.FunctionDeployment{
+		Service: "with-secret",
+		Image:   "functions/alpine:latest",
+		Secrets: []string{"some-secret"},
+	}
+	rr = doRequest(MakeDeployHandler(b, store, time.Hour).ServeHTTP, "POST", deploy, nil)
+	assert.Equal(http.StatusAccepted, rr.Code)
+	assert.Equal(server.Secrets()[0].Id, server.Services()[0].LaunchConfig.Secrets[0].SecretId)
+
+	rr = doRequest(handler, "DELETE", types.Secret{Name: "some-secret"}, nil)
+	assert.Equal(http.StatusAccepted, rr.Code)
+	assert.Len(server.Secrets(), 0)
+}
+
+func Test_EndToEnd_Namespaces(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server, b := newCattleBackend(t)
+	defer server.Close()
+	store := metastore.NewMemoryStore()
+
+	deploy := types.FunctionDeployment{
+		Service:   "e-two-e",
+		Namespace: "dev",
+		Image:     "functions/alpine:latest",
+	}
+
+	// Act & Assert: deploy into namespace
+	rr := doRequest(MakeDeployHandler(b, store, time.Hour).ServeHTTP, "POST", deploy, nil)
+	assert.Equal(http.StatusAccepted, rr.Code)
+
+	rr = doRequest(MakeNamespaceLister(b), "GET", nil, nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.JSONEq(`["faas-functions","dev"]`, rr.Body.String())
+
+	// the default namespace stays empty
+	assert.Len(listFunctions(b, store), 0)
+
+	req, _ := http.NewRequest("GET", "/system/functions?namespace=dev", nil)
+	rr = httptest.NewRecorder()
+	MakeFunctionReader(b, store).ServeHTTP(rr, req)
+
+	functions := []types.FunctionStatus{}
+	json.Unmarshal(rr.Body.Bytes(), &functions)
+	assert.Len(functions, 1)
+	assert.Equal("dev", functions[0].Namespace)
+
+	// Act & Assert: invalid namespace
+	deploy.Namespace = "Not_A_Stack"
+	rr = doRequest(MakeDeployHandler(b, store, time.Hour).ServeHTTP, "POST", deploy, nil)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+}
+
+func Test_EndToEnd_Soft_Delete(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server, b := newCattleBackend(t)
+	defer server.Close()
+	store := metastore.NewMemoryStore()
+	bin := trash.NewBin(b, store, time.Hour)
+
+	deploy := types.FunctionDeployment{
+		Service: "e-two-e",
+		Image:   "functions/alpine:latest",
+	}
+	rr := doRequest(MakeDeployHandler(b, store, time.Hour).ServeHTTP, "POST", deploy, nil)
+	assert.Equal(http.StatusAccepted, rr.Code)
+
+	// Act & Assert: soft delete
+	rr = doRequest(MakeSoftDeleteHandler(bin).ServeHTTP, "DELETE", requests.DeleteFunctionRequest{FunctionName: "e-two-e"}, nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Len(server.Services(), 1)
+	assert.Len(listFunctions(b, store), 0)
+
+	// restore
+	restore := func() int {
+		req, _ := http.NewRequest("POST", "/system/functions/e-two-e/restore", nil)
+		rr := httptest.NewRecorder()
+		MakeRestoreHandler(bin).ServeHTTP(rr, mux.SetURLVars(req, map[string]string{"name": "e-two-e"}))
+		return rr.Code
+	}
+	assert.Equal(http.StatusOK, restore())
+	assert.Len(listFunctions(b, store), 1)
+	assert.Equal(http.StatusNotFound, restore())
+
+	// deploying the name of a soft deleted function purges it
+	rr = doRequest(MakeSoftDeleteHandler(bin).ServeHTTP, "DELETE", requests.DeleteFunctionRequest{FunctionName: "e-two-e"}, nil)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	deploy.Image = "functions/alpine:next"
+	rr = doRequest(MakeDeployHandler(b, store, time.Hour).ServeHTTP, "POST", deploy, nil)
+	assert.Equal(http.StatusAccepted, rr.Code)
+	assert.Len(server.Services(), 1)
+	assert.Equal("docker:functions/alpine:next", server.Services()[0].LaunchConfig.ImageUuid)
+
+	entries, _ := bin.List()
+	assert.Len(entries, 0)
+}
+
+func Test_EndToEnd_Pause_Resume(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server, b := newCattleBackend(t)
+	defer server.Close()
+	store := metastore.NewCache(metastore.NewMemoryStore(), "faas-functions", time.Minute)
+
+	deploy := types.FunctionDeployment{
+		Service: "e-two-e",
+		Image:   "functions/alpine:latest",
+	}
+	rr := doRequest(MakeDeployHandler(b, store, time.Hour).ServeHTTP, "POST", deploy, nil)
+	assert.Equal(http.StatusAccepted, rr.Code)
+
+	call := func(handler http.HandlerFunc, name string) int {
+		req, _ := http.NewRequest("POST", "/", nil)
+		rr := httptest.NewRecorder()
+		handler(rr, mux.SetURLVars(req, map[string]string{"name": name}))
+		return rr.Code
+	}
+	proxy := MakePausedProxy(store, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Act & Assert: pause
+	assert.Equal(http.StatusOK, call(MakePauseHandler(b, store).ServeHTTP, "e-two-e"))
+	assert.Equal("inactive", server.Services()[0].State)
+	assert.Equal(http.StatusServiceUnavailable, call(proxy, "e-two-e.faas-functions"))
+
+	functions := listFunctions(b, store)
+	if assert.Len(functions, 1) {
+		assert.Equal(uint64(0), functions[0].AvailableReplicas)
+		assert.Equal("true", (*functions[0].Annotations)[PausedAnnotation])
+		assert.Equal(backend.StatusPaused, (*functions[0].Annotations)[StatusAnnotation])
+	}
+
+	// rancher doesn't upgrade inactive services
+	update := deploy
+	update.Image = "functions/alpine:next"
+	rr = doRequest(MakeUpdateHandler(b, store, time.Hour).ServeHTTP, "PUT", update, nil)
+	assert.Equal(http.StatusConflict, rr.Code)
+	assert.Contains(rr.Body.String(), "resume it before updating")
+	assert.Equal("inactive", server.Services()[0].State)
+	assert.Equal("docker:functions/alpine:latest", server.Services()[0].LaunchConfig.ImageUuid)
+
+	// resume
+	assert.Equal(http.StatusOK, call(MakeResumeHandler(b, store).ServeHTTP, "e-two-e"))
+	assert.Equal("active", server.Services()[0].State)
+	assert.Equal(http.StatusOK, call(proxy, "e-two-e"))
+
+	functions = listFunctions(b, store)
+	if assert.Len(functions, 1) {
+		assert.Equal(uint64(1), functions[0].AvailableReplicas)
+		assert.Equal(&map[string]string{StatusAnnotation: backend.StatusReady}, functions[0].Annotations)
+	}
+
+	assert.Equal(http.StatusNotFound, call(MakePauseHandler(b, store).ServeHTTP, "missing"))
+}
+
+func Test_EndToEnd_Explicit_Default_Namespace(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server, b := newCattleBackend(t)
+	defer server.Close()
+	namespaced, _ := metastore.WithDefaultNamespace(metastore.NewMemoryStore(), "faas-functions")
+	store := metastore.NewCache(namespaced, "faas-functions", time.Minute)
+
+	deploy := types.FunctionDeployment{
+		Service:     "e-two-e",
+		Namespace:   "faas-functions",
+		Image:       "functions/alpine:latest",
+		Annotations: &map[string]string{TimeoutAnnotation: "1m"},
+	}
+	rr := doRequest(MakeDeployHandler(b, store, time.Hour).ServeHTTP, "POST", deploy, nil)
+	assert.Equal(http.StatusAccepted, rr.Code)
+
+	// Act
+	req, _ := http.NewRequest("POST", "/system/functions/e-two-e/pause?namespace=faas-functions", nil)
+	rr = httptest.NewRecorder()
+	MakePauseHandler(b, store).ServeHTTP(rr, mux.SetURLVars(req, map[string]string{"name": "e-two-e"}))
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+
+	req, _ = http.NewRequest("POST", "/function/e-two-e", nil)
+	rr = httptest.NewRecorder()
+	MakePausedProxy(store, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})(rr, mux.SetURLVars(req, map[string]string{"name": "e-two-e"}))
+	assert.Equal(http.StatusServiceUnavailable, rr.Code)
+
+	meta := &metastore.FunctionMeta{Service: "e-two-e"}
+	if assert.NoError(store.Get(meta)) {
+		assert.True(meta.Paused)
+		assert.Equal("1m", meta.Annotations[TimeoutAnnotation])
+	}
+}
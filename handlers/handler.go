@@ -7,14 +7,15 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/concurrency"
 	"github.com/gitmonster/faas-rancher/helper"
 	"github.com/gitmonster/faas-rancher/metastore"
-	"github.com/gitmonster/faas-rancher/rancher"
 	"github.com/gorilla/mux"
 	"github.com/juju/errors"
 	"github.com/openfaas/faas-provider/types"
-	rancherClient "github.com/rancher/go-rancher/v2"
 	"github.com/sirupsen/logrus"
 )
 
@@ -23,8 +24,22 @@ var (
 )
 
 const (
-	// FaasFunctionLabel is the label set to faas function containers
-	FaasFunctionLabel = "faas_function"
+	// OriginAnnotation tags functions with the environment they are running in
+	OriginAnnotation = "com.openfaas.rancher.environment"
+	// DrainingAnnotation tags functions finishing their requests before they are removed
+	DrainingAnnotation = "com.openfaas.rancher.draining"
+	// PausedAnnotation tags functions paused on purpose
+	PausedAnnotation = "com.openfaas.rancher.paused"
+	// StatusAnnotation reports the status of functions, one of the backend.Status constants
+	StatusAnnotation = "com.openfaas.rancher.status"
+	// InflightAnnotation reports the requests served by functions with a concurrency limit
+	InflightAnnotation = "com.openfaas.rancher.inflight"
+	// QueuedAnnotation reports the requests waiting for functions at their concurrency limit
+	QueuedAnnotation = "com.openfaas.rancher.queued"
+	// TimeoutAnnotation sets the time invocations of a function may take, e.g. "2m"
+	TimeoutAnnotation = "com.openfaas.rancher.timeout"
+	// RetryAnnotation sets whether failed invocations of a function are retried, by default GET and HEAD requests are
+	RetryAnnotation = "com.openfaas.rancher.retry"
 )
 
 // VarsHandler a wrapper type for mux.Vars
@@ -46,157 +61,138 @@ func handleBadRequest(w http.ResponseWriter, err error) {
 	http.Error(w, err.Error(), http.StatusBadRequest)
 }
 
-func getServiceList(client rancher.BridgeClient) ([]types.FunctionStatus, error) {
+// namespaceOf returns the namespace passed as query parameter
+func namespaceOf(r *http.Request) string {
+	return r.URL.Query().Get("namespace")
+}
+
+func isNotFound(err error) bool {
+	return errors.Cause(err) == backend.ErrFunctionNotFound
+}
+
+func isInactive(err error) bool {
+	return errors.Cause(err) == backend.ErrFunctionInactive
+}
+
+func isExisting(err error) bool {
+	return errors.Cause(err) == backend.ErrFunctionExists
+}
+
+func isInvalidNamespace(err error) bool {
+	return errors.Cause(err) == backend.ErrInvalidNamespace
+}
+
+func getServiceList(b backend.Backend, store metastore.Store, namespace string) ([]types.FunctionStatus, error) {
 	functions := []types.FunctionStatus{}
 
-	services, err := client.ListServices()
+	list, err := b.ListFunctions(namespace)
 	if err != nil {
-		return nil, errors.Annotate(err, "ListServices")
+		return nil, errors.Annotate(err, "ListFunctions")
 	}
 
-	for _, service := range services {
-		if service.State != "active" {
-			// ignore inactive services
-			continue
+	for _, function := range list {
+		meta := &metastore.FunctionMeta{
+			Service:   function.Name,
+			Namespace: namespace,
+			Image:     function.Image,
 		}
 
-		if _, ok := service.LaunchConfig.Labels[FaasFunctionLabel]; ok {
-			meta := &metastore.FunctionMeta{
-				Service: service.Name,
-				Image:   service.LaunchConfig.ImageUuid,
-			}
+		err := store.Get(meta)
+		if err != nil && err != metastore.ErrEntityNotFound {
+			return nil, errors.Annotate(err, "Get [metastore]")
+		}
 
-			if err := metastore.Read(meta); err != nil {
-				if err != metastore.ErrEntityNotFound {
-					return nil, errors.Annotate(err, "Read [metastore]")
+		// restore meta from backend function
+		if err == metastore.ErrEntityNotFound {
+			// soft deleted functions are listed by the trash only
+			if _, err := store.GetTrash(meta); err != metastore.ErrEntityNotFound {
+				if err != nil {
+					return nil, errors.Annotate(err, "GetTrash [metastore]")
 				}
+				continue
 			}
 
-			// restore meta from rancher service
-			if err == metastore.ErrEntityNotFound {
-				meta.Service = service.Name
-				meta.Image = service.LaunchConfig.ImageUuid
-				meta.Labels = service.LaunchConfig.Labels
-				meta.Annotations = make(map[string]interface{})
-
-				if envProcess, ok := service.LaunchConfig.Environment["fprocess"]; ok {
-					if envProcess, ok := envProcess.(string); ok {
-						meta.EnvProcess = envProcess
-					}
-				}
+			meta.RestoreFrom(&function)
 
-				if err := metastore.Update(meta); err != nil {
-					return nil, errors.Annotate(err, "Update [metastore]")
+			// the spec of functions in transition may not be final yet
+			if function.State == backend.StateActive {
+				if err := store.Put(meta); err != nil {
+					return nil, errors.Annotate(err, "Put [metastore]")
 				}
 			}
+		}
 
-			// filter to faas function services
-			replicas := uint64(service.Scale)
-			function := types.FunctionStatus{
-				Name:              meta.Service,
-				Replicas:          replicas,
-				AvailableReplicas: replicas,
-				Image:             meta.Image,
-				EnvProcess:        meta.EnvProcess,
-				Labels:            helper.ToFaasMap(meta.Labels),
-				Annotations:       helper.ToFaasMap(meta.Annotations),
-				InvocationCount:   0,
-			}
+		status := types.FunctionStatus{
+			Name:              meta.Service,
+			Namespace:         function.Namespace,
+			Replicas:          function.Replicas,
+			AvailableReplicas: function.AvailableReplicas,
+			Image:             meta.Image,
+			EnvProcess:        meta.EnvProcess,
+			Labels:            helper.ToFaasMap(meta.Labels),
+			Annotations:       helper.ToFaasMap(meta.Annotations),
+			InvocationCount:   0,
+		}
 
-			functions = append(functions, function)
+		if function.Origin != "" {
+			annotate(&status, OriginAnnotation, function.Origin)
 		}
-	}
 
-	return functions, nil
-}
+		if function.Draining {
+			annotate(&status, DrainingAnnotation, "true")
+		}
 
-func makeUpgradeSpec(
+		if meta.Paused {
+			annotate(&status, PausedAnnotation, "true")
+		}
 
-	client rancher.BridgeClient,
-	request types.FunctionDeployment,
+		if _, limited := meta.Annotations[concurrency.MaxInflightAnnotation]; limited {
+			annotate(&status, InflightAnnotation, strconv.Itoa(function.InFlight))
+			annotate(&status, QueuedAnnotation, strconv.Itoa(function.Queued))
+		}
 
-) (*rancherClient.ServiceUpgrade, error) {
-	lc, err := launchConfigFromReq(client, request)
-	if err != nil {
-		return nil, errors.Annotate(err, "launchConfigFromReq")
-	}
+		functionStatus := statusOf(&function, meta.Paused)
+		annotate(&status, StatusAnnotation, functionStatus)
+		if !serving(functionStatus) {
+			status.AvailableReplicas = 0
+		}
 
-	spec := &rancherClient.ServiceUpgrade{
-		InServiceStrategy: &rancherClient.InServiceUpgradeStrategy{
-			BatchSize:              1,
-			StartFirst:             true,
-			LaunchConfig:           lc,
-			SecondaryLaunchConfigs: []rancherClient.SecondaryLaunchConfig{},
-		},
+		functions = append(functions, status)
 	}
 
-	return spec, nil
+	return functions, nil
 }
 
-func makeServiceSpec(
-
-	client rancher.BridgeClient,
-	request types.FunctionDeployment,
-
-) (*rancherClient.Service, error) {
-	lc, err := launchConfigFromReq(client, request)
-	if err != nil {
-		return nil, errors.Annotate(err, "launchConfigFromReq")
+func annotate(status *types.FunctionStatus, key, value string) {
+	annotations := make(map[string]string)
+	if status.Annotations != nil {
+		annotations = *status.Annotations
 	}
 
-	serviceSpec := &rancherClient.Service{
-		Name:          request.Service,
-		Scale:         1,
-		StartOnCreate: true,
-		LaunchConfig:  lc,
-	}
-
-	return serviceSpec, nil
+	annotations[key] = value
+	status.Annotations = &annotations
 }
 
-func launchConfigFromReq(
-
-	client rancher.BridgeClient,
-	request types.FunctionDeployment,
-
-) (*rancherClient.LaunchConfig, error) {
-
-	envVars := make(map[string]interface{})
-	for k, v := range request.EnvVars {
-		envVars[k] = v
+// statusOf returns the status of a function, paused functions are reported as such
+func statusOf(function *backend.Function, paused bool) string {
+	switch {
+	case paused && function.State != backend.StateActive:
+		return backend.StatusPaused
+	case function.Status != "":
+		return function.Status
+	case function.State == backend.StateActive:
+		return backend.StatusReady
 	}
 
-	if len(request.EnvProcess) > 0 {
-		envVars["fprocess"] = request.EnvProcess
-	}
-
-	labels := helper.ToRancherMap(request.Labels)
-	labels[FaasFunctionLabel] = request.Service
-	labels["io.rancher.container.pull_image"] = "always"
-
-	lc := &rancherClient.LaunchConfig{
-		Environment: envVars,
-		ImageUuid:   "docker:" + request.Image, // not sure if it's ok to just prefix with 'docker:'
-		Labels:      labels,
-	}
-
-	for _, name := range request.Secrets {
-		s := types.Secret{
-			Name: name,
-		}
-
-		sec, err := lookupSecret(client, &s)
-		if err != nil {
-			return nil, errors.Annotate(err, "lookupSecret")
-		}
-
-		ref := rancherClient.SecretReference{
-			Name:     sec.Name,
-			SecretId: sec.Id,
-		}
+	return backend.StatusUnknown
+}
 
-		lc.Secrets = append(lc.Secrets, ref)
+// serving reports whether functions of the status may serve requests
+func serving(status string) bool {
+	switch status {
+	case backend.StatusInactive, backend.StatusPaused, backend.StatusRemoving, backend.StatusError:
+		return false
 	}
 
-	return lc, nil
+	return true
 }
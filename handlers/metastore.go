@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/juju/errors"
+)
+
+// MakeMetastoreExportHandler returns a snapshot of the metastore
+func MakeMetastoreExportHandler(store metastore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := metastore.Export(store)
+		if err != nil {
+			handleServerError(w, errors.Annotate(err, "Export"))
+			return
+		}
+
+		buf, err := json.Marshal(snapshot)
+		if err != nil {
+			handleServerError(w, errors.Annotate(err, "Marshal"))
+			return
+		}
+
+		filename := fmt.Sprintf("metastore-%s.json", snapshot.Created.Format("20060102T150405Z"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf)
+	}
+}
+
+// MakeMetastoreImportHandler restores a snapshot created by the export handler.
+// The query parameter mode selects merge (default) or replace.
+func MakeMetastoreImportHandler(store metastore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		mode := metastore.ImportMode(r.URL.Query().Get("mode"))
+		if mode == "" {
+			mode = metastore.ImportMerge
+		}
+
+		snapshot := metastore.Snapshot{}
+		if err := json.NewDecoder(r.Body).Decode(&snapshot); err != nil {
+			handleBadRequest(w, errors.Annotate(err, "Decode"))
+			return
+		}
+
+		result, err := metastore.Import(store, &snapshot, mode)
+		if err != nil {
+			if errors.Cause(err) == metastore.ErrInvalidSnapshot {
+				handleBadRequest(w, errors.Annotate(err, "Import"))
+				return
+			}
+
+			handleServerError(w, errors.Annotate(err, "Import"))
+			return
+		}
+
+		buf, err := json.Marshal(result)
+		if err != nil {
+			handleServerError(w, errors.Annotate(err, "Marshal"))
+			return
+		}
+
+		logger.Infof("imported %d metastore entries, deleted %d", result.Imported, result.Deleted)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf)
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Metastore_Export_Import(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	source := metastore.NewMemoryStore()
+	source.Put(&metastore.FunctionMeta{Service: "some-function", Image: "some/image"})
+	target := metastore.NewMemoryStore()
+
+	// Act
+	exported := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/system/metastore/export", nil)
+	MakeMetastoreExportHandler(source)(exported, req)
+
+	imported := httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/system/metastore/import?mode=replace", bytes.NewReader(exported.Body.Bytes()))
+	MakeMetastoreImportHandler(target)(imported, req)
+
+	// Assert
+	assert.Equal(http.StatusOK, exported.Code)
+	assert.Equal(http.StatusOK, imported.Code)
+	assert.JSONEq(`{"imported":1,"deleted":0}`, imported.Body.String())
+	assert.NoError(target.Get(&metastore.FunctionMeta{Service: "some-function"}))
+}
+
+func Test_Metastore_Import_Rejects_Invalid_Snapshot(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	store := metastore.NewMemoryStore()
+	body := `{"format":1,"functions":[{"service":"some-function"}]}`
+
+	// Act
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/system/metastore/import", bytes.NewReader([]byte(body)))
+	MakeMetastoreImportHandler(store)(rr, req)
+
+	// Assert
+	assert.Equal(http.StatusBadRequest, rr.Code)
+}
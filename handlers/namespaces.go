@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/juju/errors"
+)
+
+// MakeNamespaceLister lists the namespaces functions can be deployed to
+func MakeNamespaceLister(b backend.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespaces, err := b.ListNamespaces()
+		if err != nil {
+			if errors.Cause(err) == backend.ErrNotSupported {
+				http.Error(w, err.Error(), http.StatusNotImplemented)
+				return
+			}
+
+			handleServerError(w, errors.Annotate(err, "ListNamespaces"))
+			return
+		}
+
+		buf, err := json.Marshal(namespaces)
+		if err != nil {
+			handleServerError(w, errors.Annotate(err, "Marshal"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf)
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+)
+
+// MakePauseHandler deactivates a function keeping its spec and scale
+func MakePauseHandler(b backend.Backend, store metastore.Store) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		if err := setPaused(b, store, vars["name"], namespaceOf(r), true); err != nil {
+			if isNotFound(err) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			handleServerError(w, errors.Annotate(err, "setPaused"))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// MakeResumeHandler activates a paused function again
+func MakeResumeHandler(b backend.Backend, store metastore.Store) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		if err := setPaused(b, store, vars["name"], namespaceOf(r), false); err != nil {
+			if isNotFound(err) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			handleServerError(w, errors.Annotate(err, "setPaused"))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// MakePausedProxy answers requests to paused functions with 503 instead
+// of passing them to next
+func MakePausedProxy(cache *metastore.Cache, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, namespace := backend.SplitFunctionName(mux.Vars(r)["name"])
+		if cache.Paused(name, namespace) {
+			http.Error(w, "function "+name+" is paused", http.StatusServiceUnavailable)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// setPaused deactivates or activates the function and records the state in the metastore
+func setPaused(b backend.Backend, store metastore.Store, name, namespace string, paused bool) error {
+	function, err := b.FindFunction(name, namespace)
+	if err != nil {
+		return errors.Annotate(err, "FindFunction")
+	}
+
+	meta := &metastore.FunctionMeta{
+		Service:   function.Name,
+		Namespace: namespace,
+		Image:     function.Image,
+	}
+
+	if err := store.Get(meta); err != nil {
+		if err != metastore.ErrEntityNotFound {
+			return errors.Annotate(err, "Get [metastore]")
+		}
+
+		meta.RestoreFrom(function)
+	}
+
+	if paused {
+		if err := b.DeactivateFunction(name, namespace); err != nil {
+			return errors.Annotate(err, "DeactivateFunction")
+		}
+	} else {
+		if err := b.ActivateFunction(name, namespace); err != nil {
+			return errors.Annotate(err, "ActivateFunction")
+		}
+	}
+
+	meta.Paused = paused
+	if err := store.Put(meta); err != nil {
+		return errors.Annotate(err, "Put [metastore]")
+	}
+
+	logger.Infof("function %q paused: %t", meta.Key(), paused)
+	return nil
+}
@@ -6,21 +6,30 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 
-	"github.com/gitmonster/faas-rancher/rancher"
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/metastore"
 	"github.com/juju/errors"
+	"github.com/openfaas/faas-provider/types"
 )
 
 // MakeFunctionReader handler for reading functions deployed in the cluster as deployments.
-func MakeFunctionReader(client rancher.BridgeClient) VarsHandler {
+// The query parameter status (repeated or comma separated) lists the statuses of the
+// functions to return, all functions are returned without it.
+func MakeFunctionReader(b backend.Backend, store metastore.Store) VarsHandler {
 	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
 
-		functions, err := getServiceList(client)
+		functions, err := getServiceList(b, store, namespaceOf(r))
 		if err != nil {
 			handleServerError(w, errors.Annotate(err, "getServiceList"))
 			return
 		}
 
+		if statuses := r.URL.Query()["status"]; len(statuses) > 0 {
+			functions = filterByStatus(functions, statuses)
+		}
+
 		functionBytes, marshalErr := json.Marshal(functions)
 		if marshalErr != nil {
 			handleServerError(w, errors.Annotate(marshalErr, "Marshal"))
@@ -31,3 +40,22 @@ func MakeFunctionReader(client rancher.BridgeClient) VarsHandler {
 		w.Write(functionBytes)
 	}
 }
+
+// filterByStatus returns the functions whose status annotation is one of statuses
+func filterByStatus(functions []types.FunctionStatus, statuses []string) []types.FunctionStatus {
+	wanted := make(map[string]bool)
+	for _, status := range statuses {
+		for _, s := range strings.Split(status, ",") {
+			wanted[strings.TrimSpace(s)] = true
+		}
+	}
+
+	filtered := []types.FunctionStatus{}
+	for _, function := range functions {
+		if function.Annotations != nil && wanted[(*function.Annotations)[StatusAnnotation]] {
+			filtered = append(filtered, function)
+		}
+	}
+
+	return filtered
+}
@@ -8,17 +8,19 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/concurrency"
+	"github.com/gitmonster/faas-rancher/metastore"
 	"github.com/gitmonster/faas-rancher/mocks"
-	"github.com/openfaas/faas/gateway/requests"
-	client "github.com/rancher/go-rancher/v2"
+	"github.com/openfaas/faas-provider/types"
 	"github.com/stretchr/testify/assert"
 )
 
 func Test_MakeFunctionReader_Get_Service_List_Error(t *testing.T) {
 	assert := assert.New(t)
 	// Arrange
-	mockClient := new(mocks.BridgeClient)
-	handler := MakeFunctionReader(mockClient)
+	mockClient := new(mocks.Backend)
+	handler := MakeFunctionReader(mockClient, metastore.NewMemoryStore())
 
 	req, reqErr := http.NewRequest("GET", "/system/functions", nil)
 	if reqErr != nil {
@@ -27,7 +29,7 @@ func Test_MakeFunctionReader_Get_Service_List_Error(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	mockClient.On("ListServices").Return(nil, fmt.Errorf("Error"))
+	mockClient.On("ListFunctions", "").Return(nil, fmt.Errorf("Error"))
 
 	// Act
 	handler(rr, req, nil)
@@ -37,11 +39,11 @@ func Test_MakeFunctionReader_Get_Service_List_Error(t *testing.T) {
 	mockClient.AssertExpectations(t)
 }
 
-func Test_MakeFunctionReader_Get_Service_List_No_Active_Services(t *testing.T) {
+func Test_MakeFunctionReader_Get_Service_List_Has_Non_Active_Services(t *testing.T) {
 	assert := assert.New(t)
 	// Arrange
-	mockClient := new(mocks.BridgeClient)
-	handler := MakeFunctionReader(mockClient)
+	mockClient := new(mocks.Backend)
+	handler := MakeFunctionReader(mockClient, metastore.NewMemoryStore())
 
 	req, reqErr := http.NewRequest("GET", "/system/functions", nil)
 	if reqErr != nil {
@@ -50,78 +52,82 @@ func Test_MakeFunctionReader_Get_Service_List_No_Active_Services(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	nonActiveService := client.Service{
-		State: "activating",
+	nonActiveFunction := backend.Function{
+		Name:   "SomeFunction",
+		State:  "activating",
+		Status: backend.StatusDeploying,
 	}
 
-	services := []client.Service{
-		nonActiveService,
+	functions := []backend.Function{
+		nonActiveFunction,
 	}
-	mockClient.On("ListServices").Return(services, nil)
+	mockClient.On("ListFunctions", "").Return(functions, nil)
 
 	// Act
 	handler(rr, req, nil)
 
 	// Assert
 	responseBody, _ := ioutil.ReadAll(rr.Body)
-	responseServices := make([]client.Service, 0)
-	json.Unmarshal(responseBody, &responseServices)
+	responseFunctions := make([]types.FunctionStatus, 0)
+	json.Unmarshal(responseBody, &responseFunctions)
 
 	assert.Equal(rr.Code, http.StatusOK)
-	assert.Equal(0, len(responseServices))
+	if assert.Equal(1, len(responseFunctions)) {
+		assert.Equal(backend.StatusDeploying, (*responseFunctions[0].Annotations)[StatusAnnotation])
+	}
 	mockClient.AssertExpectations(t)
 }
 
 func Test_MakeFunctionReader_Get_Service_List_Has_Active_Services(t *testing.T) {
 	assert := assert.New(t)
 	// Arrange
-	mockClient := new(mocks.BridgeClient)
-	handler := MakeFunctionReader(mockClient)
+	mockClient := new(mocks.Backend)
+	handler := MakeFunctionReader(mockClient, metastore.NewMemoryStore())
 
-	req, reqErr := http.NewRequest("GET", "/system/functions", nil)
+	req, reqErr := http.NewRequest("GET", "/system/functions?status=ready", nil)
 	if reqErr != nil {
 		logger.Fatal(reqErr)
 	}
 
 	rr := httptest.NewRecorder()
 
-	nonActiveService := client.Service{
+	nonActiveFunction := backend.Function{
+		Name:  "OtherFunction",
 		State: "activating",
 	}
 
-	activeService := client.Service{
-		State: "active",
-		Name:  "SomeFunction",
-		Scale: 1,
-		LaunchConfig: &client.LaunchConfig{
-			ImageUuid: "some/docker/image",
-			Labels: map[string]interface{}{
-				"faas_function": "some_function",
-			},
+	activeFunction := backend.Function{
+		State:             "active",
+		Name:              "SomeFunction",
+		Image:             "some/docker/image",
+		Replicas:          1,
+		AvailableReplicas: 1,
+		Labels: map[string]string{
+			"faas_function": "SomeFunction",
 		},
 	}
 
-	replicas := uint64(activeService.Scale)
-	expectedFunction := requests.Function{
-		Name:              activeService.Name,
-		Replicas:          replicas,
-		AvailableReplicas: replicas,
-		Image:             activeService.LaunchConfig.ImageUuid,
+	expectedFunction := types.FunctionStatus{
+		Name:              activeFunction.Name,
+		Replicas:          activeFunction.Replicas,
+		AvailableReplicas: activeFunction.AvailableReplicas,
+		Image:             activeFunction.Image,
+		Labels:            &activeFunction.Labels,
+		Annotations:       &map[string]string{StatusAnnotation: backend.StatusReady},
 		InvocationCount:   0,
 	}
 
-	services := []client.Service{
-		nonActiveService,
-		activeService,
-	}
-	mockClient.On("ListServices").Return(services, nil)
+	mockClient.On("ListFunctions", "").Return([]backend.Function{
+		nonActiveFunction,
+		activeFunction,
+	}, nil)
 
 	// Act
 	handler(rr, req, nil)
 
 	// Assert
 	responseBody, _ := ioutil.ReadAll(rr.Body)
-	functions := make([]requests.Function, 0)
+	functions := make([]types.FunctionStatus, 0)
 	json.Unmarshal(responseBody, &functions)
 
 	assert.Equal(rr.Code, http.StatusOK)
@@ -131,50 +137,64 @@ func Test_MakeFunctionReader_Get_Service_List_Has_Active_Services(t *testing.T)
 	mockClient.AssertExpectations(t)
 }
 
-func Test_MakeFunctionReader_Get_Service_List_Has_Active_Services_But_Not_Labeled(t *testing.T) {
+func Test_MakeFunctionReader_Annotates_Draining_Functions(t *testing.T) {
 	assert := assert.New(t)
 	// Arrange
-	mockClient := new(mocks.BridgeClient)
-	handler := MakeFunctionReader(mockClient)
-
-	req, reqErr := http.NewRequest("GET", "/system/functions", nil)
-	if reqErr != nil {
-		logger.Fatal(reqErr)
-	}
+	mockClient := new(mocks.Backend)
+	handler := MakeFunctionReader(mockClient, metastore.NewMemoryStore())
 
+	req, _ := http.NewRequest("GET", "/system/functions", nil)
 	rr := httptest.NewRecorder()
 
-	nonActiveService := client.Service{
-		State: "activating",
-	}
-
-	activeButNotLabeledService := client.Service{
-		State: "active",
-		Name:  "SomeFunction",
-		Scale: 1,
-		LaunchConfig: &client.LaunchConfig{
-			ImageUuid: "some/docker/image",
-			// no label to indicate faas function
-			Labels: map[string]interface{}{},
+	mockClient.On("ListFunctions", "").Return([]backend.Function{
+		{
+			State:    "active",
+			Name:     "SomeFunction",
+			Image:    "some/docker/image",
+			Draining: true,
 		},
-	}
-
-	services := []client.Service{
-		nonActiveService,
-		activeButNotLabeledService,
-	}
-	mockClient.On("ListServices").Return(services, nil)
+	}, nil)
 
 	// Act
 	handler(rr, req, nil)
 
 	// Assert
-	responseBody, _ := ioutil.ReadAll(rr.Body)
-	functions := make([]requests.Function, 0)
-	json.Unmarshal(responseBody, &functions)
+	functions := make([]types.FunctionStatus, 0)
+	json.Unmarshal(rr.Body.Bytes(), &functions)
+	if assert.Len(functions, 1) && assert.NotNil(functions[0].Annotations) {
+		assert.Equal("true", (*functions[0].Annotations)[DrainingAnnotation])
+	}
+}
 
-	assert.Equal(rr.Code, http.StatusOK)
-	assert.Equal(0, len(functions))
+func Test_MakeFunctionReader_Annotates_Limited_Functions(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	store := metastore.NewMemoryStore()
+	store.Put(&metastore.FunctionMeta{
+		Service:     "limited-function",
+		Image:       "some/docker/image",
+		Annotations: map[string]interface{}{concurrency.MaxInflightAnnotation: "2"},
+	})
+	handler := MakeFunctionReader(mockClient, store)
+
+	req, _ := http.NewRequest("GET", "/system/functions", nil)
+	rr := httptest.NewRecorder()
 
-	mockClient.AssertExpectations(t)
+	mockClient.On("ListFunctions", "").Return([]backend.Function{
+		{State: "active", Name: "limited-function", Image: "some/docker/image", InFlight: 2, Queued: 3},
+		{State: "active", Name: "other-function", Image: "some/docker/image"},
+	}, nil)
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	functions := make([]types.FunctionStatus, 0)
+	json.Unmarshal(rr.Body.Bytes(), &functions)
+	if assert.Len(functions, 2) {
+		assert.Equal("2", (*functions[0].Annotations)[InflightAnnotation])
+		assert.Equal("3", (*functions[0].Annotations)[QueuedAnnotation])
+		assert.NotContains(*functions[1].Annotations, InflightAnnotation)
+	}
 }
@@ -8,15 +8,15 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"strconv"
 
-	"github.com/gitmonster/faas-rancher/rancher"
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/metastore"
 	"github.com/juju/errors"
 	"github.com/openfaas/faas-provider/types"
 )
 
 // MakeReplicaUpdater updates desired count of replicas
-func MakeReplicaUpdater(client rancher.BridgeClient) VarsHandler {
+func MakeReplicaUpdater(b backend.Backend) VarsHandler {
 	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
 
 		log.Println("Update replicas")
@@ -38,19 +38,13 @@ func MakeReplicaUpdater(client rancher.BridgeClient) VarsHandler {
 			}
 		}
 
-		service, findErr := client.FindServiceByName(functionName)
-		if findErr != nil {
-			log.Println(errors.Annotate(findErr, "FindServiceByName"))
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("Unable to lookup function deployment " + functionName))
-			return
-		}
+		if err := b.ScaleFunction(functionName, namespaceOf(r), req.Replicas); err != nil {
+			if isNotFound(err) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
 
-		updates := make(map[string]string)
-		updates["scale"] = strconv.FormatUint(req.Replicas, 10)
-		_, upgradeErr := client.UpdateService(service, updates)
-		if upgradeErr != nil {
-			log.Println(errors.Annotate(upgradeErr, "UpdateService"))
+			log.Println(errors.Annotate(err, "ScaleFunction"))
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("Unable to update function deployment " + functionName))
 			return
@@ -59,10 +53,10 @@ func MakeReplicaUpdater(client rancher.BridgeClient) VarsHandler {
 }
 
 // MakeReplicaReader reads the amount of replicas for a deployment
-func MakeReplicaReader(client rancher.BridgeClient) VarsHandler {
+func MakeReplicaReader(b backend.Backend, store metastore.Store) VarsHandler {
 	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
 		functionName := vars["name"]
-		functions, err := getServiceList(client)
+		functions, err := getServiceList(b, store, namespaceOf(r))
 		if err != nil {
 			handleServerError(w, errors.Annotate(err, "getServiceList"))
 			return
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/balancer"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+)
+
+// ParseRetry returns the value of the RetryAnnotation, nil if the
+// annotation is missing
+func ParseRetry(annotations map[string]string) (*bool, error) {
+	value, ok := annotations[RetryAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	retry, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, errors.Annotatef(err, "%s", RetryAnnotation)
+	}
+
+	return &retry, nil
+}
+
+// MakeRetryProxy marks the requests passed to next as retryable or not
+// as annotated on the function
+func MakeRetryProxy(cache *metastore.Cache, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		service := mux.Vars(r)["name"]
+		annotations, err := cache.Annotations(backend.SplitFunctionName(service))
+		if err != nil {
+			next(w, r)
+			return
+		}
+
+		retry, err := ParseRetry(annotations)
+		if err != nil {
+			logger.Warnf("ignoring retry of %q: %v", service, err)
+		}
+
+		if retry == nil {
+			next(w, r)
+			return
+		}
+
+		next(w, r.WithContext(balancer.WithRetry(r.Context(), *retry)))
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gitmonster/faas-rancher/mocks"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	retry, err := ParseRetry(map[string]string{RetryAnnotation: "true"})
+	assert.NoError(err)
+	if assert.NotNil(retry) {
+		assert.True(*retry)
+	}
+
+	retry, err = ParseRetry(map[string]string{RetryAnnotation: "false"})
+	assert.NoError(err)
+	if assert.NotNil(retry) {
+		assert.False(*retry)
+	}
+
+	retry, err = ParseRetry(nil)
+	assert.NoError(err)
+	assert.Nil(retry)
+
+	_, err = ParseRetry(map[string]string{RetryAnnotation: "sometimes"})
+	assert.Error(err)
+}
+
+func Test_MakeDeployHandler_Rejects_Invalid_Retry(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	request := types.FunctionDeployment{
+		Service:     "some-service",
+		Image:       "some/docker/image",
+		Annotations: &map[string]string{RetryAnnotation: "sometimes"},
+	}
+
+	// Act
+	rr := doRequest(MakeDeployHandler(mockClient, metastore.NewMemoryStore(), time.Hour).ServeHTTP, "POST", request, nil)
+
+	// Assert
+	assert.Equal(http.StatusBadRequest, rr.Code)
+	mockClient.AssertExpectations(t)
+}
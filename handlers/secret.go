@@ -5,15 +5,14 @@ import (
 	"io/ioutil"
 	"net/http"
 
-	"github.com/gitmonster/faas-rancher/rancher"
+	"github.com/gitmonster/faas-rancher/backend"
 	"github.com/juju/errors"
 	"github.com/openfaas/faas-provider/types"
-	rancherClient "github.com/rancher/go-rancher/v2"
 )
 
 // MakeSecretHandler makes a handler for Create/List/Delete/Update of
-//secrets in the Rancher API
-func MakeSecretHandler(client rancher.BridgeClient) http.HandlerFunc {
+//secrets in the backend
+func MakeSecretHandler(b backend.Backend) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		defer r.Body.Close()
@@ -24,7 +23,7 @@ func MakeSecretHandler(client rancher.BridgeClient) http.HandlerFunc {
 		}
 
 		if len(body) == 0 {
-			handleList(client, w)
+			handleList(b, namespaceOf(r), w)
 			return
 		}
 
@@ -35,13 +34,13 @@ func MakeSecretHandler(client rancher.BridgeClient) http.HandlerFunc {
 		}
 
 		if secret.Name != "" && secret.Value != "" {
-			if err := ensureSecret(client, &secret); err != nil {
-				handleBadRequest(w, errors.Annotate(err, "ensureSecret"))
+			if err := b.EnsureSecret(&secret); err != nil {
+				handleBadRequest(w, errors.Annotate(err, "EnsureSecret"))
 				return
 			}
 		} else if secret.Name != "" {
-			if err := deleteSecret(client, &secret); err != nil {
-				handleBadRequest(w, errors.Annotate(err, "deleteSecret"))
+			if err := b.DeleteSecret(secret.Name, secret.Namespace); err != nil {
+				handleBadRequest(w, errors.Annotate(err, "DeleteSecret"))
 				return
 			}
 		}
@@ -50,21 +49,13 @@ func MakeSecretHandler(client rancher.BridgeClient) http.HandlerFunc {
 	}
 }
 
-func handleList(client rancher.BridgeClient, w http.ResponseWriter) {
-	coll, err := client.ListSecrets(nil)
+func handleList(b backend.Backend, namespace string, w http.ResponseWriter) {
+	results, err := b.ListSecrets(namespace)
 	if err != nil {
 		handleServerError(w, errors.Annotate(err, "ListSecrets"))
 		return
 	}
 
-	var results []types.Secret
-	for _, s := range coll.Data {
-		results = append(results, types.Secret{
-			Name:  s.Name,
-			Value: s.Value,
-		})
-	}
-
 	buf, err := json.Marshal(results)
 	if err != nil {
 		handleBadRequest(w, errors.Annotate(err, "Marshal"))
@@ -75,61 +66,3 @@ func handleList(client rancher.BridgeClient, w http.ResponseWriter) {
 	w.WriteHeader(http.StatusOK)
 	w.Write(buf)
 }
-
-func deleteSecret(client rancher.BridgeClient, secret *types.Secret) error {
-	old, err := lookupSecret(client, secret)
-	if err != nil {
-		return errors.Annotate(err, "lookupSecret")
-	}
-
-	if old != nil {
-		if err := client.DeleteSecret(old); err != nil {
-			return errors.Annotate(err, "DeleteSecret")
-		}
-		return nil
-	}
-
-	return errors.Errorf("no secret with name %q available", secret.Name)
-}
-
-func ensureSecret(client rancher.BridgeClient, secret *types.Secret) error {
-	old, err := lookupSecret(client, secret)
-	if err != nil {
-		return errors.Annotate(err, "lookupSecret")
-	}
-
-	sec := rancherClient.Secret{
-		Name:  secret.Name,
-		Value: secret.Value,
-	}
-
-	if old != nil {
-		_, err := client.UpdateSecret(old, sec.Value)
-		if err != nil {
-			return errors.Annotate(err, "UpdateSecret")
-		}
-
-		return nil
-	}
-
-	_, err = client.CreateSecret(&sec)
-	if err != nil {
-		return errors.Annotate(err, "CreateSecret")
-	}
-
-	return nil
-}
-
-func lookupSecret(client rancher.BridgeClient, secret *types.Secret) (*rancherClient.Secret, error) {
-	coll, err := client.ListSecrets(nil)
-	if err != nil {
-		return nil, errors.Annotate(err, "ListSecrets")
-	}
-
-	for _, s := range coll.Data {
-		if secret.Name == s.Name {
-			return &s, nil
-		}
-	}
-	return nil, nil
-}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+)
+
+// ParseTimeout returns the invocation timeout set by the TimeoutAnnotation,
+// 0 if the annotation is missing. Timeouts above maxTimeout are rejected,
+// the server closes the responses after it.
+func ParseTimeout(annotations map[string]string, maxTimeout time.Duration) (time.Duration, error) {
+	value, ok := annotations[TimeoutAnnotation]
+	if !ok {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Annotatef(err, "%s", TimeoutAnnotation)
+	}
+
+	if timeout <= 0 {
+		return 0, errors.Errorf("%s must be positive, got %q", TimeoutAnnotation, value)
+	}
+
+	if timeout > maxTimeout {
+		return 0, errors.Errorf("%s must not exceed %s, got %q", TimeoutAnnotation, maxTimeout, value)
+	}
+
+	return timeout, nil
+}
+
+// MakeTimeoutProxy limits the requests passed to next to the timeout
+// annotated on the function, at most maxTimeout
+func MakeTimeoutProxy(cache *metastore.Cache, maxTimeout time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		service := mux.Vars(r)["name"]
+		annotations, err := cache.Annotations(backend.SplitFunctionName(service))
+		if err != nil {
+			next(w, r)
+			return
+		}
+
+		timeout, err := ParseTimeout(annotations, maxTimeout)
+		if err != nil {
+			logger.Warnf("ignoring timeout of %q: %v", service, err)
+		}
+
+		if timeout == 0 {
+			next(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		next(w, r.WithContext(ctx))
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gitmonster/faas-rancher/mocks"
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	timeout, err := ParseTimeout(map[string]string{TimeoutAnnotation: "2m30s"}, time.Hour)
+	assert.NoError(err)
+	assert.Equal(150*time.Second, timeout)
+
+	timeout, err = ParseTimeout(nil, time.Hour)
+	assert.NoError(err)
+	assert.Equal(time.Duration(0), timeout)
+
+	_, err = ParseTimeout(map[string]string{TimeoutAnnotation: "soon"}, time.Hour)
+	assert.Error(err)
+
+	_, err = ParseTimeout(map[string]string{TimeoutAnnotation: "0s"}, time.Hour)
+	assert.Error(err)
+
+	_, err = ParseTimeout(map[string]string{TimeoutAnnotation: "2h"}, time.Hour)
+	assert.Error(err)
+}
+
+func Test_MakeTimeoutProxy_Applies_Annotated_Timeout(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	store := metastore.NewMemoryStore()
+	store.Put(&metastore.FunctionMeta{
+		Service:     "slow-function",
+		Image:       "some/image",
+		Annotations: map[string]interface{}{TimeoutAnnotation: "90s"},
+	})
+	store.Put(&metastore.FunctionMeta{Service: "other-function", Image: "some/image"})
+
+	timeouts := map[string]time.Duration{}
+	proxy := MakeTimeoutProxy(metastore.NewCache(store, "faas-functions", time.Minute), time.Hour, func(w http.ResponseWriter, r *http.Request) {
+		if deadline, ok := r.Context().Deadline(); ok {
+			timeouts[mux.Vars(r)["name"]] = time.Until(deadline).Round(time.Second)
+		}
+	})
+
+	// Act
+	for _, name := range []string{"slow-function.faas-functions", "other-function", "missing"} {
+		req, _ := http.NewRequest("POST", "/", nil)
+		proxy(httptest.NewRecorder(), mux.SetURLVars(req, map[string]string{"name": name}))
+	}
+
+	// Assert
+	assert.Equal(map[string]time.Duration{"slow-function.faas-functions": 90 * time.Second}, timeouts)
+}
+
+func Test_MakeDeployHandler_Rejects_Invalid_Timeout(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	request := types.FunctionDeployment{
+		Service:     "some-service",
+		Image:       "some/docker/image",
+		Annotations: &map[string]string{TimeoutAnnotation: "-5s"},
+	}
+
+	// Act
+	deployed := doRequest(MakeDeployHandler(mockClient, metastore.NewMemoryStore(), time.Hour).ServeHTTP, "POST", request, nil)
+	updated := doRequest(MakeUpdateHandler(mockClient, metastore.NewMemoryStore(), time.Hour).ServeHTTP, "PUT", request, nil)
+
+	// Assert
+	assert.Equal(http.StatusBadRequest, deployed.Code)
+	assert.Equal(http.StatusBadRequest, updated.Code)
+	mockClient.AssertExpectations(t)
+}
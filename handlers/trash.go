@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gitmonster/faas-rancher/trash"
+	"github.com/juju/errors"
+)
+
+// MakeSoftDeleteHandler moves a function to the trash instead of deleting it
+func MakeSoftDeleteHandler(bin *trash.Bin) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		request, err := readDeleteRequest(r)
+		if err != nil {
+			handleBadRequest(w, errors.Annotate(err, "readDeleteRequest"))
+			return
+		}
+
+		if err := bin.Delete(request.FunctionName, namespaceOf(r)); err != nil {
+			if isNotFound(err) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			handleServerError(w, errors.Annotate(err, "Delete [trash]"))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// MakeRestoreHandler restores a soft deleted function
+func MakeRestoreHandler(bin *trash.Bin) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		if err := bin.Restore(vars["name"], namespaceOf(r)); err != nil {
+			if errors.Cause(err) == metastore.ErrEntityNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			handleServerError(w, errors.Annotate(err, "Restore [trash]"))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// MakeTrashLister lists the soft deleted functions
+func MakeTrashLister(bin *trash.Bin) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := bin.List()
+		if err != nil {
+			handleServerError(w, errors.Annotate(err, "List [trash]"))
+			return
+		}
+
+		buf, err := json.Marshal(entries)
+		if err != nil {
+			handleServerError(w, errors.Annotate(err, "Marshal"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf)
+	}
+}
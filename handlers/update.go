@@ -5,18 +5,19 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"time"
 
+	"github.com/gitmonster/faas-rancher/backend"
 	"github.com/gitmonster/faas-rancher/metastore"
-	"github.com/gitmonster/faas-rancher/rancher"
 	"github.com/juju/errors"
 	"github.com/openfaas/faas-provider/types"
 )
 
 // MakeUpdateHandler creates a handler to create new functions in the cluster
-func MakeUpdateHandler(client rancher.BridgeClient) VarsHandler {
+func MakeUpdateHandler(b backend.Backend, store metastore.Store, maxTimeout time.Duration) VarsHandler {
 	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
 
 		defer r.Body.Close()
@@ -37,63 +38,48 @@ func MakeUpdateHandler(client rancher.BridgeClient) VarsHandler {
 			return
 		}
 
-		serviceSpec, findErr := client.FindServiceByName(request.Service)
-		if findErr != nil {
-			handleServerError(w, errors.Annotate(findErr, "FindServiceByName"))
-			return
-		} else if serviceSpec == nil {
-			w.WriteHeader(http.StatusNotFound)
+		if err := validateAnnotations(&request, maxTimeout); err != nil {
+			handleBadRequest(w, errors.Annotate(err, "validateAnnotations"))
 			return
 		}
 
-		if serviceSpec.State != "active" {
-			handleServerError(w, errors.New("service to upgrade is not in active state"))
-			return
+		// updates keep a paused function paused
+		existing := &metastore.FunctionMeta{
+			Service:   request.Service,
+			Namespace: request.Namespace,
 		}
-
-		spec, err := makeUpgradeSpec(client, request)
-		if err != nil {
-			handleServerError(w, errors.Annotate(err, "makeUpgradeSpec"))
+		if err := store.Get(existing); err != nil && errors.Cause(err) != metastore.ErrEntityNotFound {
+			handleServerError(w, errors.Annotate(err, "Get [metastore]"))
 			return
 		}
 
-		_, err = client.UpgradeService(serviceSpec, spec)
-		if err != nil {
-			handleServerError(w, errors.Annotate(err, "UpgradeService"))
+		spec := backend.SpecFromDeployment(&request)
+		spec.Paused = existing.Paused
+
+		if err := b.UpdateFunction(spec); err != nil {
+			if isNotFound(err) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			if isInactive(err) {
+				logger.Warn(errors.Annotate(err, "UpdateFunction"))
+				http.Error(w, fmt.Sprintf("function %q is not active, resume it before updating", request.Service), http.StatusConflict)
+				return
+			}
+
+			handleServerError(w, errors.Annotate(err, "UpdateFunction"))
 			return
 		}
 
 		meta := metastore.FunctionMeta{}
-		if err := metastore.Update(meta.CreateFrom(&request)); err != nil {
-			handleServerError(w, errors.Annotate(err, "Update [metastore]"))
+		meta.CreateFrom(&request)
+		meta.Paused = existing.Paused
+		if err := store.Put(&meta); err != nil {
+			handleServerError(w, errors.Annotate(err, "Put [metastore]"))
 			return
 		}
 
-		go func() {
-			logger.Info("Waiting for upgrade to finish")
-			for pollCounter := 30; pollCounter > 0; pollCounter-- {
-				pollResult, pollErr := client.FindServiceByName(request.Service)
-				logger.Debug(pollResult.State)
-				if pollErr != nil {
-					logger.Error(errors.Annotate(pollErr, "FindServiceByName"))
-					continue
-				}
-				time.Sleep(1 * time.Second)
-
-				if pollResult.State == "upgraded" {
-					logger.Debug("Finishing upgrade")
-					_, err = client.FinishUpgradeService(pollResult)
-					if err != nil {
-						logger.Error(errors.Annotate(err, "FinishUpgradeService"))
-						return
-					}
-					logger.Info("Upgrade finished")
-					return
-				}
-			}
-			logger.Warn("Poll timeout!")
-		}()
-
 		logger.Infof("Service %q updated", request.Service)
 		w.WriteHeader(http.StatusAccepted)
 	}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gitmonster/faas-rancher/mocks"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_MakeUpdateHandler_Keeps_Function_Paused(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	store := metastore.NewMemoryStore()
+	store.Put(&metastore.FunctionMeta{Service: "some-function", Image: "some/image:1", Paused: true})
+
+	mockClient := new(mocks.Backend)
+	mockClient.On("UpdateFunction", mock.MatchedBy(func(spec *backend.FunctionSpec) bool {
+		return spec.Name == "some-function" && spec.Paused
+	})).Return(nil)
+
+	request := types.FunctionDeployment{Service: "some-function", Image: "some/image:2"}
+
+	// Act
+	rr := doRequest(MakeUpdateHandler(mockClient, store, time.Hour).ServeHTTP, "PUT", request, nil)
+
+	// Assert
+	assert.Equal(http.StatusAccepted, rr.Code)
+	meta := &metastore.FunctionMeta{Service: "some-function"}
+	if assert.NoError(store.Get(meta)) {
+		assert.Equal("some/image:2", meta.Image)
+		assert.True(meta.Paused)
+	}
+	mockClient.AssertExpectations(t)
+}
@@ -1,31 +1,114 @@
 package helper
 
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	// rancherLabelPrefix prefixes the labels rancher manages itself
+	rancherLabelPrefix = "io.rancher."
+)
+
+// ToRancherMap converts a faas map into the map type used by rancher and
+// the metastore. A nil map pointer stays nil, so unset and empty maps can
+// be told apart after a round trip.
 func ToRancherMap(fm *map[string]string) map[string]interface{} {
-	m := make(map[string]interface{})
-	if fm != nil {
-		for k, v := range *fm {
-			m[k] = v
-		}
+	if fm == nil {
+		return nil
+	}
+
+	m := make(map[string]interface{}, len(*fm))
+	for k, v := range *fm {
+		m[k] = v
 	}
 
 	return m
 }
 
+// ToFaasMap converts a rancher map into a faas map. A nil map stays nil and
+// an empty map stays empty. Values which are no strings are JSON encoded
+// instead of dropped.
 func ToFaasMap(rm map[string]interface{}) *map[string]string {
-	if len(rm) == 0 {
+	if rm == nil {
 		return nil
 	}
 
-	m := make(map[string]string)
+	m := make(map[string]string, len(rm))
 	for k, v := range rm {
-		if val, ok := v.(string); ok {
-			m[k] = val
+		m[k] = toString(v)
+	}
+
+	return &m
+}
+
+// IsRancherLabel reports whether the label is managed by rancher
+func IsRancherLabel(key string) bool {
+	return strings.HasPrefix(key, rancherLabelPrefix)
+}
+
+// WithoutRancherLabels returns a copy of labels without the labels managed by rancher
+func WithoutRancherLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+
+	m := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if !IsRancherLabel(k) {
+			m[k] = v
+		}
+	}
+
+	return m
+}
+
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case nil:
+		return ""
+	}
+
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(buf)
+}
+
+// ClientIP returns the address of the caller of r. Clients control the
+// X-Forwarded-For header, only the addresses added by trustedProxies
+// proxies in front of the provider are used: the right-most entry not
+// added by them, or the remote address without trusted proxies.
+func ClientIP(r *http.Request, trustedProxies int) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if trustedProxies <= 0 {
+		return host
+	}
+
+	hops := []string{}
+	for _, values := range r.Header["X-Forwarded-For"] {
+		for _, hop := range strings.Split(values, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
 		}
 	}
+	hops = append(hops, host)
 
-	if len(m) > 0 {
-		return &m
+	// the last trusted proxy is the remote address
+	index := len(hops) - 1 - trustedProxies
+	if index < 0 {
+		index = 0
 	}
 
-	return nil
+	return hops[index]
 }
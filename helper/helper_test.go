@@ -0,0 +1,109 @@
+package helper
+
+import (
+	"net/http"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ToFaasMap_RoundTrip(t *testing.T) {
+	roundTrip := func(m map[string]string) bool {
+		converted := ToFaasMap(ToRancherMap(&m))
+		if m == nil {
+			return converted != nil && len(*converted) == 0
+		}
+
+		return assert.ObjectsAreEqual(m, *converted)
+	}
+
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_ToRancherMap_Keeps_Nil(t *testing.T) {
+	assert := assert.New(t)
+	// Act
+	m := ToRancherMap(nil)
+
+	// Assert
+	assert.Nil(m)
+	assert.Nil(ToFaasMap(m))
+}
+
+func Test_ToFaasMap_Keeps_Empty(t *testing.T) {
+	assert := assert.New(t)
+	// Act
+	m := ToFaasMap(map[string]interface{}{})
+
+	// Assert
+	assert.Equal(&map[string]string{}, m)
+}
+
+func Test_ToFaasMap_Encodes_Non_String_Values(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	rm := map[string]interface{}{
+		"string": "value",
+		"number": 42,
+		"bool":   true,
+		"list":   []interface{}{"a", 1},
+		"null":   nil,
+	}
+
+	// Act
+	m := ToFaasMap(rm)
+
+	// Assert
+	assert.Equal(&map[string]string{
+		"string": "value",
+		"number": "42",
+		"bool":   "true",
+		"list":   `["a",1]`,
+		"null":   "",
+	}, m)
+}
+
+func Test_WithoutRancherLabels_Filters_Internal_Labels(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	labels := map[string]string{
+		"faas_function":                   "some-function",
+		"io.rancher.container.pull_image": "always",
+		"com.example.team":                "faas",
+	}
+
+	// Act
+	filtered := WithoutRancherLabels(labels)
+
+	// Assert
+	assert.Equal(map[string]string{
+		"faas_function":    "some-function",
+		"com.example.team": "faas",
+	}, filtered)
+	assert.Len(labels, 3)
+}
+
+func Test_ClientIP(t *testing.T) {
+	assert := assert.New(t)
+
+	request := func(forwarded ...string) *http.Request {
+		req, _ := http.NewRequest("POST", "/function/some-function", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		for _, value := range forwarded {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		return req
+	}
+
+	assert.Equal("10.0.0.1", ClientIP(request("192.168.0.1"), 0))
+
+	// the gateway at 10.0.0.1 appends the address of its caller
+	assert.Equal("192.168.0.2", ClientIP(request("forged, 192.168.0.2"), 1))
+	assert.Equal("192.168.0.2", ClientIP(request("forged", "192.168.0.2"), 1))
+	assert.Equal("10.0.0.1", ClientIP(request(), 1))
+
+	assert.Equal("192.168.0.2", ClientIP(request("forged, 192.168.0.2, 10.0.0.2"), 2))
+}
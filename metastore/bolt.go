@@ -0,0 +1,410 @@
+package metastore
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	logger              = logrus.WithField("package", "metastore")
+	bucketNameFunctions = []byte("functions")
+	bucketNameTrash     = []byte("trash")
+
+	// time to wait for the lock of a database another process has open
+	openTimeout = 5 * time.Second
+
+	ErrInUse = errors.New("metastore in use, stop the provider using it first")
+)
+
+// BoltStore implements Store in a bolt database file
+type BoltStore struct {
+	database *bolt.DB
+	watchers watchers
+}
+
+// NewBoltStore opens the database stored in path and migrates it to the
+// current schema version. Missing parent directories are created. It
+// fails with ErrInUse if another process keeps the database open.
+func NewBoltStore(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Annotate(err, "MkdirAll")
+	}
+
+	db, err := openBolt(path, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketNameFunctions, bucketNameTrash} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return errors.Annotate(err, "CreateBucketIfNotExists")
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, errors.Annotate(err, "Update")
+	}
+
+	if _, err := runMigrations(db); err != nil {
+		db.Close()
+		return nil, errors.Annotate(err, "runMigrations")
+	}
+
+	s := BoltStore{
+		database: db,
+	}
+
+	return &s, nil
+}
+
+// openBolt opens the database in path, waiting up to options.Timeout for
+// its lock
+func openBolt(path string, options *bolt.Options) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, options)
+	if err == bolt.ErrTimeout {
+		return nil, errors.Annotatef(ErrInUse, "%s", path)
+	}
+
+	if err != nil {
+		return nil, errors.Annotate(err, "Open")
+	}
+
+	return db, nil
+}
+
+// Close closes the database
+func (s *BoltStore) Close() (err error) {
+	if s.database != nil {
+		err = s.database.Close()
+		s.database = nil
+	}
+	return err
+}
+
+// Put updates metadata related to a service
+func (s *BoltStore) Put(meta *FunctionMeta) error {
+	if s.database == nil {
+		return ErrDatabaseNotInitialized
+	}
+
+	if !meta.Valid() {
+		return ErrInvalidService
+	}
+
+	err := s.database.Update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(meta)
+		if err != nil {
+			return errors.Annotate(err, "Marshal")
+		}
+
+		b := tx.Bucket(bucketNameFunctions)
+		return b.Put(meta.Key(), buf)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.watchers.notify(EventPut, meta)
+	return nil
+}
+
+// Get reads metadata related to a service
+func (s *BoltStore) Get(meta *FunctionMeta) error {
+	if s.database == nil {
+		return ErrDatabaseNotInitialized
+	}
+
+	if meta.Service == "" {
+		return ErrInvalidService
+	}
+
+	return s.database.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketNameFunctions)
+		buf := b.Get(meta.Key())
+		if buf == nil {
+			return ErrEntityNotFound
+		}
+
+		if err := json.Unmarshal(buf, meta); err != nil {
+			return errors.Annotate(err, "Unmarshal")
+		}
+
+		return nil
+	})
+}
+
+// Delete deletes metadata related to a service
+func (s *BoltStore) Delete(meta *FunctionMeta) error {
+	if s.database == nil {
+		return ErrDatabaseNotInitialized
+	}
+
+	if meta.Service == "" {
+		return ErrInvalidService
+	}
+
+	err := s.database.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketNameFunctions)
+		return b.Delete(meta.Key())
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.watchers.notify(EventDelete, meta)
+	return nil
+}
+
+// List lists the metadata of all services
+func (s *BoltStore) List() ([]FunctionMeta, error) {
+	if s.database == nil {
+		return nil, ErrDatabaseNotInitialized
+	}
+
+	metas := []FunctionMeta{}
+	err := s.database.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketNameFunctions)
+		return b.ForEach(func(k, v []byte) error {
+			meta := FunctionMeta{}
+			if err := json.Unmarshal(v, &meta); err != nil {
+				return errors.Annotatef(err, "Unmarshal [%s]", k)
+			}
+
+			metas = append(metas, meta)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, errors.Annotate(err, "View")
+	}
+
+	return metas, nil
+}
+
+// Restore puts all entries in a single transaction
+func (s *BoltStore) Restore(metas []FunctionMeta, replace bool) (int, error) {
+	if s.database == nil {
+		return 0, ErrDatabaseNotInitialized
+	}
+
+	keep := make(map[string]bool)
+	for _, meta := range metas {
+		keep[string(meta.Key())] = true
+	}
+
+	var deleted []FunctionMeta
+	err := s.database.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketNameFunctions)
+		if replace {
+			err := b.ForEach(func(k, v []byte) error {
+				if keep[string(k)] {
+					return nil
+				}
+
+				meta := FunctionMeta{}
+				if err := json.Unmarshal(v, &meta); err != nil {
+					return errors.Annotatef(err, "Unmarshal [%s]", k)
+				}
+
+				deleted = append(deleted, meta)
+				return nil
+			})
+
+			if err != nil {
+				return errors.Annotate(err, "ForEach")
+			}
+
+			for _, meta := range deleted {
+				if err := b.Delete(meta.Key()); err != nil {
+					return errors.Annotate(err, "Delete")
+				}
+			}
+		}
+
+		for i := range metas {
+			buf, err := json.Marshal(&metas[i])
+			if err != nil {
+				return errors.Annotate(err, "Marshal")
+			}
+
+			if err := b.Put(metas[i].Key(), buf); err != nil {
+				return errors.Annotate(err, "Put")
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range deleted {
+		s.watchers.notify(EventDelete, &deleted[i])
+	}
+
+	for i := range metas {
+		s.watchers.notify(EventPut, &metas[i])
+	}
+
+	return len(deleted), nil
+}
+
+// Watch streams changes until stop is closed
+func (s *BoltStore) Watch(stop <-chan struct{}) <-chan Event {
+	return s.watchers.watch(stop)
+}
+
+// Trash moves the entry into the trash bucket
+func (s *BoltStore) Trash(entry *TrashEntry) error {
+	if s.database == nil {
+		return ErrDatabaseNotInitialized
+	}
+
+	if !entry.Meta.Valid() {
+		return ErrInvalidService
+	}
+
+	err := s.database.Update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			return errors.Annotate(err, "Marshal")
+		}
+
+		if err := tx.Bucket(bucketNameFunctions).Delete(entry.Meta.Key()); err != nil {
+			return errors.Annotate(err, "Delete")
+		}
+
+		return tx.Bucket(bucketNameTrash).Put(entry.Meta.Key(), buf)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.watchers.notify(EventDelete, &entry.Meta)
+	return nil
+}
+
+// GetTrash reads the trashed entry of a service
+func (s *BoltStore) GetTrash(meta *FunctionMeta) (*TrashEntry, error) {
+	if s.database == nil {
+		return nil, ErrDatabaseNotInitialized
+	}
+
+	if meta.Service == "" {
+		return nil, ErrInvalidService
+	}
+
+	entry := TrashEntry{}
+	err := s.database.View(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(bucketNameTrash).Get(meta.Key())
+		if buf == nil {
+			return ErrEntityNotFound
+		}
+
+		return errors.Annotate(json.Unmarshal(buf, &entry), "Unmarshal")
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// Untrash moves the trashed entry back into the functions bucket
+func (s *BoltStore) Untrash(meta *FunctionMeta) (*TrashEntry, error) {
+	if s.database == nil {
+		return nil, ErrDatabaseNotInitialized
+	}
+
+	if meta.Service == "" {
+		return nil, ErrInvalidService
+	}
+
+	entry := TrashEntry{}
+	err := s.database.Update(func(tx *bolt.Tx) error {
+		trash := tx.Bucket(bucketNameTrash)
+		buf := trash.Get(meta.Key())
+		if buf == nil {
+			return ErrEntityNotFound
+		}
+
+		if err := json.Unmarshal(buf, &entry); err != nil {
+			return errors.Annotate(err, "Unmarshal")
+		}
+
+		functions, err := json.Marshal(&entry.Meta)
+		if err != nil {
+			return errors.Annotate(err, "Marshal")
+		}
+
+		if err := tx.Bucket(bucketNameFunctions).Put(meta.Key(), functions); err != nil {
+			return errors.Annotate(err, "Put")
+		}
+
+		return trash.Delete(meta.Key())
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.watchers.notify(EventPut, &entry.Meta)
+	return &entry, nil
+}
+
+// ListTrash lists all trashed entries
+func (s *BoltStore) ListTrash() ([]TrashEntry, error) {
+	if s.database == nil {
+		return nil, ErrDatabaseNotInitialized
+	}
+
+	entries := []TrashEntry{}
+	err := s.database.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketNameTrash).ForEach(func(k, v []byte) error {
+			entry := TrashEntry{}
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return errors.Annotatef(err, "Unmarshal [%s]", k)
+			}
+
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, errors.Annotate(err, "View")
+	}
+
+	return entries, nil
+}
+
+// Purge deletes the trashed entry of a service
+func (s *BoltStore) Purge(meta *FunctionMeta) error {
+	if s.database == nil {
+		return ErrDatabaseNotInitialized
+	}
+
+	if meta.Service == "" {
+		return ErrInvalidService
+	}
+
+	return s.database.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketNameTrash).Delete(meta.Key())
+	})
+}
@@ -0,0 +1,146 @@
+package metastore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/helper"
+)
+
+const (
+	// functions cached at most, including the ones not stored
+	maxCached = 10000
+)
+
+type cached struct {
+	annotations map[string]string
+	paused      bool
+	err         error
+	expires     time.Time
+}
+
+// Cache reads the annotations and the paused state of functions for the
+// invocation path, where every layer of the proxy needs them. They are
+// kept for a short time, writes through the Cache drop them right away.
+type Cache struct {
+	Store
+
+	defaultNamespace string
+	ttl              time.Duration
+	now              func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*cached
+}
+
+// NewCache creates a cache reading from store, keeping entries for ttl.
+// Functions of defaultNamespace are also addressed without namespace.
+func NewCache(store Store, defaultNamespace string, ttl time.Duration) *Cache {
+	c := Cache{
+		Store:            store,
+		defaultNamespace: defaultNamespace,
+		ttl:              ttl,
+		now:              time.Now,
+		entries:          make(map[string]*cached),
+	}
+
+	return &c
+}
+
+// Annotations returns the annotations of the function or ErrEntityNotFound
+// if it is not stored. The map is shared by all callers and must not be
+// modified.
+func (c *Cache) Annotations(name, namespace string) (map[string]string, error) {
+	entry := c.lookup(name, namespace)
+	return entry.annotations, entry.err
+}
+
+// Paused reports whether the function is stored as paused
+func (c *Cache) Paused(name, namespace string) bool {
+	return c.lookup(name, namespace).paused
+}
+
+func (c *Cache) lookup(name, namespace string) *cached {
+	if namespace == c.defaultNamespace {
+		namespace = ""
+	}
+
+	meta := &FunctionMeta{
+		Service:   name,
+		Namespace: namespace,
+	}
+	key := string(meta.Key())
+	now := c.now()
+
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok && now.Before(entry.expires) {
+		c.mu.Unlock()
+		return entry
+	}
+	c.mu.Unlock()
+
+	entry := &cached{
+		annotations: map[string]string{},
+		expires:     now.Add(c.ttl),
+	}
+
+	if err := c.Store.Get(meta); err != nil {
+		entry.err = err
+	} else {
+		if converted := helper.ToFaasMap(meta.Annotations); converted != nil {
+			entry.annotations = *converted
+		}
+		entry.paused = meta.Paused
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// unknown names must not grow the cache without bounds
+	if len(c.entries) >= maxCached {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+
+	if len(c.entries) < maxCached {
+		c.entries[key] = entry
+	}
+
+	return entry
+}
+
+// invalidate drops all entries after a write
+func (c *Cache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*cached)
+}
+
+func (c *Cache) Put(meta *FunctionMeta) error {
+	defer c.invalidate()
+	return c.Store.Put(meta)
+}
+
+func (c *Cache) Delete(meta *FunctionMeta) error {
+	defer c.invalidate()
+	return c.Store.Delete(meta)
+}
+
+func (c *Cache) Restore(metas []FunctionMeta, replace bool) (int, error) {
+	defer c.invalidate()
+	return c.Store.Restore(metas, replace)
+}
+
+func (c *Cache) Trash(entry *TrashEntry) error {
+	defer c.invalidate()
+	return c.Store.Trash(entry)
+}
+
+func (c *Cache) Untrash(meta *FunctionMeta) (*TrashEntry, error) {
+	defer c.invalidate()
+	return c.Store.Untrash(meta)
+}
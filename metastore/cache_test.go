@@ -0,0 +1,79 @@
+package metastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStore counts the entries read
+type countingStore struct {
+	Store
+	gets int
+}
+
+func (s *countingStore) Get(meta *FunctionMeta) error {
+	s.gets++
+	return s.Store.Get(meta)
+}
+
+func Test_Cache_Reads_Once_Within_TTL(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	store := &countingStore{Store: NewMemoryStore()}
+	store.Put(&FunctionMeta{
+		Service:     "some-function",
+		Image:       "some/image",
+		Annotations: map[string]interface{}{"some": "annotation"},
+		Paused:      true,
+	})
+	cache := NewCache(store, "faas-functions", time.Minute)
+
+	// Act
+	annotations, err := cache.Annotations("some-function", "faas-functions")
+	paused := cache.Paused("some-function", "")
+	_, missing := cache.Annotations("other-function", "")
+	_, missingAgain := cache.Annotations("other-function", "")
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal(map[string]string{"some": "annotation"}, annotations)
+	assert.True(paused)
+	assert.Equal(ErrEntityNotFound, missing)
+	assert.Equal(ErrEntityNotFound, missingAgain)
+	assert.Equal(2, store.gets)
+}
+
+func Test_Cache_Drops_Entries_On_Write(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	cache := NewCache(NewMemoryStore(), "faas-functions", time.Minute)
+	meta := &FunctionMeta{Service: "some-function", Image: "some/image"}
+	cache.Put(meta)
+	before := cache.Paused("some-function", "")
+
+	// Act
+	meta.Paused = true
+	cache.Put(meta)
+
+	// Assert
+	assert.False(before)
+	assert.True(cache.Paused("some-function", ""))
+}
+
+func Test_Cache_Expires_Entries(t *testing.T) {
+	// Arrange
+	store := &countingStore{Store: NewMemoryStore()}
+	cache := NewCache(store, "", time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	cache.Annotations("some-function", "")
+
+	// Act
+	now = now.Add(time.Minute)
+	cache.Annotations("some-function", "")
+
+	// Assert
+	assert.Equal(t, 2, store.gets)
+}
@@ -1,6 +1,7 @@
 package metastore
 
 import (
+	"github.com/gitmonster/faas-rancher/backend"
 	"github.com/gitmonster/faas-rancher/helper"
 	"github.com/openfaas/faas-provider/types"
 )
@@ -8,6 +9,7 @@ import (
 // FunctionMeta hold function metadata for metastore
 type FunctionMeta struct {
 	Service     string                 `json:"service"`
+	Namespace   string                 `json:"namespace,omitempty"`
 	Image       string                 `json:"image"`
 	EnvProcess  string                 `json:"envProcess"`
 	EnvVars     map[string]interface{} `json:"envVars"`
@@ -15,10 +17,13 @@ type FunctionMeta struct {
 	Secrets     []string               `json:"secrets"`
 	Labels      map[string]interface{} `json:"labels"`
 	Annotations map[string]interface{} `json:"annotations"`
+	// Paused is set while the function is deactivated on purpose
+	Paused bool `json:"paused,omitempty"`
 }
 
 func (p *FunctionMeta) CreateFrom(req *types.FunctionDeployment) *FunctionMeta {
 	p.Service = req.Service
+	p.Namespace = req.Namespace
 	p.Image = req.Image
 	p.EnvProcess = req.EnvProcess
 	p.Constraints = req.Constraints
@@ -30,7 +35,79 @@ func (p *FunctionMeta) CreateFrom(req *types.FunctionDeployment) *FunctionMeta {
 	return p
 }
 
+// RestoreFrom fills meta with what the backend knows about a function
+// deployed without metadata. EnvVars, labels and secrets are the ones the
+// backend reports, so soft deleted functions keep them. Annotations are
+// only kept in the metastore and lost.
+func (p *FunctionMeta) RestoreFrom(function *backend.Function) *FunctionMeta {
+	p.Service = function.Name
+	p.Image = function.Image
+	p.EnvProcess = function.EnvProcess
+	p.EnvVars = helper.ToRancherMap(&function.EnvVars)
+	p.Secrets = function.Secrets
+	p.Labels = helper.ToRancherMap(&function.Labels)
+	p.Annotations = make(map[string]interface{})
+	p.Normalize()
+
+	return p
+}
+
 func (p *FunctionMeta) Valid() bool {
 	return p.Service != "" &&
 		p.Image != ""
 }
+
+// Key returns the store key of the function. Functions without
+// namespace are keyed by their name for compatibility.
+func (p *FunctionMeta) Key() []byte {
+	if p.Namespace == "" {
+		return []byte(p.Service)
+	}
+
+	return []byte(p.Namespace + "/" + p.Service)
+}
+
+// Normalize removes labels managed by rancher and initializes missing
+// label and annotation maps. EnvVars are left nil as they are unknown for
+// entries restored from the orchestrator. It reports whether meta changed.
+func (p *FunctionMeta) Normalize() bool {
+	changed := false
+	for k := range p.Labels {
+		if helper.IsRancherLabel(k) {
+			delete(p.Labels, k)
+			changed = true
+		}
+	}
+
+	if p.Labels == nil {
+		p.Labels = make(map[string]interface{})
+		changed = true
+	}
+
+	if p.Annotations == nil {
+		p.Annotations = make(map[string]interface{})
+		changed = true
+	}
+
+	return changed
+}
+
+// ToDeployment converts the metadata back into the deployment request it was created from
+func (p *FunctionMeta) ToDeployment() *types.FunctionDeployment {
+	req := types.FunctionDeployment{
+		Service:     p.Service,
+		Namespace:   p.Namespace,
+		Image:       p.Image,
+		EnvProcess:  p.EnvProcess,
+		Constraints: p.Constraints,
+		Secrets:     p.Secrets,
+		Labels:      helper.ToFaasMap(p.Labels),
+		Annotations: helper.ToFaasMap(p.Annotations),
+	}
+
+	if env := helper.ToFaasMap(p.EnvVars); env != nil {
+		req.EnvVars = *env
+	}
+
+	return &req
+}
@@ -0,0 +1,94 @@
+package metastore
+
+import (
+	"encoding/json"
+	"testing"
+	"testing/quick"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FunctionMeta_RoundTrip(t *testing.T) {
+	roundTrip := func(service, image, envProcess string, envVars, labels, annotations map[string]string,
+		secrets, constraints []string, withLabels, withAnnotations bool) bool {
+		req := types.FunctionDeployment{
+			Service:     service,
+			Namespace:   "dev",
+			Image:       image,
+			EnvProcess:  envProcess,
+			EnvVars:     envVars,
+			Secrets:     secrets,
+			Constraints: constraints,
+		}
+		if withLabels {
+			req.Labels = &labels
+		}
+		if withAnnotations {
+			req.Annotations = &annotations
+		}
+
+		buf, err := json.Marshal(new(FunctionMeta).CreateFrom(&req))
+		if err != nil {
+			return false
+		}
+
+		meta := FunctionMeta{}
+		if err := json.Unmarshal(buf, &meta); err != nil {
+			return false
+		}
+
+		return assert.ObjectsAreEqual(&req, meta.ToDeployment())
+	}
+
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_FunctionMeta_Normalize_Removes_Rancher_Labels(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	meta := FunctionMeta{
+		Service: "some-function",
+		Labels: map[string]interface{}{
+			"faas_function":                   "some-function",
+			"io.rancher.container.pull_image": "always",
+		},
+	}
+
+	// Act
+	changed := meta.Normalize()
+
+	// Assert
+	assert.True(changed)
+	assert.Equal(map[string]interface{}{"faas_function": "some-function"}, meta.Labels)
+	assert.Equal(map[string]interface{}{}, meta.Annotations)
+	assert.Nil(meta.EnvVars)
+	assert.False(meta.Normalize())
+}
+
+func Test_FunctionMeta_RestoreFrom_Keeps_Backend_Spec(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	function := backend.Function{
+		Name:       "some-function",
+		Image:      "some/image",
+		EnvProcess: "cat",
+		EnvVars:    map[string]string{"KEY": "value"},
+		Labels:     map[string]string{"team": "ci"},
+		Secrets:    []string{"api-key"},
+	}
+
+	// Act
+	meta := new(FunctionMeta).RestoreFrom(&function)
+
+	// Assert
+	assert.Equal("some-function", meta.Service)
+	assert.Equal("cat", meta.EnvProcess)
+	assert.Equal(map[string]interface{}{"KEY": "value"}, meta.EnvVars)
+	assert.Equal(map[string]interface{}{"team": "ci"}, meta.Labels)
+	assert.Equal([]string{"api-key"}, meta.Secrets)
+	assert.Empty(meta.Annotations)
+}
@@ -0,0 +1,273 @@
+package metastore
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// MemoryStore implements Store in memory, e.g. for tests
+type MemoryStore struct {
+	mu       sync.RWMutex
+	entries  map[string][]byte
+	trash    map[string][]byte
+	watchers watchers
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	s := MemoryStore{
+		entries: make(map[string][]byte),
+		trash:   make(map[string][]byte),
+	}
+
+	return &s
+}
+
+// Close is a no-op
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// Put updates metadata related to a service
+func (s *MemoryStore) Put(meta *FunctionMeta) error {
+	if !meta.Valid() {
+		return ErrInvalidService
+	}
+
+	// store a copy to decouple from the caller like the bolt store does
+	buf, err := json.Marshal(meta)
+	if err != nil {
+		return errors.Annotate(err, "Marshal")
+	}
+
+	s.mu.Lock()
+	s.entries[string(meta.Key())] = buf
+	s.mu.Unlock()
+
+	s.watchers.notify(EventPut, meta)
+	return nil
+}
+
+// Get reads metadata related to a service
+func (s *MemoryStore) Get(meta *FunctionMeta) error {
+	if meta.Service == "" {
+		return ErrInvalidService
+	}
+
+	s.mu.RLock()
+	buf, ok := s.entries[string(meta.Key())]
+	s.mu.RUnlock()
+
+	if !ok {
+		return ErrEntityNotFound
+	}
+
+	if err := json.Unmarshal(buf, meta); err != nil {
+		return errors.Annotate(err, "Unmarshal")
+	}
+
+	return nil
+}
+
+// Delete deletes metadata related to a service
+func (s *MemoryStore) Delete(meta *FunctionMeta) error {
+	if meta.Service == "" {
+		return ErrInvalidService
+	}
+
+	s.mu.Lock()
+	delete(s.entries, string(meta.Key()))
+	s.mu.Unlock()
+
+	s.watchers.notify(EventDelete, meta)
+	return nil
+}
+
+// List lists the metadata of all services
+func (s *MemoryStore) List() ([]FunctionMeta, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	metas := []FunctionMeta{}
+	for _, key := range keys {
+		meta := FunctionMeta{}
+		if err := json.Unmarshal(s.entries[key], &meta); err != nil {
+			return nil, errors.Annotatef(err, "Unmarshal [%s]", key)
+		}
+
+		metas = append(metas, meta)
+	}
+
+	return metas, nil
+}
+
+// Restore puts all entries at once
+func (s *MemoryStore) Restore(metas []FunctionMeta, replace bool) (int, error) {
+	entries := make(map[string][]byte)
+	for i := range metas {
+		buf, err := json.Marshal(&metas[i])
+		if err != nil {
+			return 0, errors.Annotate(err, "Marshal")
+		}
+
+		entries[string(metas[i].Key())] = buf
+	}
+
+	var deleted []FunctionMeta
+	s.mu.Lock()
+	if replace {
+		for key, buf := range s.entries {
+			if _, ok := entries[key]; ok {
+				continue
+			}
+
+			meta := FunctionMeta{}
+			json.Unmarshal(buf, &meta)
+			deleted = append(deleted, meta)
+			delete(s.entries, key)
+		}
+	}
+
+	for key, buf := range entries {
+		s.entries[key] = buf
+	}
+	s.mu.Unlock()
+
+	for i := range deleted {
+		s.watchers.notify(EventDelete, &deleted[i])
+	}
+
+	for i := range metas {
+		s.watchers.notify(EventPut, &metas[i])
+	}
+
+	return len(deleted), nil
+}
+
+// Watch streams changes until stop is closed
+func (s *MemoryStore) Watch(stop <-chan struct{}) <-chan Event {
+	return s.watchers.watch(stop)
+}
+
+// Trash moves the entry into the trash
+func (s *MemoryStore) Trash(entry *TrashEntry) error {
+	if !entry.Meta.Valid() {
+		return ErrInvalidService
+	}
+
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Annotate(err, "Marshal")
+	}
+
+	key := string(entry.Meta.Key())
+	s.mu.Lock()
+	delete(s.entries, key)
+	s.trash[key] = buf
+	s.mu.Unlock()
+
+	s.watchers.notify(EventDelete, &entry.Meta)
+	return nil
+}
+
+// GetTrash reads the trashed entry of a service
+func (s *MemoryStore) GetTrash(meta *FunctionMeta) (*TrashEntry, error) {
+	if meta.Service == "" {
+		return nil, ErrInvalidService
+	}
+
+	s.mu.RLock()
+	buf, ok := s.trash[string(meta.Key())]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrEntityNotFound
+	}
+
+	entry := TrashEntry{}
+	if err := json.Unmarshal(buf, &entry); err != nil {
+		return nil, errors.Annotate(err, "Unmarshal")
+	}
+
+	return &entry, nil
+}
+
+// Untrash moves the trashed entry back
+func (s *MemoryStore) Untrash(meta *FunctionMeta) (*TrashEntry, error) {
+	if meta.Service == "" {
+		return nil, ErrInvalidService
+	}
+
+	key := string(meta.Key())
+	s.mu.Lock()
+	buf, ok := s.trash[key]
+	if !ok {
+		s.mu.Unlock()
+		return nil, ErrEntityNotFound
+	}
+
+	entry := TrashEntry{}
+	if err := json.Unmarshal(buf, &entry); err != nil {
+		s.mu.Unlock()
+		return nil, errors.Annotate(err, "Unmarshal")
+	}
+
+	functions, err := json.Marshal(&entry.Meta)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, errors.Annotate(err, "Marshal")
+	}
+
+	s.entries[key] = functions
+	delete(s.trash, key)
+	s.mu.Unlock()
+
+	s.watchers.notify(EventPut, &entry.Meta)
+	return &entry, nil
+}
+
+// ListTrash lists all trashed entries
+func (s *MemoryStore) ListTrash() ([]TrashEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.trash))
+	for key := range s.trash {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := []TrashEntry{}
+	for _, key := range keys {
+		entry := TrashEntry{}
+		if err := json.Unmarshal(s.trash[key], &entry); err != nil {
+			return nil, errors.Annotatef(err, "Unmarshal [%s]", key)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Purge deletes the trashed entry of a service
+func (s *MemoryStore) Purge(meta *FunctionMeta) error {
+	if meta.Service == "" {
+		return ErrInvalidService
+	}
+
+	s.mu.Lock()
+	delete(s.trash, string(meta.Key()))
+	s.mu.Unlock()
+
+	return nil
+}
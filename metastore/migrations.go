@@ -0,0 +1,198 @@
+package metastore
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/juju/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketNameSchema = []byte("schema")
+	keySchemaVersion = []byte("version")
+)
+
+// Migration converts the entries stored by an older schema version
+type Migration struct {
+	// Version the schema has after the migration ran
+	Version     int
+	Description string
+	// Migrate changes meta in place and reports whether it changed
+	Migrate func(meta *FunctionMeta) (bool, error)
+}
+
+// Change is an entry changed by a migration
+type Change struct {
+	Version     int
+	Description string
+	Key         string
+}
+
+// migrations ordered by version. Never change a released migration,
+// append a new one instead.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "normalize metadata restored from the orchestrator",
+		Migrate: func(meta *FunctionMeta) (bool, error) {
+			return meta.Normalize(), nil
+		},
+	},
+}
+
+// SchemaVersion is the version of the entries written by this package
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// PlanMigrations reports the changes the pending migrations of the database
+// stored in path would apply without changing it
+func PlanMigrations(path string) (int, []Change, error) {
+	db, err := openBolt(path, &bolt.Options{ReadOnly: true, Timeout: openTimeout})
+	if err != nil {
+		return 0, nil, err
+	}
+	defer db.Close()
+
+	var version int
+	var changes []Change
+	err = db.View(func(tx *bolt.Tx) error {
+		version, err = readSchemaVersion(tx)
+		if err != nil {
+			return errors.Annotate(err, "readSchemaVersion")
+		}
+
+		changes, err = migrate(tx, version, false)
+		return err
+	})
+
+	return version, changes, err
+}
+
+// runMigrations applies all pending migrations in a single transaction
+func runMigrations(db *bolt.DB) ([]Change, error) {
+	var changes []Change
+	err := db.Update(func(tx *bolt.Tx) error {
+		version, err := readSchemaVersion(tx)
+		if err != nil {
+			return errors.Annotate(err, "readSchemaVersion")
+		}
+
+		changes, err = migrate(tx, version, true)
+		if err != nil {
+			return errors.Annotate(err, "migrate")
+		}
+
+		if version == SchemaVersion() {
+			return nil
+		}
+
+		b, err := tx.CreateBucketIfNotExists(bucketNameSchema)
+		if err != nil {
+			return errors.Annotate(err, "CreateBucketIfNotExists")
+		}
+
+		logger.Infof("migrated metastore from schema version %d to %d", version, SchemaVersion())
+		return b.Put(keySchemaVersion, []byte(strconv.Itoa(SchemaVersion())))
+	})
+
+	return changes, err
+}
+
+// readSchemaVersion returns 0 for databases written before versioning
+func readSchemaVersion(tx *bolt.Tx) (int, error) {
+	b := tx.Bucket(bucketNameSchema)
+	if b == nil {
+		return 0, nil
+	}
+
+	buf := b.Get(keySchemaVersion)
+	if buf == nil {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(string(buf))
+	if err != nil {
+		return 0, errors.Annotate(err, "Atoi")
+	}
+
+	if version > SchemaVersion() {
+		return 0, errors.Errorf("schema version %d is newer than the supported version %d", version, SchemaVersion())
+	}
+
+	return version, nil
+}
+
+// migrate applies the migrations newer than version to all entries in
+// memory and writes the changed entries back if write is set
+func migrate(tx *bolt.Tx, version int, write bool) ([]Change, error) {
+	b := tx.Bucket(bucketNameFunctions)
+	if b == nil {
+		return nil, nil
+	}
+
+	var keys []string
+	entries := make(map[string]*FunctionMeta)
+	err := b.ForEach(func(k, v []byte) error {
+		meta := FunctionMeta{}
+		if err := json.Unmarshal(v, &meta); err != nil {
+			return errors.Annotatef(err, "Unmarshal [%s]", k)
+		}
+
+		keys = append(keys, string(k))
+		entries[string(k)] = &meta
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.Annotate(err, "ForEach")
+	}
+
+	changes := []Change{}
+	changed := make(map[string]bool)
+	for _, migration := range migrations {
+		if migration.Version <= version {
+			continue
+		}
+
+		for _, key := range keys {
+			ok, err := migration.Migrate(entries[key])
+			if err != nil {
+				return nil, errors.Annotatef(err, "migration %d [%s]", migration.Version, key)
+			}
+
+			if !ok {
+				continue
+			}
+
+			changed[key] = true
+			changes = append(changes, Change{
+				Version:     migration.Version,
+				Description: migration.Description,
+				Key:         key,
+			})
+		}
+	}
+
+	if !write {
+		return changes, nil
+	}
+
+	for _, key := range keys {
+		if !changed[key] {
+			continue
+		}
+
+		buf, err := json.Marshal(entries[key])
+		if err != nil {
+			return nil, errors.Annotate(err, "Marshal")
+		}
+
+		if err := b.Put([]byte(key), buf); err != nil {
+			return nil, errors.Annotate(err, "Put")
+		}
+	}
+
+	return changes, nil
+}
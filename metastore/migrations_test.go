@@ -0,0 +1,118 @@
+package metastore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+// writeLegacyStore writes entries the way the unversioned metastore did
+func writeLegacyStore(t *testing.T, path string, metas ...FunctionMeta) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucketNameFunctions)
+		if err != nil {
+			return err
+		}
+
+		for _, meta := range metas {
+			buf, _ := json.Marshal(meta)
+			if err := b.Put([]byte(meta.Service), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Migrations_Normalize_Restored_Entries(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir, err := ioutil.TempDir("", "metastore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "store.db")
+	writeLegacyStore(t, path,
+		FunctionMeta{
+			Service: "restored",
+			Image:   "some/image",
+			Labels: map[string]interface{}{
+				"faas_function":                   "restored",
+				"io.rancher.container.pull_image": "always",
+			},
+			Annotations: map[string]interface{}{},
+		},
+		FunctionMeta{
+			Service:     "deployed",
+			Image:       "some/image",
+			EnvVars:     map[string]interface{}{},
+			Labels:      map[string]interface{}{},
+			Annotations: map[string]interface{}{},
+		},
+	)
+
+	// Act
+	version, changes, planErr := PlanMigrations(path)
+	store, openErr := NewBoltStore(path)
+
+	// Assert
+	assert.NoError(planErr)
+	assert.Equal(0, version)
+	assert.Equal([]Change{{Version: 1, Description: migrations[0].Description, Key: "restored"}}, changes)
+
+	assert.NoError(openErr)
+	meta := &FunctionMeta{Service: "restored"}
+	assert.NoError(store.Get(meta))
+	assert.Equal(map[string]interface{}{"faas_function": "restored"}, meta.Labels)
+	assert.Nil(meta.EnvVars)
+	store.Close()
+
+	version, changes, planErr = PlanMigrations(path)
+	assert.NoError(planErr)
+	assert.Equal(SchemaVersion(), version)
+	assert.Empty(changes)
+}
+
+func Test_Migrations_Reject_Newer_Schema(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir, err := ioutil.TempDir("", "metastore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "store.db")
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.database.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketNameSchema).Put(keySchemaVersion, []byte("999"))
+	})
+	store.Close()
+
+	// Act
+	_, err = NewBoltStore(path)
+
+	// Assert
+	assert.Error(err)
+}
@@ -0,0 +1,99 @@
+package metastore
+
+import (
+	"github.com/juju/errors"
+)
+
+// defaultNamespaceStore stores the functions of the default namespace
+// without namespace, however they are addressed
+type defaultNamespaceStore struct {
+	Store
+	namespace string
+}
+
+// WithDefaultNamespace returns a store keying the functions of namespace
+// like functions without namespace. Entries already stored with namespace
+// are moved.
+func WithDefaultNamespace(store Store, namespace string) (Store, error) {
+	s := &defaultNamespaceStore{
+		Store:     store,
+		namespace: namespace,
+	}
+
+	metas, err := store.List()
+	if err != nil {
+		return nil, errors.Annotate(err, "List")
+	}
+
+	for i := range metas {
+		meta := &metas[i]
+		if namespace == "" || meta.Namespace != namespace {
+			continue
+		}
+
+		if err := store.Delete(meta); err != nil {
+			return nil, errors.Annotate(err, "Delete")
+		}
+
+		if err := s.Put(meta); err != nil {
+			return nil, errors.Annotate(err, "Put")
+		}
+
+		logger.Infof("moved %q out of the default namespace", meta.Service)
+	}
+
+	return s, nil
+}
+
+func (s *defaultNamespaceStore) normalize(meta *FunctionMeta) *FunctionMeta {
+	if s.namespace == "" || meta.Namespace != s.namespace {
+		return meta
+	}
+
+	normalized := *meta
+	normalized.Namespace = ""
+	return &normalized
+}
+
+func (s *defaultNamespaceStore) Get(meta *FunctionMeta) error {
+	if meta.Namespace == s.namespace {
+		meta.Namespace = ""
+	}
+
+	return s.Store.Get(meta)
+}
+
+func (s *defaultNamespaceStore) Put(meta *FunctionMeta) error {
+	return s.Store.Put(s.normalize(meta))
+}
+
+func (s *defaultNamespaceStore) Delete(meta *FunctionMeta) error {
+	return s.Store.Delete(s.normalize(meta))
+}
+
+func (s *defaultNamespaceStore) Restore(metas []FunctionMeta, replace bool) (int, error) {
+	normalized := make([]FunctionMeta, len(metas))
+	for i := range metas {
+		normalized[i] = *s.normalize(&metas[i])
+	}
+
+	return s.Store.Restore(normalized, replace)
+}
+
+func (s *defaultNamespaceStore) Trash(entry *TrashEntry) error {
+	normalized := *entry
+	normalized.Meta = *s.normalize(&entry.Meta)
+	return s.Store.Trash(&normalized)
+}
+
+func (s *defaultNamespaceStore) GetTrash(meta *FunctionMeta) (*TrashEntry, error) {
+	return s.Store.GetTrash(s.normalize(meta))
+}
+
+func (s *defaultNamespaceStore) Untrash(meta *FunctionMeta) (*TrashEntry, error) {
+	return s.Store.Untrash(s.normalize(meta))
+}
+
+func (s *defaultNamespaceStore) Purge(meta *FunctionMeta) error {
+	return s.Store.Purge(s.normalize(meta))
+}
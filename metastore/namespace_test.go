@@ -0,0 +1,42 @@
+package metastore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WithDefaultNamespace(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		assert := assert.New(t)
+		// Arrange
+		store.Put(&FunctionMeta{Service: "stored-before", Namespace: "faas-functions", Image: "some/image"})
+		store.Put(&FunctionMeta{Service: "other-function", Namespace: "dev", Image: "some/image"})
+
+		// Act
+		s, err := WithDefaultNamespace(store, "faas-functions")
+		if !assert.NoError(err) {
+			return
+		}
+		assert.NoError(s.Put(&FunctionMeta{Service: "some-function", Namespace: "faas-functions", Image: "some/image", Paused: true}))
+
+		// Assert
+		meta := &FunctionMeta{Service: "some-function"}
+		assert.NoError(s.Get(meta))
+		assert.True(meta.Paused)
+
+		meta = &FunctionMeta{Service: "some-function", Namespace: "faas-functions"}
+		assert.NoError(s.Get(meta))
+		assert.True(meta.Paused)
+
+		metas, _ := s.List()
+		keys := []string{}
+		for i := range metas {
+			keys = append(keys, string(metas[i].Key()))
+		}
+		assert.Equal([]string{"dev/other-function", "some-function", "stored-before"}, keys)
+
+		assert.NoError(s.Delete(&FunctionMeta{Service: "some-function", Namespace: "faas-functions"}))
+		assert.Equal(ErrEntityNotFound, s.Get(&FunctionMeta{Service: "some-function"}))
+	})
+}
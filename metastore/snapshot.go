@@ -0,0 +1,123 @@
+package metastore
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	// SnapshotFormat is the version of the snapshot document
+	SnapshotFormat = 1
+)
+
+var (
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+)
+
+// ImportMode selects how a snapshot is combined with the stored entries
+type ImportMode string
+
+const (
+	// ImportMerge adds and replaces the entries of the snapshot, keeping all others
+	ImportMerge ImportMode = "merge"
+	// ImportReplace makes the store contain exactly the entries of the snapshot
+	ImportReplace ImportMode = "replace"
+)
+
+// Snapshot is a versioned copy of all store entries
+type Snapshot struct {
+	Format        int            `json:"format"`
+	SchemaVersion int            `json:"schemaVersion"`
+	Created       time.Time      `json:"created"`
+	Functions     []FunctionMeta `json:"functions"`
+}
+
+// ImportResult summarizes an import
+type ImportResult struct {
+	Imported int `json:"imported"`
+	Deleted  int `json:"deleted"`
+}
+
+// Export takes a snapshot of the store. The entries are read in a single
+// transaction, so the snapshot is consistent.
+func Export(store Store) (*Snapshot, error) {
+	metas, err := store.List()
+	if err != nil {
+		return nil, errors.Annotate(err, "List")
+	}
+
+	snapshot := Snapshot{
+		Format:        SnapshotFormat,
+		SchemaVersion: SchemaVersion(),
+		Created:       time.Now().UTC(),
+		Functions:     metas,
+	}
+
+	return &snapshot, nil
+}
+
+// Import validates the snapshot, migrates snapshots of older schema
+// versions and restores its entries. Invalid snapshots are rejected as a
+// whole with an error caused by ErrInvalidSnapshot.
+func Import(store Store, snapshot *Snapshot, mode ImportMode) (*ImportResult, error) {
+	if mode != ImportMerge && mode != ImportReplace {
+		return nil, errors.Annotatef(ErrInvalidSnapshot, "unknown import mode %q", mode)
+	}
+
+	if err := snapshot.validate(); err != nil {
+		return nil, errors.Annotate(err, "validate")
+	}
+
+	metas := make([]FunctionMeta, len(snapshot.Functions))
+	copy(metas, snapshot.Functions)
+
+	for _, migration := range migrations {
+		if migration.Version <= snapshot.SchemaVersion {
+			continue
+		}
+
+		for i := range metas {
+			if _, err := migration.Migrate(&metas[i]); err != nil {
+				return nil, errors.Annotatef(err, "migration %d [%s]", migration.Version, metas[i].Key())
+			}
+		}
+	}
+
+	deleted, err := store.Restore(metas, mode == ImportReplace)
+	if err != nil {
+		return nil, errors.Annotate(err, "Restore")
+	}
+
+	result := ImportResult{
+		Imported: len(metas),
+		Deleted:  deleted,
+	}
+
+	return &result, nil
+}
+
+func (p *Snapshot) validate() error {
+	if p.Format != SnapshotFormat {
+		return errors.Annotatef(ErrInvalidSnapshot, "unsupported format %d", p.Format)
+	}
+
+	if p.SchemaVersion < 0 || p.SchemaVersion > SchemaVersion() {
+		return errors.Annotatef(ErrInvalidSnapshot, "unsupported schema version %d", p.SchemaVersion)
+	}
+
+	seen := make(map[string]bool)
+	for i, meta := range p.Functions {
+		if !meta.Valid() {
+			return errors.Annotatef(ErrInvalidSnapshot, "function %d lacks service or image", i)
+		}
+
+		key := string(meta.Key())
+		if seen[key] {
+			return errors.Annotatef(ErrInvalidSnapshot, "duplicate function %q", key)
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package metastore
+
+import (
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Export_Import_Round_Trip(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		assert := assert.New(t)
+		// Arrange
+		source := NewMemoryStore()
+		source.Put(&FunctionMeta{Service: "a", Image: "image", EnvProcess: "cat"})
+		source.Put(&FunctionMeta{Service: "b", Namespace: "dev", Image: "image"})
+		store.Put(&FunctionMeta{Service: "c", Image: "image"})
+
+		snapshot, err := Export(source)
+		assert.NoError(err)
+
+		// Act
+		result, err := Import(store, snapshot, ImportReplace)
+
+		// Assert
+		assert.NoError(err)
+		assert.Equal(&ImportResult{Imported: 2, Deleted: 1}, result)
+
+		metas, _ := store.List()
+		assert.Equal(snapshot.Functions, metas)
+	})
+}
+
+func Test_Import_Merge_Keeps_Other_Entries(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	store := NewMemoryStore()
+	store.Put(&FunctionMeta{Service: "a", Image: "old"})
+	store.Put(&FunctionMeta{Service: "c", Image: "image"})
+
+	snapshot := &Snapshot{
+		Format:        SnapshotFormat,
+		SchemaVersion: SchemaVersion(),
+		Functions:     []FunctionMeta{{Service: "a", Image: "new"}},
+	}
+
+	// Act
+	result, err := Import(store, snapshot, ImportMerge)
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal(0, result.Deleted)
+
+	meta := &FunctionMeta{Service: "a"}
+	store.Get(meta)
+	assert.Equal("new", meta.Image)
+	assert.NoError(store.Get(&FunctionMeta{Service: "c"}))
+}
+
+func Test_Import_Migrates_Old_Snapshots(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	store := NewMemoryStore()
+	snapshot := &Snapshot{
+		Format: SnapshotFormat,
+		Functions: []FunctionMeta{{
+			Service: "a",
+			Image:   "image",
+			Labels:  map[string]interface{}{"io.rancher.container.pull_image": "always"},
+		}},
+	}
+
+	// Act
+	_, err := Import(store, snapshot, ImportMerge)
+
+	// Assert
+	assert.NoError(err)
+	meta := &FunctionMeta{Service: "a"}
+	store.Get(meta)
+	assert.Empty(meta.Labels)
+}
+
+func Test_Import_Rejects_Invalid_Snapshots(t *testing.T) {
+	valid := FunctionMeta{Service: "a", Image: "image"}
+	snapshots := map[string]*Snapshot{
+		"format":    {Format: 99, Functions: []FunctionMeta{valid}},
+		"schema":    {Format: SnapshotFormat, SchemaVersion: 99},
+		"invalid":   {Format: SnapshotFormat, Functions: []FunctionMeta{{Service: "a"}}},
+		"duplicate": {Format: SnapshotFormat, Functions: []FunctionMeta{valid, valid}},
+	}
+
+	for name, snapshot := range snapshots {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			// Arrange
+			store := NewMemoryStore()
+
+			// Act
+			_, err := Import(store, snapshot, ImportReplace)
+
+			// Assert
+			assert.Equal(ErrInvalidSnapshot, errors.Cause(err))
+			metas, _ := store.List()
+			assert.Empty(metas)
+		})
+	}
+}
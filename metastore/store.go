@@ -1,20 +1,10 @@
 package metastore
 
 import (
-	"encoding/json"
-	"fmt"
+	"sync"
+	"time"
 
 	"github.com/juju/errors"
-	bolt "go.etcd.io/bbolt"
-)
-
-const (
-	storeRoot = "/metastore"
-)
-
-var (
-	database            *bolt.DB
-	bucketNameFunctions = []byte("functions")
 )
 
 var (
@@ -23,90 +13,99 @@ var (
 	ErrInvalidService         = errors.New("invalid service")
 )
 
-//Open opens a database
-func Open() error {
-	db, err := bolt.Open(fmt.Sprintf("%s/store.db", storeRoot), 0600, nil)
-	if err != nil {
-		return errors.Annotate(err, "Open")
-	}
-
-	database = db
-	return database.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(bucketNameFunctions)
-		if err != nil {
-			return errors.Annotate(err, "CreateBucketIfNotExists")
-		}
-		return nil
-	})
+// Store persists function metadata. Entries are identified by the
+// Namespace and Service of the FunctionMeta passed in.
+type Store interface {
+	// Get reads the entry identified by meta into meta
+	// and returns ErrEntityNotFound if there is none
+	Get(meta *FunctionMeta) error
+	// Put creates or replaces the entry
+	Put(meta *FunctionMeta) error
+	// Delete deletes the entry, deleting a missing entry is no error
+	Delete(meta *FunctionMeta) error
+	// List lists all entries ordered by key
+	List() ([]FunctionMeta, error)
+	// Restore puts all entries at once. With replace set all other entries
+	// are deleted, their number is returned.
+	Restore(metas []FunctionMeta, replace bool) (int, error)
+	// Watch streams changes until stop is closed. Events are dropped
+	// for watchers not keeping up.
+	Watch(stop <-chan struct{}) <-chan Event
+
+	// Trash moves the entry of entry.Meta into the trash
+	Trash(entry *TrashEntry) error
+	// GetTrash returns the trashed entry or ErrEntityNotFound
+	GetTrash(meta *FunctionMeta) (*TrashEntry, error)
+	// Untrash moves the trashed entry back and returns it,
+	// ErrEntityNotFound is returned if there is none
+	Untrash(meta *FunctionMeta) (*TrashEntry, error)
+	// ListTrash lists all trashed entries ordered by key
+	ListTrash() ([]TrashEntry, error)
+	// Purge deletes the trashed entry, purging a missing entry is no error
+	Purge(meta *FunctionMeta) error
+
+	Close() error
 }
 
-// Close closes the database
-func Close() (err error) {
-	if database != nil {
-		err = database.Close()
-		database = nil
-	}
-	return err
+// TrashEntry is the metadata of a soft deleted function, kept until Expires
+type TrashEntry struct {
+	Meta    FunctionMeta `json:"meta"`
+	Deleted time.Time    `json:"deleted"`
+	Expires time.Time    `json:"expires"`
 }
 
-// Update updates metadata related to a service
-func Update(meta *FunctionMeta) error {
-	if database == nil {
-		return ErrDatabaseNotInitialized
-	}
+// EventType is the kind of change reported by Store.Watch
+type EventType string
 
-	if !meta.Valid() {
-		return ErrInvalidService
-	}
+const (
+	EventPut    EventType = "put"
+	EventDelete EventType = "delete"
+)
 
-	return database.Update(func(tx *bolt.Tx) error {
-		buf, err := json.Marshal(meta)
-		if err != nil {
-			return errors.Annotate(err, "Marshal")
-		}
+// Event is a change of a store entry
+type Event struct {
+	Type EventType
+	Meta FunctionMeta
+}
 
-		b := tx.Bucket(bucketNameFunctions)
-		return b.Put([]byte(meta.Service), buf)
-	})
+// watchers dispatches events to the channels returned by Store.Watch
+type watchers struct {
+	mu    sync.Mutex
+	chans map[chan Event]struct{}
 }
 
-// Read reads metadata related to a service
-func Read(meta *FunctionMeta) error {
-	if database == nil {
-		return ErrDatabaseNotInitialized
-	}
+func (w *watchers) watch(stop <-chan struct{}) <-chan Event {
+	ch := make(chan Event, 64)
 
-	if !meta.Valid() {
-		return ErrInvalidService
+	w.mu.Lock()
+	if w.chans == nil {
+		w.chans = make(map[chan Event]struct{})
 	}
+	w.chans[ch] = struct{}{}
+	w.mu.Unlock()
 
-	return database.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketNameFunctions)
-		buf := b.Get([]byte(meta.Service))
-		if buf == nil {
-			return ErrEntityNotFound
-		}
+	go func() {
+		<-stop
 
-		if err := json.Unmarshal(buf, meta); err != nil {
-			return errors.Annotate(err, "Unmarshal")
-		}
+		w.mu.Lock()
+		delete(w.chans, ch)
+		close(ch)
+		w.mu.Unlock()
+	}()
 
-		return nil
-	})
+	return ch
 }
 
-// Delete deletes metadata related to a service
-func Delete(meta *FunctionMeta) error {
-	if database == nil {
-		return ErrDatabaseNotInitialized
-	}
+func (w *watchers) notify(eventType EventType, meta *FunctionMeta) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	if !meta.Valid() {
-		return ErrInvalidService
+	event := Event{Type: eventType, Meta: *meta}
+	for ch := range w.chans {
+		select {
+		case ch <- event:
+		default:
+			logger.Warnf("watcher not keeping up, dropped %s event of %q", eventType, meta.Key())
+		}
 	}
-
-	return database.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketNameFunctions)
-		return b.Delete([]byte(meta.Service))
-	})
 }
@@ -0,0 +1,178 @@
+package metastore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func forEachStore(t *testing.T, fn func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, NewMemoryStore())
+	})
+
+	t.Run("bolt", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "metastore")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		store, err := NewBoltStore(filepath.Join(dir, "nested", "store.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		fn(t, store)
+	})
+}
+
+func Test_Store_Put_Get_Delete(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		assert := assert.New(t)
+		// Arrange
+		meta := &FunctionMeta{
+			Service:    "some-function",
+			Image:      "some/image",
+			EnvProcess: "cat",
+		}
+
+		// Act & Assert
+		assert.NoError(store.Put(meta))
+
+		read := &FunctionMeta{Service: "some-function"}
+		assert.NoError(store.Get(read))
+		assert.Equal(meta, read)
+
+		assert.Equal(ErrEntityNotFound, store.Get(&FunctionMeta{Service: "some-function", Namespace: "dev"}))
+
+		assert.NoError(store.Delete(read))
+		assert.Equal(ErrEntityNotFound, store.Get(&FunctionMeta{Service: "some-function"}))
+	})
+}
+
+func Test_Store_List_Orders_By_Key(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		assert := assert.New(t)
+		// Arrange
+		store.Put(&FunctionMeta{Service: "b", Image: "image"})
+		store.Put(&FunctionMeta{Service: "a", Namespace: "dev", Image: "image"})
+		store.Put(&FunctionMeta{Service: "a", Image: "image"})
+
+		// Act
+		metas, err := store.List()
+
+		// Assert
+		assert.NoError(err)
+		keys := []string{}
+		for _, meta := range metas {
+			keys = append(keys, string(meta.Key()))
+		}
+		assert.Equal([]string{"a", "b", "dev/a"}, keys)
+	})
+}
+
+func Test_Store_Watch_Streams_Changes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		assert := assert.New(t)
+		// Arrange
+		stop := make(chan struct{})
+		events := store.Watch(stop)
+		meta := &FunctionMeta{Service: "some-function", Image: "some/image"}
+
+		// Act
+		store.Put(meta)
+		store.Delete(meta)
+		close(stop)
+
+		// Assert
+		assert.Equal(Event{Type: EventPut, Meta: *meta}, <-events)
+		assert.Equal(EventDelete, (<-events).Type)
+		_, open := <-events
+		assert.False(open)
+	})
+}
+
+func Test_Store_Put_Rejects_Invalid_Meta(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		// Act
+		err := store.Put(&FunctionMeta{Service: "some-function"})
+
+		// Assert
+		assert.Equal(t, ErrInvalidService, err)
+	})
+}
+
+func Test_Store_Trash_Untrash_Purge(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		assert := assert.New(t)
+		// Arrange
+		meta := FunctionMeta{Service: "some-function", Namespace: "dev", Image: "some/image"}
+		assert.NoError(store.Put(&meta))
+		entry := &TrashEntry{
+			Meta:    meta,
+			Deleted: time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC),
+			Expires: time.Date(2019, 10, 2, 12, 0, 0, 0, time.UTC),
+		}
+
+		// Act & Assert: trash
+		assert.NoError(store.Trash(entry))
+		assert.Equal(ErrEntityNotFound, store.Get(&FunctionMeta{Service: "some-function", Namespace: "dev"}))
+
+		trashed, err := store.GetTrash(&FunctionMeta{Service: "some-function", Namespace: "dev"})
+		assert.NoError(err)
+		assert.Equal(entry, trashed)
+
+		list, err := store.ListTrash()
+		assert.NoError(err)
+		assert.Equal([]TrashEntry{*entry}, list)
+
+		// untrash
+		untrashed, err := store.Untrash(&FunctionMeta{Service: "some-function", Namespace: "dev"})
+		assert.NoError(err)
+		assert.Equal(entry, untrashed)
+		assert.NoError(store.Get(&FunctionMeta{Service: "some-function", Namespace: "dev"}))
+
+		_, err = store.Untrash(&FunctionMeta{Service: "some-function", Namespace: "dev"})
+		assert.Equal(ErrEntityNotFound, err)
+
+		// purge
+		assert.NoError(store.Trash(entry))
+		assert.NoError(store.Purge(&meta))
+		_, err = store.GetTrash(&meta)
+		assert.Equal(ErrEntityNotFound, err)
+		assert.NoError(store.Purge(&meta))
+	})
+}
+
+func Test_NewBoltStore_Fails_If_In_Use(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir, err := ioutil.TempDir("", "metastore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "store.db")
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	defer func(timeout time.Duration) { openTimeout = timeout }(openTimeout)
+	openTimeout = 10 * time.Millisecond
+
+	// Act
+	_, err = NewBoltStore(path)
+
+	// Assert
+	assert.Equal(ErrInUse, errors.Cause(err))
+}
@@ -10,8 +10,8 @@ type BridgeClient struct {
 	mock.Mock
 }
 
-// CreateService provides a mock function with given fields: spec
-func (_m *BridgeClient) CreateService(spec *client.Service) (*client.Service, error) {
+// ActivateService provides a mock function with given fields: spec
+func (_m *BridgeClient) ActivateService(spec *client.Service) (*client.Service, error) {
 	ret := _m.Called(spec)
 
 	var r0 *client.Service
@@ -33,6 +33,112 @@ func (_m *BridgeClient) CreateService(spec *client.Service) (*client.Service, er
 	return r0, r1
 }
 
+// CreateSecret provides a mock function with given fields: spec
+func (_m *BridgeClient) CreateSecret(spec *client.Secret) (*client.Secret, error) {
+	ret := _m.Called(spec)
+
+	var r0 *client.Secret
+	if rf, ok := ret.Get(0).(func(*client.Secret) *client.Secret); ok {
+		r0 = rf(spec)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Secret)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Secret) error); ok {
+		r1 = rf(spec)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateSecretReference provides a mock function with given fields: spec
+func (_m *BridgeClient) CreateSecretReference(spec *client.SecretReference) (*client.SecretReference, error) {
+	ret := _m.Called(spec)
+
+	var r0 *client.SecretReference
+	if rf, ok := ret.Get(0).(func(*client.SecretReference) *client.SecretReference); ok {
+		r0 = rf(spec)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.SecretReference)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.SecretReference) error); ok {
+		r1 = rf(spec)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateService provides a mock function with given fields: spec, namespace
+func (_m *BridgeClient) CreateService(spec *client.Service, namespace string) (*client.Service, error) {
+	ret := _m.Called(spec, namespace)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service, string) *client.Service); ok {
+		r0 = rf(spec, namespace)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service, string) error); ok {
+		r1 = rf(spec, namespace)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeactivateService provides a mock function with given fields: spec
+func (_m *BridgeClient) DeactivateService(spec *client.Service) (*client.Service, error) {
+	ret := _m.Called(spec)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service) *client.Service); ok {
+		r0 = rf(spec)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service) error); ok {
+		r1 = rf(spec)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteSecret provides a mock function with given fields: spec
+func (_m *BridgeClient) DeleteSecret(spec *client.Secret) error {
+	ret := _m.Called(spec)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*client.Secret) error); ok {
+		r0 = rf(spec)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteService provides a mock function with given fields: spec
 func (_m *BridgeClient) DeleteService(spec *client.Service) error {
 	ret := _m.Called(spec)
@@ -47,13 +153,13 @@ func (_m *BridgeClient) DeleteService(spec *client.Service) error {
 	return r0
 }
 
-// FindServiceByName provides a mock function with given fields: name
-func (_m *BridgeClient) FindServiceByName(name string) (*client.Service, error) {
-	ret := _m.Called(name)
+// FindServiceByName provides a mock function with given fields: name, namespace
+func (_m *BridgeClient) FindServiceByName(name string, namespace string) (*client.Service, error) {
+	ret := _m.Called(name, namespace)
 
 	var r0 *client.Service
-	if rf, ok := ret.Get(0).(func(string) *client.Service); ok {
-		r0 = rf(name)
+	if rf, ok := ret.Get(0).(func(string, string) *client.Service); ok {
+		r0 = rf(name, namespace)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*client.Service)
@@ -61,8 +167,8 @@ func (_m *BridgeClient) FindServiceByName(name string) (*client.Service, error)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(name)
+	if rf, ok := ret.Get(1).(func(string, string) error); ok {
+		r1 = rf(name, namespace)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -93,16 +199,39 @@ func (_m *BridgeClient) FinishUpgradeService(spec *client.Service) (*client.Serv
 	return r0, r1
 }
 
-// ListServices provides a mock function with given fields:
-func (_m *BridgeClient) ListServices() ([]client.Service, error) {
+// ListInstances provides a mock function with given fields: spec
+func (_m *BridgeClient) ListInstances(spec *client.Service) ([]client.Container, error) {
+	ret := _m.Called(spec)
+
+	var r0 []client.Container
+	if rf, ok := ret.Get(0).(func(*client.Service) []client.Container); ok {
+		r0 = rf(spec)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]client.Container)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service) error); ok {
+		r1 = rf(spec)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListNamespaces provides a mock function with given fields:
+func (_m *BridgeClient) ListNamespaces() ([]string, error) {
 	ret := _m.Called()
 
-	var r0 []client.Service
-	if rf, ok := ret.Get(0).(func() []client.Service); ok {
+	var r0 []string
+	if rf, ok := ret.Get(0).(func() []string); ok {
 		r0 = rf()
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]client.Service)
+			r0 = ret.Get(0).([]string)
 		}
 	}
 
@@ -116,6 +245,75 @@ func (_m *BridgeClient) ListServices() ([]client.Service, error) {
 	return r0, r1
 }
 
+// ListSecrets provides a mock function with given fields: listOpts
+func (_m *BridgeClient) ListSecrets(listOpts *client.ListOpts) (*client.SecretCollection, error) {
+	ret := _m.Called(listOpts)
+
+	var r0 *client.SecretCollection
+	if rf, ok := ret.Get(0).(func(*client.ListOpts) *client.SecretCollection); ok {
+		r0 = rf(listOpts)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.SecretCollection)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.ListOpts) error); ok {
+		r1 = rf(listOpts)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListServices provides a mock function with given fields: namespace
+func (_m *BridgeClient) ListServices(namespace string) ([]client.Service, error) {
+	ret := _m.Called(namespace)
+
+	var r0 []client.Service
+	if rf, ok := ret.Get(0).(func(string) []client.Service); ok {
+		r0 = rf(namespace)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(namespace)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateSecret provides a mock function with given fields: spec, update
+func (_m *BridgeClient) UpdateSecret(spec *client.Secret, update interface{}) (*client.Secret, error) {
+	ret := _m.Called(spec, update)
+
+	var r0 *client.Secret
+	if rf, ok := ret.Get(0).(func(*client.Secret, interface{}) *client.Secret); ok {
+		r0 = rf(spec, update)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Secret)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Secret, interface{}) error); ok {
+		r1 = rf(spec, update)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // UpdateService provides a mock function with given fields: spec, updates
 func (_m *BridgeClient) UpdateService(spec *client.Service, updates map[string]string) (*client.Service, error) {
 	ret := _m.Called(spec, updates)
@@ -0,0 +1,224 @@
+// Package policy decides which operations identities may do on which
+// functions. Policies are read from a JSON file of rules; a request is
+// allowed if any rule allows it.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"github.com/juju/errors"
+)
+
+// Operation is an operation of the provider API
+type Operation string
+
+const (
+	// OperationAll matches every operation in rules
+	OperationAll Operation = "*"
+
+	OperationRead      Operation = "read"
+	OperationDeploy    Operation = "deploy"
+	OperationUpdate    Operation = "update"
+	OperationDelete    Operation = "delete"
+	OperationScale     Operation = "scale"
+	OperationPause     Operation = "pause"
+	OperationResume    Operation = "resume"
+	OperationRestore   Operation = "restore"
+	OperationSecrets   Operation = "secrets"
+	OperationMetastore Operation = "metastore"
+)
+
+var (
+	ErrInvalidPolicy = errors.New("invalid policy")
+)
+
+var operations = map[Operation]bool{
+	OperationAll:       true,
+	OperationRead:      true,
+	OperationDeploy:    true,
+	OperationUpdate:    true,
+	OperationDelete:    true,
+	OperationScale:     true,
+	OperationPause:     true,
+	OperationResume:    true,
+	OperationRestore:   true,
+	OperationSecrets:   true,
+	OperationMetastore: true,
+}
+
+// Rule allows identities to do operations on the functions matching
+// all of its patterns. Empty patterns match every function.
+type Rule struct {
+	Name       string      `json:"name"`
+	Identities []string    `json:"identities"`
+	Operations []Operation `json:"operations"`
+	// glob patterns of function names and namespaces
+	Functions  []string `json:"functions"`
+	Namespaces []string `json:"namespaces"`
+	// glob patterns of label values by label name
+	Labels map[string]string `json:"labels"`
+}
+
+// Policy is the content of the policy file
+type Policy struct {
+	Rules []Rule `json:"rules"`
+
+	// namespace of functions requested without namespace
+	defaultNamespace string
+}
+
+// Request is an operation requested by an identity. Function is empty
+// for operations not targeting a function, e.g. listing functions.
+type Request struct {
+	Identity  string
+	Operation Operation
+	Function  string
+	Namespace string
+	Labels    map[string]string
+}
+
+// Decision is the outcome of a request
+type Decision struct {
+	Allowed bool
+	// name of the rule allowing the request
+	Rule   string
+	Reason string
+}
+
+// Load reads a policy file. Functions of defaultNamespace are also
+// requested without namespace.
+func Load(path string, defaultNamespace string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotate(err, "ReadFile")
+	}
+
+	p := Policy{}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, errors.Annotate(err, "Unmarshal")
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, errors.Annotate(err, "Validate")
+	}
+
+	p.defaultNamespace = defaultNamespace
+	return &p, nil
+}
+
+// Validate checks the rules for unknown operations and malformed patterns
+func (p *Policy) Validate() error {
+	for i, rule := range p.Rules {
+		name := rule.name(i)
+		if len(rule.Identities) == 0 || len(rule.Operations) == 0 {
+			return errors.Annotatef(ErrInvalidPolicy, "rule %s requires identities and operations", name)
+		}
+
+		for _, op := range rule.Operations {
+			if !operations[op] {
+				return errors.Annotatef(ErrInvalidPolicy, "rule %s: unknown operation %q", name, op)
+			}
+		}
+
+		patterns := append(append([]string{}, rule.Identities...), rule.Functions...)
+		patterns = append(patterns, rule.Namespaces...)
+		for _, pattern := range rule.Labels {
+			patterns = append(patterns, pattern)
+		}
+
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return errors.Annotatef(ErrInvalidPolicy, "rule %s: pattern %q", name, pattern)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Decide decides whether the request is allowed
+func (p *Policy) Decide(req *Request) Decision {
+	namespace := req.Namespace
+	if namespace == "" {
+		namespace = p.defaultNamespace
+	}
+
+	for i, rule := range p.Rules {
+		if !matchAny(rule.Identities, req.Identity) || !rule.allows(req.Operation) {
+			continue
+		}
+
+		if req.Function != "" && !rule.matchesFunction(req.Function, namespace, req.Labels) {
+			continue
+		}
+
+		name := rule.name(i)
+		return Decision{
+			Allowed: true,
+			Rule:    name,
+			Reason:  fmt.Sprintf("allowed by rule %s", name),
+		}
+	}
+
+	reason := fmt.Sprintf("identity %q may not %s", req.Identity, req.Operation)
+	if req.Function != "" {
+		reason = fmt.Sprintf("%s function %q in namespace %q", reason, req.Function, namespace)
+	}
+
+	return Decision{Reason: reason}
+}
+
+// name returns the name of the i-th rule, rules without name are numbered
+func (r *Rule) name(i int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	return fmt.Sprintf("#%d", i+1)
+}
+
+func (r *Rule) allows(op Operation) bool {
+	for _, allowed := range r.Operations {
+		if allowed == OperationAll || allowed == op {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Rule) matchesFunction(name, namespace string, labels map[string]string) bool {
+	if len(r.Functions) > 0 && !matchAny(r.Functions, name) {
+		return false
+	}
+
+	if len(r.Namespaces) > 0 && !matchAny(r.Namespaces, namespace) {
+		return false
+	}
+
+	for key, pattern := range r.Labels {
+		value, ok := labels[key]
+		if !ok {
+			return false
+		}
+
+		if matched, _ := path.Match(pattern, value); !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+
+	return false
+}
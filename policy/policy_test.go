@@ -0,0 +1,86 @@
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadPolicy(t *testing.T, content string) (*Policy, error) {
+	file, err := ioutil.TempFile("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString(content)
+	file.Close()
+
+	return Load(file.Name(), "faas-functions")
+}
+
+const teams = `{
+	"rules": [
+		{"name": "ci", "identities": ["ci"], "operations": ["deploy", "update", "read"], "labels": {"team": "*"}},
+		{"name": "oncall", "identities": ["oncall-*"], "operations": ["scale", "read"], "namespaces": ["faas-functions"]},
+		{"name": "payments", "identities": ["payments"], "operations": ["*"], "functions": ["pay-*"], "labels": {"team": "payments"}},
+		{"identities": ["admin"], "operations": ["*"]}
+	]
+}`
+
+func Test_Policy_Decide(t *testing.T) {
+	p, err := loadPolicy(t, teams)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	payments := map[string]string{"team": "payments"}
+	tests := []struct {
+		name    string
+		req     Request
+		allowed bool
+		rule    string
+	}{
+		{"ci deploys labeled", Request{Identity: "ci", Operation: OperationDeploy, Function: "fn", Labels: payments}, true, "ci"},
+		{"ci deploys unlabeled", Request{Identity: "ci", Operation: OperationDeploy, Function: "fn"}, false, ""},
+		{"ci lists", Request{Identity: "ci", Operation: OperationRead}, true, "ci"},
+		{"ci deletes", Request{Identity: "ci", Operation: OperationDelete, Function: "fn", Labels: payments}, false, ""},
+		{"oncall scales default namespace", Request{Identity: "oncall-eu", Operation: OperationScale, Function: "fn"}, true, "oncall"},
+		{"oncall scales other namespace", Request{Identity: "oncall-eu", Operation: OperationScale, Function: "fn", Namespace: "other"}, false, ""},
+		{"payments deletes own", Request{Identity: "payments", Operation: OperationDelete, Function: "pay-out", Labels: payments}, true, "payments"},
+		{"payments deletes other", Request{Identity: "payments", Operation: OperationDelete, Function: "ship-out", Labels: payments}, false, ""},
+		{"payments secrets", Request{Identity: "payments", Operation: OperationSecrets}, true, "payments"},
+		{"admin secrets", Request{Identity: "admin", Operation: OperationSecrets}, true, "#4"},
+		{"anonymous", Request{Operation: OperationRead}, false, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision := p.Decide(&test.req)
+			assert.Equal(t, test.allowed, decision.Allowed, decision.Reason)
+			assert.Equal(t, test.rule, decision.Rule)
+			assert.NotEmpty(t, decision.Reason)
+		})
+	}
+}
+
+func Test_Load_Rejects_Invalid_Policies(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	policies := []string{
+		`{"rules": [{"identities": ["ci"]}]}`,
+		`{"rules": [{"identities": ["ci"], "operations": ["destroy"]}]}`,
+		`{"rules": [{"identities": ["ci"], "operations": ["read"], "functions": ["["]}]}`,
+		`{"rules": `,
+	}
+
+	for _, content := range policies {
+		// Act
+		_, err := loadPolicy(t, content)
+
+		// Assert
+		assert.Error(err, content)
+	}
+}
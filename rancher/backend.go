@@ -0,0 +1,525 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package rancher
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/helper"
+	"github.com/juju/errors"
+	"github.com/openfaas/faas-provider/types"
+	client "github.com/rancher/go-rancher/v2"
+)
+
+const (
+	imagePrefix = "docker:"
+	envProcess  = "fprocess"
+	// secrets of other than the default namespace are stored as <namespace>/<name>
+	secretSeparator = "/"
+)
+
+// Backend implements backend.Backend on top of the Rancher 1.x API.
+// Namespaces are mapped to stacks of the same name by the client.
+type Backend struct {
+	client           BridgeClient
+	defaultNamespace string
+	pollInterval     time.Duration
+	pollAttempts     int
+}
+
+// NewBackend creates a backend.Backend for the given rancher client.
+// Functions deployed without namespace belong to defaultNamespace.
+func NewBackend(client BridgeClient, defaultNamespace string) *Backend {
+	b := Backend{
+		client:           client,
+		defaultNamespace: defaultNamespace,
+		pollInterval:     1 * time.Second,
+		pollAttempts:     30,
+	}
+
+	return &b
+}
+
+// ListNamespaces lists the stacks used as namespace
+func (b *Backend) ListNamespaces() ([]string, error) {
+	namespaces, err := b.client.ListNamespaces()
+	if err != nil {
+		return nil, errors.Annotate(err, "ListNamespaces")
+	}
+
+	return namespaces, nil
+}
+
+// ListFunctions lists all rancher services labeled as faas function
+func (b *Backend) ListFunctions(namespace string) ([]backend.Function, error) {
+	namespace = b.namespace(namespace)
+	services, err := b.client.ListServices(namespace)
+	if err != nil {
+		return nil, errors.Annotate(err, "ListServices")
+	}
+
+	functions := []backend.Function{}
+	for _, service := range services {
+		if !isFunction(&service) || isRemoved(&service) {
+			continue
+		}
+
+		function := functionFromService(&service)
+		function.Namespace = namespace
+		functions = append(functions, function)
+	}
+
+	return functions, nil
+}
+
+// FindFunction finds the rancher service of a function
+func (b *Backend) FindFunction(name, namespace string) (*backend.Function, error) {
+	service, err := b.findService(name, namespace)
+	if err != nil {
+		return nil, errors.Annotate(err, "findService")
+	}
+
+	function := functionFromService(service)
+	function.Namespace = b.namespace(namespace)
+	return &function, nil
+}
+
+// DeployFunction creates a rancher service for the function
+func (b *Backend) DeployFunction(spec *backend.FunctionSpec) error {
+	lc, err := b.launchConfigFromSpec(spec)
+	if err != nil {
+		return errors.Annotate(err, "launchConfigFromSpec")
+	}
+
+	serviceSpec := &client.Service{
+		Name:          spec.Name,
+		Scale:         1,
+		StartOnCreate: true,
+		LaunchConfig:  lc,
+	}
+
+	if _, err := b.client.CreateService(serviceSpec, b.namespace(spec.Namespace)); err != nil {
+		return errors.Annotate(err, "CreateService")
+	}
+
+	return nil
+}
+
+// UpdateFunction starts an in service upgrade of the function and
+// finishes it in the background as soon as rancher reports it as upgraded.
+// Rancher only upgrades active services, updating an inactive (paused or
+// soft deleted) function returns backend.ErrFunctionInactive.
+func (b *Backend) UpdateFunction(spec *backend.FunctionSpec) error {
+	service, err := b.findService(spec.Name, spec.Namespace)
+	if err != nil {
+		return errors.Annotate(err, "findService")
+	}
+
+	if service.State != backend.StateActive {
+		return errors.Annotatef(backend.ErrFunctionInactive, "service %q is %s", service.Name, service.State)
+	}
+
+	lc, err := b.launchConfigFromSpec(spec)
+	if err != nil {
+		return errors.Annotate(err, "launchConfigFromSpec")
+	}
+
+	upgrade := &client.ServiceUpgrade{
+		InServiceStrategy: &client.InServiceUpgradeStrategy{
+			BatchSize:              1,
+			StartFirst:             true,
+			LaunchConfig:           lc,
+			SecondaryLaunchConfigs: []client.SecondaryLaunchConfig{},
+		},
+	}
+
+	if _, err := b.client.UpgradeService(service, upgrade); err != nil {
+		return errors.Annotate(err, "UpgradeService")
+	}
+
+	go b.finishUpgrade(spec.Name, b.namespace(spec.Namespace))
+	return nil
+}
+
+func (b *Backend) finishUpgrade(name, namespace string) {
+	logger.Info("Waiting for upgrade to finish")
+	for pollCounter := b.pollAttempts; pollCounter > 0; pollCounter-- {
+		time.Sleep(b.pollInterval)
+
+		service, err := b.client.FindServiceByName(name, namespace)
+		if err != nil {
+			logger.Error(errors.Annotate(err, "FindServiceByName"))
+			continue
+		}
+
+		if service == nil {
+			logger.Errorf("service %q vanished during upgrade", name)
+			return
+		}
+
+		logger.Debug(service.State)
+		if service.State == "upgraded" {
+			logger.Debug("Finishing upgrade")
+			if _, err := b.client.FinishUpgradeService(service); err != nil {
+				logger.Error(errors.Annotate(err, "FinishUpgradeService"))
+				return
+			}
+			logger.Info("Upgrade finished")
+			return
+		}
+	}
+	logger.Warn("Poll timeout!")
+}
+
+// DeleteFunction deletes the rancher service of a function
+func (b *Backend) DeleteFunction(name, namespace string) error {
+	service, err := b.findService(name, namespace)
+	if err != nil {
+		return errors.Annotate(err, "findService")
+	}
+
+	if err := b.client.DeleteService(service); err != nil {
+		return errors.Annotate(err, "DeleteService")
+	}
+
+	return nil
+}
+
+// ScaleFunction sets the scale of the rancher service of a function
+func (b *Backend) ScaleFunction(name, namespace string, replicas uint64) error {
+	service, err := b.findService(name, namespace)
+	if err != nil {
+		return errors.Annotate(err, "findService")
+	}
+
+	updates := make(map[string]string)
+	updates["scale"] = strconv.FormatUint(replicas, 10)
+	if _, err := b.client.UpdateService(service, updates); err != nil {
+		return errors.Annotate(err, "UpdateService")
+	}
+
+	return nil
+}
+
+// DeactivateFunction deactivates the rancher service of a function
+func (b *Backend) DeactivateFunction(name, namespace string) error {
+	service, err := b.findService(name, namespace)
+	if err != nil {
+		return errors.Annotate(err, "findService")
+	}
+
+	if service.State == backend.StateInactive {
+		return nil
+	}
+
+	if _, err := b.client.DeactivateService(service); err != nil {
+		return errors.Annotate(err, "DeactivateService")
+	}
+
+	return nil
+}
+
+// ActivateFunction activates the rancher service of a function
+func (b *Backend) ActivateFunction(name, namespace string) error {
+	service, err := b.findService(name, namespace)
+	if err != nil {
+		return errors.Annotate(err, "findService")
+	}
+
+	if service.State == backend.StateActive {
+		return nil
+	}
+
+	if _, err := b.client.ActivateService(service); err != nil {
+		return errors.Annotate(err, "ActivateService")
+	}
+
+	return nil
+}
+
+// ListInstances lists the running containers of the rancher service of a function
+func (b *Backend) ListInstances(name, namespace string) ([]backend.Instance, error) {
+	service, err := b.findService(name, namespace)
+	if err != nil {
+		return nil, errors.Annotate(err, "findService")
+	}
+
+	containers, err := b.client.ListInstances(service)
+	if err != nil {
+		return nil, errors.Annotate(err, "ListInstances")
+	}
+
+	instances := []backend.Instance{}
+	for _, container := range containers {
+		if container.State != "running" || container.PrimaryIpAddress == "" {
+			continue
+		}
+
+		instances = append(instances, backend.Instance{
+			ID:      container.Id,
+			Address: container.PrimaryIpAddress,
+			// containers without health check report no health state
+			Healthy: container.HealthState == "" || container.HealthState == "healthy",
+		})
+	}
+
+	return instances, nil
+}
+
+// ListSecrets lists the rancher secrets of the namespace
+func (b *Backend) ListSecrets(namespace string) ([]types.Secret, error) {
+	coll, err := b.client.ListSecrets(nil)
+	if err != nil {
+		return nil, errors.Annotate(err, "ListSecrets")
+	}
+
+	namespace = b.namespace(namespace)
+	var results []types.Secret
+	for _, s := range coll.Data {
+		name, ok := b.secretName(s.Name, namespace)
+		if !ok {
+			continue
+		}
+
+		results = append(results, types.Secret{
+			Name:      name,
+			Namespace: namespace,
+			Value:     s.Value,
+		})
+	}
+
+	return results, nil
+}
+
+// EnsureSecret creates or updates a rancher secret
+func (b *Backend) EnsureSecret(secret *types.Secret) error {
+	old, err := b.lookupSecret(secret.Name, secret.Namespace)
+	if err != nil {
+		return errors.Annotate(err, "lookupSecret")
+	}
+
+	sec := client.Secret{
+		Name:  b.rancherSecretName(secret.Name, secret.Namespace),
+		Value: secret.Value,
+	}
+
+	if old != nil {
+		update := map[string]string{"value": sec.Value}
+		if _, err := b.client.UpdateSecret(old, update); err != nil {
+			return errors.Annotate(err, "UpdateSecret")
+		}
+
+		return nil
+	}
+
+	if _, err := b.client.CreateSecret(&sec); err != nil {
+		return errors.Annotate(err, "CreateSecret")
+	}
+
+	return nil
+}
+
+// DeleteSecret deletes a rancher secret
+func (b *Backend) DeleteSecret(name, namespace string) error {
+	old, err := b.lookupSecret(name, namespace)
+	if err != nil {
+		return errors.Annotate(err, "lookupSecret")
+	}
+
+	if old == nil {
+		return errors.Annotatef(backend.ErrSecretNotFound, "no secret with name %q available", name)
+	}
+
+	if err := b.client.DeleteSecret(old); err != nil {
+		return errors.Annotate(err, "DeleteSecret")
+	}
+
+	return nil
+}
+
+func (b *Backend) findService(name, namespace string) (*client.Service, error) {
+	service, err := b.client.FindServiceByName(name, b.namespace(namespace))
+	if err != nil {
+		return nil, errors.Annotate(err, "FindServiceByName")
+	}
+
+	// This makes sure we don't touch non-labelled or deleted services
+	if service == nil || !isFunction(service) || isRemoved(service) {
+		return nil, backend.ErrFunctionNotFound
+	}
+
+	return service, nil
+}
+
+func (b *Backend) lookupSecret(name, namespace string) (*client.Secret, error) {
+	coll, err := b.client.ListSecrets(nil)
+	if err != nil {
+		return nil, errors.Annotate(err, "ListSecrets")
+	}
+
+	name = b.rancherSecretName(name, namespace)
+	for _, s := range coll.Data {
+		if s.Name == name {
+			return &s, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (b *Backend) launchConfigFromSpec(spec *backend.FunctionSpec) (*client.LaunchConfig, error) {
+	envVars := make(map[string]interface{})
+	for k, v := range spec.EnvVars {
+		envVars[k] = v
+	}
+
+	if len(spec.EnvProcess) > 0 {
+		envVars[envProcess] = spec.EnvProcess
+	}
+
+	labels := helper.ToRancherMap(&spec.Labels)
+	labels[backend.FunctionLabel] = spec.Name
+	labels["io.rancher.container.pull_image"] = "always"
+
+	lc := &client.LaunchConfig{
+		Environment: envVars,
+		ImageUuid:   imagePrefix + spec.Image, // not sure if it's ok to just prefix with 'docker:'
+		Labels:      labels,
+	}
+
+	for _, name := range spec.Secrets {
+		sec, err := b.lookupSecret(name, spec.Namespace)
+		if err != nil {
+			return nil, errors.Annotate(err, "lookupSecret")
+		}
+
+		if sec == nil {
+			return nil, errors.Annotatef(backend.ErrSecretNotFound, "secret %q", name)
+		}
+
+		ref := client.SecretReference{
+			Name:     name,
+			SecretId: sec.Id,
+		}
+
+		lc.Secrets = append(lc.Secrets, ref)
+	}
+
+	return lc, nil
+}
+
+// namespace returns the namespace functions without namespace belong to
+func (b *Backend) namespace(namespace string) string {
+	if namespace == "" {
+		return b.defaultNamespace
+	}
+
+	return namespace
+}
+
+// rancherSecretName returns the name of the rancher secret storing a secret of the namespace
+func (b *Backend) rancherSecretName(name, namespace string) string {
+	namespace = b.namespace(namespace)
+	if namespace == b.defaultNamespace {
+		return name
+	}
+
+	return namespace + secretSeparator + name
+}
+
+// secretName returns the name of the secret stored in a rancher secret
+// and whether it belongs to the namespace
+func (b *Backend) secretName(rancherName, namespace string) (string, bool) {
+	parts := strings.SplitN(rancherName, secretSeparator, 2)
+	if len(parts) == 1 {
+		return rancherName, namespace == b.defaultNamespace
+	}
+
+	return parts[1], parts[0] == namespace
+}
+
+func isFunction(service *client.Service) bool {
+	if service.LaunchConfig == nil {
+		return false
+	}
+
+	_, ok := service.LaunchConfig.Labels[backend.FunctionLabel]
+	return ok
+}
+
+// isRemoved reports whether the service is deleted, rancher keeps removed
+// services around until they are purged
+func isRemoved(service *client.Service) bool {
+	return service.State == "removed" || service.State == "purged"
+}
+
+func functionFromService(service *client.Service) backend.Function {
+	replicas := uint64(service.Scale)
+	function := backend.Function{
+		Name:              service.Name,
+		Replicas:          replicas,
+		AvailableReplicas: replicas,
+		State:             service.State,
+		Status:            statusFromService(service),
+		EnvVars:           make(map[string]string),
+		Labels:            make(map[string]string),
+	}
+
+	lc := service.LaunchConfig
+	if lc == nil {
+		return function
+	}
+
+	function.Image = strings.TrimPrefix(lc.ImageUuid, imagePrefix)
+
+	// rancher internals are never shown to users
+	if labels := helper.ToFaasMap(lc.Labels); labels != nil {
+		function.Labels = helper.WithoutRancherLabels(*labels)
+	}
+
+	if env := helper.ToFaasMap(lc.Environment); env != nil {
+		function.EnvVars = *env
+	}
+
+	function.EnvProcess = function.EnvVars[envProcess]
+	delete(function.EnvVars, envProcess)
+
+	for _, ref := range lc.Secrets {
+		function.Secrets = append(function.Secrets, ref.Name)
+	}
+
+	return function
+}
+
+// statusFromService maps the state and health of a rancher service to a function status
+func statusFromService(service *client.Service) string {
+	switch service.State {
+	case "active":
+		switch service.HealthState {
+		case "unhealthy":
+			return backend.StatusUnhealthy
+		case "degraded":
+			return backend.StatusDegraded
+		case "initializing", "reinitializing":
+			return backend.StatusDeploying
+		}
+		return backend.StatusReady
+	case "registering", "activating", "upgrading", "upgraded", "finishing-upgrade",
+		"rolling-back", "canceling-upgrade", "canceled-upgrade", "updating-active", "restarting":
+		return backend.StatusDeploying
+	case "inactive", "deactivating", "updating-inactive":
+		return backend.StatusInactive
+	case "removing", "purging":
+		return backend.StatusRemoving
+	case "removed", "purged":
+		return backend.StatusInactive
+	case "error", "erroring":
+		return backend.StatusError
+	}
+
+	return backend.StatusUnknown
+}
@@ -0,0 +1,319 @@
+package rancher
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"testing/quick"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/helper"
+	"github.com/gitmonster/faas-rancher/mocks"
+	"github.com/juju/errors"
+	"github.com/openfaas/faas-provider/types"
+	client "github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_Backend_DeployFunction_Creates_Service(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	b := NewBackend(mockClient, "faas-functions")
+
+	spec := &backend.FunctionSpec{
+		Name:       "some-service",
+		Image:      "some/docker/image",
+		EnvProcess: "path/to/process",
+		EnvVars: map[string]string{
+			"SOME_ENV": "SOME_VALUE",
+		},
+	}
+
+	mockClient.On("CreateService",
+		mock.MatchedBy(func(s *client.Service) bool {
+			return s.Name == spec.Name &&
+				s.Scale == 1 &&
+				s.StartOnCreate == true &&
+				s.LaunchConfig.ImageUuid == "docker:some/docker/image" &&
+				s.LaunchConfig.Environment["SOME_ENV"] == spec.EnvVars["SOME_ENV"] &&
+				s.LaunchConfig.Environment["fprocess"] == spec.EnvProcess &&
+				s.LaunchConfig.Labels["faas_function"] == spec.Name
+		}),
+		"faas-functions",
+	).Return(nil, nil)
+
+	// Act
+	err := b.DeployFunction(spec)
+
+	// Assert
+	assert.NoError(err)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_Backend_ListFunctions_Converts_Labeled_Services(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	b := NewBackend(mockClient, "faas-functions")
+
+	services := []client.Service{
+		{
+			Name:  "not-a-function",
+			State: "active",
+			LaunchConfig: &client.LaunchConfig{
+				ImageUuid: "docker:some/other/image",
+				Labels:    map[string]interface{}{},
+			},
+		},
+		{
+			Name:  "some-function",
+			State: "active",
+			Scale: 2,
+			LaunchConfig: &client.LaunchConfig{
+				ImageUuid: "docker:some/docker/image",
+				Environment: map[string]interface{}{
+					"fprocess": "cat",
+					"SOME_ENV": "SOME_VALUE",
+				},
+				Labels: map[string]interface{}{
+					"faas_function": "some-function",
+				},
+				Secrets: []client.SecretReference{
+					{Name: "some-secret"},
+				},
+			},
+		},
+		{
+			Name:  "deleted-function",
+			State: "removed",
+			LaunchConfig: &client.LaunchConfig{
+				ImageUuid: "docker:some/docker/image",
+				Labels: map[string]interface{}{
+					"faas_function": "deleted-function",
+				},
+			},
+		},
+	}
+	mockClient.On("ListServices", "faas-functions").Return(services, nil)
+
+	// Act
+	functions, err := b.ListFunctions("")
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal([]backend.Function{
+		{
+			Name:              "some-function",
+			Namespace:         "faas-functions",
+			Image:             "some/docker/image",
+			EnvProcess:        "cat",
+			EnvVars:           map[string]string{"SOME_ENV": "SOME_VALUE"},
+			Labels:            map[string]string{"faas_function": "some-function"},
+			Secrets:           []string{"some-secret"},
+			Replicas:          2,
+			AvailableReplicas: 2,
+			State:             "active",
+			Status:            backend.StatusReady,
+		},
+	}, functions)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_Backend_FindFunction_Ignores_Unlabeled_Service(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	b := NewBackend(mockClient, "faas-functions")
+
+	service := client.Service{
+		Name:         "some-service",
+		LaunchConfig: &client.LaunchConfig{},
+	}
+	mockClient.On("FindServiceByName", "some-service", "faas-functions").Return(&service, nil)
+
+	// Act
+	_, err := b.FindFunction("some-service", "")
+
+	// Assert
+	assert.Equal(backend.ErrFunctionNotFound, errors.Cause(err))
+	mockClient.AssertExpectations(t)
+}
+
+func Test_Backend_ScaleFunction_Updates_Scale(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	b := NewBackend(mockClient, "faas-functions")
+
+	service := client.Service{
+		Name: "some-function",
+		LaunchConfig: &client.LaunchConfig{
+			Labels: map[string]interface{}{
+				"faas_function": "some-function",
+			},
+		},
+	}
+	mockClient.On("FindServiceByName", "some-function", "faas-functions").Return(&service, nil)
+	mockClient.On("UpdateService", &service, map[string]string{"scale": "3"}).Return(&service, nil)
+
+	// Act
+	err := b.ScaleFunction("some-function", "", 3)
+
+	// Assert
+	assert.NoError(err)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_Backend_ListSecrets_Scopes_By_Namespace(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	b := NewBackend(mockClient, "faas-functions")
+
+	secrets := &client.SecretCollection{
+		Data: []client.Secret{
+			{Name: "default-secret", Value: "a"},
+			{Name: "dev/dev-secret", Value: "b"},
+		},
+	}
+	mockClient.On("ListSecrets", (*client.ListOpts)(nil)).Return(secrets, nil)
+
+	// Act
+	dev, devErr := b.ListSecrets("dev")
+	defaults, defaultsErr := b.ListSecrets("")
+
+	// Assert
+	assert.NoError(devErr)
+	assert.NoError(defaultsErr)
+	assert.Equal([]types.Secret{{Name: "dev-secret", Namespace: "dev", Value: "b"}}, dev)
+	assert.Equal([]types.Secret{{Name: "default-secret", Namespace: "faas-functions", Value: "a"}}, defaults)
+}
+
+func Test_Backend_LaunchConfig_RoundTrip(t *testing.T) {
+	roundTrip := func(image, envProcess string, envVars, labels map[string]string, secrets []string) bool {
+		mockClient := new(mocks.BridgeClient)
+		b := NewBackend(mockClient, "faas-functions")
+
+		// keys with a meaning of their own are not part of the property
+		delete(envVars, envProcess)
+		delete(labels, backend.FunctionLabel)
+		for k := range labels {
+			if helper.IsRancherLabel(k) {
+				delete(labels, k)
+			}
+		}
+
+		coll := &client.SecretCollection{}
+		for i, name := range secrets {
+			coll.Data = append(coll.Data, client.Secret{Name: name, Resource: client.Resource{Id: fmt.Sprint(i)}})
+		}
+		mockClient.On("ListSecrets", (*client.ListOpts)(nil)).Return(coll, nil)
+
+		spec := &backend.FunctionSpec{
+			Name:       "some-function",
+			Image:      image,
+			EnvProcess: envProcess,
+			EnvVars:    envVars,
+			Labels:     labels,
+			Secrets:    secrets,
+		}
+
+		lc, err := b.launchConfigFromSpec(spec)
+		if err != nil {
+			return false
+		}
+
+		// the service passes the rancher API as JSON
+		buf, err := json.Marshal(client.Service{Name: spec.Name, LaunchConfig: lc})
+		if err != nil {
+			return false
+		}
+
+		service := client.Service{}
+		if err := json.Unmarshal(buf, &service); err != nil {
+			return false
+		}
+
+		function := functionFromService(&service)
+		if function.Labels[backend.FunctionLabel] != spec.Name {
+			return false
+		}
+		delete(function.Labels, backend.FunctionLabel)
+
+		if len(secrets) == 0 {
+			secrets = nil
+		}
+
+		return function.Image == image &&
+			function.EnvProcess == envProcess &&
+			assert.ObjectsAreEqual(envVars, function.EnvVars) &&
+			assert.ObjectsAreEqual(labels, function.Labels) &&
+			assert.ObjectsAreEqual(secrets, function.Secrets)
+	}
+
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_Backend_DeactivateFunction_Skips_Inactive_Service(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	b := NewBackend(mockClient, "faas-functions")
+
+	active := client.Service{
+		Name:         "active",
+		State:        backend.StateActive,
+		LaunchConfig: &client.LaunchConfig{Labels: map[string]interface{}{"faas_function": "active"}},
+	}
+	inactive := client.Service{
+		Name:         "inactive",
+		State:        backend.StateInactive,
+		LaunchConfig: &client.LaunchConfig{Labels: map[string]interface{}{"faas_function": "inactive"}},
+	}
+	mockClient.On("FindServiceByName", "active", "faas-functions").Return(&active, nil)
+	mockClient.On("FindServiceByName", "inactive", "faas-functions").Return(&inactive, nil)
+	mockClient.On("DeactivateService", &active).Return(&active, nil)
+
+	// Act
+	activeErr := b.DeactivateFunction("active", "")
+	inactiveErr := b.DeactivateFunction("inactive", "")
+
+	// Assert
+	assert.NoError(activeErr)
+	assert.NoError(inactiveErr)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_StatusFromService_Maps_State_And_Health(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	cases := []struct {
+		state, health, status string
+	}{
+		{"active", "healthy", backend.StatusReady},
+		{"active", "", backend.StatusReady},
+		{"active", "degraded", backend.StatusDegraded},
+		{"active", "unhealthy", backend.StatusUnhealthy},
+		{"active", "initializing", backend.StatusDeploying},
+		{"activating", "", backend.StatusDeploying},
+		{"upgraded", "healthy", backend.StatusDeploying},
+		{"inactive", "", backend.StatusInactive},
+		{"removing", "", backend.StatusRemoving},
+		{"removed", "", backend.StatusInactive},
+		{"purged", "", backend.StatusInactive},
+		{"error", "", backend.StatusError},
+		{"something-new", "", backend.StatusUnknown},
+	}
+
+	for _, c := range cases {
+		// Act
+		status := statusFromService(&client.Service{State: c.state, HealthState: c.health})
+
+		// Assert
+		assert.Equal(c.status, status, "%s/%s", c.state, c.health)
+	}
+}
@@ -0,0 +1,709 @@
+// Package cattletest provides a stateful in-memory fake of the Rancher 1.x
+// Cattle v2-beta API, so the real rancher.Client and the handlers can be
+// tested together end to end.
+//
+// The fake serves stacks, services, containers (also listed as instances),
+// secrets and secret references. Service actions move the service into a
+// transitioning state (e.g. "upgrading") which completes the next time the
+// service is read, mimicking the asynchronous behaviour of Cattle without
+// depending on timing.
+package cattletest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	client "github.com/rancher/go-rancher/v2"
+)
+
+const (
+	apiPath = "/v2-beta"
+)
+
+type schemaDef struct {
+	id     string
+	plural string
+	prefix string
+}
+
+// the container collection is also served as "instances"
+var schemaDefs = []schemaDef{
+	{id: client.STACK_TYPE, plural: "stacks", prefix: "1st"},
+	{id: client.SERVICE_TYPE, plural: "services", prefix: "1s"},
+	{id: client.CONTAINER_TYPE, plural: "containers", prefix: "1i"},
+	{id: client.INSTANCE_TYPE, plural: "instances", prefix: "1i"},
+	{id: client.SECRET_TYPE, plural: "secrets", prefix: "1se"},
+	{id: client.SECRET_REFERENCE_TYPE, plural: "secretreferences", prefix: "1sr"},
+}
+
+// transitions of service actions: action -> transitioning state, final state
+var transitions = map[string][2]string{
+	"activate":      {"activating", "active"},
+	"deactivate":    {"deactivating", "inactive"},
+	"upgrade":       {"upgrading", "upgraded"},
+	"finishupgrade": {"finishing-upgrade", "active"},
+	"rollback":      {"rolling-back", "active"},
+}
+
+// actions available per service state
+var serviceActions = map[string][]string{
+	"active":   {"deactivate", "upgrade"},
+	"inactive": {"activate"},
+	"upgraded": {"finishupgrade", "rollback"},
+}
+
+type object map[string]interface{}
+
+// Server is the fake Cattle API server
+type Server struct {
+	*httptest.Server
+
+	accessKey string
+	secretKey string
+
+	mu      sync.Mutex
+	nextID  int
+	nextIP  int
+	objects map[string]object
+	// final states of pending service transitions by id
+	pending map[string]string
+}
+
+// NewServer starts a fake Cattle API accepting the given api key pair.
+// Use the server URL as cattle url of rancher.Config.
+func NewServer(accessKey, secretKey string) *Server {
+	s := newServer(accessKey, secretKey)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// NewTLSServer starts a fake Cattle API serving TLS with a self-signed
+// certificate, available from the Certificate method of the server
+func NewTLSServer(accessKey, secretKey string) *Server {
+	s := newServer(accessKey, secretKey)
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func newServer(accessKey, secretKey string) *Server {
+	s := Server{
+		accessKey: accessKey,
+		secretKey: secretKey,
+		objects:   make(map[string]object),
+		pending:   make(map[string]string),
+	}
+
+	return &s
+}
+
+// SetCredentials replaces the accepted api key pair, e.g. to simulate a key rotation
+func (s *Server) SetCredentials(accessKey, secretKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accessKey = accessKey
+	s.secretKey = secretKey
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	accessKey, secretKey := s.accessKey, s.secretKey
+	s.mu.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != accessKey || pass != secretKey {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, apiPath), "/")
+	if path == "" {
+		w.Header().Set("X-API-Schemas", s.URL+apiPath+"/schemas")
+		writeJSON(w, http.StatusOK, object{"type": "apiRoot", "links": object{}})
+		return
+	}
+
+	if path == "schemas" {
+		writeJSON(w, http.StatusOK, s.schemas())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.Split(path, "/")
+	def, ok := schemaByPlural(parts[0])
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unknown collection "+parts[0])
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.list(w, r, def)
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		s.create(w, r, def)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		s.get(w, parts[1])
+	case len(parts) == 2 && r.Method == http.MethodPut:
+		s.update(w, r, parts[1])
+	case len(parts) == 2 && r.Method == http.MethodPost && r.URL.Query().Get("action") != "":
+		s.action(w, r, parts[1], r.URL.Query().Get("action"))
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		s.delete(w, parts[1])
+	case len(parts) == 3 && parts[2] == "instances" && r.Method == http.MethodGet:
+		s.serviceInstances(w, parts[1])
+	default:
+		writeError(w, http.StatusMethodNotAllowed, r.Method+" "+r.URL.Path)
+	}
+}
+
+func (s *Server) schemas() object {
+	data := []object{}
+	for _, def := range schemaDefs {
+		data = append(data, object{
+			"id":                def.id,
+			"type":              "schema",
+			"pluralName":        def.plural,
+			"collectionMethods": []string{http.MethodGet, http.MethodPost},
+			"resourceMethods":   []string{http.MethodGet, http.MethodPut, http.MethodDelete},
+			"links": object{
+				"self":       s.URL + apiPath + "/schemas/" + def.id,
+				"collection": s.URL + apiPath + "/" + def.plural,
+			},
+		})
+	}
+
+	return object{"type": "collection", "resourceType": "schema", "data": data}
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, def schemaDef) {
+	query := r.URL.Query()
+	data := []object{}
+	for _, id := range s.sortedIDs() {
+		obj := s.objects[id]
+		if !sameKind(obj, def.id) {
+			continue
+		}
+
+		s.complete(id)
+		if matches(obj, query) {
+			data = append(data, obj)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, object{"type": "collection", "resourceType": def.id, "data": data})
+}
+
+func (s *Server) get(w http.ResponseWriter, id string) {
+	obj, ok := s.objects[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found "+id)
+		return
+	}
+
+	s.complete(id)
+	writeJSON(w, http.StatusOK, obj)
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request, def schemaDef) {
+	obj := object{}
+	if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	kind := def.id
+	if kind == client.INSTANCE_TYPE {
+		kind = client.CONTAINER_TYPE
+	}
+
+	if name, _ := obj["name"].(string); name != "" && kind != client.CONTAINER_TYPE {
+		for _, other := range s.objects {
+			if other["type"] == kind && other["name"] == name && sameScope(kind, obj, other) {
+				writeError(w, http.StatusUnprocessableEntity, "NotUnique name")
+				return
+			}
+		}
+	}
+
+	id := s.add(kind, def.prefix, obj)
+	switch kind {
+	case client.STACK_TYPE, client.SECRET_TYPE, client.SECRET_REFERENCE_TYPE:
+		obj["state"] = "active"
+	case client.SERVICE_TYPE:
+		obj["state"] = "inactive"
+		if start, _ := obj["startOnCreate"].(bool); start {
+			obj["state"] = "active"
+		}
+		s.syncInstances(id)
+	case client.CONTAINER_TYPE:
+		obj["state"] = "running"
+	}
+
+	s.setActions(id)
+	writeJSON(w, http.StatusCreated, obj)
+}
+
+func (s *Server) update(w http.ResponseWriter, r *http.Request, id string) {
+	obj, ok := s.objects[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found "+id)
+		return
+	}
+
+	updates := object{}
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	for k, v := range updates {
+		switch k {
+		case "id", "type", "links", "actions", "state":
+			continue
+		case "scale":
+			// go-rancher sends scale updates as strings
+			var scale int64
+			if _, err := fmt.Sscan(fmt.Sprint(v), &scale); err != nil {
+				writeError(w, http.StatusUnprocessableEntity, "invalid scale")
+				return
+			}
+			obj[k] = scale
+		default:
+			obj[k] = v
+		}
+	}
+
+	if obj["type"] == client.SERVICE_TYPE {
+		s.syncInstances(id)
+	}
+
+	writeJSON(w, http.StatusOK, obj)
+}
+
+func (s *Server) action(w http.ResponseWriter, r *http.Request, id, action string) {
+	obj, ok := s.objects[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found "+id)
+		return
+	}
+
+	s.complete(id)
+	actions := asObject(obj["actions"])
+	if _, ok := actions[action]; !ok {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Action %s not available in state %v", action, obj["state"]))
+		return
+	}
+
+	transition, ok := transitions[action]
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "Action "+action)
+		return
+	}
+
+	switch action {
+	case "upgrade":
+		input := client.ServiceUpgrade{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		if input.InServiceStrategy == nil || input.InServiceStrategy.LaunchConfig == nil {
+			writeError(w, http.StatusUnprocessableEntity, "inServiceStrategy.launchConfig required")
+			return
+		}
+
+		obj["previousLaunchConfig"] = obj["launchConfig"]
+		obj["launchConfig"] = toObject(input.InServiceStrategy.LaunchConfig)
+		s.replaceInstances(id)
+	case "rollback":
+		obj["launchConfig"] = obj["previousLaunchConfig"]
+		s.replaceInstances(id)
+	}
+
+	obj["state"] = transition[0]
+	obj["transitioning"] = "yes"
+	obj["actions"] = object{}
+	s.pending[id] = transition[1]
+
+	writeJSON(w, http.StatusAccepted, obj)
+}
+
+func (s *Server) delete(w http.ResponseWriter, id string) {
+	obj, ok := s.objects[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found "+id)
+		return
+	}
+
+	if obj["type"] == client.SERVICE_TYPE {
+		for _, instanceID := range s.instanceIDs(id) {
+			delete(s.objects, instanceID)
+		}
+	}
+
+	delete(s.objects, id)
+	delete(s.pending, id)
+
+	obj["state"] = "removed"
+	writeJSON(w, http.StatusOK, obj)
+}
+
+func (s *Server) serviceInstances(w http.ResponseWriter, id string) {
+	data := []object{}
+	for _, instanceID := range s.instanceIDs(id) {
+		data = append(data, s.objects[instanceID])
+	}
+
+	writeJSON(w, http.StatusOK, object{"type": "collection", "resourceType": client.INSTANCE_TYPE, "data": data})
+}
+
+func (s *Server) add(kind, prefix string, obj object) string {
+	s.nextID++
+	id := fmt.Sprintf("%s%d", prefix, s.nextID)
+
+	def, _ := schemaByID(kind)
+	self := s.URL + apiPath + "/" + def.plural + "/" + id
+
+	obj["id"] = id
+	obj["type"] = kind
+	obj["links"] = object{"self": self}
+	obj["actions"] = object{}
+	obj["transitioning"] = "no"
+	if kind == client.SERVICE_TYPE {
+		obj["links"].(object)["instances"] = self + "/instances"
+	}
+
+	s.objects[id] = obj
+	return id
+}
+
+// complete finishes a pending transition of the object
+func (s *Server) complete(id string) {
+	state, ok := s.pending[id]
+	if !ok {
+		return
+	}
+
+	delete(s.pending, id)
+	obj := s.objects[id]
+	obj["state"] = state
+	obj["transitioning"] = "no"
+	s.syncInstances(id)
+	s.setActions(id)
+}
+
+func (s *Server) setActions(id string) {
+	obj := s.objects[id]
+	actions := object{}
+	self := fmt.Sprint(asObject(obj["links"])["self"])
+
+	if obj["type"] == client.SERVICE_TYPE {
+		for _, action := range serviceActions[fmt.Sprint(obj["state"])] {
+			actions[action] = self + "?action=" + action
+		}
+	}
+
+	obj["actions"] = actions
+}
+
+// syncInstances creates or removes containers to match scale and state of a service
+func (s *Server) syncInstances(id string) {
+	service := s.objects[id]
+	if service["type"] != client.SERVICE_TYPE {
+		return
+	}
+
+	var scale int64
+	fmt.Sscan(fmt.Sprint(service["scale"]), &scale)
+
+	running := "running"
+	switch service["state"] {
+	case "inactive", "deactivating":
+		running = "stopped"
+	}
+
+	instances := s.instanceIDs(id)
+	for int64(len(instances)) > scale {
+		last := instances[len(instances)-1]
+		delete(s.objects, last)
+		instances = instances[:len(instances)-1]
+	}
+
+	for int64(len(instances)) < scale {
+		instance := s.newInstance(id, len(instances)+1)
+		instances = append(instances, instance)
+	}
+
+	for _, instanceID := range instances {
+		s.objects[instanceID]["state"] = running
+	}
+}
+
+func (s *Server) replaceInstances(id string) {
+	for _, instanceID := range s.instanceIDs(id) {
+		delete(s.objects, instanceID)
+	}
+
+	s.syncInstances(id)
+}
+
+func (s *Server) newInstance(serviceID string, index int) string {
+	service := s.objects[serviceID]
+	stackName := ""
+	if stack, ok := s.objects[fmt.Sprint(service["stackId"])]; ok {
+		stackName = fmt.Sprint(stack["name"])
+	}
+
+	s.nextIP++
+	instance := object{
+		"name":             fmt.Sprintf("%s-%s-%d", stackName, service["name"], index),
+		"state":            "running",
+		"healthState":      "healthy",
+		"primaryIpAddress": fmt.Sprintf("10.42.%d.%d", s.nextIP/250, s.nextIP%250+1),
+		"serviceIds":       []interface{}{serviceID},
+		"stackId":          service["stackId"],
+	}
+
+	if lc := asObject(service["launchConfig"]); lc != nil {
+		instance["imageUuid"] = lc["imageUuid"]
+		instance["labels"] = lc["labels"]
+		instance["environment"] = lc["environment"]
+	}
+
+	return s.add(client.CONTAINER_TYPE, "1i", instance)
+}
+
+func (s *Server) instanceIDs(serviceID string) []string {
+	ids := []string{}
+	for _, id := range s.sortedIDs() {
+		obj := s.objects[id]
+		if obj["type"] != client.CONTAINER_TYPE {
+			continue
+		}
+
+		serviceIDs, _ := obj["serviceIds"].([]interface{})
+		for _, sid := range serviceIDs {
+			if sid == serviceID {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	return ids
+}
+
+// sortedIDs returns the ids in creation order
+func (s *Server) sortedIDs() []string {
+	ids := make([]string, 0, len(s.objects))
+	for id := range s.objects {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return idNumber(ids[i]) < idNumber(ids[j])
+	})
+
+	return ids
+}
+
+// idNumber returns the counter following the type prefix of an id
+func idNumber(id string) int {
+	n := 0
+	fmt.Sscanf(id[strings.LastIndexAny(id, "abcdefghijklmnopqrstuvwxyz")+1:], "%d", &n)
+	return n
+}
+
+func sameKind(obj object, kind string) bool {
+	if kind == client.INSTANCE_TYPE {
+		kind = client.CONTAINER_TYPE
+	}
+
+	return obj["type"] == kind
+}
+
+// services are unique per stack, everything else per environment
+func sameScope(kind string, a, b object) bool {
+	if kind == client.SERVICE_TYPE {
+		return fmt.Sprint(a["stackId"]) == fmt.Sprint(b["stackId"])
+	}
+
+	return true
+}
+
+func matches(obj object, query map[string][]string) bool {
+	for field, values := range query {
+		if field == "action" || field == "limit" || field == "marker" {
+			continue
+		}
+
+		value := fmt.Sprint(obj[field])
+		found := false
+		for _, v := range values {
+			if v == value {
+				found = true
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func schemaByPlural(plural string) (schemaDef, bool) {
+	for _, def := range schemaDefs {
+		if strings.EqualFold(def.plural, plural) {
+			return def, true
+		}
+	}
+
+	return schemaDef{}, false
+}
+
+func schemaByID(id string) (schemaDef, bool) {
+	for _, def := range schemaDefs {
+		if def.id == id {
+			return def, true
+		}
+	}
+
+	return schemaDef{}, false
+}
+
+// asObject returns nested JSON objects regardless of how they got into the store
+func asObject(v interface{}) object {
+	switch o := v.(type) {
+	case object:
+		return o
+	case map[string]interface{}:
+		return object(o)
+	}
+
+	return nil
+}
+
+func toObject(v interface{}) object {
+	buf, _ := json.Marshal(v)
+	obj := object{}
+	json.Unmarshal(buf, &obj)
+	return obj
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, object{
+		"type":    "error",
+		"status":  code,
+		"code":    http.StatusText(code),
+		"message": msg,
+	})
+}
+
+// Stacks returns all stacks
+func (s *Server) Stacks() []client.Stack {
+	var stacks []client.Stack
+	s.decodeAll(client.STACK_TYPE, &stacks)
+	return stacks
+}
+
+// Services returns all services
+func (s *Server) Services() []client.Service {
+	var services []client.Service
+	s.decodeAll(client.SERVICE_TYPE, &services)
+	return services
+}
+
+// Secrets returns all secrets
+func (s *Server) Secrets() []client.Secret {
+	var secrets []client.Secret
+	s.decodeAll(client.SECRET_TYPE, &secrets)
+	return secrets
+}
+
+// Instances returns the containers of a service
+func (s *Server) Instances(serviceID string) []client.Container {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := []object{}
+	for _, id := range s.instanceIDs(serviceID) {
+		data = append(data, s.objects[id])
+	}
+
+	var instances []client.Container
+	decode(data, &instances)
+	return instances
+}
+
+// SetState forces the state of a resource, e.g. to simulate failures
+func (s *Server) SetState(id, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[id]; !ok {
+		return
+	}
+
+	delete(s.pending, id)
+	s.objects[id]["state"] = state
+	s.syncInstances(id)
+	s.setActions(id)
+}
+
+// Set changes a field of a resource behind the clients back, like an
+// edit in the Rancher UI would
+func (s *Server) Set(id, field string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if obj, ok := s.objects[id]; ok {
+		obj[field] = toObject(object{field: value})[field]
+	}
+}
+
+// Remove deletes a resource behind the clients back
+func (s *Server) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, instanceID := range s.instanceIDs(id) {
+		delete(s.objects, instanceID)
+	}
+
+	delete(s.objects, id)
+	delete(s.pending, id)
+}
+
+func (s *Server) decodeAll(kind string, out interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := []object{}
+	for _, id := range s.sortedIDs() {
+		if s.objects[id]["type"] == kind {
+			// observing a resource completes its transition like an api read
+			s.complete(id)
+			data = append(data, s.objects[id])
+		}
+	}
+
+	decode(data, out)
+}
+
+func decode(in, out interface{}) {
+	buf, _ := json.Marshal(in)
+	json.Unmarshal(buf, out)
+}
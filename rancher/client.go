@@ -4,6 +4,14 @@
 package rancher
 
 import (
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/certs"
 	"github.com/juju/errors"
 	client "github.com/rancher/go-rancher/v2"
 	"github.com/sirupsen/logrus"
@@ -11,17 +19,28 @@ import (
 
 var (
 	logger = logrus.WithField("package", "rancher")
+
+	validNamespace = regexp.MustCompile(`^[a-z0-9]([a-z0-9\-]*[a-z0-9])?$`)
+)
+
+const (
+	// namespaceGroup marks stacks created for OpenFaaS namespaces
+	namespaceGroup = "openfaas-namespace"
 )
 
 // BridgeClient is the interface for Rancher API
 type BridgeClient interface {
-	ListServices() ([]client.Service, error)
-	FindServiceByName(name string) (*client.Service, error)
-	CreateService(spec *client.Service) (*client.Service, error)
+	ListNamespaces() ([]string, error)
+	ListServices(namespace string) ([]client.Service, error)
+	FindServiceByName(name, namespace string) (*client.Service, error)
+	CreateService(spec *client.Service, namespace string) (*client.Service, error)
 	DeleteService(spec *client.Service) error
 	UpdateService(spec *client.Service, updates map[string]string) (*client.Service, error)
 	UpgradeService(spec *client.Service, upgrade *client.ServiceUpgrade) (*client.Service, error)
 	FinishUpgradeService(spec *client.Service) (*client.Service, error)
+	ActivateService(spec *client.Service) (*client.Service, error)
+	DeactivateService(spec *client.Service) (*client.Service, error)
+	ListInstances(spec *client.Service) ([]client.Container, error)
 	CreateSecret(spec *client.Secret) (*client.Secret, error)
 	ListSecrets(listOpts *client.ListOpts) (*client.SecretCollection, error)
 	DeleteSecret(spec *client.Secret) error
@@ -29,67 +48,189 @@ type BridgeClient interface {
 	CreateSecretReference(spec *client.SecretReference) (*client.SecretReference, error)
 }
 
-// Client is the REST client type
+// Client is the REST client type. Every namespace is mapped to the stack
+// of the same name, an empty namespace to the stack set in config.
 type Client struct {
-	rancherClient    *client.RancherClient
-	config           *Config
-	functionsStackID string
+	config *Config
+
+	// the current go-rancher client, swapped on credential reloads
+	rancherClient atomic.Value
+	// credentials the current client was created with
+	reload    sync.Mutex
+	accessKey string
+	secretKey string
+
+	// TLS settings of the cattle server, nil for the defaults
+	certs *certs.Reloader
+
+	mu sync.Mutex
+	// stack ids by namespace
+	stackIDs map[string]string
 }
 
 // NewClientForConfig creates a new rancher REST client
-func NewClientForConfig(config *Config) (BridgeClient, error) {
-	c, newErr := client.NewRancherClient(&client.ClientOpts{
-		Url:       config.CattleURL,
-		AccessKey: config.CattleAccessKey,
-		SecretKey: config.CattleSecretKey,
-	})
+func NewClientForConfig(config *Config) (*Client, error) {
+	accessKey, secretKey, err := config.Credentials()
+	if err != nil {
+		return nil, errors.Annotate(err, "Credentials")
+	}
+
+	var reloader *certs.Reloader
+	if config.HasTLSFiles() {
+		reloader, err = certs.NewReloader(config.CattleCertFile, config.CattleKeyFile, config.CattleCAFile)
+		if err != nil {
+			return nil, errors.Annotate(err, "NewReloader")
+		}
+
+		if err := transports.register(config.CattleURL, reloader); err != nil {
+			return nil, errors.Annotate(err, "register [transport]")
+		}
+	}
 
+	c, newErr := newRancherClient(config.CattleURL, accessKey, secretKey)
 	if newErr != nil {
 		return nil, newErr
 	}
 
-	coll, listErr := c.Stack.List(&client.ListOpts{
-		Filters: map[string]interface{}{
-			"name": config.FunctionsStackName,
-		},
-	})
+	client := Client{
+		config:    config,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		certs:     reloader,
+		stackIDs:  make(map[string]string),
+	}
+	client.rancherClient.Store(c)
+
+	if _, err := client.stackID("", true); err != nil {
+		return nil, errors.Annotate(err, "stackID")
+	}
+
+	return &client, nil
+}
+
+// ReloadCredentials rereads the credentials and swaps the underlying
+// go-rancher client if they changed. Requests in flight complete with the
+// previous client. The previous client is kept if the new credentials are
+// rejected.
+func (c *Client) ReloadCredentials() error {
+	c.reload.Lock()
+	defer c.reload.Unlock()
+
+	accessKey, secretKey, err := c.config.Credentials()
+	if err != nil {
+		return errors.Annotate(err, "Credentials")
+	}
 
-	if listErr != nil {
-		return nil, errors.Annotate(listErr, "List")
+	if accessKey == c.accessKey && secretKey == c.secretKey {
+		return nil
 	}
 
-	var stack *client.Stack
-	if len(coll.Data) == 0 {
-		logger.Infof("stack named %s not found. creating...", config.FunctionsStackName)
-		// create stack if not present
-		reqStack := &client.Stack{
-			Name: config.FunctionsStackName,
+	rc, err := newRancherClient(c.config.CattleURL, accessKey, secretKey)
+	if err != nil {
+		return errors.Annotate(err, "newRancherClient")
+	}
+
+	c.rancherClient.Store(rc)
+	c.accessKey = accessKey
+	c.secretKey = secretKey
+
+	logger.Info("rancher credentials reloaded")
+	return nil
+}
+
+// WatchCertificates polls the client certificate and key files for changes
+// until stop is closed. Changes of the CA bundle require a restart.
+func (c *Client) WatchCertificates(interval time.Duration, stop <-chan struct{}) {
+	if c.certs == nil {
+		return
+	}
+
+	c.certs.Watch(interval, stop)
+}
+
+// WatchCredentials polls the credential files for changes until stop is closed.
+// Failed reloads are logged and retried with the next change.
+func (c *Client) WatchCredentials(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var failed string
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
 		}
-		newStack, err := c.Stack.Create(reqStack)
+
+		accessKey, secretKey, err := c.config.Credentials()
 		if err != nil {
-			return nil, errors.Annotate(err, "Create")
+			logger.Error(errors.Annotate(err, "Credentials"))
+			continue
+		}
+
+		// don't retry rejected credentials on every tick
+		if accessKey+"\x00"+secretKey == failed {
+			continue
 		}
-		logger.Info("stack creation complete")
-		stack = newStack
-	} else {
-		stack = &coll.Data[0]
+
+		if err := c.ReloadCredentials(); err != nil {
+			logger.Errorf("reloading rancher credentials failed, keeping previous client: %v", err)
+			failed = accessKey + "\x00" + secretKey
+			continue
+		}
+
+		failed = ""
 	}
+}
 
-	client := Client{
-		rancherClient:    c,
-		config:           config,
-		functionsStackID: stack.Id,
+func (c *Client) api() *client.RancherClient {
+	return c.rancherClient.Load().(*client.RancherClient)
+}
+
+func newRancherClient(url, accessKey, secretKey string) (*client.RancherClient, error) {
+	return client.NewRancherClient(&client.ClientOpts{
+		Url:       url,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+	})
+}
+
+// ListNamespaces lists the default namespace and all stacks created for namespaces
+func (c *Client) ListNamespaces() ([]string, error) {
+	coll, err := c.api().Stack.List(&client.ListOpts{
+		Filters: map[string]interface{}{
+			"group": namespaceGroup,
+		},
+	})
+	if err != nil {
+		return nil, errors.Annotate(err, "List")
 	}
 
-	return &client, nil
+	namespaces := []string{c.config.FunctionsStackName}
+	for _, stack := range coll.Data {
+		if stack.Name != c.config.FunctionsStackName {
+			namespaces = append(namespaces, stack.Name)
+		}
+	}
 
+	sort.Strings(namespaces[1:])
+	return namespaces, nil
 }
 
-// ListServices lists rancher services inside the specified stack (set in config)
-func (c *Client) ListServices() ([]client.Service, error) {
-	services, err := c.rancherClient.Service.List(&client.ListOpts{
+// ListServices lists rancher services inside the stack of the namespace
+func (c *Client) ListServices(namespace string) ([]client.Service, error) {
+	stackID, err := c.stackID(namespace, false)
+	if err != nil {
+		return nil, errors.Annotate(err, "stackID")
+	}
+
+	if stackID == "" {
+		return []client.Service{}, nil
+	}
+
+	services, err := c.api().Service.List(&client.ListOpts{
 		Filters: map[string]interface{}{
-			"stackId": c.functionsStackID,
+			"stackId": stackID,
 		},
 	})
 	if err != nil {
@@ -98,11 +239,21 @@ func (c *Client) ListServices() ([]client.Service, error) {
 	return services.Data, nil
 }
 
-// FindServiceByName finds a service based on its name
-func (c *Client) FindServiceByName(name string) (*client.Service, error) {
-	services, err := c.rancherClient.Service.List(&client.ListOpts{
+// FindServiceByName finds a service based on its name inside the stack of the namespace
+func (c *Client) FindServiceByName(name, namespace string) (*client.Service, error) {
+	stackID, err := c.stackID(namespace, false)
+	if err != nil {
+		return nil, errors.Annotate(err, "stackID")
+	}
+
+	if stackID == "" {
+		return nil, nil
+	}
+
+	services, err := c.api().Service.List(&client.ListOpts{
 		Filters: map[string]interface{}{
-			"name": name,
+			"name":    name,
+			"stackId": stackID,
 		},
 	})
 	if err != nil {
@@ -116,19 +267,87 @@ func (c *Client) FindServiceByName(name string) (*client.Service, error) {
 	return nil, nil
 }
 
-// CreateService creates a service inside rancher
-func (c *Client) CreateService(spec *client.Service) (*client.Service, error) {
-	spec.StackId = c.functionsStackID
-	service, err := c.rancherClient.Service.Create(spec)
+// CreateService creates a service inside the stack of the namespace.
+// The stack is created if not present.
+func (c *Client) CreateService(spec *client.Service, namespace string) (*client.Service, error) {
+	stackID, err := c.stackID(namespace, true)
+	if err != nil {
+		return nil, errors.Annotate(err, "stackID")
+	}
+
+	spec.StackId = stackID
+	service, err := c.api().Service.Create(spec)
 	if err != nil {
 		return nil, errors.Annotate(err, "Create")
 	}
 	return service, nil
 }
 
+// stackID returns the id of the stack of the namespace or an empty id
+// if the stack does not exist and should not be created
+func (c *Client) stackID(namespace string, create bool) (string, error) {
+	name := namespace
+	if name == "" {
+		name = c.config.FunctionsStackName
+	}
+
+	if name != c.config.FunctionsStackName && !validNamespace.MatchString(name) {
+		return "", errors.Annotatef(backend.ErrInvalidNamespace, "%q", name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if id, ok := c.stackIDs[name]; ok {
+		return id, nil
+	}
+
+	coll, err := c.api().Stack.List(&client.ListOpts{
+		Filters: map[string]interface{}{
+			"name": name,
+		},
+	})
+	if err != nil {
+		return "", errors.Annotate(err, "List")
+	}
+
+	if len(coll.Data) > 0 {
+		stack := coll.Data[0]
+		// never deploy into stacks not owned by the provider
+		if name != c.config.FunctionsStackName && stack.Group != namespaceGroup {
+			return "", errors.Annotatef(backend.ErrInvalidNamespace, "stack %q is not an OpenFaaS namespace", name)
+		}
+
+		c.stackIDs[name] = stack.Id
+		return stack.Id, nil
+	}
+
+	if !create {
+		return "", nil
+	}
+
+	logger.Infof("stack named %s not found. creating...", name)
+	reqStack := &client.Stack{
+		Name: name,
+	}
+
+	if name != c.config.FunctionsStackName {
+		reqStack.Group = namespaceGroup
+	}
+
+	stack, err := c.api().Stack.Create(reqStack)
+	if err != nil {
+		return "", errors.Annotate(err, "Create")
+	}
+
+	logger.Info("stack creation complete")
+	c.stackIDs[name] = stack.Id
+	return stack.Id, nil
+}
+
 // DeleteService deletes the specified service in rancher
 func (c *Client) DeleteService(spec *client.Service) error {
-	err := c.rancherClient.Service.Delete(spec)
+	err := c.api().Service.Delete(spec)
 	if err != nil {
 		return errors.Annotate(err, "Delete")
 	}
@@ -138,7 +357,7 @@ func (c *Client) DeleteService(spec *client.Service) error {
 
 // UpdateService upgrades the specified service in rancher
 func (c *Client) UpdateService(spec *client.Service, updates map[string]string) (*client.Service, error) {
-	service, err := c.rancherClient.Service.Update(spec, updates)
+	service, err := c.api().Service.Update(spec, updates)
 	if err != nil {
 		return nil, errors.Annotate(err, "Update")
 	}
@@ -147,7 +366,7 @@ func (c *Client) UpdateService(spec *client.Service, updates map[string]string)
 
 // UpgradeService starts service upgrade of the specified service in rancher
 func (c *Client) UpgradeService(spec *client.Service, upgrade *client.ServiceUpgrade) (*client.Service, error) {
-	service, err := c.rancherClient.Service.ActionUpgrade(spec, upgrade)
+	service, err := c.api().Service.ActionUpgrade(spec, upgrade)
 	if err != nil {
 		return nil, errors.Annotate(err, "ActionUpgrade")
 	}
@@ -156,16 +375,43 @@ func (c *Client) UpgradeService(spec *client.Service, upgrade *client.ServiceUpg
 
 // FinishUpgradeService finishes service upgrade of the specified service in rancher
 func (c *Client) FinishUpgradeService(spec *client.Service) (*client.Service, error) {
-	service, err := c.rancherClient.Service.ActionFinishupgrade(spec)
+	service, err := c.api().Service.ActionFinishupgrade(spec)
 	if err != nil {
 		return nil, errors.Annotate(err, "ActionFinishupgrade")
 	}
 	return service, nil
 }
 
+// ActivateService starts the containers of an inactive service
+func (c *Client) ActivateService(spec *client.Service) (*client.Service, error) {
+	service, err := c.api().Service.ActionActivate(spec)
+	if err != nil {
+		return nil, errors.Annotate(err, "ActionActivate")
+	}
+	return service, nil
+}
+
+// ListInstances lists the containers of a service
+func (c *Client) ListInstances(spec *client.Service) ([]client.Container, error) {
+	coll := client.ContainerCollection{}
+	if err := c.api().GetLink(spec.Resource, "instances", &coll); err != nil {
+		return nil, errors.Annotate(err, "GetLink [instances]")
+	}
+	return coll.Data, nil
+}
+
+// DeactivateService stops the containers of a service
+func (c *Client) DeactivateService(spec *client.Service) (*client.Service, error) {
+	service, err := c.api().Service.ActionDeactivate(spec)
+	if err != nil {
+		return nil, errors.Annotate(err, "ActionDeactivate")
+	}
+	return service, nil
+}
+
 // CreateSecret creates a rancher secret
 func (c *Client) CreateSecret(spec *client.Secret) (*client.Secret, error) {
-	secret, err := c.rancherClient.Secret.Create(spec)
+	secret, err := c.api().Secret.Create(spec)
 	if err != nil {
 		return nil, errors.Annotate(err, "Create")
 	}
@@ -174,7 +420,7 @@ func (c *Client) CreateSecret(spec *client.Secret) (*client.Secret, error) {
 
 // ListSecrets lists rancher secrets
 func (c *Client) ListSecrets(listOpts *client.ListOpts) (*client.SecretCollection, error) {
-	coll, err := c.rancherClient.Secret.List(listOpts)
+	coll, err := c.api().Secret.List(listOpts)
 	if err != nil {
 		return nil, errors.Annotate(err, "List")
 	}
@@ -183,7 +429,7 @@ func (c *Client) ListSecrets(listOpts *client.ListOpts) (*client.SecretCollectio
 
 // DeleteSecret deletes a rancher secret
 func (c *Client) DeleteSecret(spec *client.Secret) error {
-	if err := c.rancherClient.Secret.Delete(spec); err != nil {
+	if err := c.api().Secret.Delete(spec); err != nil {
 		return errors.Annotate(err, "Delete")
 	}
 
@@ -192,7 +438,7 @@ func (c *Client) DeleteSecret(spec *client.Secret) error {
 
 // UpdateSecret updates a rancher secret
 func (c *Client) UpdateSecret(spec *client.Secret, update interface{}) (*client.Secret, error) {
-	secret, err := c.rancherClient.Secret.Update(spec, update)
+	secret, err := c.api().Secret.Update(spec, update)
 	if err != nil {
 		return nil, errors.Annotate(err, "Update")
 	}
@@ -201,7 +447,7 @@ func (c *Client) UpdateSecret(spec *client.Secret, update interface{}) (*client.
 
 // UpdateSecret updates a rancher secret
 func (c *Client) CreateSecretReference(spec *client.SecretReference) (*client.SecretReference, error) {
-	secret, err := c.rancherClient.SecretReference.Create(spec)
+	secret, err := c.api().SecretReference.Create(spec)
 	if err != nil {
 		return nil, errors.Annotate(err, "Create")
 	}
@@ -0,0 +1,232 @@
+package rancher
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/rancher/cattletest"
+	"github.com/juju/errors"
+	client "github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewClientForConfig_Creates_Missing_Stack(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server := cattletest.NewServer("access", "secret")
+	defer server.Close()
+
+	config, _ := NewClientConfig("faas-functions", server.URL, "access", "secret")
+
+	// Act
+	_, err := NewClientForConfig(config)
+	assert.NoError(err)
+	_, err = NewClientForConfig(config)
+	assert.NoError(err)
+
+	// Assert
+	stacks := server.Stacks()
+	assert.Len(stacks, 1)
+	assert.Equal("faas-functions", stacks[0].Name)
+}
+
+func Test_NewClientForConfig_Invalid_Credentials(t *testing.T) {
+	// Arrange
+	server := cattletest.NewServer("access", "secret")
+	defer server.Close()
+
+	config, _ := NewClientConfig("faas-functions", server.URL, "access", "wrong")
+
+	// Act
+	_, err := NewClientForConfig(config)
+
+	// Assert
+	assert.Error(t, err)
+}
+
+func Test_Client_Services_Are_Scoped_To_Stack(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server := cattletest.NewServer("access", "secret")
+	defer server.Close()
+
+	functionsConfig, _ := NewClientConfig("faas-functions", server.URL, "access", "secret")
+	functions, err := NewClientForConfig(functionsConfig)
+	assert.NoError(err)
+
+	otherConfig, _ := NewClientConfig("other", server.URL, "access", "secret")
+	other, err := NewClientForConfig(otherConfig)
+	assert.NoError(err)
+
+	// Act
+	_, err = functions.CreateService(&client.Service{Name: "some-function", Scale: 2, StartOnCreate: true}, "")
+	assert.NoError(err)
+	_, err = other.CreateService(&client.Service{Name: "other-service", Scale: 1}, "")
+	assert.NoError(err)
+
+	// Assert
+	services, err := functions.ListServices("")
+	assert.NoError(err)
+	assert.Len(services, 1)
+	assert.Equal("some-function", services[0].Name)
+	assert.Equal("active", services[0].State)
+	assert.Len(server.Instances(services[0].Id), 2)
+}
+
+func Test_Client_Namespaces_Are_Mapped_To_Stacks(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server := cattletest.NewServer("access", "secret")
+	defer server.Close()
+
+	config, _ := NewClientConfig("faas-functions", server.URL, "access", "secret")
+	functions, err := NewClientForConfig(config)
+	assert.NoError(err)
+
+	// Act
+	_, err = functions.CreateService(&client.Service{Name: "some-function", Scale: 1}, "dev")
+	assert.NoError(err)
+	_, err = functions.CreateService(&client.Service{Name: "some-function", Scale: 1}, "")
+	assert.NoError(err)
+
+	// Assert
+	namespaces, err := functions.ListNamespaces()
+	assert.NoError(err)
+	assert.Equal([]string{"faas-functions", "dev"}, namespaces)
+
+	dev, err := functions.ListServices("dev")
+	assert.NoError(err)
+	assert.Len(dev, 1)
+
+	service, err := functions.FindServiceByName("some-function", "staging")
+	assert.NoError(err)
+	assert.Nil(service)
+	assert.Len(server.Stacks(), 2)
+}
+
+func Test_Client_Rejects_Foreign_Stack_As_Namespace(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server := cattletest.NewServer("access", "secret")
+	defer server.Close()
+
+	otherConfig, _ := NewClientConfig("ingress", server.URL, "access", "secret")
+	_, err := NewClientForConfig(otherConfig)
+	assert.NoError(err)
+
+	config, _ := NewClientConfig("faas-functions", server.URL, "access", "secret")
+	functions, err := NewClientForConfig(config)
+	assert.NoError(err)
+
+	// Act
+	_, err = functions.CreateService(&client.Service{Name: "some-function", Scale: 1}, "ingress")
+
+	// Assert
+	assert.Equal(backend.ErrInvalidNamespace, errors.Cause(err))
+}
+
+func Test_Client_ReloadCredentials_Swaps_Client(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server := cattletest.NewServer("access", "secret")
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	accessKeyFile := filepath.Join(dir, "access")
+	secretKeyFile := filepath.Join(dir, "secret")
+	ioutil.WriteFile(accessKeyFile, []byte("access\n"), 0600)
+	ioutil.WriteFile(secretKeyFile, []byte("secret\n"), 0600)
+
+	config, _ := NewClientConfig("faas-functions", server.URL, "", "")
+	config.CattleAccessKeyFile = accessKeyFile
+	config.CattleSecretKeyFile = secretKeyFile
+
+	functions, err := NewClientForConfig(config)
+	assert.NoError(err)
+
+	// Act & Assert: rejected credentials keep the previous client
+	ioutil.WriteFile(secretKeyFile, []byte("wrong"), 0600)
+	assert.Error(functions.ReloadCredentials())
+	_, err = functions.ListServices("")
+	assert.NoError(err)
+
+	// Act & Assert: rotated credentials swap the client
+	server.SetCredentials("access", "rotated")
+	_, err = functions.ListServices("")
+	assert.Error(err)
+
+	ioutil.WriteFile(secretKeyFile, []byte("rotated"), 0600)
+	assert.NoError(functions.ReloadCredentials())
+	_, err = functions.ListServices("")
+	assert.NoError(err)
+}
+
+func Test_NewClientForConfig_Custom_CA(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server := cattletest.NewTLSServer("access", "secret")
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "certificates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+
+	config, _ := NewClientConfig("faas-functions", server.URL, "access", "secret")
+	untrusted, _ := NewClientConfig("faas-functions", server.URL, "access", "secret")
+
+	// Act
+	_, untrustedErr := NewClientForConfig(untrusted)
+	config.CattleCAFile = caFile
+	functions, err := NewClientForConfig(config)
+
+	// Assert
+	assert.Error(untrustedErr)
+	if assert.NoError(err) {
+		_, err = functions.ListServices("")
+		assert.NoError(err)
+	}
+}
+
+func Test_Backend_ListInstances(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server := cattletest.NewServer("access", "secret")
+	defer server.Close()
+
+	config, _ := NewClientConfig("faas-functions", server.URL, "access", "secret")
+	functions, err := NewClientForConfig(config)
+	if !assert.NoError(err) {
+		return
+	}
+
+	b := NewBackend(functions, "faas-functions")
+	assert.NoError(b.DeployFunction(&backend.FunctionSpec{Name: "some-function", Image: "some/image"}))
+	service, _ := functions.FindServiceByName("some-function", "")
+
+	// Act
+	instances, err := b.ListInstances("some-function", "")
+	_, missingErr := b.ListInstances("other-function", "")
+
+	// Assert
+	assert.NoError(err)
+	if assert.Len(instances, 1) {
+		containers := server.Instances(service.Id)
+		assert.Equal(containers[0].PrimaryIpAddress, instances[0].Address)
+		assert.True(instances[0].Healthy)
+	}
+	assert.Equal(backend.ErrFunctionNotFound, errors.Cause(missingErr))
+}
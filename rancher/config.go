@@ -3,6 +3,13 @@
 
 package rancher
 
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
 // Config for the rancher REST client
 type Config struct {
 	// Stack name where the faas functions get deployed
@@ -13,6 +20,15 @@ type Config struct {
 	CattleAccessKey string
 	// cattle secret key
 	CattleSecretKey string
+	// file to read the cattle access key from, takes precedence over CattleAccessKey
+	CattleAccessKeyFile string
+	// file to read the cattle secret key from, takes precedence over CattleSecretKey
+	CattleSecretKeyFile string
+	// CA bundle to verify the cattle server with instead of the system roots
+	CattleCAFile string
+	// client certificate and key presented to the cattle server
+	CattleCertFile string
+	CattleKeyFile  string
 }
 
 // NewClientConfig creates a new config for rancher REST client
@@ -25,3 +41,46 @@ func NewClientConfig(fn string, url string, aKey string, sKey string) (*Config,
 	}
 	return &config, nil
 }
+
+// Credentials returns the cattle access and secret key
+func (c *Config) Credentials() (string, string, error) {
+	accessKey, err := readKey(c.CattleAccessKeyFile, c.CattleAccessKey)
+	if err != nil {
+		return "", "", errors.Annotate(err, "readKey [access]")
+	}
+
+	secretKey, err := readKey(c.CattleSecretKeyFile, c.CattleSecretKey)
+	if err != nil {
+		return "", "", errors.Annotate(err, "readKey [secret]")
+	}
+
+	return accessKey, secretKey, nil
+}
+
+// HasCredentialFiles reports whether any key is read from a file
+func (c *Config) HasCredentialFiles() bool {
+	return c.CattleAccessKeyFile != "" || c.CattleSecretKeyFile != ""
+}
+
+// HasTLSFiles reports whether custom TLS settings are read from files
+func (c *Config) HasTLSFiles() bool {
+	return c.CattleCAFile != "" || c.CattleCertFile != ""
+}
+
+func readKey(path string, fallback string) (string, error) {
+	if path == "" {
+		return fallback, nil
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Annotate(err, "ReadFile")
+	}
+
+	key := strings.TrimSpace(string(buf))
+	if key == "" {
+		return "", errors.Errorf("%s is empty", path)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package rancher
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/juju/errors"
+)
+
+// Environment configures one rancher environment functions can be deployed to
+type Environment struct {
+	Name      string `json:"name"`
+	CattleURL string `json:"url"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	// files to read the keys from, reloaded on change
+	AccessKeyFile string `json:"accessKeyFile"`
+	SecretKeyFile string `json:"secretKeyFile"`
+	// TLS settings for self-hosted cattle servers
+	CAFile   string `json:"caFile"`
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// Stack name where the faas functions get deployed
+	StackName string `json:"stack"`
+	// Namespaces routed to this environment
+	Namespaces []string `json:"namespaces"`
+}
+
+// Environments is the content of the environments file
+type Environments struct {
+	// Default environment for functions without namespace or routing label
+	Default string `json:"default"`
+	// Label selecting the target environment of a function
+	Label        string        `json:"label"`
+	Environments []Environment `json:"environments"`
+}
+
+// LoadEnvironments reads an environments file
+func LoadEnvironments(path string) (*Environments, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotate(err, "ReadFile")
+	}
+
+	envs := Environments{}
+	if err := json.Unmarshal(data, &envs); err != nil {
+		return nil, errors.Annotate(err, "Unmarshal")
+	}
+
+	if len(envs.Environments) == 0 {
+		return nil, errors.New("no environments configured")
+	}
+
+	for _, env := range envs.Environments {
+		if env.Name == "" || env.CattleURL == "" || env.StackName == "" ||
+			(env.AccessKey == "" && env.AccessKeyFile == "") ||
+			(env.SecretKey == "" && env.SecretKeyFile == "") {
+			return nil, errors.Errorf("environment %q requires name, url, accessKey, secretKey and stack", env.Name)
+		}
+	}
+
+	if envs.Default == "" {
+		envs.Default = envs.Environments[0].Name
+	}
+
+	return &envs, nil
+}
+
+// Config returns the client config of the environment
+func (e *Environment) Config() (*Config, error) {
+	config, err := NewClientConfig(e.StackName, e.CattleURL, e.AccessKey, e.SecretKey)
+	if err != nil {
+		return nil, errors.Annotate(err, "NewClientConfig")
+	}
+
+	config.CattleAccessKeyFile = e.AccessKeyFile
+	config.CattleSecretKeyFile = e.SecretKeyFile
+	config.CattleCAFile = e.CAFile
+	config.CattleCertFile = e.CertFile
+	config.CattleKeyFile = e.KeyFile
+	return config, nil
+}
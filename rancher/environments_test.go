@@ -0,0 +1,55 @@
+package rancher
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeEnvironments(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "environments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func Test_LoadEnvironments_Defaults_To_First_Environment(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	path := writeEnvironments(t, `{"environments": [
+		{"name": "prod", "url": "http://prod", "accessKey": "a", "secretKey": "s", "stack": "faas"},
+		{"name": "staging", "url": "http://staging", "accessKey": "a", "secretKey": "s", "stack": "faas", "namespaces": ["dev"]}
+	]}`)
+	defer os.Remove(path)
+
+	// Act
+	envs, err := LoadEnvironments(path)
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal("prod", envs.Default)
+	assert.Len(envs.Environments, 2)
+	assert.Equal([]string{"dev"}, envs.Environments[1].Namespaces)
+}
+
+func Test_LoadEnvironments_Requires_Credentials(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	path := writeEnvironments(t, `{"environments": [{"name": "prod", "url": "http://prod", "stack": "faas"}]}`)
+	defer os.Remove(path)
+
+	// Act
+	_, err := LoadEnvironments(path)
+
+	// Assert
+	assert.Error(err)
+}
@@ -0,0 +1,81 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package rancher
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/certs"
+	"github.com/juju/errors"
+)
+
+// go-rancher creates a new http.Client without transport for every call
+// and can't be given a client, so the TLS settings of cattle servers can
+// only be applied through http.DefaultTransport. The hostTransport
+// replacing it sends the requests to the scheme and host of a registered
+// cattle URL through the cattle transport and all other requests through
+// the original default transport unchanged. The provider's own clients,
+// like the function proxy and the async callbacks, bring their own
+// transports and never reach it.
+var transports = hostTransport{
+	hosts: make(map[string]http.RoundTripper),
+}
+
+type hostTransport struct {
+	once     sync.Once
+	fallback http.RoundTripper
+
+	mu    sync.RWMutex
+	hosts map[string]http.RoundTripper
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.RLock()
+	transport, ok := t.hosts[hostKey(req.URL)]
+	t.mu.RUnlock()
+
+	if !ok {
+		transport = t.fallback
+	}
+
+	return transport.RoundTrip(req)
+}
+
+// register routes the requests to the host of cattleURL through a
+// transport with the TLS settings of reloader
+func (t *hostTransport) register(cattleURL string, reloader *certs.Reloader) error {
+	u, err := url.Parse(cattleURL)
+	if err != nil {
+		return errors.Annotate(err, "Parse")
+	}
+
+	t.once.Do(func() {
+		t.fallback = http.DefaultTransport
+		http.DefaultTransport = t
+	})
+
+	transport := http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     reloader.ClientConfig(),
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConns:        100,
+	}
+
+	t.mu.Lock()
+	t.hosts[hostKey(u)] = &transport
+	t.mu.Unlock()
+
+	return nil
+}
+
+// hostKey identifies the cattle server of u, plain and TLS requests to
+// the same host are told apart
+func hostKey(u *url.URL) string {
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
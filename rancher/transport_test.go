@@ -0,0 +1,36 @@
+package rancher
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// namedTransport answers every request with its name in the status
+type namedTransport string
+
+func (t namedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{Status: string(t), Request: req}, nil
+}
+
+func Test_HostTransport_Routes_Cattle_Requests_Only(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	transport := hostTransport{
+		fallback: namedTransport("default"),
+		hosts:    map[string]http.RoundTripper{"https://cattle:8443": namedTransport("cattle")},
+	}
+
+	route := func(rawurl string) string {
+		req, _ := http.NewRequest("GET", rawurl, nil)
+		res, _ := transport.RoundTrip(req)
+		return res.Status
+	}
+
+	// Act & Assert
+	assert.Equal("cattle", route("https://CATTLE:8443/v2-beta/projects"))
+	assert.Equal("default", route("http://cattle:8443/v2-beta/projects"))
+	assert.Equal("default", route("https://cattle/v2-beta/projects"))
+	assert.Equal("default", route("http://some-function:8080/"))
+}
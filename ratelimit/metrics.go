@@ -0,0 +1,18 @@
+package ratelimit
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	limited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "faas_rancher",
+		Subsystem: "proxy",
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected because they exceeded the rate limit of functions.",
+	}, []string{"function"})
+)
+
+func init() {
+	prometheus.MustRegister(limited)
+}
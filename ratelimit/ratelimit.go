@@ -0,0 +1,225 @@
+// Package ratelimit limits the rate of requests to a function with token
+// buckets. The rate is annotated on the functions, either for all callers
+// of a function or per client IP or header value. Requests beyond the
+// rate are rejected with 429 Too Many Requests.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/helper"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	logger = logrus.WithField("package", "ratelimit")
+)
+
+const (
+	// RateAnnotation sets the requests per second a function accepts, e.g. "0.5" or "100"
+	RateAnnotation = "com.openfaas.rancher.rate-limit"
+	// BurstAnnotation sets the requests accepted at once, the rounded up rate by default
+	BurstAnnotation = "com.openfaas.rancher.rate-burst"
+	// KeyAnnotation applies the rate per client, "ip" or "header:<name>"
+	KeyAnnotation = "com.openfaas.rancher.rate-limit-key"
+
+	keyIP           = "ip"
+	keyHeaderPrefix = "header:"
+
+	// interval idle buckets are dropped
+	sweepInterval = time.Minute
+)
+
+// Limit is the rate limit of a function
+type Limit struct {
+	// Rate is the number of requests per second, 0 disables the limit
+	Rate  float64
+	Burst int
+	// Key is empty for the function, "ip" or "header:<name>"
+	Key string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// Limiter enforces the rate limits of the functions
+type Limiter struct {
+	cache            *metastore.Cache
+	defaultNamespace string
+	trustedProxies   int
+	now              func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewLimiter creates a limiter reading the limits from cache. Functions
+// of defaultNamespace are also addressed without namespace. Clients are
+// identified by their address, behind trustedProxies proxies by the
+// right-most address in X-Forwarded-For not added by them.
+func NewLimiter(cache *metastore.Cache, defaultNamespace string, trustedProxies int) *Limiter {
+	l := Limiter{
+		cache:            cache,
+		defaultNamespace: defaultNamespace,
+		trustedProxies:   trustedProxies,
+		now:              time.Now,
+		buckets:          make(map[string]*bucket),
+	}
+
+	return &l
+}
+
+// ParseLimit returns the limit set by the annotations, a zero Rate if
+// the function is not limited
+func ParseLimit(annotations map[string]string) (Limit, error) {
+	limit := Limit{}
+
+	value, ok := annotations[RateAnnotation]
+	if !ok {
+		if _, ok := annotations[BurstAnnotation]; ok {
+			return limit, errors.Errorf("%s requires %s", BurstAnnotation, RateAnnotation)
+		}
+		return limit, nil
+	}
+
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate <= 0 || math.IsInf(rate, 0) {
+		return limit, errors.Errorf("%s must be a positive number, got %q", RateAnnotation, value)
+	}
+	limit.Rate = rate
+	limit.Burst = int(math.Ceil(rate))
+
+	if value, ok := annotations[BurstAnnotation]; ok {
+		burst, err := strconv.Atoi(value)
+		if err != nil || burst <= 0 {
+			return Limit{}, errors.Errorf("%s must be a positive integer, got %q", BurstAnnotation, value)
+		}
+		limit.Burst = burst
+	}
+
+	if key, ok := annotations[KeyAnnotation]; ok {
+		if key != keyIP && (!strings.HasPrefix(key, keyHeaderPrefix) || len(key) == len(keyHeaderPrefix)) {
+			return Limit{}, errors.Errorf("%s must be %q or \"%s<name>\", got %q", KeyAnnotation, keyIP, keyHeaderPrefix, key)
+		}
+		limit.Key = key
+	}
+
+	return limit, nil
+}
+
+// Limit passes requests for the function in the route variable name to
+// next within the rate limit of the function
+func (l *Limiter) Limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, namespace := backend.SplitFunctionName(mux.Vars(r)["name"])
+		if namespace == l.defaultNamespace {
+			namespace = ""
+		}
+
+		function := name
+		if namespace != "" {
+			function = name + "." + namespace
+		}
+
+		limit := l.limit(name, namespace, function)
+		if limit.Rate == 0 {
+			next(w, r)
+			return
+		}
+
+		allowed, retryAfter := l.allow(function+"|"+l.clientKey(r, limit.Key), limit)
+		if !allowed {
+			limited.WithLabelValues(function).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, fmt.Sprintf("rate limit of function %s exceeded", function), http.StatusTooManyRequests)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// allow takes a token from the bucket of key, otherwise it returns the
+// time until the next token is available
+func (l *Limiter) allow(key string, limit Limit) (bool, time.Duration) {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now, limit: limit}
+		l.buckets[key] = b
+	}
+
+	// a changed limit applies right away
+	b.limit = limit
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := (1 - b.tokens) / limit.Rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// sweep drops the buckets refilled completely, l.mu must be held
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// limit returns the limit annotated on the function
+func (l *Limiter) limit(name, namespace, function string) Limit {
+	annotations, err := l.cache.Annotations(name, namespace)
+	if err != nil {
+		return Limit{}
+	}
+
+	limit, err := ParseLimit(annotations)
+	if err != nil {
+		logger.Warnf("ignoring rate limit of %q: %v", function, err)
+	}
+
+	return limit
+}
+
+// clientKey identifies the caller of r by key
+func (l *Limiter) clientKey(r *http.Request, key string) string {
+	switch {
+	case key == keyIP:
+		return helper.ClientIP(r, l.trustedProxies)
+	case strings.HasPrefix(key, keyHeaderPrefix):
+		return r.Header.Get(strings.TrimPrefix(key, keyHeaderPrefix))
+	}
+
+	return ""
+}
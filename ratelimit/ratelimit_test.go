@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// invoke serves a request to the function through the limited handler
+func invoke(handler http.HandlerFunc, name, remoteAddr string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/function/"+name, nil)
+	req.RemoteAddr = remoteAddr
+	rr := httptest.NewRecorder()
+	handler(rr, mux.SetURLVars(req, map[string]string{"name": name}))
+	return rr
+}
+
+func ok(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func newStore(annotations map[string]interface{}) metastore.Store {
+	store := metastore.NewMemoryStore()
+	store.Put(&metastore.FunctionMeta{
+		Service:     "some-function",
+		Image:       "some/image",
+		Annotations: annotations,
+	})
+
+	return store
+}
+
+// clock is a settable time source for the limiter
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func Test_Limiter_Rejects_Requests_Beyond_Rate(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	store := newStore(map[string]interface{}{RateAnnotation: "0.5", BurstAnnotation: "2"})
+	limiter := NewLimiter(metastore.NewCache(store, "faas-functions", time.Minute), "faas-functions", 0)
+	c := &clock{now: time.Date(2019, 11, 6, 10, 0, 0, 0, time.UTC)}
+	limiter.now = c.Now
+	handler := limiter.Limit(ok)
+
+	// Act & Assert
+	assert.Equal(http.StatusOK, invoke(handler, "some-function", "10.0.0.1:1234").Code)
+	assert.Equal(http.StatusOK, invoke(handler, "some-function.faas-functions", "10.0.0.1:1234").Code)
+
+	rejected := invoke(handler, "some-function", "10.0.0.1:1234")
+	assert.Equal(http.StatusTooManyRequests, rejected.Code)
+	assert.Equal("2", rejected.Header().Get("Retry-After"))
+
+	c.now = c.now.Add(2 * time.Second)
+	assert.Equal(http.StatusOK, invoke(handler, "some-function", "10.0.0.1:1234").Code)
+	assert.Equal(http.StatusTooManyRequests, invoke(handler, "some-function", "10.0.0.1:1234").Code)
+}
+
+func Test_Limiter_Limits_Per_Client(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	store := newStore(map[string]interface{}{RateAnnotation: "1", KeyAnnotation: "ip"})
+	limiter := NewLimiter(metastore.NewCache(store, "faas-functions", time.Minute), "faas-functions", 0)
+	limiter.now = (&clock{now: time.Date(2019, 11, 6, 10, 0, 0, 0, time.UTC)}).Now
+	handler := limiter.Limit(ok)
+
+	// Act & Assert
+	assert.Equal(http.StatusOK, invoke(handler, "some-function", "10.0.0.1:1234").Code)
+	assert.Equal(http.StatusTooManyRequests, invoke(handler, "some-function", "10.0.0.1:5678").Code)
+	assert.Equal(http.StatusOK, invoke(handler, "some-function", "10.0.0.2:1234").Code)
+}
+
+func Test_Limiter_Applies_Updated_Limits(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	store := metastore.NewCache(newStore(map[string]interface{}{RateAnnotation: "1"}), "faas-functions", time.Minute)
+	limiter := NewLimiter(store, "faas-functions", 0)
+	limiter.now = (&clock{now: time.Date(2019, 11, 6, 10, 0, 0, 0, time.UTC)}).Now
+	handler := limiter.Limit(ok)
+
+	assert.Equal(http.StatusOK, invoke(handler, "some-function", "10.0.0.1:1234").Code)
+	assert.Equal(http.StatusTooManyRequests, invoke(handler, "some-function", "10.0.0.1:1234").Code)
+
+	// Act
+	store.Put(&metastore.FunctionMeta{Service: "some-function", Image: "some/image"})
+
+	// Assert
+	assert.Equal(http.StatusOK, invoke(handler, "some-function", "10.0.0.1:1234").Code)
+	assert.Equal(http.StatusOK, invoke(handler, "other-function", "10.0.0.1:1234").Code)
+}
+
+func Test_ParseLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	limit, err := ParseLimit(map[string]string{RateAnnotation: "2.5"})
+	assert.NoError(err)
+	assert.Equal(Limit{Rate: 2.5, Burst: 3}, limit)
+
+	limit, err = ParseLimit(map[string]string{RateAnnotation: "10", BurstAnnotation: "20", KeyAnnotation: "header:X-Api-Key"})
+	assert.NoError(err)
+	assert.Equal(Limit{Rate: 10, Burst: 20, Key: "header:X-Api-Key"}, limit)
+
+	limit, err = ParseLimit(nil)
+	assert.NoError(err)
+	assert.Equal(Limit{}, limit)
+
+	for _, annotations := range []map[string]string{
+		{RateAnnotation: "0"},
+		{RateAnnotation: "fast"},
+		{RateAnnotation: "1", BurstAnnotation: "-1"},
+		{RateAnnotation: "1", KeyAnnotation: "header:"},
+		{RateAnnotation: "1", KeyAnnotation: "user"},
+		{BurstAnnotation: "5"},
+	} {
+		_, err = ParseLimit(annotations)
+		assert.Error(err, "%v", annotations)
+	}
+}
@@ -4,22 +4,40 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"time"
 
+	"github.com/gitmonster/faas-rancher/async"
+	"github.com/gitmonster/faas-rancher/audit"
+	"github.com/gitmonster/faas-rancher/auth"
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/balancer"
+	"github.com/gitmonster/faas-rancher/certs"
+	"github.com/gitmonster/faas-rancher/concurrency"
+	"github.com/gitmonster/faas-rancher/cron"
+	"github.com/gitmonster/faas-rancher/docker"
+	"github.com/gitmonster/faas-rancher/drain"
+	"github.com/gitmonster/faas-rancher/drift"
 	"github.com/gitmonster/faas-rancher/handlers"
 	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gitmonster/faas-rancher/policy"
 	"github.com/gitmonster/faas-rancher/rancher"
+	"github.com/gitmonster/faas-rancher/ratelimit"
+	"github.com/gitmonster/faas-rancher/trash"
 	"github.com/juju/errors"
 	"github.com/kelseyhightower/envconfig"
 	bootstrap "github.com/openfaas/faas-provider"
 	proxy "github.com/openfaas/faas-provider/proxy"
 	"github.com/openfaas/faas-provider/types"
 	bootTypes "github.com/openfaas/faas-provider/types"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
 
@@ -35,18 +53,82 @@ const (
 	Version = "0.13.0"
 )
 
+const (
+	backendRancher = "rancher"
+	backendDocker  = "docker"
+)
+
 type Settings struct {
-	Debug                  bool          `default:"false"`
-	RancherCattleURL       string        `default:"" required:"true" split_words:"true"`
-	RancherCattleAccessKey string        `default:"" required:"true" split_words:"true"`
-	RancherCattleSecretKey string        `default:"" required:"true" split_words:"true"`
-	FaasStackName          string        `default:"faas-functions" required:"true" split_words:"true"`
-	FaasReadTimeout        time.Duration `default:"8s" split_words:"true"`
-	FaasWriteTimeout       time.Duration `default:"8s" split_words:"true"`
-	FaasPort               int           `default:"8080" split_words:"true"`
+	Debug                      bool          `default:"false"`
+	Backend                    string        `default:"rancher"`
+	RancherCattleURL           string        `default:"" split_words:"true"`
+	RancherCattleAccessKey     string        `default:"" split_words:"true"`
+	RancherCattleSecretKey     string        `default:"" split_words:"true"`
+	RancherCattleAccessKeyFile string        `default:"" split_words:"true"`
+	RancherCattleSecretKeyFile string        `default:"" split_words:"true"`
+	RancherCredentialsInterval time.Duration `default:"30s" split_words:"true"`
+	RancherEnvironmentsFile    string        `default:"" split_words:"true"`
+	RancherCattleCAFile        string        `default:"" split_words:"true"`
+	RancherCattleCertFile      string        `default:"" split_words:"true"`
+	RancherCattleKeyFile       string        `default:"" split_words:"true"`
+	DockerSocket               string        `default:"/var/run/docker.sock" split_words:"true"`
+	DockerNetwork              string        `default:"faas-functions" split_words:"true"`
+	MetastorePath              string        `default:"/metastore/store.db" split_words:"true"`
+	MetastoreCacheTTL          time.Duration `default:"1s" split_words:"true"`
+	DriftInterval              time.Duration `default:"5m" split_words:"true"`
+	DriftReapply               bool          `default:"false" split_words:"true"`
+	DrainTimeout               time.Duration `default:"5s" split_words:"true"`
+	SoftDeleteTTL              time.Duration `default:"0" split_words:"true"`
+	TrashPurgeInterval         time.Duration `default:"1m" split_words:"true"`
+	AuditPath                  string        `default:"/metastore/audit.db" split_words:"true"`
+	AuditMaxEntries            int           `default:"100000" split_words:"true"`
+	AuditMaxAge                time.Duration `default:"2160h" split_words:"true"`
+	BasicAuth                  bool          `default:"false" split_words:"true"`
+	SecretMountPath            string        `default:"/run/secrets/" split_words:"true"`
+	AuthMode                   string        `default:"" split_words:"true"`
+	AuthReloadInterval         time.Duration `default:"30s" split_words:"true"`
+	PolicyFile                 string        `default:"" split_words:"true"`
+	TLSCertFile                string        `default:"" split_words:"true"`
+	TLSKeyFile                 string        `default:"" split_words:"true"`
+	TLSClientCAFile            string        `default:"" split_words:"true"`
+	TLSReloadInterval          time.Duration `default:"30s" split_words:"true"`
+	FaasStackName              string        `default:"faas-functions" required:"true" split_words:"true"`
+	FaasReadTimeout            time.Duration `default:"8s" split_words:"true"`
+	FaasWriteTimeout           time.Duration `default:"8s" split_words:"true"`
+	FaasMaxTimeout             time.Duration `default:"5m" split_words:"true"`
+	FaasPort                   int           `default:"8080" split_words:"true"`
+	WatchdogPort               int           `default:"8080" split_words:"true"`
+	ProxyBalancing             string        `default:"" split_words:"true"`
+	ProxyRefreshInterval       time.Duration `default:"5s" split_words:"true"`
+	ProxyEjectDuration         time.Duration `default:"30s" split_words:"true"`
+	ProxyMissTTL               time.Duration `default:"2s" split_words:"true"`
+	ProxyQueueTimeout          time.Duration `default:"10s" split_words:"true"`
+	ProxyTrustedProxies        int           `default:"0" split_words:"true"`
+	ProxyRetries               int           `default:"2" split_words:"true"`
+	ProxyRetryMaxBodyBytes     int64         `default:"1048576" split_words:"true"`
+	ProxyBreakerThreshold      int           `default:"0" split_words:"true"`
+	ProxyBreakerCooldown       time.Duration `default:"10s" split_words:"true"`
+	AsyncPath                  string        `default:"/metastore/async.db" split_words:"true"`
+	AsyncWorkers               int           `default:"4" split_words:"true"`
+	AsyncMaxAttempts           int           `default:"5" split_words:"true"`
+	AsyncBackoff               time.Duration `default:"1s" split_words:"true"`
+	AsyncMaxBackoff            time.Duration `default:"5m" split_words:"true"`
+	AsyncFunctionConcurrency   int           `default:"0" split_words:"true"`
+	AsyncPollInterval          time.Duration `default:"1s" split_words:"true"`
+	AsyncMaxBodyBytes          int64         `default:"10485760" split_words:"true"`
+	CronEnabled                bool          `default:"false" split_words:"true"`
+	CronPath                   string        `default:"/metastore/cron.db" split_words:"true"`
+	CronMissedRuns             string        `default:"skip" split_words:"true"`
+	CronResyncInterval         time.Duration `default:"1m" split_words:"true"`
 }
 
 func main() {
+	migrateOnly := flag.Bool("migrate-only", false, "report the pending metastore migrations and exit")
+	exportFile := flag.String("export", "", "export the metastore to `file` and exit")
+	importFile := flag.String("import", "", "import the metastore snapshot in `file` and exit")
+	importMode := flag.String("import-mode", string(metastore.ImportMerge), "merge or replace the stored entries on import")
+	flag.Parse()
+
 	logrus.SetOutput(os.Stdout)
 
 	logger.Info("process settings")
@@ -58,39 +140,139 @@ func main() {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
-	// creates the rancher client config
-	config, err := rancher.NewClientConfig(
-		settings.FaasStackName,
-		settings.RancherCattleURL,
-		settings.RancherCattleAccessKey,
-		settings.RancherCattleSecretKey,
-	)
+	if *migrateOnly {
+		if err := reportMigrations(); err != nil {
+			logger.Fatal(errors.Annotate(err, "reportMigrations"))
+		}
+		return
+	}
 
-	if err != nil {
-		log.Fatal(errors.Annotate(err, "NewClientConfig"))
+	if *exportFile != "" || *importFile != "" {
+		if err := transferMetastore(*exportFile, *importFile, metastore.ImportMode(*importMode)); err != nil {
+			logger.Fatal(errors.Annotate(err, "transferMetastore"))
+		}
+		return
 	}
 
-	logger.Debug("created rancher client")
-	rancherClient, err := rancher.NewClientForConfig(config)
+	functions, resolver, err := createBackend()
 	if err != nil {
-		logger.Fatal(errors.Annotate(err, "NewClientForConfig"))
+		logger.Fatal(errors.Annotate(err, "createBackend"))
 	}
 
 	logger.Debug("open storage")
-	if err := metastore.Open(); err != nil {
-		logger.Fatal(errors.Annotate(err, "Open [metastore]"))
+	boltStore, err := metastore.NewBoltStore(settings.MetastorePath)
+	if err != nil {
+		logger.Fatal(errors.Annotate(err, "NewBoltStore"))
 	}
 
-	defer metastore.Close()
+	defer boltStore.Close()
 
-	resolver := NewFunctionURLResolver(8080)
-	var bootstrapHandlers bootTypes.FaaSHandlers
+	namespaced, err := metastore.WithDefaultNamespace(boltStore, settings.FaasStackName)
+	if err != nil {
+		logger.Fatal(errors.Annotate(err, "WithDefaultNamespace"))
+	}
+
+	// all writes pass the cache, it drops the outdated entries right away
+	store := metastore.NewCache(namespaced, settings.FaasStackName, settings.MetastoreCacheTTL)
 
 	faasConfig := types.FaaSConfig{
 		ReadTimeout:  settings.FaasReadTimeout,
 		WriteTimeout: settings.FaasWriteTimeout,
 	}
 
+	invoke, err := createFunctionProxy(functions, resolver, faasConfig)
+	if err != nil {
+		logger.Fatal(errors.Annotate(err, "createFunctionProxy"))
+	}
+
+	// the limiter counts the requests in flight for draining too
+	tracker := drain.NewTracker(settings.FaasStackName)
+	limiter := concurrency.NewLimiter(store, tracker, settings.FaasStackName, settings.ProxyQueueTimeout)
+	functions = concurrency.NewBackend(functions, limiter)
+	if settings.DrainTimeout > 0 {
+		functions = drain.NewBackend(functions, tracker, settings.DrainTimeout)
+	}
+
+	rateLimiter := ratelimit.NewLimiter(store, settings.FaasStackName, settings.ProxyTrustedProxies)
+
+	invoke = handlers.MakeRetryProxy(store, invoke)
+	invoke = handlers.MakeTimeoutProxy(store, settings.FaasMaxTimeout, invoke)
+	functionProxy := handlers.MakePausedProxy(store, rateLimiter.Limit(limiter.Limit(invoke)))
+
+	logger.Debug("open async queue")
+	queue, err := async.NewBoltQueue(settings.AsyncPath)
+	if err != nil {
+		logger.Fatal(errors.Annotate(err, "NewBoltQueue"))
+	}
+
+	defer queue.Close()
+
+	dispatcher := async.NewDispatcher(queue, store, functionProxy, async.Options{
+		Workers:             settings.AsyncWorkers,
+		MaxAttempts:         settings.AsyncMaxAttempts,
+		Backoff:             settings.AsyncBackoff,
+		MaxBackoff:          settings.AsyncMaxBackoff,
+		FunctionConcurrency: settings.AsyncFunctionConcurrency,
+		PollInterval:        settings.AsyncPollInterval,
+		DefaultNamespace:    settings.FaasStackName,
+	})
+	if settings.AsyncWorkers > 0 {
+		go dispatcher.Run(nil)
+	}
+
+	var scheduler *cron.Scheduler
+	if settings.CronEnabled {
+		logger.Debug("open cron state")
+		state, err := cron.NewBoltState(settings.CronPath)
+		if err != nil {
+			logger.Fatal(errors.Annotate(err, "NewBoltState"))
+		}
+
+		defer state.Close()
+
+		scheduler, err = cron.NewScheduler(store, state, functionProxy, cron.MissedRunPolicy(settings.CronMissedRuns))
+		if err != nil {
+			logger.Fatal(errors.Annotate(err, "NewScheduler"))
+		}
+
+		go scheduler.Run(settings.CronResyncInterval, nil)
+	}
+
+	logger.Debug("open audit log")
+	auditLog, err := audit.NewBoltLog(settings.AuditPath, settings.AuditMaxEntries, settings.AuditMaxAge)
+	if err != nil {
+		logger.Fatal(errors.Annotate(err, "NewBoltLog"))
+	}
+
+	defer auditLog.Close()
+
+	bin := trash.NewBin(functions, store, settings.SoftDeleteTTL)
+	if settings.TrashPurgeInterval > 0 {
+		go bin.Run(settings.TrashPurgeInterval, nil)
+	}
+
+	deleteFunction := handlers.MakeDeleteHandler(functions, store)
+	if settings.SoftDeleteTTL > 0 {
+		deleteFunction = handlers.MakeSoftDeleteHandler(bin)
+	}
+
+	authorize, err := createAuthorizer(functions)
+	if err != nil {
+		logger.Fatal(errors.Annotate(err, "createAuthorizer"))
+	}
+
+	deleteHandler := authorize(policy.OperationDelete, handlers.MakeAuditHandler(auditLog, settings.ProxyTrustedProxies, functions, audit.OperationDelete, deleteFunction.ServeHTTP))
+	deployHandler := authorize(policy.OperationDeploy, handlers.MakeAuditHandler(auditLog, settings.ProxyTrustedProxies, functions, audit.OperationDeploy, handlers.MakeDeployHandler(functions, store, settings.FaasMaxTimeout).ServeHTTP))
+	replicaUpdater := authorize(policy.OperationScale, handlers.MakeAuditHandler(auditLog, settings.ProxyTrustedProxies, functions, audit.OperationScale, handlers.MakeReplicaUpdater(functions).ServeHTTP))
+	updateHandler := authorize(policy.OperationUpdate, handlers.MakeAuditHandler(auditLog, settings.ProxyTrustedProxies, functions, audit.OperationUpdate, handlers.MakeUpdateHandler(functions, store, settings.FaasMaxTimeout).ServeHTTP))
+	secretHandler := authorize(policy.OperationSecrets, handlers.MakeSecretAuditHandler(auditLog, settings.ProxyTrustedProxies, handlers.MakeSecretHandler(functions)))
+	functionReader := authorize(policy.OperationRead, handlers.MakeFunctionReader(functions, store).ServeHTTP)
+	replicaReader := authorize(policy.OperationRead, handlers.MakeReplicaReader(functions, store).ServeHTTP)
+	namespaceLister := authorize(policy.OperationRead, handlers.MakeNamespaceLister(functions))
+	infoHandler := authorize(policy.OperationRead, handlers.MakeInfoHandler(Version, CommitSHA))
+
+	var bootstrapHandlers bootTypes.FaaSHandlers
+
 	if settings.Debug {
 		decorateDebug := func(name string, fn http.HandlerFunc) http.HandlerFunc {
 			return func(w http.ResponseWriter, r *http.Request) {
@@ -101,57 +283,468 @@ func main() {
 		}
 
 		bootstrapHandlers = bootTypes.FaaSHandlers{
-			FunctionProxy:  decorateDebug("Proxy", proxy.NewHandlerFunc(faasConfig, resolver)),
-			DeleteHandler:  decorateDebug("DeleteHandler", handlers.MakeDeleteHandler(rancherClient).ServeHTTP),
-			DeployHandler:  decorateDebug("DeployHandler", handlers.MakeDeployHandler(rancherClient).ServeHTTP),
-			FunctionReader: decorateDebug("FunctionReader", handlers.MakeFunctionReader(rancherClient).ServeHTTP),
-			ReplicaReader:  decorateDebug("ReplicaReader", handlers.MakeReplicaReader(rancherClient).ServeHTTP),
-			ReplicaUpdater: decorateDebug("ReplicaUpdater", handlers.MakeReplicaUpdater(rancherClient).ServeHTTP),
-			UpdateHandler:  decorateDebug("UpdateHandler", handlers.MakeUpdateHandler(rancherClient).ServeHTTP),
-			SecretHandler:  decorateDebug("SecretHandler", handlers.MakeSecretHandler(rancherClient)),
-			InfoHandler:    decorateDebug("InfoHandler", handlers.MakeInfoHandler(Version, CommitSHA)),
-			HealthHandler:  decorateDebug("HealthHandler", handlers.MakeHealthHandler()),
+			FunctionProxy:        decorateDebug("Proxy", functionProxy),
+			DeleteHandler:        decorateDebug("DeleteHandler", deleteHandler),
+			DeployHandler:        decorateDebug("DeployHandler", deployHandler),
+			FunctionReader:       decorateDebug("FunctionReader", functionReader),
+			ReplicaReader:        decorateDebug("ReplicaReader", replicaReader),
+			ReplicaUpdater:       decorateDebug("ReplicaUpdater", replicaUpdater),
+			UpdateHandler:        decorateDebug("UpdateHandler", updateHandler),
+			SecretHandler:        decorateDebug("SecretHandler", secretHandler),
+			ListNamespaceHandler: decorateDebug("ListNamespaceHandler", namespaceLister),
+			InfoHandler:          decorateDebug("InfoHandler", infoHandler),
+			HealthHandler:        decorateDebug("HealthHandler", handlers.MakeHealthHandler()),
 		}
 	} else {
 		bootstrapHandlers = bootTypes.FaaSHandlers{
-			FunctionProxy:  proxy.NewHandlerFunc(faasConfig, resolver),
-			DeleteHandler:  handlers.MakeDeleteHandler(rancherClient).ServeHTTP,
-			DeployHandler:  handlers.MakeDeployHandler(rancherClient).ServeHTTP,
-			FunctionReader: handlers.MakeFunctionReader(rancherClient).ServeHTTP,
-			ReplicaReader:  handlers.MakeReplicaReader(rancherClient).ServeHTTP,
-			ReplicaUpdater: handlers.MakeReplicaUpdater(rancherClient).ServeHTTP,
-			UpdateHandler:  handlers.MakeUpdateHandler(rancherClient).ServeHTTP,
-			SecretHandler:  handlers.MakeSecretHandler(rancherClient),
-			InfoHandler:    handlers.MakeInfoHandler(Version, CommitSHA),
-			HealthHandler:  handlers.MakeHealthHandler(),
+			FunctionProxy:        functionProxy,
+			DeleteHandler:        deleteHandler,
+			DeployHandler:        deployHandler,
+			FunctionReader:       functionReader,
+			ReplicaReader:        replicaReader,
+			ReplicaUpdater:       replicaUpdater,
+			UpdateHandler:        updateHandler,
+			SecretHandler:        secretHandler,
+			ListNamespaceHandler: namespaceLister,
+			InfoHandler:          infoHandler,
+			HealthHandler:        handlers.MakeHealthHandler(),
+		}
+	}
+
+	reconciler := drift.NewReconciler(functions, store, settings.DriftReapply)
+	if settings.DriftInterval > 0 {
+		go reconciler.Run(settings.DriftInterval, nil)
+	}
+
+	router := bootstrap.Router()
+	if authEnabled() {
+		mode := auth.Mode(settings.AuthMode)
+		if mode == "" {
+			mode = auth.ModeBasic
+		}
+
+		authenticator, err := auth.NewAuthenticator(settings.SecretMountPath, mode)
+		if err != nil {
+			logger.Fatal(errors.Annotate(err, "NewAuthenticator"))
+		}
+
+		if settings.AuthReloadInterval > 0 {
+			go authenticator.Watch(settings.AuthReloadInterval, nil)
 		}
+
+		router.Use(authenticator.Middleware)
+	}
+
+	router.HandleFunc("/system/drift", authorize(policy.OperationRead, handlers.MakeDriftHandler(reconciler))).Methods(http.MethodGet)
+	router.HandleFunc("/system/drift", authorize(policy.OperationMetastore, handlers.MakeDriftReconciler(reconciler))).Methods(http.MethodPost)
+	router.HandleFunc("/system/metastore/export", authorize(policy.OperationMetastore, handlers.MakeMetastoreExportHandler(store))).Methods(http.MethodGet)
+	router.HandleFunc("/system/metastore/import", authorize(policy.OperationMetastore, handlers.MakeMetastoreImportHandler(store))).Methods(http.MethodPost)
+	router.HandleFunc("/system/functions/{name}/restore", authorize(policy.OperationRestore,
+		handlers.MakeAuditHandler(auditLog, settings.ProxyTrustedProxies, functions, audit.OperationRestore, handlers.MakeRestoreHandler(bin).ServeHTTP))).Methods(http.MethodPost)
+	router.HandleFunc("/system/functions/{name}/pause", authorize(policy.OperationPause,
+		handlers.MakeAuditHandler(auditLog, settings.ProxyTrustedProxies, functions, audit.OperationPause, handlers.MakePauseHandler(functions, store).ServeHTTP))).Methods(http.MethodPost)
+	router.HandleFunc("/system/functions/{name}/resume", authorize(policy.OperationResume,
+		handlers.MakeAuditHandler(auditLog, settings.ProxyTrustedProxies, functions, audit.OperationResume, handlers.MakeResumeHandler(functions, store).ServeHTTP))).Methods(http.MethodPost)
+	router.HandleFunc("/system/trash", authorize(policy.OperationRead, handlers.MakeTrashLister(bin))).Methods(http.MethodGet)
+	router.HandleFunc("/system/audit", authorize(policy.OperationRead, handlers.MakeAuditReader(auditLog))).Methods(http.MethodGet)
+	router.HandleFunc("/system/async/dead-letters", authorize(policy.OperationRead, handlers.MakeDeadLetterLister(dispatcher))).Methods(http.MethodGet)
+
+	if scheduler != nil {
+		router.HandleFunc("/system/cron", authorize(policy.OperationRead, handlers.MakeCronLister(scheduler))).Methods(http.MethodGet)
+	}
+
+	if settings.AsyncWorkers > 0 {
+		asyncHandler := handlers.MakeAsyncHandler(dispatcher, settings.AsyncMaxBodyBytes).ServeHTTP
+		router.HandleFunc("/async-function/{name:["+bootstrap.NameExpression+"]+}", asyncHandler).Methods(http.MethodPost)
+		router.HandleFunc("/async-function/{name:["+bootstrap.NameExpression+"]+}/", asyncHandler).Methods(http.MethodPost)
+		router.HandleFunc("/async-function/{name:["+bootstrap.NameExpression+"]+}/{params:.*}", asyncHandler).Methods(http.MethodPost)
 	}
+	router.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+
+	// the server must not close invocations running up to the longest
+	// function timeout, the margin leaves time to answer timed out ones.
+	// Reading requests is still bound by FAAS_READ_TIMEOUT.
+	writeTimeout := settings.FaasMaxTimeout
+	if writeTimeout < settings.FaasWriteTimeout {
+		writeTimeout = settings.FaasWriteTimeout
+	}
+	writeTimeout += time.Second
 
 	port := settings.FaasPort
 	bootstrapConfig := bootTypes.FaaSConfig{
 		ReadTimeout:  settings.FaasReadTimeout,
-		WriteTimeout: settings.FaasWriteTimeout,
+		WriteTimeout: writeTimeout,
 		TCPPort:      &port,
 	}
 
-	bootstrap.Serve(&bootstrapHandlers, &bootstrapConfig)
+	tlsConfig, err := createTLSConfig()
+	if err != nil {
+		logger.Fatal(errors.Annotate(err, "createTLSConfig"))
+	}
+
+	logger.Fatal(serve(&bootstrapHandlers, &bootstrapConfig, tlsConfig))
+}
+
+// serve registers the handlers like bootstrap.Serve does and listens,
+// with TLS if tlsConfig is set. This function is blocking.
+func serve(handlers *bootTypes.FaaSHandlers, config *bootTypes.FaaSConfig, tlsConfig *tls.Config) error {
+	r := bootstrap.Router()
+
+	// System (auth) endpoints
+	r.HandleFunc("/system/functions", handlers.FunctionReader).Methods(http.MethodGet)
+	r.HandleFunc("/system/functions", handlers.DeployHandler).Methods(http.MethodPost)
+	r.HandleFunc("/system/functions", handlers.DeleteHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/system/functions", handlers.UpdateHandler).Methods(http.MethodPut)
+
+	r.HandleFunc("/system/function/{name:["+bootstrap.NameExpression+"]+}", handlers.ReplicaReader).Methods(http.MethodGet)
+	r.HandleFunc("/system/scale-function/{name:["+bootstrap.NameExpression+"]+}", handlers.ReplicaUpdater).Methods(http.MethodPost)
+	r.HandleFunc("/system/info", handlers.InfoHandler).Methods(http.MethodGet)
+
+	r.HandleFunc("/system/secrets", handlers.SecretHandler).Methods(http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete)
+	r.HandleFunc("/system/namespaces", handlers.ListNamespaceHandler).Methods(http.MethodGet)
+
+	// Open endpoints
+	r.HandleFunc("/function/{name:["+bootstrap.NameExpression+"]+}", handlers.FunctionProxy)
+	r.HandleFunc("/function/{name:["+bootstrap.NameExpression+"]+}/", handlers.FunctionProxy)
+	r.HandleFunc("/function/{name:["+bootstrap.NameExpression+"]+}/{params:.*}", handlers.FunctionProxy)
+	r.HandleFunc("/healthz", handlers.HealthHandler).Methods(http.MethodGet)
+
+	s := &http.Server{
+		Addr:           fmt.Sprintf(":%d", *config.TCPPort),
+		ReadTimeout:    config.ReadTimeout,
+		WriteTimeout:   config.WriteTimeout,
+		MaxHeaderBytes: http.DefaultMaxHeaderBytes,
+		Handler:        r,
+		TLSConfig:      tlsConfig,
+	}
+
+	if tlsConfig != nil {
+		logger.Infof("listening with TLS on %s", s.Addr)
+		// the certificate is served by tlsConfig
+		return s.ListenAndServeTLS("", "")
+	}
+
+	return s.ListenAndServe()
+}
+
+// createTLSConfig returns the TLS config of the listener, nil if TLS is
+// not configured. Certificates are reloaded when their files change.
+func createTLSConfig() (*tls.Config, error) {
+	if settings.TLSCertFile == "" && settings.TLSKeyFile == "" {
+		if settings.TLSClientCAFile != "" {
+			return nil, errors.New("client certificate verification requires a server certificate")
+		}
+		return nil, nil
+	}
+
+	reloader, err := certs.NewReloader(settings.TLSCertFile, settings.TLSKeyFile, settings.TLSClientCAFile)
+	if err != nil {
+		return nil, errors.Annotate(err, "NewReloader")
+	}
+
+	if settings.TLSReloadInterval > 0 {
+		go reloader.Watch(settings.TLSReloadInterval, nil)
+	}
+
+	return reloader.ServerConfig(), nil
+}
+
+// authEnabled reports whether the /system/* routes require credentials,
+// setting an auth mode enables authentication like BASIC_AUTH does
+func authEnabled() bool {
+	return settings.BasicAuth || settings.AuthMode != ""
+}
+
+// createAuthorizer returns a decorator authorizing the requests of handlers
+// with the policy file, if one is configured
+func createAuthorizer(functions backend.Backend) (func(policy.Operation, http.HandlerFunc) http.HandlerFunc, error) {
+	if settings.PolicyFile == "" {
+		return func(op policy.Operation, next http.HandlerFunc) http.HandlerFunc {
+			return next
+		}, nil
+	}
+
+	if !authEnabled() {
+		logger.Warn("policy file set without authentication, only rules for any identity (\"*\") apply")
+	}
+
+	p, err := policy.Load(settings.PolicyFile, settings.FaasStackName)
+	if err != nil {
+		return nil, errors.Annotate(err, "Load [policy]")
+	}
+
+	logger.Infof("authorizing requests with %d policy rules", len(p.Rules))
+	return func(op policy.Operation, next http.HandlerFunc) http.HandlerFunc {
+		return handlers.MakeAuthorizeHandler(p, functions, op, next)
+	}, nil
+}
+
+// reportMigrations logs the changes the pending migrations would apply
+// to the metastore without touching it
+func reportMigrations() error {
+	version, changes, err := metastore.PlanMigrations(settings.MetastorePath)
+	if err != nil {
+		return errors.Annotate(err, "PlanMigrations")
+	}
+
+	logger.Infof("metastore schema version %d, current version %d", version, metastore.SchemaVersion())
+	for _, change := range changes {
+		logger.Infof("migration %d (%s) changes %q", change.Version, change.Description, change.Key)
+	}
+
+	logger.Infof("%d entries would change", len(changes))
+	return nil
+}
+
+// transferMetastore exports the metastore to exportFile and
+// imports the snapshot in importFile if set
+func transferMetastore(exportFile, importFile string, mode metastore.ImportMode) error {
+	boltStore, err := metastore.NewBoltStore(settings.MetastorePath)
+	if err != nil {
+		return errors.Annotate(err, "NewBoltStore")
+	}
+	defer boltStore.Close()
+
+	store, err := metastore.WithDefaultNamespace(boltStore, settings.FaasStackName)
+	if err != nil {
+		return errors.Annotate(err, "WithDefaultNamespace")
+	}
+
+	if exportFile != "" {
+		snapshot, err := metastore.Export(store)
+		if err != nil {
+			return errors.Annotate(err, "Export")
+		}
+
+		buf, err := json.MarshalIndent(snapshot, "", "  ")
+		if err != nil {
+			return errors.Annotate(err, "MarshalIndent")
+		}
+
+		if err := ioutil.WriteFile(exportFile, buf, 0600); err != nil {
+			return errors.Annotate(err, "WriteFile")
+		}
+
+		logger.Infof("exported %d metastore entries to %s", len(snapshot.Functions), exportFile)
+	}
+
+	if importFile != "" {
+		buf, err := ioutil.ReadFile(importFile)
+		if err != nil {
+			return errors.Annotate(err, "ReadFile")
+		}
+
+		snapshot := metastore.Snapshot{}
+		if err := json.Unmarshal(buf, &snapshot); err != nil {
+			return errors.Annotate(err, "Unmarshal")
+		}
+
+		result, err := metastore.Import(store, &snapshot, mode)
+		if err != nil {
+			return errors.Annotate(err, "Import")
+		}
+
+		logger.Infof("imported %d metastore entries from %s, deleted %d", result.Imported, importFile, result.Deleted)
+	}
+
+	return nil
+}
+
+// createFunctionProxy returns the proxy of the function invocations, balancing
+// them across the instances of the functions if enabled
+func createFunctionProxy(functions backend.Backend, resolver proxy.BaseURLResolver, config types.FaaSConfig) (http.HandlerFunc, error) {
+	if settings.ProxyBalancing != "" {
+		logger.Infof("balance invocations with strategy %s", settings.ProxyBalancing)
+	}
+
+	bal, err := balancer.NewBalancer(functions, resolver, balancer.Options{
+		Strategy:          balancer.Strategy(settings.ProxyBalancing),
+		DefaultNamespace:  settings.FaasStackName,
+		Port:              settings.WatchdogPort,
+		RefreshInterval:   settings.ProxyRefreshInterval,
+		EjectDuration:     settings.ProxyEjectDuration,
+		MissTTL:           settings.ProxyMissTTL,
+		Retries:           settings.ProxyRetries,
+		RetryMaxBodyBytes: settings.ProxyRetryMaxBodyBytes,
+		BreakerThreshold:  settings.ProxyBreakerThreshold,
+		BreakerCooldown:   settings.ProxyBreakerCooldown,
+	})
+	if err != nil {
+		return nil, errors.Annotate(err, "NewBalancer")
+	}
+
+	return bal.Proxy(config), nil
+}
+
+// createBackend creates the configured backend and returns it together
+// with the resolver for the addresses functions are reachable at
+func createBackend() (backend.Backend, proxy.BaseURLResolver, error) {
+	switch settings.Backend {
+	case backendRancher:
+		if settings.RancherEnvironmentsFile != "" {
+			return createEnvironmentsBackend()
+		}
+
+		if settings.RancherCattleURL == "" ||
+			(settings.RancherCattleAccessKey == "" && settings.RancherCattleAccessKeyFile == "") ||
+			(settings.RancherCattleSecretKey == "" && settings.RancherCattleSecretKeyFile == "") {
+			return nil, nil, errors.New("rancher backend requires cattle url, access key and secret key")
+		}
+
+		// creates the rancher client config
+		config, err := rancher.NewClientConfig(
+			settings.FaasStackName,
+			settings.RancherCattleURL,
+			settings.RancherCattleAccessKey,
+			settings.RancherCattleSecretKey,
+		)
+
+		if err != nil {
+			return nil, nil, errors.Annotate(err, "NewClientConfig")
+		}
+
+		config.CattleAccessKeyFile = settings.RancherCattleAccessKeyFile
+		config.CattleSecretKeyFile = settings.RancherCattleSecretKeyFile
+		config.CattleCAFile = settings.RancherCattleCAFile
+		config.CattleCertFile = settings.RancherCattleCertFile
+		config.CattleKeyFile = settings.RancherCattleKeyFile
+
+		rancherClient, err := rancher.NewClientForConfig(config)
+		if err != nil {
+			return nil, nil, errors.Annotate(err, "NewClientForConfig")
+		}
+
+		watchCredentials(rancherClient, config)
+
+		logger.Debug("created rancher client")
+		resolver := NewFunctionURLResolver(settings.FaasStackName, settings.WatchdogPort)
+		return rancher.NewBackend(rancherClient, settings.FaasStackName), resolver, nil
+	case backendDocker:
+		logger.Debug("created docker client")
+		// containers are reachable by their network alias
+		resolver := NewFunctionURLResolver("", settings.WatchdogPort)
+		return docker.NewBackend(settings.DockerSocket, settings.DockerNetwork), resolver, nil
+	}
+
+	return nil, nil, errors.Errorf("unknown backend %q", settings.Backend)
+}
+
+// createEnvironmentsBackend creates a rancher backend for every environment
+// of the environments file and routes the functions between them
+func createEnvironmentsBackend() (backend.Backend, proxy.BaseURLResolver, error) {
+	envs, err := rancher.LoadEnvironments(settings.RancherEnvironmentsFile)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "LoadEnvironments")
+	}
+
+	routes := []backend.Route{}
+	domains := make(map[string]string)
+	for _, env := range envs.Environments {
+		config, err := env.Config()
+		if err != nil {
+			return nil, nil, errors.Annotatef(err, "Config [%s]", env.Name)
+		}
+
+		rancherClient, err := rancher.NewClientForConfig(config)
+		if err != nil {
+			return nil, nil, errors.Annotatef(err, "NewClientForConfig [%s]", env.Name)
+		}
+
+		watchCredentials(rancherClient, config)
+
+		logger.Debugf("created rancher client for environment %s", env.Name)
+		routes = append(routes, backend.Route{
+			Name:       env.Name,
+			Backend:    rancher.NewBackend(rancherClient, env.StackName),
+			Namespaces: env.Namespaces,
+		})
+		domains[env.Name] = env.StackName
+	}
+
+	router, err := backend.NewRouter(routes, envs.Default, envs.Label)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "NewRouter")
+	}
+
+	return router, NewRouterURLResolver(router, domains, settings.WatchdogPort), nil
+}
+
+// watchCredentials reloads the client whenever its credential or certificate files change
+func watchCredentials(c *rancher.Client, config *rancher.Config) {
+	if config.HasTLSFiles() {
+		logger.Debugf("watching certificate files every %s", settings.RancherCredentialsInterval)
+		go c.WatchCertificates(settings.RancherCredentialsInterval, nil)
+	}
+
+	if !config.HasCredentialFiles() {
+		return
+	}
+
+	logger.Debugf("watching credential files every %s", settings.RancherCredentialsInterval)
+	go c.WatchCredentials(settings.RancherCredentialsInterval, nil)
 }
 
 type FunctionURLResolver struct {
+	domain       string
 	watchdogPort int
 }
 
+// Resolve resolves service or service.namespace. Namespaces are mapped
+// to stacks of the same name, so the namespace is the stack domain.
 func (p *FunctionURLResolver) Resolve(service string) (url.URL, error) {
-	u, err := url.Parse(fmt.Sprintf("http://%s.%s:%d/",
-		service,
-		settings.FaasStackName,
-		p.watchdogPort,
-	))
+	name, namespace := backend.SplitFunctionName(service)
+
+	host := name
+	if p.domain != "" {
+		domain := p.domain
+		if namespace != "" {
+			domain = namespace
+		}
+		host = fmt.Sprintf("%s.%s", name, domain)
+	}
+
+	u, err := url.Parse(fmt.Sprintf("http://%s:%d/", host, p.watchdogPort))
 	return *u, err
 }
 
-func NewFunctionURLResolver(watchdogPort int) *FunctionURLResolver {
+func NewFunctionURLResolver(domain string, watchdogPort int) *FunctionURLResolver {
 	r := FunctionURLResolver{
+		domain:       domain,
+		watchdogPort: watchdogPort,
+	}
+
+	return &r
+}
+
+// RouterURLResolver resolves functions to the stack of the environment they
+// are routed to. Rancher DNS is scoped to an environment, the stack names of
+// other environments only resolve with DNS set up outside of Rancher.
+type RouterURLResolver struct {
+	router       *backend.Router
+	domains      map[string]string
+	watchdogPort int
+}
+
+func (p *RouterURLResolver) Resolve(service string) (url.URL, error) {
+	name, namespace := backend.SplitFunctionName(service)
+	route, err := p.router.Route(name, namespace)
+	if err != nil {
+		return url.URL{}, errors.Annotate(err, "Route")
+	}
+
+	domain := p.domains[route]
+	if namespace != "" {
+		domain = namespace
+	}
+
+	u, err := url.Parse(fmt.Sprintf("http://%s.%s:%d/", name, domain, p.watchdogPort))
+	return *u, err
+}
+
+func NewRouterURLResolver(router *backend.Router, domains map[string]string, watchdogPort int) *RouterURLResolver {
+	r := RouterURLResolver{
+		router:       router,
+		domains:      domains,
 		watchdogPort: watchdogPort,
 	}
 
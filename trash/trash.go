@@ -0,0 +1,195 @@
+// Package trash soft deletes functions. A soft deleted function is
+// deactivated and its metadata moved to the trash of the metastore until
+// the restore window expires, then the function is purged for good.
+package trash
+
+import (
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	logger = logrus.WithField("package", "trash")
+)
+
+// Bin soft deletes and restores functions
+type Bin struct {
+	backend backend.Backend
+	store   metastore.Store
+	ttl     time.Duration
+}
+
+// NewBin creates a bin keeping soft deleted functions restorable for ttl
+func NewBin(b backend.Backend, store metastore.Store, ttl time.Duration) *Bin {
+	bin := Bin{
+		backend: b,
+		store:   store,
+		ttl:     ttl,
+	}
+
+	return &bin
+}
+
+// Delete deactivates the function and moves its metadata to the trash.
+// It returns backend.ErrFunctionNotFound if there is no such function or
+// if it is in the trash already, the trashed entry is left unchanged then.
+func (t *Bin) Delete(name, namespace string) error {
+	function, err := t.backend.FindFunction(name, namespace)
+	if err != nil {
+		return errors.Annotate(err, "FindFunction")
+	}
+
+	meta := &metastore.FunctionMeta{
+		Service:   function.Name,
+		Namespace: namespace,
+		Image:     function.Image,
+	}
+
+	if _, err := t.store.GetTrash(meta); err == nil {
+		return errors.Annotatef(backend.ErrFunctionNotFound, "function %q in trash", meta.Key())
+	} else if err != metastore.ErrEntityNotFound {
+		return errors.Annotate(err, "GetTrash [metastore]")
+	}
+
+	if err := t.store.Get(meta); err != nil {
+		if err != metastore.ErrEntityNotFound {
+			return errors.Annotate(err, "Get [metastore]")
+		}
+
+		// keep what the backend knows about functions without metadata
+		meta.RestoreFrom(function)
+	}
+
+	if err := t.backend.DeactivateFunction(name, namespace); err != nil {
+		return errors.Annotate(err, "DeactivateFunction")
+	}
+
+	now := time.Now().UTC()
+	entry := metastore.TrashEntry{
+		Meta:    *meta,
+		Deleted: now,
+		Expires: now.Add(t.ttl),
+	}
+
+	if err := t.store.Trash(&entry); err != nil {
+		if activateErr := t.backend.ActivateFunction(name, namespace); activateErr != nil {
+			logger.Error(errors.Annotate(activateErr, "ActivateFunction"))
+		}
+
+		return errors.Annotate(err, "Trash [metastore]")
+	}
+
+	logger.Infof("function %q moved to trash until %s", meta.Key(), entry.Expires.Format(time.RFC3339))
+	return nil
+}
+
+// Restore activates a soft deleted function and moves its metadata back.
+// It returns metastore.ErrEntityNotFound if the function is not in the trash.
+func (t *Bin) Restore(name, namespace string) error {
+	meta := &metastore.FunctionMeta{
+		Service:   name,
+		Namespace: namespace,
+	}
+
+	entry, err := t.store.Untrash(meta)
+	if err != nil {
+		return errors.Annotate(err, "Untrash [metastore]")
+	}
+
+	if entry.Meta.Paused {
+		logger.Infof("function %q restored from trash, it stays paused", meta.Key())
+		return nil
+	}
+
+	if err := t.backend.ActivateFunction(name, namespace); err != nil {
+		// keep it restorable
+		if trashErr := t.store.Trash(entry); trashErr != nil {
+			logger.Error(errors.Annotate(trashErr, "Trash [metastore]"))
+		}
+
+		return errors.Annotate(err, "ActivateFunction")
+	}
+
+	logger.Infof("function %q restored from trash", meta.Key())
+	return nil
+}
+
+// List lists the soft deleted functions
+func (t *Bin) List() ([]metastore.TrashEntry, error) {
+	entries, err := t.store.ListTrash()
+	if err != nil {
+		return nil, errors.Annotate(err, "ListTrash [metastore]")
+	}
+
+	return entries, nil
+}
+
+// PurgeExpired purges all functions whose restore window expired and returns their number
+func (t *Bin) PurgeExpired() (int, error) {
+	entries, err := t.store.ListTrash()
+	if err != nil {
+		return 0, errors.Annotate(err, "ListTrash [metastore]")
+	}
+
+	now := time.Now()
+	purged := 0
+	for _, entry := range entries {
+		if now.Before(entry.Expires) {
+			continue
+		}
+
+		if err := Discard(t.backend, t.store, entry.Meta.Service, entry.Meta.Namespace); err != nil {
+			return purged, errors.Annotatef(err, "Discard [%s]", entry.Meta.Key())
+		}
+
+		logger.Infof("purged expired function %q", entry.Meta.Key())
+		purged++
+	}
+
+	return purged, nil
+}
+
+// Run purges expired functions every interval until stop is closed
+func (t *Bin) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := t.PurgeExpired(); err != nil {
+			logger.Error(errors.Annotate(err, "PurgeExpired"))
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Discard purges a soft deleted function right away, e.g. before a function
+// of the same name is deployed. Functions not in the trash are left alone.
+func Discard(b backend.Backend, store metastore.Store, name, namespace string) error {
+	meta := &metastore.FunctionMeta{
+		Service:   name,
+		Namespace: namespace,
+	}
+
+	if _, err := store.GetTrash(meta); err != nil {
+		if err == metastore.ErrEntityNotFound {
+			return nil
+		}
+
+		return errors.Annotate(err, "GetTrash [metastore]")
+	}
+
+	if err := b.DeleteFunction(name, namespace); err != nil && errors.Cause(err) != backend.ErrFunctionNotFound {
+		return errors.Annotate(err, "DeleteFunction")
+	}
+
+	return errors.Annotate(store.Purge(meta), "Purge [metastore]")
+}
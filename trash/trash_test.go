@@ -0,0 +1,178 @@
+package trash
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gitmonster/faas-rancher/backend"
+	"github.com/gitmonster/faas-rancher/metastore"
+	"github.com/gitmonster/faas-rancher/mocks"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Bin_Delete_Restore(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	store := metastore.NewMemoryStore()
+	bin := NewBin(mockClient, store, time.Hour)
+
+	meta := &metastore.FunctionMeta{Service: "some-function", Namespace: "dev", Image: "some/image"}
+	store.Put(meta)
+
+	function := backend.Function{Name: "some-function", Image: "some/image"}
+	mockClient.On("FindFunction", "some-function", "dev").Return(&function, nil)
+	mockClient.On("DeactivateFunction", "some-function", "dev").Return(nil)
+	mockClient.On("ActivateFunction", "some-function", "dev").Return(nil)
+
+	// Act & Assert: delete
+	assert.NoError(bin.Delete("some-function", "dev"))
+	assert.Equal(metastore.ErrEntityNotFound, store.Get(&metastore.FunctionMeta{Service: "some-function", Namespace: "dev"}))
+
+	entries, err := bin.List()
+	assert.NoError(err)
+	if assert.Len(entries, 1) {
+		assert.Equal("some/image", entries[0].Meta.Image)
+		assert.WithinDuration(time.Now().Add(time.Hour), entries[0].Expires, time.Minute)
+	}
+
+	// restore
+	assert.NoError(bin.Restore("some-function", "dev"))
+	assert.NoError(store.Get(&metastore.FunctionMeta{Service: "some-function", Namespace: "dev"}))
+
+	// restoring twice fails
+	assert.Equal(metastore.ErrEntityNotFound, errors.Cause(bin.Restore("some-function", "dev")))
+	mockClient.AssertExpectations(t)
+}
+
+func Test_Bin_Delete_Twice_Keeps_Entry(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	store := metastore.NewMemoryStore()
+	bin := NewBin(mockClient, store, time.Hour)
+
+	store.Put(&metastore.FunctionMeta{
+		Service:   "some-function",
+		Namespace: "dev",
+		Image:     "some/image",
+		EnvVars:   map[string]interface{}{"KEY": "value"},
+	})
+
+	function := backend.Function{Name: "some-function", Image: "some/image"}
+	mockClient.On("FindFunction", "some-function", "dev").Return(&function, nil)
+	mockClient.On("DeactivateFunction", "some-function", "dev").Return(nil).Once()
+	assert.NoError(bin.Delete("some-function", "dev"))
+
+	trashed, err := store.GetTrash(&metastore.FunctionMeta{Service: "some-function", Namespace: "dev"})
+	assert.NoError(err)
+
+	// Act
+	err = bin.Delete("some-function", "dev")
+
+	// Assert
+	assert.Equal(backend.ErrFunctionNotFound, errors.Cause(err))
+	entry, err := store.GetTrash(&metastore.FunctionMeta{Service: "some-function", Namespace: "dev"})
+	if assert.NoError(err) {
+		assert.Equal(trashed, entry)
+		assert.Equal("value", entry.Meta.EnvVars["KEY"])
+	}
+	mockClient.AssertExpectations(t)
+}
+
+func Test_Bin_Restore_Keeps_Entry_On_Failure(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	store := metastore.NewMemoryStore()
+	bin := NewBin(mockClient, store, time.Hour)
+
+	entry := &metastore.TrashEntry{
+		Meta:    metastore.FunctionMeta{Service: "some-function", Image: "some/image"},
+		Expires: time.Now().Add(time.Hour),
+	}
+	store.Trash(entry)
+	mockClient.On("ActivateFunction", "some-function", "").Return(errors.New("activate failed"))
+
+	// Act
+	err := bin.Restore("some-function", "")
+
+	// Assert
+	assert.Error(err)
+	_, trashErr := store.GetTrash(&entry.Meta)
+	assert.NoError(trashErr)
+}
+
+func Test_Bin_PurgeExpired_Deletes_Expired_Functions(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	store := metastore.NewMemoryStore()
+	bin := NewBin(mockClient, store, time.Hour)
+
+	store.Trash(&metastore.TrashEntry{
+		Meta:    metastore.FunctionMeta{Service: "expired", Image: "some/image"},
+		Expires: time.Now().Add(-time.Minute),
+	})
+	store.Trash(&metastore.TrashEntry{
+		Meta:    metastore.FunctionMeta{Service: "gone", Image: "some/image"},
+		Expires: time.Now().Add(-time.Minute),
+	})
+	store.Trash(&metastore.TrashEntry{
+		Meta:    metastore.FunctionMeta{Service: "recent", Image: "some/image"},
+		Expires: time.Now().Add(time.Hour),
+	})
+	mockClient.On("DeleteFunction", "expired", "").Return(nil)
+	mockClient.On("DeleteFunction", "gone", "").Return(backend.ErrFunctionNotFound)
+
+	// Act
+	purged, err := bin.PurgeExpired()
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal(2, purged)
+	entries, _ := bin.List()
+	if assert.Len(entries, 1) {
+		assert.Equal("recent", entries[0].Meta.Service)
+	}
+	mockClient.AssertExpectations(t)
+}
+
+func Test_Discard_Ignores_Functions_Not_In_Trash(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	store := metastore.NewMemoryStore()
+
+	// Act
+	err := Discard(mockClient, store, "some-function", "")
+
+	// Assert
+	assert.NoError(err)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_Bin_Restore_Keeps_Paused_Functions_Inactive(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.Backend)
+	store := metastore.NewMemoryStore()
+	bin := NewBin(mockClient, store, time.Hour)
+
+	entry := &metastore.TrashEntry{
+		Meta:    metastore.FunctionMeta{Service: "some-function", Image: "some/image", Paused: true},
+		Expires: time.Now().Add(time.Hour),
+	}
+	store.Trash(entry)
+
+	// Act
+	err := bin.Restore("some-function", "")
+
+	// Assert
+	assert.NoError(err)
+	meta := &metastore.FunctionMeta{Service: "some-function"}
+	assert.NoError(store.Get(meta))
+	assert.True(meta.Paused)
+	mockClient.AssertExpectations(t)
+}